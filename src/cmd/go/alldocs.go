@@ -265,6 +265,10 @@
 // download cache, including unpacked source code of versioned
 // dependencies.
 //
+// The -fuzzcache flag causes clean to remove values used for fuzz testing
+// that are stored in the go build cache. Inputs that caused a failure
+// are stored in the package's testdata directory and are not removed.
+//
 // For more about build flags, see 'go help build'.
 //
 // For more about specifying packages, see 'go help packages'.
@@ -787,6 +791,14 @@
 //         TestGoFiles     []string // _test.go files in package
 //         XTestGoFiles    []string // _test.go files outside package
 //
+//         // Embedded files
+//         EmbedPatterns      []string // //go:embed patterns
+//         EmbedFiles         []string // files matched by EmbedPatterns
+//         TestEmbedPatterns  []string // //go:embed patterns in TestGoFiles
+//         TestEmbedFiles     []string // files matched by TestEmbedPatterns
+//         XTestEmbedPatterns []string // //go:embed patterns in XTestGoFiles
+//         XTestEmbedFiles    []string // files matched by XTestEmbedPatterns
+//
 //         // Cgo directives
 //         CgoCFLAGS    []string // cgo: flags for C compiler
 //         CgoCPPFLAGS  []string // cgo: flags for C preprocessor
@@ -3158,10 +3170,30 @@
 // 	-failfast
 // 	    Do not start new tests after the first test failure.
 //
+// 	-fuzz regexp
+// 	    Run the fuzz target matching the regular expression. When specified,
+// 	    the command line argument must match exactly one package, and regexp
+// 	    must match exactly one fuzz target within that package. After tests,
+// 	    benchmarks, seed corpora of other fuzz targets, and examples have
+// 	    completed, the matching target will be fuzzed. See the Fuzzing
+// 	    section of the testing package documentation for details.
+//
+// 	-fuzztime t
+// 	    Run enough iterations of the fuzz target to take t, specified as a
+// 	    time.Duration (for example, -fuzztime 1h30s). The default is to run
+// 	    forever. The special syntax Nx means to run the fuzz target N times
+// 	    (for example, -fuzztime 100x).
+//
+// 	-fuzzminimizetime t
+// 	    Spend at most t, specified as a time.Duration, minimizing an input
+// 	    that caused the fuzz target to fail. The special syntax Nx is also
+// 	    accepted. The default is 60s.
+//
 // 	-list regexp
-// 	    List tests, benchmarks, or examples matching the regular expression.
-// 	    No tests, benchmarks or examples will be run. This will only
-// 	    list top-level tests. No subtest or subbenchmarks will be shown.
+// 	    List tests, benchmarks, fuzz targets, or examples matching the
+// 	    regular expression. No tests, benchmarks, fuzz targets, or examples
+// 	    will be run. This will only list top-level tests. No subtest or
+// 	    subbenchmarks will be shown.
 //
 // 	-parallel n
 // 	    Allow parallel execution of test functions that call t.Parallel.
@@ -3173,7 +3205,8 @@
 // 	    (see 'go help build').
 //
 // 	-run regexp
-// 	    Run only those tests and examples matching the regular expression.
+// 	    Run only those tests, examples, and fuzz targets matching the
+// 	    regular expression.
 // 	    For tests, the regular expression is split by unbracketed slash (/)
 // 	    characters into a sequence of regular expressions, and each part
 // 	    of a test's identifier must match the corresponding element in
@@ -3191,7 +3224,8 @@
 // 	-timeout d
 // 	    If a test binary runs longer than duration d, panic.
 // 	    If d is 0, the timeout is disabled.
-// 	    The default is 10 minutes (10m).
+// 	    The default is 10 minutes (10m), or no timeout when fuzzing
+// 	    with -fuzz.
 //
 // 	-v
 // 	    Verbose output: log all tests as they are run. Also print all
@@ -3324,8 +3358,9 @@
 //
 // Testing functions
 //
-// The 'go test' command expects to find test, benchmark, and example functions
-// in the "*_test.go" files corresponding to the package under test.
+// The 'go test' command expects to find test, benchmark, fuzz target, and
+// example functions in the "*_test.go" files corresponding to the package
+// under test.
 //
 // A test function is one named TestXxx (where Xxx does not start with a
 // lower case letter) and should have the signature,
@@ -3336,6 +3371,10 @@
 //
 // 	func BenchmarkXxx(b *testing.B) { ... }
 //
+// A fuzz target is one named FuzzXxx and should have the signature,
+//
+// 	func FuzzXxx(f *testing.F) { ... }
+//
 // An example function is similar to a test function but, instead of using
 // *testing.T to report success or failure, prints output to os.Stdout.
 // If the last comment in the function starts with "Output:" then the output
@@ -40,6 +40,10 @@ type TestCover struct {
 	Paths    []string
 	Vars     []coverInfo
 	DeclVars func(*Package, ...string) map[string]*CoverVar
+
+	// FuzzOnly reports that coverage is only instrumented to guide fuzzing,
+	// and was not requested by the user. No coverage report is printed.
+	FuzzOnly bool
 }
 
 // TestPackagesFor is like TestPackagesAndErrors but it returns
@@ -122,6 +126,17 @@ func TestPackagesAndErrors(ctx context.Context, p *Package, cover *TestCover) (p
 		p.TestImports[i] = p1.ImportPath
 		imports = append(imports, p1)
 	}
+	var err error
+	var testEmbed map[string][]string
+	p.TestEmbedFiles, testEmbed, err = resolveEmbed(p.Dir, p.TestEmbedPatterns)
+	if err != nil && ptestErr == nil {
+		ptestErr = &PackageError{
+			ImportStack: stk.Copy(),
+			Err:         err,
+		}
+		embedErr := err.(*EmbedError)
+		ptestErr.setPos(p.Internal.Build.TestEmbedPatternPos[embedErr.Pattern])
+	}
 	stk.Pop()
 	stk.Push(p.ImportPath + "_test")
 	pxtestNeedsPtest := false
@@ -135,6 +150,16 @@ func TestPackagesAndErrors(ctx context.Context, p *Package, cover *TestCover) (p
 		}
 		p.XTestImports[i] = p1.ImportPath
 	}
+	var xtestEmbed map[string][]string
+	p.XTestEmbedFiles, xtestEmbed, err = resolveEmbed(p.Dir, p.XTestEmbedPatterns)
+	if err != nil && pxtestErr == nil {
+		pxtestErr = &PackageError{
+			ImportStack: stk.Copy(),
+			Err:         err,
+		}
+		embedErr := err.(*EmbedError)
+		pxtestErr.setPos(p.Internal.Build.XTestEmbedPatternPos[embedErr.Pattern])
+	}
 	stk.Pop()
 
 	// Test package.
@@ -147,6 +172,17 @@ func TestPackagesAndErrors(ctx context.Context, p *Package, cover *TestCover) (p
 		ptest.GoFiles = append(ptest.GoFiles, p.GoFiles...)
 		ptest.GoFiles = append(ptest.GoFiles, p.TestGoFiles...)
 		ptest.Target = ""
+		if len(p.TestEmbedPatterns) > 0 {
+			ptest.EmbedPatterns = str.StringList(p.EmbedPatterns, p.TestEmbedPatterns)
+			ptest.EmbedFiles = str.StringList(p.EmbedFiles, p.TestEmbedFiles)
+			ptest.Internal.Embed = map[string][]string{}
+			for k, v := range p.Internal.Embed {
+				ptest.Internal.Embed[k] = v
+			}
+			for k, v := range testEmbed {
+				ptest.Internal.Embed[k] = v
+			}
+		}
 		// Note: The preparation of the vet config requires that common
 		// indexes in ptest.Imports and ptest.Internal.RawImports
 		// all line up (but RawImports can be shorter than the others).
@@ -183,16 +219,18 @@ func TestPackagesAndErrors(ctx context.Context, p *Package, cover *TestCover) (p
 	if len(p.XTestGoFiles) > 0 {
 		pxtest = &Package{
 			PackagePublic: PackagePublic{
-				Name:       p.Name + "_test",
-				ImportPath: p.ImportPath + "_test",
-				Root:       p.Root,
-				Dir:        p.Dir,
-				Goroot:     p.Goroot,
-				GoFiles:    p.XTestGoFiles,
-				Imports:    p.XTestImports,
-				ForTest:    p.ImportPath,
-				Module:     p.Module,
-				Error:      pxtestErr,
+				Name:          p.Name + "_test",
+				ImportPath:    p.ImportPath + "_test",
+				Root:          p.Root,
+				Dir:           p.Dir,
+				Goroot:        p.Goroot,
+				GoFiles:       p.XTestGoFiles,
+				Imports:       p.XTestImports,
+				ForTest:       p.ImportPath,
+				Module:        p.Module,
+				Error:         pxtestErr,
+				EmbedPatterns: p.XTestEmbedPatterns,
+				EmbedFiles:    p.XTestEmbedFiles,
 			},
 			Internal: PackageInternal{
 				LocalPrefix: p.Internal.LocalPrefix,
@@ -201,6 +239,7 @@ func TestPackagesAndErrors(ctx context.Context, p *Package, cover *TestCover) (p
 				},
 				Imports:    ximports,
 				RawImports: rawXTestImports,
+				Embed:      xtestEmbed,
 
 				Asmflags:   p.Internal.Asmflags,
 				Gcflags:    p.Internal.Gcflags,
@@ -419,7 +458,7 @@ func recompileForTest(pmain, preal, ptest, pxtest *Package) {
 }
 
 // isTestFunc tells whether fn has the type of a testing function. arg
-// specifies the parameter type we look for: B, M or T.
+// specifies the parameter type we look for: B, F, M or T.
 func isTestFunc(fn *ast.FuncDecl, arg string) bool {
 	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 ||
 		fn.Type.Params.List == nil ||
@@ -434,7 +473,7 @@ func isTestFunc(fn *ast.FuncDecl, arg string) bool {
 	// We can't easily check that the type is *testing.M
 	// because we don't know how testing has been imported,
 	// but at least check that it's *M or *something.M.
-	// Same applies for B and T.
+	// Same applies for B, F and T.
 	if name, ok := ptr.X.(*ast.Ident); ok && name.Name == arg {
 		return true
 	}
@@ -444,7 +483,8 @@ func isTestFunc(fn *ast.FuncDecl, arg string) bool {
 	return false
 }
 
-// isTest tells whether name looks like a test (or benchmark, according to prefix).
+// isTest tells whether name looks like a test (or benchmark or fuzz target,
+// according to prefix).
 // It is a Test (say) if there is a character after Test that is not a lower-case letter.
 // We don't want TesticularCancer.
 func isTest(name, prefix string) bool {
@@ -496,6 +536,7 @@ func formatTestmain(t *testFuncs) ([]byte, error) {
 type testFuncs struct {
 	Tests       []testFunc
 	Benchmarks  []testFunc
+	FuzzTargets []testFunc
 	Examples    []testFunc
 	TestMain    *testFunc
 	Package     *Package
@@ -588,6 +629,13 @@ func (t *testFuncs) load(filename, pkg string, doImport, seen *bool) error {
 			}
 			t.Benchmarks = append(t.Benchmarks, testFunc{pkg, name, "", false})
 			*doImport, *seen = true, true
+		case isTest(name, "Fuzz"):
+			err := checkTestFunc(n, "F")
+			if err != nil {
+				return err
+			}
+			t.FuzzTargets = append(t.FuzzTargets, testFunc{pkg, name, "", false})
+			*doImport, *seen = true, true
 		}
 	}
 	ex := doc.Examples(f)
@@ -651,6 +699,12 @@ var benchmarks = []testing.InternalBenchmark{
 {{end}}
 }
 
+var fuzzTargets = []testing.InternalFuzzTarget{
+{{range .FuzzTargets}}
+	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{end}}
+}
+
 var examples = []testing.InternalExample{
 {{range .Examples}}
 	{"{{.Name}}", {{.Package}}.{{.Name}}, {{.Output | printf "%q"}}, {{.Unordered}}},
@@ -702,6 +756,8 @@ func coverRegisterFile(fileName string, counter []uint32, pos []uint32, numStmts
 
 func main() {
 {{if .Cover}}
+	testdeps.CoverCounters = coverCounters
+{{if not .Cover.FuzzOnly}}
 	testing.RegisterCover(testing.Cover{
 		Mode: {{printf "%q" .Cover.Mode}},
 		Counters: coverCounters,
@@ -709,7 +765,8 @@ func main() {
 		CoveredPackages: {{printf "%q" .Covered}},
 	})
 {{end}}
-	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, examples)
+{{end}}
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, fuzzTargets, examples)
 {{with .TestMain}}
 	{{.Package}}.{{.Name}}(m)
 	os.Exit(int(reflect.ValueOf(m).Elem().FieldByName("exitCode").Int()))
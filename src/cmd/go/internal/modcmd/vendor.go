@@ -7,7 +7,9 @@ package modcmd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"go/build"
 	"io"
 	"io/ioutil"
 	"os"
@@ -18,6 +20,7 @@ import (
 	"cmd/go/internal/base"
 	"cmd/go/internal/cfg"
 	"cmd/go/internal/imports"
+	"cmd/go/internal/load"
 	"cmd/go/internal/modload"
 	"cmd/go/internal/work"
 
@@ -169,6 +172,28 @@ func vendorPkg(vdir, pkg string) {
 	if m := modload.PackageModule(realPath); m.Path != "" {
 		copyMetadata(m.Path, realPath, dst, src)
 	}
+
+	// Copy the files embedded with //go:embed, which may live in
+	// subdirectories that copyDir does not visit.
+	var noGoError *build.NoGoError
+	var multiplePackageError *build.MultiplePackageError
+	bp, err := build.ImportDir(src, build.IgnoreVendor)
+	if errors.As(err, &noGoError) {
+		return // No source files in this package are built, so nothing is embedded.
+	} else if err != nil && !errors.As(err, &multiplePackageError) {
+		base.Fatalf("go mod vendor: finding embedded files of %s: %v", pkg, err)
+	}
+	embeds, err := load.ResolveEmbed(bp.Dir, bp.EmbedPatterns)
+	if err != nil {
+		base.Fatalf("go mod vendor: %v", err)
+	}
+	for _, embed := range embeds {
+		embedDst := filepath.Join(dst, filepath.FromSlash(embed))
+		if err := os.MkdirAll(filepath.Dir(embedDst), 0777); err != nil {
+			base.Fatalf("go mod vendor: %v", err)
+		}
+		copyFile(embedDst, filepath.Join(src, filepath.FromSlash(embed)))
+	}
 }
 
 type metakey struct {
@@ -267,20 +292,25 @@ func copyDir(dst, src string, match func(dir string, info os.FileInfo) bool) {
 		if file.IsDir() || !file.Mode().IsRegular() || !match(src, file) {
 			continue
 		}
-		r, err := os.Open(filepath.Join(src, file.Name()))
-		if err != nil {
-			base.Fatalf("go mod vendor: %v", err)
-		}
-		w, err := os.Create(filepath.Join(dst, file.Name()))
-		if err != nil {
-			base.Fatalf("go mod vendor: %v", err)
-		}
-		if _, err := io.Copy(w, r); err != nil {
-			base.Fatalf("go mod vendor: %v", err)
-		}
-		r.Close()
-		if err := w.Close(); err != nil {
-			base.Fatalf("go mod vendor: %v", err)
-		}
+		copyFile(filepath.Join(dst, file.Name()), filepath.Join(src, file.Name()))
+	}
+}
+
+// copyFile copies the file src to dst.
+func copyFile(dst, src string) {
+	r, err := os.Open(src)
+	if err != nil {
+		base.Fatalf("go mod vendor: %v", err)
+	}
+	w, err := os.Create(dst)
+	if err != nil {
+		base.Fatalf("go mod vendor: %v", err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		base.Fatalf("go mod vendor: %v", err)
+	}
+	r.Close()
+	if err := w.Close(); err != nil {
+		base.Fatalf("go mod vendor: %v", err)
 	}
 }
@@ -241,10 +241,30 @@ control the execution of any test:
 	-failfast
 	    Do not start new tests after the first test failure.
 
+	-fuzz regexp
+	    Run the fuzz target matching the regular expression. When specified,
+	    the command line argument must match exactly one package, and regexp
+	    must match exactly one fuzz target within that package. After tests,
+	    benchmarks, seed corpora of other fuzz targets, and examples have
+	    completed, the matching target will be fuzzed. See the Fuzzing
+	    section of the testing package documentation for details.
+
+	-fuzztime t
+	    Run enough iterations of the fuzz target to take t, specified as a
+	    time.Duration (for example, -fuzztime 1h30s). The default is to run
+	    forever. The special syntax Nx means to run the fuzz target N times
+	    (for example, -fuzztime 100x).
+
+	-fuzzminimizetime t
+	    Spend at most t, specified as a time.Duration, minimizing an input
+	    that caused the fuzz target to fail. The special syntax Nx is also
+	    accepted. The default is 60s.
+
 	-list regexp
-	    List tests, benchmarks, or examples matching the regular expression.
-	    No tests, benchmarks or examples will be run. This will only
-	    list top-level tests. No subtest or subbenchmarks will be shown.
+	    List tests, benchmarks, fuzz targets, or examples matching the
+	    regular expression. No tests, benchmarks, fuzz targets, or examples
+	    will be run. This will only list top-level tests. No subtest or
+	    subbenchmarks will be shown.
 
 	-parallel n
 	    Allow parallel execution of test functions that call t.Parallel.
@@ -256,7 +276,8 @@ control the execution of any test:
 	    (see 'go help build').
 
 	-run regexp
-	    Run only those tests and examples matching the regular expression.
+	    Run only those tests, examples, and fuzz targets matching the
+	    regular expression.
 	    For tests, the regular expression is split by unbracketed slash (/)
 	    characters into a sequence of regular expressions, and each part
 	    of a test's identifier must match the corresponding element in
@@ -274,7 +295,8 @@ control the execution of any test:
 	-timeout d
 	    If a test binary runs longer than duration d, panic.
 	    If d is 0, the timeout is disabled.
-	    The default is 10 minutes (10m).
+	    The default is 10 minutes (10m), or no timeout when fuzzing
+	    with -fuzz.
 
 	-v
 	    Verbose output: log all tests as they are run. Also print all
@@ -410,8 +432,9 @@ var HelpTestfunc = &base.Command{
 	UsageLine: "testfunc",
 	Short:     "testing functions",
 	Long: `
-The 'go test' command expects to find test, benchmark, and example functions
-in the "*_test.go" files corresponding to the package under test.
+The 'go test' command expects to find test, benchmark, fuzz target, and
+example functions in the "*_test.go" files corresponding to the package
+under test.
 
 A test function is one named TestXxx (where Xxx does not start with a
 lower case letter) and should have the signature,
@@ -422,6 +445,10 @@ A benchmark function is one named BenchmarkXxx and should have the signature,
 
 	func BenchmarkXxx(b *testing.B) { ... }
 
+A fuzz target is one named FuzzXxx and should have the signature,
+
+	func FuzzXxx(f *testing.F) { ... }
+
 An example function is similar to a test function but, instead of using
 *testing.T to report success or failure, prints output to os.Stdout.
 If the last comment in the function starts with "Output:" then the output
@@ -475,6 +502,7 @@ var (
 	testCoverPaths   []string                          // -coverpkg flag
 	testCoverPkgs    []*load.Package                   // -coverpkg flag
 	testCoverProfile string                            // -coverprofile flag
+	testFuzz         string                            // -fuzz flag
 	testJSON         bool                              // -json flag
 	testList         string                            // -list flag
 	testO            string                            // -o flag
@@ -609,6 +637,9 @@ func runTest(ctx context.Context, cmd *base.Command, args []string) {
 	if testProfile() != "" && len(pkgs) != 1 {
 		base.Fatalf("cannot use %s flag with multiple packages", testProfile())
 	}
+	if testFuzz != "" && len(pkgs) != 1 {
+		base.Fatalf("cannot use -fuzz flag with multiple packages")
+	}
 	initCoverProfile()
 	defer closeCoverProfile()
 
@@ -763,6 +794,11 @@ func runTest(ctx context.Context, cmd *base.Command, args []string) {
 		if testCover && testCoverMode == "atomic" {
 			ensureImport(p, "sync/atomic")
 		}
+		// Fuzzing instruments the package under test for coverage, in
+		// atomic mode when the race detector is enabled.
+		if testFuzz != "" && !testCover && cfg.BuildRace {
+			ensureImport(p, "sync/atomic")
+		}
 
 		buildTest, runTest, printTest, err := builderTest(&b, ctx, p)
 		if err != nil {
@@ -853,6 +889,20 @@ func builderTest(b *work.Builder, ctx context.Context, p *load.Package) (buildAc
 			Paths:    testCoverPaths,
 			DeclVars: declareCoverVars,
 		}
+	} else if testFuzz != "" {
+		// Fuzzing uses the coverage counters of the package under test to
+		// find interesting inputs, so instrument it even if -cover was not
+		// given. No coverage report is printed in that case.
+		mode := "count"
+		if cfg.BuildRace {
+			mode = "atomic"
+		}
+		cover = &load.TestCover{
+			Mode:     mode,
+			Local:    true,
+			DeclVars: declareCoverVars,
+			FuzzOnly: true,
+		}
 	}
 	pmain, ptest, pxtest, err := load.TestPackagesFor(ctx, p, cover)
 	if err != nil {
@@ -1109,10 +1159,10 @@ func (c *runCache) builderRunTest(b *work.Builder, ctx context.Context, a *work.
 	}
 
 	var buf bytes.Buffer
-	if len(pkgArgs) == 0 || (testBench != "") {
+	if len(pkgArgs) == 0 || testBench != "" || testFuzz != "" {
 		// Stream test output (no buffering) when no package has
 		// been given on the command line (implicit current directory)
-		// or when benchmarking.
+		// or when benchmarking or fuzzing.
 		// No change to stdout.
 	} else {
 		// If we're only running a single package under test or if parallelism is
@@ -1165,7 +1215,12 @@ func (c *runCache) builderRunTest(b *work.Builder, ctx context.Context, a *work.
 		testlogArg = []string{"-test.testlogfile=" + a.Objdir + "testlog.txt"}
 	}
 	panicArg := "-test.paniconexit0"
-	args := str.StringList(execCmd, a.Deps[0].BuiltTarget(), testlogArg, panicArg, testArgs)
+	fuzzArg := []string{}
+	if testFuzz != "" {
+		fuzzCacheDir := filepath.Join(cache.Default().FuzzDir(), a.Package.ImportPath)
+		fuzzArg = []string{"-test.fuzzcachedir=" + fuzzCacheDir}
+	}
+	args := str.StringList(execCmd, a.Deps[0].BuiltTarget(), testlogArg, panicArg, fuzzArg, testArgs)
 
 	if testCoverProfile != "" {
 		// Write coverage to temporary profile, for merging later.
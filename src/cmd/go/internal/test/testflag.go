@@ -56,6 +56,9 @@ func init() {
 	cf.String("cpu", "", "")
 	cf.StringVar(&testCPUProfile, "cpuprofile", "", "")
 	cf.Bool("failfast", false, "")
+	cf.StringVar(&testFuzz, "fuzz", "", "")
+	cf.String("fuzzminimizetime", "", "")
+	cf.String("fuzztime", "", "")
 	cf.StringVar(&testList, "list", "", "")
 	cf.StringVar(&testMemProfile, "memprofile", "", "")
 	cf.String("memprofilerate", "", "")
@@ -341,7 +344,11 @@ func testFlags(args []string) (packageNames, passToTest []string) {
 
 	// 'go test' has a default timeout, but the test binary itself does not.
 	// If the timeout wasn't set (and forwarded) explicitly, add the default
-	// timeout to the command line.
+	// timeout to the command line. Fuzzing runs until it finds a problem or
+	// -fuzztime runs out, so it has no default timeout.
+	if testFuzz != "" && !timeoutSet {
+		testTimeout = 0
+	}
 	if testTimeout > 0 && !timeoutSet {
 		injectedFlags = append(injectedFlags, fmt.Sprintf("-test.timeout=%v", testTimeout))
 	}
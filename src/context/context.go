@@ -22,6 +22,12 @@
 // fires. The go vet tool checks that CancelFuncs are used on all
 // control-flow paths.
 //
+// The WithCancelCause function returns a CancelCauseFunc, which
+// takes an error and records it as the cancellation cause. Calling
+// Cause on the canceled context or any of its children retrieves
+// the cause. If no cause is specified, Cause(ctx) returns the same
+// value as ctx.Err().
+//
 // Programs that use Contexts should follow these rules to keep interfaces
 // consistent across packages and enable static analysis tools to check context
 // propagation:
@@ -230,17 +236,128 @@ type CancelFunc func()
 // Canceling this context releases resources associated with it, so code should
 // call cancel as soon as the operations running in this Context complete.
 func WithCancel(parent Context) (ctx Context, cancel CancelFunc) {
+	c := withCancel(parent)
+	return c, func() { c.cancel(true, Canceled, nil) }
+}
+
+// A CancelCauseFunc behaves like a CancelFunc but additionally sets the cancellation cause.
+// This cause can be retrieved by calling Cause on the canceled Context or on
+// any of its derived Contexts.
+//
+// If the context has already been canceled, CancelCauseFunc does not set the cause.
+// For example, if childContext is derived from parentContext:
+//   - if parentContext is canceled with cause1 before childContext is canceled with cause2,
+//     then Cause(parentContext) == Cause(childContext) == cause1
+//   - if childContext is canceled with cause2 before parentContext is canceled with cause1,
+//     then Cause(parentContext) == cause1 and Cause(childContext) == cause2
+type CancelCauseFunc func(cause error)
+
+// WithCancelCause behaves like WithCancel but returns a CancelCauseFunc instead of a CancelFunc.
+// Calling cancel with a non-nil error (the "cause") records that error in ctx;
+// it can then be retrieved using Cause(ctx).
+// Calling cancel with nil sets the cause to Canceled.
+//
+// Example use:
+//
+// 	ctx, cancel := context.WithCancelCause(parent)
+// 	cancel(myError)
+// 	ctx.Err() // returns context.Canceled
+// 	context.Cause(ctx) // returns myError
+func WithCancelCause(parent Context) (ctx Context, cancel CancelCauseFunc) {
+	c := withCancel(parent)
+	return c, func(cause error) { c.cancel(true, Canceled, cause) }
+}
+
+func withCancel(parent Context) *cancelCtx {
 	if parent == nil {
 		panic("cannot create context from nil parent")
 	}
-	c := newCancelCtx(parent)   //将传入的上下文包装成私有结构体 context.cancelCtx
-	propagateCancel(parent, &c) //会构建父子上下文之间的关联，当父上下文被取消时，子上下文也会被取消
-	return &c, func() { c.cancel(true, Canceled) }
+	c := &cancelCtx{}            //将传入的上下文包装成私有结构体 context.cancelCtx
+	c.propagateCancel(parent, c) //会构建父子上下文之间的关联，当父上下文被取消时，子上下文也会被取消
+	return c
+}
+
+// Cause returns a non-nil error explaining why c was canceled.
+// The first cancellation of c or one of its parents sets the cause.
+// If that cancellation happened via a call to CancelCauseFunc(err),
+// then Cause returns err.
+// Otherwise Cause(c) returns the same value as c.Err().
+// Cause returns nil if c has not been canceled yet.
+func Cause(c Context) error {
+	if cc, ok := c.Value(&cancelCtxKey).(*cancelCtx); ok {
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		return cc.cause
+	}
+	// There is no cancelCtxKey value, so we know that c is
+	// not a descendant of some Context created by WithCancelCause.
+	// Therefore, there is no specific cause to return.
+	// If this is not one of the standard Context types,
+	// it might still have an error even though it won't have a cause.
+	return c.Err()
+}
+
+// AfterFunc arranges to call f in its own goroutine after ctx is done
+// (canceled or timed out).
+// If ctx is already done, AfterFunc calls f immediately in its own goroutine.
+//
+// Multiple calls to AfterFunc on a context operate independently;
+// one does not replace another.
+//
+// Calling the returned stop function stops the association of ctx with f.
+// It returns true if the call stopped f from being run.
+// If stop returns false,
+// either the context is done and f has been started in its own goroutine;
+// or f was already stopped.
+// The stop function does not wait for f to complete before returning.
+// If the caller needs to know whether f is completed,
+// it must coordinate with f explicitly.
+//
+// If ctx has a "AfterFunc(func()) func() bool" method,
+// AfterFunc will use it to schedule the call.
+func AfterFunc(ctx Context, f func()) (stop func() bool) {
+	a := &afterFuncCtx{
+		f: f,
+	}
+	a.cancelCtx.propagateCancel(ctx, a)
+	return func() bool {
+		stopped := false
+		a.once.Do(func() {
+			stopped = true
+		})
+		if stopped {
+			a.cancel(true, Canceled, nil)
+		}
+		return stopped
+	}
+}
+
+type afterFuncer interface {
+	AfterFunc(func()) func() bool
+}
+
+type afterFuncCtx struct {
+	cancelCtx
+	once sync.Once // either starts running f or stops f from running
+	f    func()
+}
+
+func (a *afterFuncCtx) cancel(removeFromParent bool, err, cause error) {
+	a.cancelCtx.cancel(false, err, cause)
+	if removeFromParent {
+		removeChild(a.Context, a)
+	}
+	a.once.Do(func() {
+		go a.f()
+	})
 }
 
-// newCancelCtx returns an initialized cancelCtx.
-func newCancelCtx(parent Context) cancelCtx {
-	return cancelCtx{Context: parent}
+// A stopCtx is used as the parent context of a cancelCtx when
+// an AfterFunc has been registered with the parent.
+// It holds the stop function used to unregister the AfterFunc.
+type stopCtx struct {
+	Context
+	stop func() bool
 }
 
 //记录当前已经创建的goroutine数量
@@ -248,9 +365,12 @@ func newCancelCtx(parent Context) cancelCtx {
 var goroutines int32
 
 // propagateCancel arranges for child to be canceled when parent is.
+// It sets the parent context of cancelCtx.
 //构建父子上下文之间的关联，当父上下文被取消时，子上下文也会被取消
 //在 parent 和 child 之间同步取消和结束的信号，保证在 parent 被取消时，child 也会收到对应的信号，不会发生状态不一致的问题
-func propagateCancel(parent Context, child canceler) {
+func (c *cancelCtx) propagateCancel(parent Context, child canceler) {
+	c.Context = parent
+
 	done := parent.Done()
 	if done == nil { //当 parent.Done() == nil，也就是 parent 不会触发取消事件时，当前函数会直接返回；（说明父上下文根本就没有结束这个操作（不是调用 context.WithCancel或者context.WithDeadline生成的，没有对应的cancel方法）（从语义上 done表示context完成或者取消，如果done为nil，说明外部根本就没有办法通过done感知到 context是否结束)，这样的话，就不用构建 父子上下文之间的关联了（反正父上下文也不会被取消，所以子上下文 只有在自己的cancel方法被执行或者过期的时候 才会被cancel））
 		return // parent is never canceled
@@ -259,7 +379,7 @@ func propagateCancel(parent Context, child canceler) {
 	select {
 	case <-done: // 父上下文已经被取消，则
 		// parent is already canceled
-		child.cancel(false, parent.Err()) //取消子上下文，并向所有的子上下文同步取消信号
+		child.cancel(false, parent.Err(), Cause(parent)) //取消子上下文，并向所有的子上下文同步取消信号
 		return
 	default:
 	}
@@ -268,7 +388,7 @@ func propagateCancel(parent Context, child canceler) {
 		p.mu.Lock()
 		if p.err != nil { //如果父context已经取消，则直接取消 子context
 			// parent has already been canceled
-			child.cancel(false, p.err) //取消子上下文
+			child.cancel(false, p.err, p.cause) //取消子上下文
 		} else {
 			if p.children == nil {
 				p.children = make(map[canceler]struct{})
@@ -276,16 +396,31 @@ func propagateCancel(parent Context, child canceler) {
 			p.children[child] = struct{}{} //c否则child 会被加入 parent 的 children 列表中，等待 parent 释放取消信号
 		}
 		p.mu.Unlock()
-	} else { //如果父上下文没有对应的cancelCtx结构体（内部实现其实是父context是 cancelCtx结构体，其实就是没有cancel方法）
-		atomic.AddInt32(&goroutines, +1) //运行一个新的 Goroutine 同时监听 parent.Done() 和 child.Done() 两个 Channel
-		go func() {
-			select {
-			case <-parent.Done(): //监听parent.Done()，接收到 done消息则 cancel掉子context
-				child.cancel(false, parent.Err()) //在 parent.Done() 关闭时调用 child.cancel 取消子上下文
-			case <-child.Done(): // 监听 子context.Done()的消息，如果done 也直接返回
-			}
-		}()
+		return
+	}
+
+	if a, ok := parent.(afterFuncer); ok { //父上下文自己实现了 AfterFunc 方法，由它负责在结束时通知子上下文，不需要额外的 Goroutine
+		c.mu.Lock()
+		stop := a.AfterFunc(func() {
+			child.cancel(false, parent.Err(), Cause(parent))
+		})
+		c.Context = stopCtx{
+			Context: parent,
+			stop:    stop,
+		}
+		c.mu.Unlock()
+		return
 	}
+
+	//如果父上下文没有对应的cancelCtx结构体（内部实现其实是父context是 cancelCtx结构体，其实就是没有cancel方法）
+	atomic.AddInt32(&goroutines, +1) //运行一个新的 Goroutine 同时监听 parent.Done() 和 child.Done() 两个 Channel
+	go func() {
+		select {
+		case <-parent.Done(): //监听parent.Done()，接收到 done消息则 cancel掉子context
+			child.cancel(false, parent.Err(), Cause(parent)) //在 parent.Done() 关闭时调用 child.cancel 取消子上下文
+		case <-child.Done(): // 监听 子context.Done()的消息，如果done 也直接返回
+		}
+	}()
 }
 
 // &cancelCtxKey is the key that a cancelCtx returns itself for.
@@ -324,6 +459,10 @@ func parentCancelCtx(parent Context) (*cancelCtx, bool) {
 // removeChild removes a context from its parent.
 //将context从 他们parent的cancelCtx中child元素中移除
 func removeChild(parent Context, child canceler) {
+	if s, ok := parent.(stopCtx); ok { //父上下文是通过 AfterFunc 关联的，注销对应的回调即可
+		s.stop()
+		return
+	}
 	//parentCancelCtx返回parent对应的cancelCtx
 	p, ok := parentCancelCtx(parent)
 	if !ok { //如果parent 没有 cancelCtx，直接返回
@@ -337,9 +476,9 @@ func removeChild(parent Context, child canceler) {
 }
 
 // A canceler is a context type that can be canceled directly. The
-// implementations are *cancelCtx and *timerCtx.
+// implementations are *cancelCtx, *timerCtx and *afterFuncCtx.
 type canceler interface {
-	cancel(removeFromParent bool, err error)
+	cancel(removeFromParent bool, err, cause error)
 	Done() <-chan struct{}
 }
 
@@ -359,6 +498,7 @@ type cancelCtx struct {
 	done     chan struct{}         // created lazily, closed by first cancel call 懒加载，第一次调用cancel函数或者Done()的时候 才会被赋值
 	children map[canceler]struct{} // set to nil by the first cancel call 子cancel context列表
 	err      error                 // set to non-nil by the first cancel call cancel原因，如果不为nil，则表示已经被cancel
+	cause    error                 // set to non-nil by the first cancel call 调用方记录的取消原因，由 Cause 返回
 }
 
 func (c *cancelCtx) Value(key interface{}) interface{} {
@@ -402,18 +542,23 @@ func (c *cancelCtx) String() string {
 
 // cancel closes c.done, cancels each of c's children, and, if
 // removeFromParent is true, removes c from its parent's children.
+// cancel sets c.cause to cause if this is the first time c is canceled.
 // 关闭上下文中的 Channel 并向所有的子上下文同步取消信号
 //如果 removeFromParent为true，则将 c从他的parent的chilren列表中移除
-func (c *cancelCtx) cancel(removeFromParent bool, err error) {
+func (c *cancelCtx) cancel(removeFromParent bool, err, cause error) {
 	if err == nil {
 		panic("context: internal error: missing cancel error")
 	}
+	if cause == nil {
+		cause = err
+	}
 	c.mu.Lock()
 	if c.err != nil {
 		c.mu.Unlock()
 		return // already canceled
 	}
 	c.err = err
+	c.cause = cause
 	if c.done == nil { //关闭done channel
 		c.done = closedchan
 	} else {
@@ -421,7 +566,7 @@ func (c *cancelCtx) cancel(removeFromParent bool, err error) {
 	}
 	for child := range c.children { //将子 context全部关闭
 		// NOTE: acquiring the child's lock while holding parent's lock.
-		child.cancel(false, err)
+		child.cancel(false, err, cause)
 	}
 	c.children = nil
 	c.mu.Unlock()
@@ -443,6 +588,13 @@ func (c *cancelCtx) cancel(removeFromParent bool, err error) {
 //WithDeadline在创建 context.timerCtx 的过程中，判断了父上下文的截止日期与当前日期，
 //并通过 time.AfterFunc 创建定时器，当时间超过了截止日期后会调用 context.timerCtx.cancel 方法同步取消信号
 func WithDeadline(parent Context, d time.Time) (Context, CancelFunc) {
+	return WithDeadlineCause(parent, d, nil)
+}
+
+// WithDeadlineCause behaves like WithDeadline but also sets the cause of the
+// returned Context when the deadline is exceeded. The returned CancelFunc does
+// not set the cause.
+func WithDeadlineCause(parent Context, d time.Time, cause error) (Context, CancelFunc) {
 	if parent == nil {
 		panic("cannot create context from nil parent")
 	}
@@ -451,24 +603,23 @@ func WithDeadline(parent Context, d time.Time) (Context, CancelFunc) {
 		return WithCancel(parent)
 	}
 	c := &timerCtx{
-		cancelCtx: newCancelCtx(parent),
-		deadline:  d,
+		deadline: d,
 	}
 	//建立父子之间的级联关系
-	propagateCancel(parent, c)
+	c.cancelCtx.propagateCancel(parent, c)
 	dur := time.Until(d) //计算当前时间到 截止时间之间的 间隔
 	if dur <= 0 {        //如果已经过了截止时间
-		c.cancel(true, DeadlineExceeded)               // deadline has already passed 直接cancel当前的上下文
-		return c, func() { c.cancel(false, Canceled) } //返回已经被cancel的上下文
+		c.cancel(true, DeadlineExceeded, cause)             // deadline has already passed 直接cancel当前的上下文
+		return c, func() { c.cancel(false, Canceled, nil) } //返回已经被cancel的上下文
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.err == nil {
 		c.timer = time.AfterFunc(dur, func() {
-			c.cancel(true, DeadlineExceeded) //定时cancel上下文
+			c.cancel(true, DeadlineExceeded, cause) //定时cancel上下文
 		})
 	}
-	return c, func() { c.cancel(true, Canceled) } //返回 cancel函数（还可以通过cancel函数来cancel上下文）
+	return c, func() { c.cancel(true, Canceled, nil) } //返回 cancel函数（还可以通过cancel函数来cancel上下文）
 }
 
 // A timerCtx carries a timer and a deadline. It embeds a cancelCtx to
@@ -493,8 +644,8 @@ func (c *timerCtx) String() string {
 }
 
 //context.timerCtx.cancel 方法不仅调用了 context.cancelCtx.cancel，还会停止持有的定时器减少不必要的资源浪费。
-func (c *timerCtx) cancel(removeFromParent bool, err error) {
-	c.cancelCtx.cancel(false, err)
+func (c *timerCtx) cancel(removeFromParent bool, err, cause error) {
+	c.cancelCtx.cancel(false, err, cause)
 	if removeFromParent {
 		// Remove this timerCtx from its parent cancelCtx's children.
 		removeChild(c.cancelCtx.Context, c)
@@ -522,6 +673,13 @@ func WithTimeout(parent Context, timeout time.Duration) (Context, CancelFunc) {
 	return WithDeadline(parent, time.Now().Add(timeout))
 }
 
+// WithTimeoutCause behaves like WithTimeout but also sets the cause of the
+// returned Context when the timeout expires. The returned CancelFunc does
+// not set the cause.
+func WithTimeoutCause(parent Context, timeout time.Duration, cause error) (Context, CancelFunc) {
+	return WithDeadlineCause(parent, time.Now().Add(timeout), cause)
+}
+
 // WithValue returns a copy of parent in which the value associated with key is
 // val.
 //
@@ -9,23 +9,32 @@ import (
 	"testing"
 )
 
-func TestBackground(t *testing.T)                      { XTestBackground(t) }
-func TestTODO(t *testing.T)                            { XTestTODO(t) }
-func TestWithCancel(t *testing.T)                      { XTestWithCancel(t) }
-func TestParentFinishesChild(t *testing.T)             { XTestParentFinishesChild(t) }
-func TestChildFinishesFirst(t *testing.T)              { XTestChildFinishesFirst(t) }
-func TestDeadline(t *testing.T)                        { XTestDeadline(t) }
-func TestTimeout(t *testing.T)                         { XTestTimeout(t) }
-func TestCanceledTimeout(t *testing.T)                 { XTestCanceledTimeout(t) }
-func TestValues(t *testing.T)                          { XTestValues(t) }
-func TestAllocs(t *testing.T)                          { XTestAllocs(t, testing.Short, testing.AllocsPerRun) }
-func TestSimultaneousCancels(t *testing.T)             { XTestSimultaneousCancels(t) }
-func TestInterlockedCancels(t *testing.T)              { XTestInterlockedCancels(t) }
-func TestLayersCancel(t *testing.T)                    { XTestLayersCancel(t) }
-func TestLayersTimeout(t *testing.T)                   { XTestLayersTimeout(t) }
-func TestCancelRemoves(t *testing.T)                   { XTestCancelRemoves(t) }
-func TestWithCancelCanceledParent(t *testing.T)        { XTestWithCancelCanceledParent(t) }
-func TestWithValueChecksKey(t *testing.T)              { XTestWithValueChecksKey(t) }
-func TestInvalidDerivedFail(t *testing.T)              { XTestInvalidDerivedFail(t) }
-func TestDeadlineExceededSupportsTimeout(t *testing.T) { XTestDeadlineExceededSupportsTimeout(t) }
-func TestCustomContextGoroutines(t *testing.T)         { XTestCustomContextGoroutines(t) }
+func TestBackground(t *testing.T)                       { XTestBackground(t) }
+func TestTODO(t *testing.T)                             { XTestTODO(t) }
+func TestWithCancel(t *testing.T)                       { XTestWithCancel(t) }
+func TestParentFinishesChild(t *testing.T)              { XTestParentFinishesChild(t) }
+func TestChildFinishesFirst(t *testing.T)               { XTestChildFinishesFirst(t) }
+func TestDeadline(t *testing.T)                         { XTestDeadline(t) }
+func TestTimeout(t *testing.T)                          { XTestTimeout(t) }
+func TestCanceledTimeout(t *testing.T)                  { XTestCanceledTimeout(t) }
+func TestValues(t *testing.T)                           { XTestValues(t) }
+func TestAllocs(t *testing.T)                           { XTestAllocs(t, testing.Short, testing.AllocsPerRun) }
+func TestSimultaneousCancels(t *testing.T)              { XTestSimultaneousCancels(t) }
+func TestInterlockedCancels(t *testing.T)               { XTestInterlockedCancels(t) }
+func TestLayersCancel(t *testing.T)                     { XTestLayersCancel(t) }
+func TestLayersTimeout(t *testing.T)                    { XTestLayersTimeout(t) }
+func TestCancelRemoves(t *testing.T)                    { XTestCancelRemoves(t) }
+func TestWithCancelCanceledParent(t *testing.T)         { XTestWithCancelCanceledParent(t) }
+func TestWithValueChecksKey(t *testing.T)               { XTestWithValueChecksKey(t) }
+func TestInvalidDerivedFail(t *testing.T)               { XTestInvalidDerivedFail(t) }
+func TestDeadlineExceededSupportsTimeout(t *testing.T)  { XTestDeadlineExceededSupportsTimeout(t) }
+func TestCustomContextGoroutines(t *testing.T)          { XTestCustomContextGoroutines(t) }
+func TestCause(t *testing.T)                            { XTestCause(t) }
+func TestCauseRace(t *testing.T)                        { XTestCauseRace(t) }
+func TestAfterFuncCalledAfterCancel(t *testing.T)       { XTestAfterFuncCalledAfterCancel(t) }
+func TestAfterFuncCalledAfterTimeout(t *testing.T)      { XTestAfterFuncCalledAfterTimeout(t) }
+func TestAfterFuncCalledImmediately(t *testing.T)       { XTestAfterFuncCalledImmediately(t) }
+func TestAfterFuncNotCalledAfterStop(t *testing.T)      { XTestAfterFuncNotCalledAfterStop(t) }
+func TestAfterFuncCalledAsynchronously(t *testing.T)    { XTestAfterFuncCalledAsynchronously(t) }
+func TestAfterFuncRemovesChild(t *testing.T)            { XTestAfterFuncRemovesChild(t) }
+func TestCustomContextAfterFuncGoroutines(t *testing.T) { XTestCustomContextAfterFuncGoroutines(t) }
@@ -0,0 +1,129 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ecdh
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// nistCurve implements ECDH over a NIST curve on top of crypto/elliptic.
+// Keys are validated when they are created, so the curve operations only
+// ever see scalars in [1, N-1] and points on the curve.
+type nistCurve struct {
+	name  string
+	curve elliptic.Curve
+}
+
+func (c *nistCurve) String() string {
+	return c.name
+}
+
+// scalarSize returns the length of the encoding of a private key.
+func (c *nistCurve) scalarSize() int {
+	return (c.curve.Params().N.BitLen() + 7) / 8
+}
+
+// fieldSize returns the length of the encoding of a field element.
+func (c *nistCurve) fieldSize() int {
+	return (c.curve.Params().BitSize + 7) / 8
+}
+
+var errInvalidPrivateKey = errors.New("crypto/ecdh: invalid private key")
+
+func (c *nistCurve) GenerateKey(rand io.Reader) (*PrivateKey, error) {
+	bitSize := c.curve.Params().N.BitLen()
+	key := make([]byte, c.scalarSize())
+	for {
+		if _, err := io.ReadFull(rand, key); err != nil {
+			return nil, err
+		}
+		// Mask off any excess bits if the size of the order is not a whole
+		// number of bytes, which is only the case for P-521.
+		if bitSize%8 != 0 {
+			key[0] &= byte(1<<uint(bitSize%8)) - 1
+		}
+		// In tests, rand will return all zeros and NewPrivateKey will reject
+		// the zero key. This also makes this function consistent with
+		// crypto/elliptic.GenerateKey.
+		key[1] ^= 0x42
+
+		k, err := c.NewPrivateKey(key)
+		if err == errInvalidPrivateKey {
+			continue
+		}
+		return k, err
+	}
+}
+
+func (c *nistCurve) NewPrivateKey(key []byte) (*PrivateKey, error) {
+	if len(key) != c.scalarSize() {
+		return nil, errInvalidPrivateKey
+	}
+	k := new(big.Int).SetBytes(key)
+	if k.Sign() == 0 || k.Cmp(c.curve.Params().N) >= 0 {
+		return nil, errInvalidPrivateKey
+	}
+	x, y := c.curve.ScalarBaseMult(key)
+	return &PrivateKey{
+		curve:      c,
+		privateKey: append([]byte(nil), key...),
+		publicKey:  &PublicKey{curve: c, publicKey: elliptic.Marshal(c.curve, x, y)},
+	}, nil
+}
+
+func (c *nistCurve) NewPublicKey(key []byte) (*PublicKey, error) {
+	// Unmarshal rejects compressed encodings, the point at infinity,
+	// non-canonical coordinates and points not on the curve.
+	if x, _ := elliptic.Unmarshal(c.curve, key); x == nil {
+		return nil, errors.New("crypto/ecdh: invalid public key")
+	}
+	return &PublicKey{
+		curve:     c,
+		publicKey: append([]byte(nil), key...),
+	}, nil
+}
+
+func (c *nistCurve) ecdh(local *PrivateKey, remote *PublicKey) ([]byte, error) {
+	// NewPublicKey rejects invalid points and the point at infinity, and
+	// NewPrivateKey rejects invalid scalars and the zero value, so in a prime
+	// order group such as the NIST curves the result can't be the point at
+	// infinity.
+	x, y := elliptic.Unmarshal(c.curve, remote.publicKey)
+	if x == nil {
+		return nil, errors.New("crypto/ecdh: invalid public key")
+	}
+	xShared, _ := c.curve.ScalarMult(x, y, local.privateKey)
+	return xShared.FillBytes(make([]byte, c.fieldSize())), nil
+}
+
+// P256 returns a Curve which implements NIST P-256 (FIPS 186-3, section D.2.3),
+// also known as secp256r1 or prime256v1.
+//
+// Multiple invocations of this function will return the same value, which can
+// be used for equality checks and switch statements.
+func P256() Curve { return p256 }
+
+var p256 = &nistCurve{name: "P-256", curve: elliptic.P256()}
+
+// P384 returns a Curve which implements NIST P-384 (FIPS 186-3, section D.2.4),
+// also known as secp384r1.
+//
+// Multiple invocations of this function will return the same value, which can
+// be used for equality checks and switch statements.
+func P384() Curve { return p384 }
+
+var p384 = &nistCurve{name: "P-384", curve: elliptic.P384()}
+
+// P521 returns a Curve which implements NIST P-521 (FIPS 186-3, section D.2.5),
+// also known as secp521r1.
+//
+// Multiple invocations of this function will return the same value, which can
+// be used for equality checks and switch statements.
+func P521() Curve { return p521 }
+
+var p521 = &nistCurve{name: "P-521", curve: elliptic.P521()}
@@ -30,7 +30,9 @@ import (
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/elliptic"
+	"crypto/internal/bigmod"
 	"crypto/internal/randutil"
 	"crypto/sha512"
 	"errors"
@@ -83,6 +85,20 @@ func (pub *PublicKey) Equal(x crypto.PublicKey) bool {
 		pub.Curve == xx.Curve
 }
 
+// ECDH returns pub as an ecdh.PublicKey. It returns an error if the key is
+// invalid according to the definition of ecdh.Curve.NewPublicKey, or if the
+// Curve is not supported by crypto/ecdh.
+func (pub *PublicKey) ECDH() (*ecdh.PublicKey, error) {
+	c := curveToECDH(pub.Curve)
+	if c == nil {
+		return nil, errors.New("ecdsa: unsupported curve by crypto/ecdh")
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("ecdsa: invalid public key")
+	}
+	return c.NewPublicKey(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+}
+
 // PrivateKey represents an ECDSA private key.
 type PrivateKey struct {
 	PublicKey
@@ -105,6 +121,37 @@ func (priv *PrivateKey) Equal(x crypto.PrivateKey) bool {
 	return priv.PublicKey.Equal(&xx.PublicKey) && priv.D.Cmp(xx.D) == 0
 }
 
+// ECDH returns priv as an ecdh.PrivateKey. It returns an error if the key is
+// invalid according to the definition of ecdh.Curve.NewPrivateKey, or if the
+// Curve is not supported by crypto/ecdh.
+func (priv *PrivateKey) ECDH() (*ecdh.PrivateKey, error) {
+	c := curveToECDH(priv.Curve)
+	if c == nil {
+		return nil, errors.New("ecdsa: unsupported curve by crypto/ecdh")
+	}
+	size := (priv.Curve.Params().N.BitLen() + 7) / 8
+	if priv.D.BitLen() > size*8 {
+		return nil, errors.New("ecdsa: invalid private key")
+	}
+	return c.NewPrivateKey(priv.D.FillBytes(make([]byte, size)))
+}
+
+// curveToECDH returns the crypto/ecdh curve matching c, or nil if c is not
+// one of the standard library NIST curves. P-224 is not supported by
+// crypto/ecdh.
+func curveToECDH(c elliptic.Curve) ecdh.Curve {
+	switch c {
+	case elliptic.P256():
+		return ecdh.P256()
+	case elliptic.P384():
+		return ecdh.P384()
+	case elliptic.P521():
+		return ecdh.P521()
+	default:
+		return nil
+	}
+}
+
 // Sign signs digest with priv, reading randomness from rand. The opts argument
 // is not currently used but, in keeping with the crypto.Signer interface,
 // should be the hash function used to digest the message.
@@ -180,14 +227,14 @@ func hashToInt(hash []byte, c elliptic.Curve) *big.Int {
 	return ret
 }
 
-// fermatInverse calculates the inverse of k in GF(P) using Fermat's method.
-// This has better constant-time properties than Euclid's method (implemented
-// in math/big.Int.ModInverse) although math/big itself isn't strictly
-// constant-time so it's not perfect.
-func fermatInverse(k, N *big.Int) *big.Int {
+// fermatInverse calculates the inverse of k in GF(N) using Fermat's method,
+// as k^(N-2) mod N. Unlike Euclid's method (implemented in
+// math/big.Int.ModInverse), the exponentiation is constant-time. n must be N
+// as a bigmod.Modulus.
+func fermatInverse(k *bigmod.Nat, N *big.Int, n *bigmod.Modulus) *bigmod.Nat {
 	two := big.NewInt(2)
 	nMinus2 := new(big.Int).Sub(N, two)
-	return new(big.Int).Exp(k, nMinus2, N)
+	return bigmod.NewNat().Exp(k, nMinus2.Bytes(), n)
 }
 
 var errZeroParam = errors.New("zero parameter")
@@ -242,7 +289,29 @@ func signGeneric(priv *PrivateKey, csprng *cipher.StreamReader, c elliptic.Curve
 	if N.Sign() == 0 {
 		return nil, nil, errZeroParam
 	}
-	var k, kInv *big.Int
+	// The scalar arithmetic is performed in constant time with bigmod, which
+	// requires an odd modulus. The order of any curve usable with ECDSA is a
+	// large prime.
+	if N.Bit(0) == 0 {
+		return nil, nil, errors.New("ecdsa: curve order is even")
+	}
+	n, err := bigmod.NewModulusFromBig(N)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Secret scalars are encoded at the full size of N, so that their
+	// encoding doesn't leak their bit length.
+	scalarSize := (N.BitLen() + 7) / 8
+	if priv.D.Sign() < 0 || priv.D.Cmp(N) >= 0 {
+		return nil, nil, errors.New("ecdsa: invalid private key")
+	}
+	d, err := bigmod.NewNat().SetBytes(priv.D.FillBytes(make([]byte, scalarSize)), n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var k *big.Int
+	var kInv *bigmod.Nat
 	for {
 		for {
 			k, err = randFieldElement(c, *csprng)
@@ -250,25 +319,43 @@ func signGeneric(priv *PrivateKey, csprng *cipher.StreamReader, c elliptic.Curve
 				r = nil
 				return
 			}
+			kBytes := k.FillBytes(make([]byte, scalarSize))
 
 			if in, ok := priv.Curve.(invertible); ok {
-				kInv = in.Inverse(k)
+				kInv, err = bigmod.NewNat().SetBytes(in.Inverse(k).FillBytes(make([]byte, scalarSize)), n)
+				if err != nil {
+					return nil, nil, err
+				}
 			} else {
-				kInv = fermatInverse(k, N) // N != 0
+				kNat, err := bigmod.NewNat().SetBytes(kBytes, n)
+				if err != nil {
+					return nil, nil, err
+				}
+				kInv = fermatInverse(kNat, N, n)
 			}
 
-			r, _ = priv.Curve.ScalarBaseMult(k.Bytes())
+			r, _ = priv.Curve.ScalarBaseMult(kBytes)
 			r.Mod(r, N)
 			if r.Sign() != 0 {
 				break
 			}
 		}
 
-		e := hashToInt(hash, c)
-		s = new(big.Int).Mul(priv.D, r)
-		s.Add(s, e)
-		s.Mul(s, kInv)
-		s.Mod(s, N) // N != 0
+		rNat, err := bigmod.NewNat().SetBytes(r.Bytes(), n)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := bigmod.NewNat().SetOverflowingBytes(hashToInt(hash, c).Bytes(), n)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// s = k⁻¹ * (e + d * r) mod N
+		sNat := bigmod.NewNat().ExpandFor(n).Add(d, n)
+		sNat.Mul(rNat, n)
+		sNat.Add(e, n)
+		sNat.Mul(kInv, n)
+		s = new(big.Int).SetBytes(sNat.Bytes(n))
 		if s.Sign() != 0 {
 			break
 		}
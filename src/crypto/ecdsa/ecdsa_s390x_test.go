@@ -20,9 +20,17 @@ func TestNoAsm(t *testing.T) {
 
 	for _, curve := range curves {
 		// override the name of the curve to stop the assembly path being taken
-		params := *curve.Params()
-		name := params.Name
-		params.Name = name + "_GENERIC_OVERRIDE"
+		p := curve.Params()
+		name := p.Name
+		params := elliptic.CurveParams{
+			P:       p.P,
+			N:       p.N,
+			B:       p.B,
+			Gx:      p.Gx,
+			Gy:      p.Gy,
+			BitSize: p.BitSize,
+			Name:    name + "_GENERIC_OVERRIDE",
+		}
 
 		testKeyGeneration(t, &params, name)
 		testSignAndVerify(t, &params, name)
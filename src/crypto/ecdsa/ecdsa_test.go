@@ -6,6 +6,7 @@ package ecdsa
 
 import (
 	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -395,3 +396,54 @@ func TestZeroHashSignature(t *testing.T) {
 		}
 	}
 }
+
+func TestECDH(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		name := curve.Params().Name
+		alice, err := GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bob, err := GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alicePriv, err := alice.ECDH()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		bobPub, err := bob.PublicKey.ECDH()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := elliptic.Marshal(curve, alice.X, alice.Y); !bytes.Equal(alicePriv.PublicKey().Bytes(), want) {
+			t.Errorf("%s: converted private key has the wrong public key", name)
+		}
+
+		secret, err := alicePriv.ECDH(bobPub)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		x, _ := curve.ScalarMult(bob.X, bob.Y, alice.D.Bytes())
+		if want := x.FillBytes(make([]byte, len(secret))); !bytes.Equal(secret, want) {
+			t.Errorf("%s: shared secret mismatch", name)
+		}
+	}
+
+	p224, err := GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p224.ECDH(); err == nil {
+		t.Error("P-224 private key converted to crypto/ecdh")
+	}
+	if _, err := p224.PublicKey.ECDH(); err == nil {
+		t.Error("P-224 public key converted to crypto/ecdh")
+	}
+
+	invalid := &PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}
+	if _, err := invalid.ECDH(); err == nil {
+		t.Error("point not on the curve converted to crypto/ecdh")
+	}
+}
@@ -14,6 +14,8 @@ package elliptic
 // reverse the transform than to operate in affine coordinates.
 
 import (
+	"crypto/internal/bigmod"
+	"crypto/subtle"
 	"io"
 	"math/big"
 	"sync"
@@ -41,7 +43,21 @@ type Curve interface {
 }
 
 // CurveParams contains the parameters of an elliptic curve and also provides
-// a generic, non-constant time implementation of Curve.
+// a generic implementation of Curve.
+//
+// The generic implementation performs the field arithmetic in constant time,
+// and ScalarMult and ScalarBaseMult do not branch on the bits of the scalar.
+// The execution time still depends on the length of the scalar, and the
+// conversions between big.Int values and field elements are not constant
+// time.
+//
+// If P is even, which it is for no prime field, the field arithmetic can't be
+// done in constant time, and the generic implementation falls back to
+// variable-time math/big arithmetic. None of its operations are then constant
+// time, and they must not be used with secret inputs.
+//
+// The field arithmetic is set up on first use, so P and B must not be
+// modified after that.
 type CurveParams struct {
 	P       *big.Int // the order of the underlying field
 	N       *big.Int // the order of the base point
@@ -55,223 +71,311 @@ func (curve *CurveParams) Params() *CurveParams {
 	return curve
 }
 
-// polynomial returns x³ - 3x + b.
-func (curve *CurveParams) polynomial(x *big.Int) *big.Int {
-	x3 := new(big.Int).Mul(x, x)
-	x3.Mul(x3, x)
-
-	threeX := new(big.Int).Lsh(x, 1)
-	threeX.Add(threeX, x)
-
-	x3.Sub(x3, threeX)
-	x3.Add(x3, curve.B)
-	x3.Mod(x3, curve.P)
-
-	return x3
+// field holds the parameters of a CurveParams needed for the arithmetic
+// modulo P, as implemented by crypto/internal/bigmod.
+//
+// Field elements are kept in Montgomery form, see bigmod.Nat.ToMontgomery, so
+// that each multiplication costs a single Montgomery multiplication. Addition,
+// subtraction and comparisons work the same on that form.
+type field struct {
+	pBig    *big.Int
+	p       *bigmod.Modulus
+	b       *bigmod.Nat // B mod P, in Montgomery form
+	pMinus2 []byte      // the exponent for inversion, in big-endian form
 }
 
-func (curve *CurveParams) IsOnCurve(x, y *big.Int) bool {
-	// y² = x³ - 3x + b
-	y2 := new(big.Int).Mul(y, y)
-	y2.Mod(y2, curve.P)
-
-	return curve.polynomial(x).Cmp(y2) == 0
+// fields caches the field parameters of each CurveParams used with the generic
+// implementation, as a map[*CurveParams]*field. The value is a nil *field if P
+// is not supported by field. The cache lives outside CurveParams so that
+// CurveParams stays a plain value type, which callers copy and modify.
+var fields sync.Map
+
+// newField returns the field parameters for P and B, or nil if P is not odd
+// and greater than one.
+func newField(P, B *big.Int) *field {
+	if P.Bit(0) == 0 {
+		return nil
+	}
+	p, err := bigmod.NewModulusFromBig(P)
+	if err != nil {
+		return nil
+	}
+	f := &field{pBig: P, p: p}
+	f.b = f.element(B)
+	f.pMinus2 = new(big.Int).Sub(P, big.NewInt(2)).Bytes()
+	return f
 }
 
-// zForAffine returns a Jacobian Z value for the affine point (x, y). If x and
-// y are zero, it assumes that they represent the point at infinity because (0,
-// 0) is not on the any of the curves handled here.
-func zForAffine(x, y *big.Int) *big.Int {
-	z := new(big.Int)
-	if x.Sign() != 0 || y.Sign() != 0 {
-		z.SetInt64(1)
+// field returns the field parameters of curve, computed once, or nil if the
+// curve must use the math/big implementation.
+func (curve *CurveParams) field() *field {
+	if f, ok := fields.Load(curve); ok {
+		return f.(*field)
 	}
-	return z
+	f, _ := fields.LoadOrStore(curve, newField(curve.P, curve.B))
+	return f.(*field)
 }
 
-// affineFromJacobian reverses the Jacobian transform. See the comment at the
-// top of the file. If the point is ∞ it returns 0, 0.
-func (curve *CurveParams) affineFromJacobian(x, y, z *big.Int) (xOut, yOut *big.Int) {
-	if z.Sign() == 0 {
-		return new(big.Int), new(big.Int)
+// element returns x mod P as a field element.
+func (f *field) element(x *big.Int) *bigmod.Nat {
+	if x.Sign() < 0 || x.Cmp(f.pBig) >= 0 {
+		x = new(big.Int).Mod(x, f.pBig)
 	}
+	e, err := bigmod.NewNat().SetBytes(x.Bytes(), f.p)
+	if err != nil {
+		panic("crypto/elliptic: internal error: reduced value overflows the field")
+	}
+	return e.ToMontgomery(f.p)
+}
 
-	zinv := new(big.Int).ModInverse(z, curve.P)
-	zinvsq := new(big.Int).Mul(zinv, zinv)
+// toBig returns e as a big.Int.
+func (f *field) toBig(e *bigmod.Nat) *big.Int {
+	return new(big.Int).SetBytes(bigmod.NewNat().Set(e).FromMontgomery(f.p).Bytes(f.p))
+}
 
-	xOut = new(big.Int).Mul(x, zinvsq)
-	xOut.Mod(xOut, curve.P)
-	zinvsq.Mul(zinvsq, zinv)
-	yOut = new(big.Int).Mul(y, zinvsq)
-	yOut.Mod(yOut, curve.P)
-	return
+// zero returns the field element 0.
+func (f *field) zero() *bigmod.Nat {
+	return bigmod.NewNat().ExpandFor(f.p)
 }
 
-func (curve *CurveParams) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
-	z1 := zForAffine(x1, y1)
-	z2 := zForAffine(x2, y2)
-	return curve.affineFromJacobian(curve.addJacobian(x1, y1, z1, x2, y2, z2))
+// one returns the field element 1.
+func (f *field) one() *bigmod.Nat {
+	one, _ := bigmod.NewNat().SetBytes([]byte{1}, f.p)
+	return one.ToMontgomery(f.p)
 }
 
-// addJacobian takes two points in Jacobian coordinates, (x1, y1, z1) and
-// (x2, y2, z2) and returns their sum, also in Jacobian form.
-func (curve *CurveParams) addJacobian(x1, y1, z1, x2, y2, z2 *big.Int) (*big.Int, *big.Int, *big.Int) {
-	// See https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-3.html#addition-add-2007-bl
-	x3, y3, z3 := new(big.Int), new(big.Int), new(big.Int)
-	if z1.Sign() == 0 {
-		x3.Set(x2)
-		y3.Set(y2)
-		z3.Set(z2)
-		return x3, y3, z3
-	}
-	if z2.Sign() == 0 {
-		x3.Set(x1)
-		y3.Set(y1)
-		z3.Set(z1)
-		return x3, y3, z3
-	}
+// jacobianPoint is a point (x, y, z) in Jacobian coordinates. See the comment
+// at the top of the file. The point at infinity has z = 0.
+//
+// The arithmetic below updates field elements in place, rather than returning
+// new ones, so that its temporaries don't have to be allocated on the heap.
+type jacobianPoint struct {
+	x, y, z *bigmod.Nat
+}
 
-	z1z1 := new(big.Int).Mul(z1, z1)
-	z1z1.Mod(z1z1, curve.P)
-	z2z2 := new(big.Int).Mul(z2, z2)
-	z2z2.Mod(z2z2, curve.P)
-
-	u1 := new(big.Int).Mul(x1, z2z2)
-	u1.Mod(u1, curve.P)
-	u2 := new(big.Int).Mul(x2, z1z1)
-	u2.Mod(u2, curve.P)
-	h := new(big.Int).Sub(u2, u1)
-	xEqual := h.Sign() == 0
-	if h.Sign() == -1 {
-		h.Add(h, curve.P)
-	}
-	i := new(big.Int).Lsh(h, 1)
-	i.Mul(i, i)
-	j := new(big.Int).Mul(h, i)
-
-	s1 := new(big.Int).Mul(y1, z2)
-	s1.Mul(s1, z2z2)
-	s1.Mod(s1, curve.P)
-	s2 := new(big.Int).Mul(y2, z1)
-	s2.Mul(s2, z1z1)
-	s2.Mod(s2, curve.P)
-	r := new(big.Int).Sub(s2, s1)
-	if r.Sign() == -1 {
-		r.Add(r, curve.P)
-	}
-	yEqual := r.Sign() == 0
-	if xEqual && yEqual {
-		return curve.doubleJacobian(x1, y1, z1)
+// newPoint returns a jacobianPoint holding the point at infinity.
+func (f *field) newPoint() *jacobianPoint {
+	return &jacobianPoint{
+		x: bigmod.NewNat().ExpandFor(f.p),
+		y: bigmod.NewNat().ExpandFor(f.p),
+		z: bigmod.NewNat().ExpandFor(f.p),
 	}
-	r.Lsh(r, 1)
-	v := new(big.Int).Mul(u1, i)
-
-	x3.Set(r)
-	x3.Mul(x3, x3)
-	x3.Sub(x3, j)
-	x3.Sub(x3, v)
-	x3.Sub(x3, v)
-	x3.Mod(x3, curve.P)
-
-	y3.Set(r)
-	v.Sub(v, x3)
-	y3.Mul(y3, v)
-	s1.Mul(s1, j)
-	s1.Lsh(s1, 1)
-	y3.Sub(y3, s1)
-	y3.Mod(y3, curve.P)
-
-	z3.Add(z1, z2)
-	z3.Mul(z3, z3)
-	z3.Sub(z3, z1z1)
-	z3.Sub(z3, z2z2)
-	z3.Mul(z3, h)
-	z3.Mod(z3, curve.P)
-
-	return x3, y3, z3
 }
 
-func (curve *CurveParams) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
-	z1 := zForAffine(x1, y1)
-	return curve.affineFromJacobian(curve.doubleJacobian(x1, y1, z1))
+// selectPoint sets p = q if on is 1, and leaves p unchanged if on is 0, in
+// constant time.
+func (p *jacobianPoint) selectPoint(on uint, q *jacobianPoint) {
+	p.x.Select(on, q.x)
+	p.y.Select(on, q.y)
+	p.z.Select(on, q.z)
 }
 
-// doubleJacobian takes a point in Jacobian coordinates, (x, y, z), and
-// returns its double, also in Jacobian form.
-func (curve *CurveParams) doubleJacobian(x, y, z *big.Int) (*big.Int, *big.Int, *big.Int) {
-	// See https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-3.html#doubling-dbl-2001-b
-	delta := new(big.Int).Mul(z, z)
-	delta.Mod(delta, curve.P)
-	gamma := new(big.Int).Mul(y, y)
-	gamma.Mod(gamma, curve.P)
-	alpha := new(big.Int).Sub(x, delta)
-	if alpha.Sign() == -1 {
-		alpha.Add(alpha, curve.P)
-	}
-	alpha2 := new(big.Int).Add(x, delta)
-	alpha.Mul(alpha, alpha2)
-	alpha2.Set(alpha)
-	alpha.Lsh(alpha, 1)
-	alpha.Add(alpha, alpha2)
-
-	beta := alpha2.Mul(x, gamma)
-
-	x3 := new(big.Int).Mul(alpha, alpha)
-	beta8 := new(big.Int).Lsh(beta, 3)
-	beta8.Mod(beta8, curve.P)
-	x3.Sub(x3, beta8)
-	if x3.Sign() == -1 {
-		x3.Add(x3, curve.P)
-	}
-	x3.Mod(x3, curve.P)
+// polynomial returns x³ - 3x + b.
+func (f *field) polynomial(x *bigmod.Nat) *bigmod.Nat {
+	x3 := bigmod.NewNat().MontgomeryMul(x, x, f.p)
+	x3.MontgomeryMul(x3, x, f.p)
+
+	threeX := bigmod.NewNat().Set(x).Add(x, f.p)
+	threeX.Add(x, f.p)
+
+	return x3.Sub(threeX, f.p).Add(f.b, f.p)
+}
 
-	z3 := new(big.Int).Add(y, z)
-	z3.Mul(z3, z3)
-	z3.Sub(z3, gamma)
-	if z3.Sign() == -1 {
-		z3.Add(z3, curve.P)
+// polynomial returns x³ - 3x + b.
+func (curve *CurveParams) polynomial(x *big.Int) *big.Int {
+	f := curve.field()
+	if f == nil {
+		return curve.bigPolynomial(x)
 	}
-	z3.Sub(z3, delta)
-	if z3.Sign() == -1 {
-		z3.Add(z3, curve.P)
+	return f.toBig(f.polynomial(f.element(x)))
+}
+
+func (curve *CurveParams) IsOnCurve(x, y *big.Int) bool {
+	f := curve.field()
+	if f == nil {
+		return curve.bigIsOnCurve(x, y)
 	}
-	z3.Mod(z3, curve.P)
+	// y² = x³ - 3x + b
+	y2 := f.element(y)
+	y2.MontgomeryMul(y2, y2, f.p)
+	return y2.Equal(f.polynomial(f.element(x))) == 1
+}
+
+// jacobianFromAffine returns the Jacobian form of the affine point (x, y).
+// If x and y are zero, it assumes that they represent the point at infinity
+// because (0, 0) is not on the any of the curves handled here.
+func (f *field) jacobianFromAffine(x, y *big.Int) *jacobianPoint {
+	p := &jacobianPoint{x: f.element(x), y: f.element(y), z: f.one()}
+	p.z.Select(uint(p.x.IsZero()&p.y.IsZero()), f.zero())
+	return p
+}
 
-	beta.Lsh(beta, 2)
-	beta.Sub(beta, x3)
-	if beta.Sign() == -1 {
-		beta.Add(beta, curve.P)
+// affineFromJacobian reverses the Jacobian transform. See the comment at the
+// top of the file. If the point is ∞ it returns 0, 0.
+func (f *field) affineFromJacobian(p *jacobianPoint) (x, y *big.Int) {
+	// If z is zero, so is zinv, and the result is (0, 0). zinv is computed
+	// as z^(P-2), by Fermat's little theorem. Exp works outside of the
+	// Montgomery form.
+	zinv := bigmod.NewNat().Set(p.z).FromMontgomery(f.p)
+	zinv.Exp(bigmod.NewNat().Set(zinv), f.pMinus2, f.p).ToMontgomery(f.p)
+	zinvsq := bigmod.NewNat().MontgomeryMul(zinv, zinv, f.p)
+
+	x = f.toBig(bigmod.NewNat().MontgomeryMul(p.x, zinvsq, f.p))
+	zinvsq.MontgomeryMul(zinvsq, zinv, f.p)
+	y = f.toBig(zinvsq.MontgomeryMul(zinvsq, p.y, f.p))
+	return
+}
+
+func (curve *CurveParams) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	f := curve.field()
+	if f == nil {
+		return curve.bigAdd(x1, y1, x2, y2)
 	}
-	y3 := alpha.Mul(alpha, beta)
+	p1 := f.jacobianFromAffine(x1, y1)
+	p2 := f.jacobianFromAffine(x2, y2)
+	return f.affineFromJacobian(f.addJacobian(f.newPoint(), p1, p2))
+}
 
-	gamma.Mul(gamma, gamma)
-	gamma.Lsh(gamma, 3)
-	gamma.Mod(gamma, curve.P)
+// addJacobian sets q = p1 + p2 and returns q. q must not alias p1 or p2. The
+// cases where either point is ∞, or where the points are equal, are handled
+// without branching.
+func (f *field) addJacobian(q, p1, p2 *jacobianPoint) *jacobianPoint {
+	// See https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-3.html#addition-add-2007-bl
+	m := f.p
+	z1z1 := bigmod.NewNat().MontgomeryMul(p1.z, p1.z, m)
+	z2z2 := bigmod.NewNat().MontgomeryMul(p2.z, p2.z, m)
+
+	u1 := bigmod.NewNat().MontgomeryMul(p1.x, z2z2, m)
+	u2 := bigmod.NewNat().MontgomeryMul(p2.x, z1z1, m)
+	h := u2.Sub(u1, m)
+	i := bigmod.NewNat().Set(h).Add(h, m)
+	i.MontgomeryMul(i, i, m)
+	j := bigmod.NewNat().MontgomeryMul(h, i, m)
+
+	s1 := bigmod.NewNat().MontgomeryMul(p1.y, p2.z, m)
+	s1.MontgomeryMul(s1, z2z2, m)
+	r := bigmod.NewNat().MontgomeryMul(p2.y, p1.z, m)
+	r.MontgomeryMul(r, z1z1, m)
+	r.Sub(s1, m)
+	equal := uint(h.IsZero() & r.IsZero())
+	r.Add(r, m)
+	v := u1.MontgomeryMul(u1, i, m)
+
+	x3 := bigmod.NewNat().MontgomeryMul(r, r, m)
+	x3.Sub(j, m).Sub(v, m).Sub(v, m)
+
+	y3 := v.Sub(x3, m)
+	y3.MontgomeryMul(y3, r, m)
+	s1.MontgomeryMul(s1, j, m)
+	y3.Sub(s1, m).Sub(s1, m)
+
+	z3 := bigmod.NewNat().Set(p1.z).Add(p2.z, m)
+	z3.MontgomeryMul(z3, z3, m)
+	z3.Sub(z1z1, m).Sub(z2z2, m)
+	z3.MontgomeryMul(z3, h, m)
+
+	q.x.Set(x3)
+	q.y.Set(y3)
+	q.z.Set(z3)
+	// The formulas above don't work for doubling, in which case h and r are
+	// both zero, or if either point is ∞. The selection order matters, because
+	// the doubling case also covers two points at ∞.
+	dbl := &jacobianPoint{x: bigmod.NewNat(), y: bigmod.NewNat(), z: bigmod.NewNat()}
+	q.selectPoint(equal, f.doubleJacobian(dbl, p1))
+	q.selectPoint(uint(p1.z.IsZero()), p2)
+	q.selectPoint(uint(p2.z.IsZero()), p1)
+	return q
+}
 
-	y3.Sub(y3, gamma)
-	if y3.Sign() == -1 {
-		y3.Add(y3, curve.P)
+func (curve *CurveParams) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	f := curve.field()
+	if f == nil {
+		return curve.bigDouble(x1, y1)
 	}
-	y3.Mod(y3, curve.P)
+	p := f.jacobianFromAffine(x1, y1)
+	return f.affineFromJacobian(f.doubleJacobian(p, p))
+}
 
-	return x3, y3, z3
+// doubleJacobian sets q = 2*p and returns q. q may alias p. If p is ∞, so is
+// the result.
+func (f *field) doubleJacobian(q, p *jacobianPoint) *jacobianPoint {
+	// See https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-3.html#doubling-dbl-2001-b
+	m := f.p
+	delta := bigmod.NewNat().MontgomeryMul(p.z, p.z, m)
+	gamma := bigmod.NewNat().MontgomeryMul(p.y, p.y, m)
+	alpha := bigmod.NewNat().Set(p.x).Sub(delta, m)
+	alpha2 := bigmod.NewNat().Set(p.x).Add(delta, m)
+	alpha2.MontgomeryMul(alpha2, alpha, m)
+	alpha.Set(alpha2).Add(alpha2, m).Add(alpha2, m)
+	beta := bigmod.NewNat().MontgomeryMul(p.x, gamma, m)
+
+	beta4 := beta.Add(beta, m).Add(beta, m)
+	beta8 := bigmod.NewNat().Set(beta4).Add(beta4, m)
+	x3 := bigmod.NewNat().MontgomeryMul(alpha, alpha, m)
+	x3.Sub(beta8, m)
+
+	z3 := bigmod.NewNat().Set(p.y).Add(p.z, m)
+	z3.MontgomeryMul(z3, z3, m)
+	z3.Sub(gamma, m).Sub(delta, m)
+
+	gamma.MontgomeryMul(gamma, gamma, m)
+	gamma.Add(gamma, m).Add(gamma, m).Add(gamma, m)
+	y3 := beta4.Sub(x3, m)
+	y3.MontgomeryMul(y3, alpha, m)
+	y3.Sub(gamma, m)
+
+	q.x.Set(x3)
+	q.y.Set(y3)
+	q.z.Set(z3)
+	return q
+}
+
+// ctEq returns 1 if x == y, and 0 otherwise, in constant time.
+func ctEq(x, y uint) uint {
+	return uint(subtle.ConstantTimeEq(int32(x), int32(y)))
 }
 
 func (curve *CurveParams) ScalarMult(Bx, By *big.Int, k []byte) (*big.Int, *big.Int) {
-	Bz := new(big.Int).SetInt64(1)
-	x, y, z := new(big.Int), new(big.Int), new(big.Int)
+	f := curve.field()
+	if f == nil {
+		return curve.bigScalarMult(Bx, By, k)
+	}
+
+	// table[i] = i*B, for i from 0 to 15. table[0] is ∞.
+	var table [16]*jacobianPoint
+	table[0] = f.newPoint()
+	table[1] = f.jacobianFromAffine(Bx, By)
+	for i := 2; i < len(table); i++ {
+		table[i] = f.newPoint()
+		if i%2 == 0 {
+			f.doubleJacobian(table[i], table[i/2])
+		} else {
+			f.addJacobian(table[i], table[i-1], table[1])
+		}
+	}
 
+	// This is a fixed-window loop over the scalar, four bits at a time. It
+	// reads every table entry to select the one for the window, and always
+	// adds it, so that its execution time doesn't depend on the value of k.
+	// Adding table[0], which is ∞, leaves q unchanged.
+	q, sum, t := f.newPoint(), f.newPoint(), f.newPoint()
 	for _, byte := range k {
-		for bitNum := 0; bitNum < 8; bitNum++ {
-			x, y, z = curve.doubleJacobian(x, y, z)
-			if byte&0x80 == 0x80 {
-				x, y, z = curve.addJacobian(Bx, By, Bz, x, y, z)
+		for _, shift := range []uint{4, 0} {
+			f.doubleJacobian(q, q)
+			f.doubleJacobian(q, q)
+			f.doubleJacobian(q, q)
+			f.doubleJacobian(q, q)
+
+			w := uint(byte>>shift) & 0b1111
+			for i := range table {
+				t.selectPoint(ctEq(w, uint(i)), table[i])
 			}
-			byte <<= 1
+			f.addJacobian(sum, q, t)
+			q, sum = sum, q
 		}
 	}
 
-	return curve.affineFromJacobian(x, y, z)
+	return f.affineFromJacobian(q)
 }
 
 func (curve *CurveParams) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
@@ -440,7 +544,8 @@ func P256() Curve {
 // Multiple invocations of this function will return the same value, so it can
 // be used for equality checks and switch statements.
 //
-// The cryptographic operations do not use constant-time algorithms.
+// The cryptographic operations are implemented by the generic CurveParams
+// code, which uses constant-time field arithmetic. See CurveParams.
 func P384() Curve {
 	initonce.Do(initAll)
 	return p384
@@ -452,7 +557,8 @@ func P384() Curve {
 // Multiple invocations of this function will return the same value, so it can
 // be used for equality checks and switch statements.
 //
-// The cryptographic operations do not use constant-time algorithms.
+// The cryptographic operations are implemented by the generic CurveParams
+// code, which uses constant-time field arithmetic. See CurveParams.
 func P521() Curve {
 	initonce.Do(initAll)
 	return p521
@@ -477,6 +477,101 @@ func TestInfinity(t *testing.T) {
 	}
 }
 
+// TestGenericAdd checks the cases of CurveParams.Add that the addition
+// formulas don't cover, against the specialized implementations.
+func TestGenericAdd(t *testing.T) {
+	for _, curve := range []Curve{P224(), P256()} {
+		params := curve.Params()
+		t.Run(params.Name, func(t *testing.T) {
+			gx, gy := params.Gx, params.Gy
+			negGy := new(big.Int).Sub(params.P, gy)
+			x, y := curve.ScalarBaseMult([]byte{5})
+
+			for _, tt := range []struct {
+				name           string
+				x1, y1, x2, y2 *big.Int
+			}{
+				{"G+G", gx, gy, gx, gy},
+				{"G+(-G)", gx, gy, gx, negGy},
+				{"G+5G", gx, gy, x, y},
+				{"5G+5G", x, y, x, y},
+			} {
+				wantX, wantY := curve.Add(tt.x1, tt.y1, tt.x2, tt.y2)
+				gotX, gotY := params.Add(tt.x1, tt.y1, tt.x2, tt.y2)
+				if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+					t.Errorf("%s: got (%x, %x), want (%x, %x)", tt.name, gotX, gotY, wantX, wantY)
+				}
+			}
+
+			wantX, wantY := curve.Double(x, y)
+			gotX, gotY := params.Double(x, y)
+			if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+				t.Errorf("Double: got (%x, %x), want (%x, %x)", gotX, gotY, wantX, wantY)
+			}
+		})
+	}
+}
+
+// TestGenericFallback checks that CurveParams reduces a B that is not reduced
+// modulo P, and that it falls back to math/big, rather than panicking, for a P
+// that crypto/internal/bigmod can't handle.
+func TestGenericFallback(t *testing.T) {
+	p384 := P384().Params()
+	unreduced := &CurveParams{
+		P:       p384.P,
+		N:       p384.N,
+		B:       new(big.Int).Add(p384.B, p384.P),
+		Gx:      p384.Gx,
+		Gy:      p384.Gy,
+		BitSize: p384.BitSize,
+		Name:    "P-384 with B+P",
+	}
+	if unreduced.field() == nil {
+		t.Fatal("field() is nil for an unreduced B")
+	}
+	x, y := unreduced.ScalarBaseMult([]byte{5})
+	wantX, wantY := p384.ScalarBaseMult([]byte{5})
+	if x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+		t.Errorf("ScalarBaseMult: got (%x, %x), want (%x, %x)", x, y, wantX, wantY)
+	}
+	if !unreduced.IsOnCurve(x, y) {
+		t.Errorf("IsOnCurve(5G) = false for an unreduced B")
+	}
+
+	even := &CurveParams{
+		P:       new(big.Int).Add(p384.P, big.NewInt(1)),
+		N:       p384.N,
+		B:       p384.B,
+		Gx:      p384.Gx,
+		Gy:      p384.Gy,
+		BitSize: p384.BitSize,
+		Name:    "P-384 with P+1",
+	}
+	if even.field() != nil {
+		t.Fatal("field() is non-nil for an even P")
+	}
+	if even.IsOnCurve(x, y) {
+		t.Errorf("IsOnCurve(5G) = true for an even P")
+	}
+}
+
+// TestCurveParamsCopy checks that a copy of a CurveParams that was already
+// used, given a different P, doesn't use the field of the original.
+func TestCurveParamsCopy(t *testing.T) {
+	p384 := P384().Params()
+	if p384.field() == nil {
+		t.Fatal("field() is nil for P-384")
+	}
+	even := *p384
+	even.P = new(big.Int).Add(p384.P, big.NewInt(1))
+	if even.field() != nil {
+		t.Fatal("field() is non-nil for a copy of P-384 with P+1")
+	}
+	if p384.field() == nil {
+		t.Fatal("field() of P-384 changed after copying it")
+	}
+}
+
 type synthCombinedMult struct {
 	Curve
 }
@@ -583,6 +678,32 @@ func BenchmarkScalarMultP256(b *testing.B) {
 	})
 }
 
+func BenchmarkScalarBaseMult(b *testing.B) {
+	for _, curve := range []Curve{P384(), P521()} {
+		b.Run(curve.Params().Name, func(b *testing.B) {
+			priv, _, _, _ := GenerateKey(curve, rand.Reader)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				curve.ScalarBaseMult(priv)
+			}
+		})
+	}
+}
+
+func BenchmarkIsOnCurve(b *testing.B) {
+	for _, curve := range []Curve{P384(), P521()} {
+		b.Run(curve.Params().Name, func(b *testing.B) {
+			_, x, y, _ := GenerateKey(curve, rand.Reader)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				curve.IsOnCurve(x, y)
+			}
+		})
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	p224 := P224()
 	_, x, y, err := GenerateKey(p224, rand.Reader)
@@ -0,0 +1,36 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// The loop below only branches on the length of z, so its running time
+// does not depend on the values of the operands.
+
+// func addMulVVW(z, x []uint, y uint) (carry uint)
+TEXT ·addMulVVW(SB), NOSPLIT, $0
+	MOVQ z_base+0(FP), DI
+	MOVQ z_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y+48(FP), R8
+	XORQ BX, BX		// carry = 0
+	TESTQ CX, CX
+	JZ done
+
+loop:
+	MOVQ (SI), AX
+	MULQ R8			// DX:AX = x[i] * y
+	ADDQ (DI), AX		// add z[i]
+	ADCQ $0, DX
+	ADDQ BX, AX		// add the incoming carry
+	ADCQ $0, DX
+	MOVQ AX, (DI)		// z[i] = low word
+	MOVQ DX, BX		// carry = high word
+	ADDQ $8, SI
+	ADDQ $8, DI
+	DECQ CX
+	JNZ loop
+
+done:
+	MOVQ BX, carry+56(FP)
+	RET
@@ -0,0 +1,34 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// The loop below only branches on the length of z, so its running time
+// does not depend on the values of the operands.
+
+// func addMulVVW(z, x []uint, y uint) (carry uint)
+TEXT ·addMulVVW(SB), NOSPLIT, $0
+	MOVD	z_base+0(FP), R1
+	MOVD	z_len+8(FP), R0
+	MOVD	x_base+24(FP), R2
+	MOVD	y+48(FP), R3
+	MOVD	$0, R4		// carry = 0
+	CBZ	R0, done
+
+loop:
+	MOVD.P	8(R2), R5	// x[i]
+	MOVD	(R1), R6	// z[i]
+	MUL	R5, R3, R7	// low word of x[i] * y
+	UMULH	R5, R3, R8	// high word of x[i] * y
+	ADDS	R7, R6		// add the low word to z[i]
+	ADC	$0, R8, R8
+	ADDS	R4, R6		// add the incoming carry
+	ADC	$0, R8, R4	// carry = high word
+	MOVD.P	R6, 8(R1)	// z[i] = low word
+	SUB	$1, R0
+	CBNZ	R0, loop
+
+done:
+	MOVD	R4, carry+56(FP)
+	RET
@@ -0,0 +1,13 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !amd64,!arm64
+
+package bigmod
+
+// addMulVVW is addMulVVWGeneric. See arith_decl.go for the
+// architectures with assembly implementations.
+func addMulVVW(z, x []uint, y uint) (carry uint) {
+	return addMulVVWGeneric(z, x, y)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cttest issues Certificate Transparency signed certificate
+// timestamps (SCTs) for the tests of crypto/x509 and crypto/tls.
+package cttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// X509Entry returns the signed entry of an SCT for cert, a DER-encoded
+// certificate.
+func X509Entry(cert []byte) []byte {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16(0) // x509_entry
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cert)
+	})
+	return b.BytesOrPanic()
+}
+
+// PrecertEntry returns the signed entry of an SCT for a precertificate with
+// the DER-encoded TBSCertificate tbs, issued by the CA whose DER-encoded
+// SubjectPublicKeyInfo is issuerSPKI.
+func PrecertEntry(issuerSPKI, tbs []byte) []byte {
+	issuerKeyHash := sha256.Sum256(issuerSPKI)
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16(1) // precert_entry
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(tbs)
+	})
+	return b.BytesOrPanic()
+}
+
+// SCT returns a serialized version 1 SCT for entry, as specified by RFC 6962,
+// Section 3.2. The SCT is issued at ts, truncated to milliseconds, by the log
+// whose ECDSA key is logKey and whose DER-encoded SubjectPublicKeyInfo is
+// logSPKI. It is signed with SHA-256 and has no extensions.
+func SCT(logKey *ecdsa.PrivateKey, logSPKI, entry []byte, ts time.Time) ([]byte, error) {
+	logID := sha256.Sum256(logSPKI)
+	ms := uint64(ts.UnixNano() / int64(time.Millisecond))
+
+	signed := cryptobyte.NewBuilder(nil)
+	signed.AddUint8(0) // v1
+	signed.AddUint8(0) // certificate_timestamp
+	signed.AddUint32(uint32(ms >> 32))
+	signed.AddUint32(uint32(ms))
+	signed.AddBytes(entry)
+	signed.AddUint16(0) // no extensions
+	digest := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := ecdsa.SignASN1(rand.Reader, logKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(0) // v1
+	b.AddBytes(logID[:])
+	b.AddUint32(uint32(ms >> 32))
+	b.AddUint32(uint32(ms))
+	b.AddUint16(0) // no extensions
+	b.AddUint8(4)  // sha256
+	b.AddUint8(3)  // ecdsa
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sig)
+	})
+	return b.BytesOrPanic(), nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hpke implements the base mode of Hybrid Public Key Encryption, as
+// specified in RFC 9180, with the subset of algorithms needed by crypto/tls
+// for Encrypted Client Hello.
+package hpke
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// KEM, KDF and AEAD identifiers from the IANA HPKE registry.
+const (
+	DHKEM_X25519_HKDF_SHA256 uint16 = 0x0020
+
+	KDF_HKDF_SHA256 uint16 = 0x0001
+	KDF_HKDF_SHA384 uint16 = 0x0002
+	KDF_HKDF_SHA512 uint16 = 0x0003
+
+	AEAD_AES_128_GCM      uint16 = 0x0001
+	AEAD_AES_256_GCM      uint16 = 0x0002
+	AEAD_ChaCha20Poly1305 uint16 = 0x0003
+)
+
+// SupportedKEMs maps the supported KEM identifiers to the length of their
+// encoded public keys.
+var SupportedKEMs = map[uint16]int{
+	DHKEM_X25519_HKDF_SHA256: curve25519.PointSize,
+}
+
+// SupportedKDFs maps the supported KDF identifiers to their hash function.
+var SupportedKDFs = map[uint16]crypto.Hash{
+	KDF_HKDF_SHA256: crypto.SHA256,
+	KDF_HKDF_SHA384: crypto.SHA384,
+	KDF_HKDF_SHA512: crypto.SHA512,
+}
+
+// SupportedAEADs maps the supported AEAD identifiers to their parameters.
+var SupportedAEADs = map[uint16]struct {
+	keySize   int
+	nonceSize int
+	aead      func([]byte) (cipher.AEAD, error)
+}{
+	AEAD_AES_128_GCM:      {keySize: 16, nonceSize: 12, aead: aesGCM},
+	AEAD_AES_256_GCM:      {keySize: 32, nonceSize: 12, aead: aesGCM},
+	AEAD_ChaCha20Poly1305: {keySize: chacha20poly1305.KeySize, nonceSize: chacha20poly1305.NonceSize, aead: chacha20poly1305.New},
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfKDF is a KDF bound to a suite_id, as used by LabeledExtract and
+// LabeledExpand. See RFC 9180, Section 4.
+type hkdfKDF struct {
+	hash    crypto.Hash
+	suiteID []byte
+}
+
+func (kdf *hkdfKDF) labeledExtract(salt []byte, label string, inputKey []byte) []byte {
+	labeledIKM := make([]byte, 0, 7+len(kdf.suiteID)+len(label)+len(inputKey))
+	labeledIKM = append(labeledIKM, "HPKE-v1"...)
+	labeledIKM = append(labeledIKM, kdf.suiteID...)
+	labeledIKM = append(labeledIKM, label...)
+	labeledIKM = append(labeledIKM, inputKey...)
+	return hkdf.Extract(kdf.hash.New, labeledIKM, salt)
+}
+
+func (kdf *hkdfKDF) labeledExpand(randomKey []byte, label string, info []byte, length uint16) []byte {
+	labeledInfo := make([]byte, 2, 2+7+len(kdf.suiteID)+len(label)+len(info))
+	binary.BigEndian.PutUint16(labeledInfo, length)
+	labeledInfo = append(labeledInfo, "HPKE-v1"...)
+	labeledInfo = append(labeledInfo, kdf.suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(kdf.hash.New, randomKey, labeledInfo), out); err != nil {
+		panic("hpke: HKDF-Expand invocation failed unexpectedly")
+	}
+	return out
+}
+
+// dhKEM implements DHKEM(X25519, HKDF-SHA256). See RFC 9180, Section 4.1.
+type dhKEM struct {
+	kdf     hkdfKDF
+	nSecret uint16
+}
+
+func newDHKEM(kemID uint16) (*dhKEM, error) {
+	if _, ok := SupportedKEMs[kemID]; !ok {
+		return nil, errors.New("hpke: unsupported KEM")
+	}
+	suiteID := make([]byte, 0, 5)
+	suiteID = append(suiteID, "KEM"...)
+	suiteID = appendUint16(suiteID, kemID)
+	return &dhKEM{
+		kdf:     hkdfKDF{hash: crypto.SHA256, suiteID: suiteID},
+		nSecret: 32,
+	}, nil
+}
+
+func (dh *dhKEM) extractAndExpand(dhKey, kemContext []byte) []byte {
+	eaePRK := dh.kdf.labeledExtract(nil, "eae_prk", dhKey)
+	return dh.kdf.labeledExpand(eaePRK, "shared_secret", kemContext, dh.nSecret)
+}
+
+func (dh *dhKEM) encap(rand io.Reader, pubRecipient []byte) (sharedSecret, encapPub []byte, err error) {
+	privEph := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand, privEph); err != nil {
+		return nil, nil, err
+	}
+	encapPub, err = curve25519.X25519(privEph, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	dhVal, err := curve25519.X25519(privEph, pubRecipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	kemContext := append(encapPub[:len(encapPub):len(encapPub)], pubRecipient...)
+	return dh.extractAndExpand(dhVal, kemContext), encapPub, nil
+}
+
+func (dh *dhKEM) decap(encPubEph, privRecipient []byte) ([]byte, error) {
+	if len(encPubEph) != curve25519.PointSize {
+		return nil, errors.New("hpke: invalid encapsulated key")
+	}
+	dhVal, err := curve25519.X25519(privRecipient, encPubEph)
+	if err != nil {
+		return nil, err
+	}
+	pubRecipient, err := curve25519.X25519(privRecipient, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	kemContext := append(encPubEph[:len(encPubEph):len(encPubEph)], pubRecipient...)
+	return dh.extractAndExpand(dhVal, kemContext), nil
+}
+
+type context struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	seqNum    uint64
+}
+
+// A Sender is an HPKE encryption context held by the sender of messages.
+type Sender struct {
+	*context
+}
+
+// A Recipient is an HPKE encryption context held by the recipient of messages.
+type Recipient struct {
+	*context
+}
+
+func newContext(sharedSecret []byte, kemID, kdfID, aeadID uint16, info []byte) (*context, error) {
+	kdfHash, ok := SupportedKDFs[kdfID]
+	if !ok {
+		return nil, errors.New("hpke: unsupported KDF")
+	}
+	aeadInfo, ok := SupportedAEADs[aeadID]
+	if !ok {
+		return nil, errors.New("hpke: unsupported AEAD")
+	}
+
+	suiteID := make([]byte, 0, 10)
+	suiteID = append(suiteID, "HPKE"...)
+	suiteID = appendUint16(suiteID, kemID)
+	suiteID = appendUint16(suiteID, kdfID)
+	suiteID = appendUint16(suiteID, aeadID)
+	kdf := &hkdfKDF{hash: kdfHash, suiteID: suiteID}
+
+	// Only the base mode (mode_base, 0x00) without a PSK is implemented, and
+	// the secret export interface is not. See RFC 9180, Section 5.1.
+	pskIDHash := kdf.labeledExtract(nil, "psk_id_hash", nil)
+	infoHash := kdf.labeledExtract(nil, "info_hash", info)
+	ksContext := append([]byte{0}, pskIDHash...)
+	ksContext = append(ksContext, infoHash...)
+
+	secret := kdf.labeledExtract(sharedSecret, "secret", nil)
+
+	key := kdf.labeledExpand(secret, "key", ksContext, uint16(aeadInfo.keySize))
+	baseNonce := kdf.labeledExpand(secret, "base_nonce", ksContext, uint16(aeadInfo.nonceSize))
+
+	aead, err := aeadInfo.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &context{aead: aead, baseNonce: baseNonce}, nil
+}
+
+// SetupSender establishes an encryption context to the holder of the private
+// key matching pubRecipient, reading ephemeral key material from rand. It
+// returns the encapsulated key, which must be sent to the recipient, and the
+// context. See RFC 9180, Section 5.1.1.
+func SetupSender(rand io.Reader, kemID, kdfID, aeadID uint16, pubRecipient, info []byte) ([]byte, *Sender, error) {
+	kem, err := newDHKEM(kemID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pubRecipient) != SupportedKEMs[kemID] {
+		return nil, nil, errors.New("hpke: invalid public key")
+	}
+	sharedSecret, encapsulatedKey, err := kem.encap(rand, pubRecipient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	context, err := newContext(sharedSecret, kemID, kdfID, aeadID, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encapsulatedKey, &Sender{context}, nil
+}
+
+// SetupRecipient establishes the decryption context matching the one set up
+// by SetupSender, given the recipient's private key and the encapsulated key
+// sent by the sender. See RFC 9180, Section 5.1.1.
+func SetupRecipient(kemID, kdfID, aeadID uint16, privRecipient, info, encPubEph []byte) (*Recipient, error) {
+	kem, err := newDHKEM(kemID)
+	if err != nil {
+		return nil, err
+	}
+	if len(privRecipient) != curve25519.ScalarSize {
+		return nil, errors.New("hpke: invalid private key")
+	}
+	sharedSecret, err := kem.decap(encPubEph, privRecipient)
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := newContext(sharedSecret, kemID, kdfID, aeadID, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recipient{context}, nil
+}
+
+func (ctx *context) nextNonce() []byte {
+	nonce := make([]byte, len(ctx.baseNonce))
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], ctx.seqNum)
+	for i := range ctx.baseNonce {
+		nonce[i] ^= ctx.baseNonce[i]
+	}
+	return nonce
+}
+
+func (ctx *context) incrementNonce() error {
+	// The sequence number is limited by the nonce size, which is at least 8
+	// bytes for every supported AEAD, so it can't overflow before the uint64.
+	if ctx.seqNum == ^uint64(0) {
+		return errors.New("hpke: message limit reached")
+	}
+	ctx.seqNum++
+	return nil
+}
+
+// Seal encrypts and authenticates plaintext, authenticates aad, and returns
+// the ciphertext. Each call uses the next nonce in the sequence.
+func (s *Sender) Seal(aad, plaintext []byte) ([]byte, error) {
+	ciphertext := s.aead.Seal(nil, s.nextNonce(), plaintext, aad)
+	if err := s.incrementNonce(); err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
+}
+
+// Open decrypts and authenticates ciphertext and aad, and returns the
+// plaintext. Each successful call advances the nonce sequence.
+func (r *Recipient) Open(aad, ciphertext []byte) ([]byte, error) {
+	plaintext, err := r.aead.Open(nil, r.nextNonce(), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.incrementNonce(); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hpke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func mustDecodeHex(t *testing.T, in string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The recipient key pair is the one from RFC 9180, Appendix A.1.1.
+const (
+	testPrivateKey = "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8"
+	testPublicKey  = "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d"
+	testInfo       = "Ode on a Grecian Urn"
+	testPlaintext  = "Beauty is truth, truth beauty"
+)
+
+var openTests = []struct {
+	kdfID, aeadID uint16
+	enc           string
+	ciphertexts   []string
+}{
+	{
+		kdfID: KDF_HKDF_SHA256, aeadID: AEAD_AES_128_GCM,
+		enc: "8e2ef13fb8d203e0f3911e66894d305da0dfd9dcae60e3ae1821b194185dc071",
+		ciphertexts: []string{
+			"d38ae60d6fb03e8324f3787e02d4779132e20faef4e7675e0649e50b0c65d25d420d0d858af0bea6bc85ed7f6e",
+			"f223fce7018118912e9a900689ca4587ab4337d6e0816215c8c4362d7b4621298afbcb1d7f91149761b12de5e7",
+		},
+	},
+	{
+		kdfID: KDF_HKDF_SHA256, aeadID: AEAD_AES_256_GCM,
+		enc: "0ec7e1da4429e8cb6557ef76bbfecab72a75e6f8cbb3da9c46ea69f700a8e424",
+		ciphertexts: []string{
+			"287e4f889d4894dec300abfba947feccf61f0bb796cefa05220aa8db5075e1433c04cbe23e83b92f43d749bb68",
+			"ebd5ded404ba69c238e8ef2ed7fea047b5e03a9904eeb0544c6b04aaca1798bb0a83e54724fdd233d602ba75db",
+		},
+	},
+	{
+		kdfID: KDF_HKDF_SHA256, aeadID: AEAD_ChaCha20Poly1305,
+		enc: "53609b9bd4a93f9fd618e48b5311bc773bd7fbd68f58aa441689dc1dca1bbd23",
+		ciphertexts: []string{
+			"6f4d6c48ed4dc6437cdc81ccdfebc4400fb9ee2784e5a5b95ac4f22a56033ed2154bc35d0b3188990495fd0c3f",
+			"5244544ae1a7c49fc07eab8ee6ec5e957536afeaf7b01220665a1cb65c27d6d6640db8f5c527b706be0f83bc0f",
+		},
+	},
+	{
+		kdfID: KDF_HKDF_SHA512, aeadID: AEAD_AES_128_GCM,
+		enc: "b22bdf4a22c8e93ee6b85d46cc9e09873e4ec55e7bbb36fd69ad21feab15ee36",
+		ciphertexts: []string{
+			"2e326f7ad19eed3aa817cd9be9bce463394b9341088566b4d2344df3d1cf860c7bd110e7aa4458ce2af5196c06",
+			"acaf3fb8c51e0e62563fbd4d1a616126d376a2bee22d23c6f053a0e2402ef224db155bf01b2a1621d29b8b0b88",
+		},
+	},
+	{
+		kdfID: KDF_HKDF_SHA512, aeadID: AEAD_AES_256_GCM,
+		enc: "3218b039c5bf41c1755ab1cfa6b5811c25ae176a2cd76fa5165a78701cbbcf51",
+		ciphertexts: []string{
+			"db4fab0729834b6817a663d9c8f1dc0ae4cdb72b0b5b91cfccc74b6469efa4ef5f1811cfef22b60f914c9745f4",
+			"aa31f768e94b42d1f72fa46be23f41e54b87864544b0d173861a14ad636ea075f5e8ae26c58167c99b7f407c18",
+		},
+	},
+	{
+		kdfID: KDF_HKDF_SHA512, aeadID: AEAD_ChaCha20Poly1305,
+		enc: "febfb910ec0d3ac6dd20a92e0aec9da3ac8fdef45316eaf9050a3dcbc7d06857",
+		ciphertexts: []string{
+			"e7394a2e52c2d82526cf5afb1b7eab115352611befb8faf0552a5e4505b07c43e2efdb6ff175da7f94a734ec7b",
+			"3eb915fc6ff33adda164c2c6e321ad03cc44b51a3f20f30a16cacc3a6a0b74a9b0668504dbebd06b3cd8abe428",
+		},
+	},
+}
+
+func TestOpen(t *testing.T) {
+	for _, tt := range openTests {
+		t.Run(fmt.Sprintf("KDF-%d/AEAD-%d", tt.kdfID, tt.aeadID), func(t *testing.T) {
+			r, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, tt.kdfID, tt.aeadID,
+				mustDecodeHex(t, testPrivateKey), []byte(testInfo), mustDecodeHex(t, tt.enc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, ct := range tt.ciphertexts {
+				aad := []byte(fmt.Sprintf("Count-%d", i))
+				pt, err := r.Open(aad, mustDecodeHex(t, ct))
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if string(pt) != testPlaintext {
+					t.Errorf("message %d: got %q, want %q", i, pt, testPlaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for kdfID := range SupportedKDFs {
+		for aeadID := range SupportedAEADs {
+			enc, s, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, kdfID, aeadID,
+				mustDecodeHex(t, testPublicKey), []byte(testInfo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, kdfID, aeadID,
+				mustDecodeHex(t, testPrivateKey), []byte(testInfo), enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				aad := []byte{byte(i)}
+				plaintext := bytes.Repeat([]byte{byte(i)}, i*10)
+				ct, err := s.Seal(aad, plaintext)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Open([]byte("wrong aad"), ct); err == nil {
+					t.Errorf("KDF %d, AEAD %d: Open succeeded with the wrong additional data", kdfID, aeadID)
+				}
+				pt, err := r.Open(aad, ct)
+				if err != nil {
+					t.Fatalf("KDF %d, AEAD %d: message %d: %v", kdfID, aeadID, i, err)
+				}
+				if !bytes.Equal(pt, plaintext) {
+					t.Errorf("KDF %d, AEAD %d: message %d: got %x, want %x", kdfID, aeadID, i, pt, plaintext)
+				}
+			}
+		}
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	pub, priv := mustDecodeHex(t, testPublicKey), mustDecodeHex(t, testPrivateKey)
+	if _, _, err := SetupSender(rand.Reader, 0x0010, KDF_HKDF_SHA256, AEAD_AES_128_GCM, pub, nil); err == nil {
+		t.Error("SetupSender accepted an unsupported KEM")
+	}
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, 0x0004, AEAD_AES_128_GCM, pub, nil); err == nil {
+		t.Error("SetupSender accepted an unsupported KDF")
+	}
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, 0xffff, pub, nil); err == nil {
+		t.Error("SetupSender accepted the export-only AEAD")
+	}
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, pub[:31], nil); err == nil {
+		t.Error("SetupSender accepted a short public key")
+	}
+	lowOrder := make([]byte, curve25519.PointSize)
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, lowOrder, nil); err == nil {
+		t.Error("SetupSender accepted a low order public key")
+	}
+	if _, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, priv, nil, lowOrder); err == nil {
+		t.Error("SetupRecipient accepted a low order encapsulated key")
+	}
+	if _, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, priv, nil, pub[:31]); err == nil {
+		t.Error("SetupRecipient accepted a short encapsulated key")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sha3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"io"
+	"testing"
+)
+
+var _ hash.Hash = (*Digest)(nil)
+
+func TestVectors(t *testing.T) {
+	long := bytes.Repeat([]byte{0xa3}, 200)
+	var seq []byte
+	for i := 0; i < 512; i++ {
+		seq = append(seq, byte(i))
+	}
+
+	tests := []struct {
+		name string
+		new  func() *Digest
+		in   []byte
+		out  string
+	}{
+		{"SHA3-256/empty", New256, nil, "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{"SHA3-256/512", New256, seq, "d4728ea5e9f3819f2b4760151a8f802dbe9f941fd6fb59b3715892436555772a"},
+		{"SHA3-512/abc", New512, []byte("abc"), "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0"},
+	}
+	for _, tt := range tests {
+		// Write one byte at a time to exercise partial blocks.
+		d := tt.new()
+		for i := range tt.in {
+			d.Write(tt.in[i : i+1])
+		}
+		if got := hex.EncodeToString(d.Sum(nil)); got != tt.out {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.out)
+		}
+		d.Reset()
+		d.Write(tt.in)
+		if got := hex.EncodeToString(d.Sum(nil)); got != tt.out {
+			t.Errorf("%s after Reset: got %s, want %s", tt.name, got, tt.out)
+		}
+	}
+
+	shakeTests := []struct {
+		name   string
+		new    func() *SHAKE
+		in     []byte
+		outLen int
+		suffix string
+	}{
+		{"SHAKE128/empty", NewShake128, nil, 32, "7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26"},
+		{"SHAKE128/long", NewShake128, long, 256, "71efbb47d4caeed5e6d633805d2d323e6fd81b4684b93a2677d45e7421c2c6ae"},
+		{"SHAKE256/abc", NewShake256, []byte("abc"), 64, "483366601360a8771c6863080cc4114d8db44530f8f1e1ee4f94ea37e78b5739d5a15bef186a5386c75744c0527e1faa9f8726e462a12a4feb06bd8801e751e4"},
+	}
+	for _, tt := range shakeTests {
+		s := tt.new()
+		s.Write(tt.in)
+		// Read in uneven chunks to exercise squeezing across blocks.
+		out := make([]byte, tt.outLen)
+		for b := out; len(b) > 0; {
+			n := 7
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := io.ReadFull(s, b[:n]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[n:]
+		}
+		got := hex.EncodeToString(out)
+		if got[len(got)-len(tt.suffix):] != tt.suffix {
+			t.Errorf("%s: got %s, want suffix %s", tt.name, got, tt.suffix)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sha3
+
+// SHAKE is a SHAKE128 or SHAKE256 extendable-output function state.
+type SHAKE struct {
+	d Digest // SHA-3 state context and Read/Write operations
+}
+
+// NewShake128 creates a new SHAKE128 XOF.
+func NewShake128() *SHAKE {
+	return &SHAKE{d: Digest{rate: rateK256, outputLen: 32, dsbyte: dsbyteShake}}
+}
+
+// NewShake256 creates a new SHAKE256 XOF.
+func NewShake256() *SHAKE {
+	return &SHAKE{d: Digest{rate: rateK512, outputLen: 64, dsbyte: dsbyteShake}}
+}
+
+// Write absorbs more data into the XOF's state.
+//
+// It panics if any output has already been read.
+func (s *SHAKE) Write(p []byte) (n int, err error) { return s.d.Write(p) }
+
+// Read squeezes more output from the XOF.
+//
+// Any call to Write after a call to Read will panic.
+func (s *SHAKE) Read(out []byte) (n int, err error) { return s.d.read(out) }
+
+// Reset resets the XOF to its initial state.
+func (s *SHAKE) Reset() { s.d.Reset() }
@@ -19,7 +19,10 @@
 // over the public key primitive, the PrivateKey type implements the
 // Decrypter and Signer interfaces from the crypto package.
 //
-// The RSA operations in this package are not implemented using constant-time algorithms.
+// Decryption and signing are implemented using constant-time algorithms, and
+// only leak the bit sizes of the key and its parameters. GenerateKey,
+// PrivateKey.Precompute, PrivateKey.Validate and the public key operations are
+// not implemented using constant-time algorithms.
 package rsa
 
 import (
@@ -32,6 +35,7 @@ import (
 	"math"
 	"math/big"
 
+	"crypto/internal/bigmod"
 	"crypto/internal/randutil"
 )
 
@@ -189,6 +193,12 @@ type PrecomputedValues struct {
 	// differently in PKCS #1 and interoperability is sufficiently
 	// important that we mirror this.
 	CRTValues []CRTValue
+
+	// n, p and q are the moduli used by the constant-time private key
+	// operations, and qInv is Qinv reduced modulo p. They are set only by
+	// Precompute, see precomputeModuli.
+	n, p, q *bigmod.Modulus
+	qInv    *bigmod.Nat
 }
 
 // CRTValue contains the precomputed Chinese remainder theorem values.
@@ -456,6 +466,10 @@ var ErrVerification = errors.New("crypto/rsa: verification error")
 // Precompute performs some calculations that speed up private key operations
 // in the future.
 func (priv *PrivateKey) Precompute() {
+	// The moduli are derived from the CRT values, so they are set up last,
+	// including when the CRT values were already there.
+	defer priv.precomputeModuli()
+
 	if priv.Precomputed.Dp != nil {
 		return
 	}
@@ -484,6 +498,54 @@ func (priv *PrivateKey) Precompute() {
 	}
 }
 
+// precomputeModuli stores the result of crtModuli in priv.Precomputed, unless
+// an earlier call did. Only Precompute calls it: other methods may run
+// concurrently with private key operations, so they must not write to priv.
+// For keys on which Precompute wasn't called, such as keys built by hand or
+// copied before Precompute, decrypt calls crtModuli itself.
+func (priv *PrivateKey) precomputeModuli() {
+	pre := &priv.Precomputed
+	if pre.n != nil {
+		return
+	}
+	pre.n, pre.p, pre.q, pre.qInv = priv.crtModuli()
+}
+
+// crtModuli returns the moduli used by decrypt, and Qinv reduced modulo p. n
+// is nil if N is not odd. p, q and qInv are nil unless the key has exactly two
+// odd primes and its Dp, Dq and Qinv values, in which case decrypt uses the
+// CRT. Otherwise it performs a single exponentiation modulo N.
+func (priv *PrivateKey) crtModuli() (n, p, q *bigmod.Modulus, qInv *bigmod.Nat) {
+	if priv.N == nil || priv.N.Bit(0) == 0 {
+		return nil, nil, nil, nil
+	}
+	n, err := bigmod.NewModulusFromBig(priv.N)
+	if err != nil {
+		return nil, nil, nil, nil
+	}
+
+	pre := &priv.Precomputed
+	if len(priv.Primes) != 2 || pre.Dp == nil || pre.Dq == nil || pre.Qinv == nil {
+		return n, nil, nil, nil
+	}
+	if priv.Primes[0].Bit(0) == 0 || priv.Primes[1].Bit(0) == 0 {
+		return n, nil, nil, nil
+	}
+	p, err = bigmod.NewModulusFromBig(priv.Primes[0])
+	if err != nil {
+		return n, nil, nil, nil
+	}
+	q, err = bigmod.NewModulusFromBig(priv.Primes[1])
+	if err != nil {
+		return n, nil, nil, nil
+	}
+	qInv, err = bigmod.NewNat().SetBytes(pre.Qinv.Bytes(), p)
+	if err != nil {
+		return n, nil, nil, nil
+	}
+	return n, p, q, qInv
+}
+
 // decrypt performs an RSA decryption, resulting in a plaintext integer. If a
 // random source is given, RSA blinding is used.
 func decrypt(random io.Reader, priv *PrivateKey, c *big.Int) (m *big.Int, err error) {
@@ -496,6 +558,16 @@ func decrypt(random io.Reader, priv *PrivateKey, c *big.Int) (m *big.Int, err er
 		return nil, ErrDecryption
 	}
 
+	N, P, Q, qInv := priv.Precomputed.n, priv.Precomputed.p, priv.Precomputed.q, priv.Precomputed.qInv
+	if N == nil {
+		N, P, Q, qInv = priv.crtModuli()
+	}
+	// The constant-time exponentiation requires an odd modulus, which any
+	// valid RSA modulus is.
+	if N == nil {
+		return nil, ErrDecryption
+	}
+
 	var ir *big.Int
 	if random != nil {
 		randutil.MaybeReadByte(random)
@@ -528,42 +600,41 @@ func decrypt(random io.Reader, priv *PrivateKey, c *big.Int) (m *big.Int, err er
 		c = cCopy
 	}
 
-	if priv.Precomputed.Dp == nil {
-		m = new(big.Int).Exp(c, priv.D, priv.N)
+	cNat, err := bigmod.NewNat().SetBytes(c.Bytes(), N)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	var mNat *bigmod.Nat
+	if P == nil {
+		mNat = bigmod.NewNat().Exp(cNat, priv.D.Bytes(), N)
 	} else {
 		// We have the precalculated values needed for the CRT.
-		m = new(big.Int).Exp(c, priv.Precomputed.Dp, priv.Primes[0])
-		m2 := new(big.Int).Exp(c, priv.Precomputed.Dq, priv.Primes[1])
-		m.Sub(m, m2)
-		if m.Sign() < 0 {
-			m.Add(m, priv.Primes[0])
-		}
-		m.Mul(m, priv.Precomputed.Qinv)
-		m.Mod(m, priv.Primes[0])
-		m.Mul(m, priv.Primes[1])
-		m.Add(m, m2)
-
-		for i, values := range priv.Precomputed.CRTValues {
-			prime := priv.Primes[2+i]
-			m2.Exp(c, values.Exp, prime)
-			m2.Sub(m2, m)
-			m2.Mul(m2, values.Coeff)
-			m2.Mod(m2, prime)
-			if m2.Sign() < 0 {
-				m2.Add(m2, prime)
-			}
-			m2.Mul(m2, values.R)
-			m.Add(m, m2)
-		}
+		t0 := bigmod.NewNat()
+		// m = c ^ Dp mod p
+		mNat = bigmod.NewNat().Exp(t0.Mod(cNat, P), priv.Precomputed.Dp.Bytes(), P)
+		// m2 = c ^ Dq mod q
+		m2 := bigmod.NewNat().Exp(t0.Mod(cNat, Q), priv.Precomputed.Dq.Bytes(), Q)
+		// m = m - m2 mod p
+		mNat.Sub(t0.Mod(m2, P), P)
+		// m = m * Qinv mod p
+		mNat.Mul(qInv, P)
+		// m = m * q mod N
+		mNat.ExpandFor(N).Mul(t0.Mod(Q.Nat(), N), N)
+		// m = m + m2 mod N
+		mNat.Add(m2.ExpandFor(N), N)
 	}
 
 	if ir != nil {
 		// Unblind.
-		m.Mul(m, ir)
-		m.Mod(m, priv.N)
+		irNat, err := bigmod.NewNat().SetBytes(ir.Bytes(), N)
+		if err != nil {
+			return nil, ErrDecryption
+		}
+		mNat.Mul(irNat, N)
 	}
 
-	return
+	return new(big.Int).SetBytes(mNat.Bytes(N)), nil
 }
 
 func decryptAndCheck(random io.Reader, priv *PrivateKey, c *big.Int) (m *big.Int, err error) {
@@ -10,6 +10,7 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"fmt"
 	"math/big"
 	"testing"
 )
@@ -132,6 +133,120 @@ func testKeyBasics(t *testing.T, priv *PrivateKey) {
 	}
 }
 
+func TestDecryptPrecomputedValues(t *testing.T) {
+	// Decryption must give the same result with the CRT, without it, and with
+	// precomputed values filled in by hand without calling Precompute.
+	c := encrypt(new(big.Int), &test2048Key.PublicKey, big.NewInt(42))
+
+	noCRT := *test2048Key
+	noCRT.Precomputed = PrecomputedValues{}
+	byHand := *test2048Key
+	byHand.Precomputed = PrecomputedValues{
+		Dp:   test2048Key.Precomputed.Dp,
+		Dq:   test2048Key.Precomputed.Dq,
+		Qinv: test2048Key.Precomputed.Qinv,
+	}
+
+	for name, priv := range map[string]*PrivateKey{
+		"CRT":    test2048Key,
+		"no CRT": &noCRT,
+		"byHand": &byHand,
+	} {
+		m, err := decrypt(rand.Reader, priv, c)
+		if err != nil {
+			t.Errorf("%s: error while decrypting: %s", name, err)
+			continue
+		}
+		if m.Int64() != 42 {
+			t.Errorf("%s: got %v, want 42", name, m)
+		}
+	}
+
+	byHand.Precompute()
+	if byHand.Precomputed.p == nil {
+		t.Errorf("Precompute did not set the moduli for precomputed values")
+	}
+}
+
+func TestHandBuiltKey(t *testing.T) {
+	// A key built field by field, as by a parser of another key format, and
+	// never passed to Precompute.
+	priv := &PrivateKey{
+		PublicKey: PublicKey{N: new(big.Int).Set(test2048Key.N), E: test2048Key.E},
+		D:         new(big.Int).Set(test2048Key.D),
+		Primes:    []*big.Int{test2048Key.Primes[0], test2048Key.Primes[1]},
+		Precomputed: PrecomputedValues{
+			Dp:   new(big.Int).Set(test2048Key.Precomputed.Dp),
+			Dq:   new(big.Int).Set(test2048Key.Precomputed.Dq),
+			Qinv: new(big.Int).Set(test2048Key.Precomputed.Qinv),
+		},
+	}
+	copied := *priv
+
+	if _, p, _, _ := priv.crtModuli(); p == nil {
+		t.Fatal("crtModuli does not use the CRT for a key built by hand")
+	}
+	if err := priv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if priv.Precomputed.n != nil {
+		t.Fatal("Validate set the moduli of a key built by hand")
+	}
+	priv.Precompute()
+	pre := priv.Precomputed
+	if pre.n == nil || pre.p == nil || pre.q == nil || pre.qInv == nil {
+		t.Fatal("Precompute did not set the moduli of a key built by hand")
+	}
+	if copied.Precomputed.n != nil {
+		t.Fatal("Precompute changed a copy of the key")
+	}
+
+	c := encrypt(new(big.Int), &test2048Key.PublicKey, big.NewInt(42))
+	for name, priv := range map[string]*PrivateKey{
+		"precomputed": priv,
+		"copied":      &copied,
+	} {
+		m, err := decrypt(rand.Reader, priv, c)
+		if err != nil {
+			t.Errorf("%s: error while decrypting: %s", name, err)
+			continue
+		}
+		if m.Int64() != 42 {
+			t.Errorf("%s: got %v, want 42", name, m)
+		}
+	}
+}
+
+func TestValidateConcurrentDecrypt(t *testing.T) {
+	// Validate must not write to the key, which may be in use by private
+	// key operations on other goroutines. The race detector checks this.
+	priv := &PrivateKey{
+		PublicKey: PublicKey{N: new(big.Int).Set(test2048Key.N), E: test2048Key.E},
+		D:         new(big.Int).Set(test2048Key.D),
+		Primes:    []*big.Int{test2048Key.Primes[0], test2048Key.Primes[1]},
+		Precomputed: PrecomputedValues{
+			Dp:   new(big.Int).Set(test2048Key.Precomputed.Dp),
+			Dq:   new(big.Int).Set(test2048Key.Precomputed.Dq),
+			Qinv: new(big.Int).Set(test2048Key.Precomputed.Qinv),
+		},
+	}
+	c := encrypt(new(big.Int), &test2048Key.PublicKey, big.NewInt(42))
+	done := make(chan error)
+	go func() {
+		m, err := decrypt(rand.Reader, priv, c)
+		if err == nil && m.Int64() != 42 {
+			err = fmt.Errorf("got %v, want 42", m)
+		}
+		done <- err
+	}()
+	if err := priv.Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("error while decrypting: %s", err)
+	}
+}
+
 func fromBase10(base10 string) *big.Int {
 	i, ok := new(big.Int).SetString(base10, 10)
 	if !ok {
@@ -0,0 +1,602 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/internal/hpke"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// This file contains the Encrypted Client Hello (ECH) support shared by the
+// client and the server. See RFC 9849.
+
+type echCipher struct {
+	KDFID  uint16
+	AEADID uint16
+}
+
+type echExtension struct {
+	Type uint16
+	Data []byte
+}
+
+type echConfig struct {
+	raw []byte
+
+	Version uint16
+	Length  uint16
+
+	ConfigID             uint8
+	KemID                uint16
+	PublicKey            []byte
+	SymmetricCipherSuite []echCipher
+
+	MaxNameLength uint8
+	PublicName    []byte
+	Extensions    []echExtension
+}
+
+var errMalformedECHConfig = errors.New("tls: malformed ECHConfigList")
+
+// parseECHConfig parses the ECHConfig at the start of enc. If the config has
+// a version other than the one implemented here, skip is true and the config
+// should be ignored.
+func parseECHConfig(enc []byte) (skip bool, ec echConfig, err error) {
+	s := cryptobyte.String(enc)
+	ec.raw = enc
+	if !s.ReadUint16(&ec.Version) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	if !s.ReadUint16(&ec.Length) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	if len(ec.raw) < int(ec.Length)+4 {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	ec.raw = ec.raw[:ec.Length+4]
+	if ec.Version != extensionEncryptedClientHello {
+		s.Skip(int(ec.Length))
+		return true, echConfig{}, nil
+	}
+	if !s.ReadUint8(&ec.ConfigID) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	if !s.ReadUint16(&ec.KemID) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	if !readUint16LengthPrefixed(&s, &ec.PublicKey) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	var cipherSuites cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&cipherSuites) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	for !cipherSuites.Empty() {
+		var c echCipher
+		if !cipherSuites.ReadUint16(&c.KDFID) {
+			return false, echConfig{}, errMalformedECHConfig
+		}
+		if !cipherSuites.ReadUint16(&c.AEADID) {
+			return false, echConfig{}, errMalformedECHConfig
+		}
+		ec.SymmetricCipherSuite = append(ec.SymmetricCipherSuite, c)
+	}
+	if !s.ReadUint8(&ec.MaxNameLength) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	if !readUint8LengthPrefixed(&s, &ec.PublicName) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return false, echConfig{}, errMalformedECHConfig
+	}
+	for !extensions.Empty() {
+		var e echExtension
+		if !extensions.ReadUint16(&e.Type) {
+			return false, echConfig{}, errMalformedECHConfig
+		}
+		if !readUint16LengthPrefixed(&extensions, &e.Data) {
+			return false, echConfig{}, errMalformedECHConfig
+		}
+		ec.Extensions = append(ec.Extensions, e)
+	}
+
+	return false, ec, nil
+}
+
+// parseECHConfigList parses an ECHConfigList, returning the configs with a
+// supported version in the order they appear in the list, or an error if the
+// list is malformed.
+func parseECHConfigList(data []byte) ([]echConfig, error) {
+	s := cryptobyte.String(data)
+	var length uint16
+	if !s.ReadUint16(&length) {
+		return nil, errMalformedECHConfig
+	}
+	if length != uint16(len(data)-2) {
+		return nil, errMalformedECHConfig
+	}
+	var configs []echConfig
+	for len(s) > 0 {
+		if len(s) < 4 {
+			return nil, errMalformedECHConfig
+		}
+		configLen := uint16(s[2])<<8 | uint16(s[3])
+		skip, ec, err := parseECHConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		s = s[configLen+4:]
+		if !skip {
+			configs = append(configs, ec)
+		}
+	}
+	return configs, nil
+}
+
+// pickECHConfig returns the first config in list that uses a supported KEM
+// and has no mandatory extensions, together with the first of its cipher
+// suites that is supported. It returns nil if there is no such config.
+func pickECHConfig(list []echConfig) (*echConfig, echCipher) {
+	for _, ec := range list {
+		if !validDNSName(string(ec.PublicName)) {
+			continue
+		}
+		var unsupportedExt bool
+		for _, ext := range ec.Extensions {
+			// If the high order bit is set the extension is mandatory, and
+			// as none are supported, the config can't be used.
+			if ext.Type&uint16(1<<15) != 0 {
+				unsupportedExt = true
+			}
+		}
+		if unsupportedExt {
+			continue
+		}
+		if keyLen, ok := hpke.SupportedKEMs[ec.KemID]; !ok || len(ec.PublicKey) != keyLen {
+			continue
+		}
+		for _, cs := range ec.SymmetricCipherSuite {
+			if _, ok := hpke.SupportedKDFs[cs.KDFID]; !ok {
+				continue
+			}
+			if _, ok := hpke.SupportedAEADs[cs.AEADID]; !ok {
+				continue
+			}
+			return &ec, cs
+		}
+	}
+	return nil, echCipher{}
+}
+
+// encodeInnerClientHello returns the EncodedClientHelloInner for inner,
+// padded according to RFC 9849, Section 6.1.3. Extensions are not compressed.
+func encodeInnerClientHello(inner *clientHelloMsg, maxNameLength int) []byte {
+	// The legacy_session_id is omitted, and the server restores it from the
+	// outer ClientHello. See RFC 9849, Section 5.1.
+	encoded := *inner
+	encoded.raw = nil
+	encoded.sessionId = nil
+	h := encoded.marshal()[4:] // strip the handshake message header
+
+	var paddingLen int
+	if inner.serverName != "" {
+		if n := maxNameLength - len(inner.serverName); n > 0 {
+			paddingLen = n
+		}
+	} else {
+		paddingLen = maxNameLength + 9
+	}
+	paddingLen += 31 - ((len(h) + paddingLen - 1) % 32)
+
+	return append(h, make([]byte, paddingLen)...)
+}
+
+func skipUint8LengthPrefixed(s *cryptobyte.String) bool {
+	var skip uint8
+	if !s.ReadUint8(&skip) {
+		return false
+	}
+	return s.Skip(int(skip))
+}
+
+func skipUint16LengthPrefixed(s *cryptobyte.String) bool {
+	var skip uint16
+	if !s.ReadUint16(&skip) {
+		return false
+	}
+	return s.Skip(int(skip))
+}
+
+type rawExtension struct {
+	extType uint16
+	data    []byte
+}
+
+// extractRawExtensions returns the extensions of the marshaled ClientHello
+// hello, in the order they appear in the message.
+func extractRawExtensions(hello []byte) ([]rawExtension, error) {
+	s := cryptobyte.String(hello)
+	if !s.Skip(4+2+32) || // header, version, random
+		!skipUint8LengthPrefixed(&s) || // session ID
+		!skipUint16LengthPrefixed(&s) || // cipher suites
+		!skipUint8LengthPrefixed(&s) { // compression methods
+		return nil, errors.New("tls: malformed outer client hello")
+	}
+	var rawExtensions []rawExtension
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("tls: malformed outer client hello")
+	}
+
+	for !extensions.Empty() {
+		var extension uint16
+		var extData cryptobyte.String
+		if !extensions.ReadUint16(&extension) ||
+			!extensions.ReadUint16LengthPrefixed(&extData) {
+			return nil, errors.New("tls: malformed outer client hello")
+		}
+		rawExtensions = append(rawExtensions, rawExtension{extension, extData})
+	}
+	return rawExtensions, nil
+}
+
+// decodeInnerClientHello reconstructs the ClientHelloInner from its encoding,
+// restoring the legacy_session_id and any extensions compressed with
+// ech_outer_extensions from the outer ClientHello. See RFC 9849, Section 5.1.
+func decodeInnerClientHello(outer *clientHelloMsg, encoded []byte) (*clientHelloMsg, error) {
+	innerReader := cryptobyte.String(encoded)
+	var versionAndRandom, sessionID, cipherSuites, compressionMethods []byte
+	var extensions cryptobyte.String
+	if !innerReader.ReadBytes(&versionAndRandom, 2+32) ||
+		!readUint8LengthPrefixed(&innerReader, &sessionID) ||
+		len(sessionID) != 0 ||
+		!readUint16LengthPrefixed(&innerReader, &cipherSuites) ||
+		!readUint8LengthPrefixed(&innerReader, &compressionMethods) ||
+		!innerReader.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("tls: invalid inner client hello")
+	}
+
+	// The padding must be all zeroes.
+	for _, p := range innerReader {
+		if p != 0 {
+			return nil, errors.New("tls: invalid inner client hello")
+		}
+	}
+
+	rawOuterExts, err := extractRawExtensions(outer.raw)
+	if err != nil {
+		return nil, err
+	}
+
+	recon := cryptobyte.NewBuilder(nil)
+	recon.AddUint8(typeClientHello)
+	recon.AddUint24LengthPrefixed(func(recon *cryptobyte.Builder) {
+		recon.AddBytes(versionAndRandom)
+		recon.AddUint8LengthPrefixed(func(recon *cryptobyte.Builder) {
+			recon.AddBytes(outer.sessionId)
+		})
+		recon.AddUint16LengthPrefixed(func(recon *cryptobyte.Builder) {
+			recon.AddBytes(cipherSuites)
+		})
+		recon.AddUint8LengthPrefixed(func(recon *cryptobyte.Builder) {
+			recon.AddBytes(compressionMethods)
+		})
+		recon.AddUint16LengthPrefixed(func(recon *cryptobyte.Builder) {
+			for !extensions.Empty() {
+				var extension uint16
+				var extData cryptobyte.String
+				if !extensions.ReadUint16(&extension) ||
+					!extensions.ReadUint16LengthPrefixed(&extData) {
+					recon.SetError(errors.New("tls: invalid inner client hello"))
+					return
+				}
+				if extension != extensionECHOuterExtensions {
+					recon.AddUint16(extension)
+					recon.AddUint16LengthPrefixed(func(recon *cryptobyte.Builder) {
+						recon.AddBytes(extData)
+					})
+					continue
+				}
+				// The referenced outer extensions must appear in the same
+				// order in the outer ClientHello.
+				var outerExts cryptobyte.String
+				if !extData.ReadUint8LengthPrefixed(&outerExts) || !extData.Empty() {
+					recon.SetError(errors.New("tls: invalid inner client hello"))
+					return
+				}
+				var i int
+				for !outerExts.Empty() {
+					var extType uint16
+					if !outerExts.ReadUint16(&extType) {
+						recon.SetError(errors.New("tls: invalid inner client hello"))
+						return
+					}
+					if extType == extensionEncryptedClientHello {
+						recon.SetError(errors.New("tls: invalid outer extensions"))
+						return
+					}
+					for ; i <= len(rawOuterExts); i++ {
+						if i == len(rawOuterExts) {
+							recon.SetError(errors.New("tls: invalid outer extensions"))
+							return
+						}
+						if rawOuterExts[i].extType == extType {
+							break
+						}
+					}
+					ext := rawOuterExts[i]
+					recon.AddUint16(ext.extType)
+					recon.AddUint16LengthPrefixed(func(recon *cryptobyte.Builder) {
+						recon.AddBytes(ext.data)
+					})
+					i++
+				}
+			}
+		})
+	})
+
+	reconBytes, err := recon.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	inner := &clientHelloMsg{}
+	if !inner.unmarshal(reconBytes) {
+		return nil, errors.New("tls: invalid reconstructed inner client hello")
+	}
+
+	if !bytes.Equal(inner.encryptedClientHello, []byte{byte(innerECHExt)}) {
+		return nil, errInvalidECHExt
+	}
+
+	hasTLS13 := false
+	for _, v := range inner.supportedVersions {
+		// Skip GREASE values, of the form 0x?A?A. See RFC 8701.
+		if v&0x0F0F == 0x0A0A && v&0xff == v>>8 {
+			continue
+		}
+		if v == VersionTLS13 {
+			hasTLS13 = true
+		} else if v < VersionTLS13 {
+			// ECH requires TLS 1.3 or later. See RFC 9849, Section 7.1.
+			return nil, errors.New("tls: client sent encrypted_client_hello extension with unsupported versions")
+		}
+	}
+	if !hasTLS13 {
+		return nil, errors.New("tls: client sent encrypted_client_hello extension but did not offer TLS 1.3")
+	}
+
+	return inner, nil
+}
+
+// decryptECHPayload decrypts the ECH payload from the marshaled ClientHello
+// hello, authenticating the rest of the message as additional data.
+func decryptECHPayload(context *hpke.Recipient, hello, payload []byte) ([]byte, error) {
+	// The additional data is the ClientHelloOuter with the payload replaced
+	// by zeroes. See RFC 9849, Section 5.2.
+	outerAAD := bytes.Replace(hello[4:], payload, make([]byte, len(payload)), 1)
+	return context.Open(outerAAD, payload)
+}
+
+func marshalOuterECHExt(id uint8, cs echCipher, encodedKey, payload []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(byte(outerECHExt))
+	b.AddUint16(cs.KDFID)
+	b.AddUint16(cs.AEADID)
+	b.AddUint8(id)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(encodedKey) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(payload) })
+	return b.BytesOrPanic()
+}
+
+// computeAndUpdateOuterECHExtension encrypts inner and sets the result as
+// the encrypted_client_hello extension of outer. The encapsulated key is only
+// sent in the first ClientHello. See RFC 9849, Section 6.1.5.
+func computeAndUpdateOuterECHExtension(outer, inner *clientHelloMsg, ech *echClientContext, useKey bool) error {
+	var encapKey []byte
+	if useKey {
+		encapKey = ech.encapsulatedKey
+	}
+	encodedInner := encodeInnerClientHello(inner, int(ech.config.MaxNameLength))
+	// All the supported AEADs have a 16 bytes tag. If support is added for
+	// an AEAD with a different tag length, this must be changed.
+	encryptedLen := len(encodedInner) + 16
+	outer.encryptedClientHello = marshalOuterECHExt(ech.config.ConfigID, ech.cipherSuite, encapKey, make([]byte, encryptedLen))
+	outer.raw = nil
+	// The additional data is the ClientHelloOuter with a zeroed payload. See
+	// RFC 9849, Section 5.2.
+	aad := outer.marshal()[4:] // strip the handshake message header
+	encryptedInner, err := ech.hpkeContext.Seal(aad, encodedInner)
+	if err != nil {
+		return err
+	}
+	outer.encryptedClientHello = marshalOuterECHExt(ech.config.ConfigID, ech.cipherSuite, encapKey, encryptedInner)
+	outer.raw = nil
+	return nil
+}
+
+// validDNSName is a rather rudimentary check for the validity of a DNS name.
+// This is used to check if the public_name in a ECHConfig is valid when we are
+// picking a config. This can be somewhat lax because even if we pick a
+// valid-looking name, the DNS layer will later reject it anyway.
+func validDNSName(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) <= 1 {
+		return false
+	}
+	for _, l := range labels {
+		labelLen := len(l)
+		if labelLen == 0 {
+			return false
+		}
+		for i, r := range l {
+			if r == '-' && (i == 0 || i == labelLen-1) {
+				return false
+			}
+			if (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ECHRejectionError is the error type returned when ECH is rejected by a remote
+// server. If the server offered a ECHConfigList to use for retries, the
+// RetryConfigList field will contain this list.
+//
+// The client may treat an ECHRejectionError with an empty set of RetryConfigs
+// as a secure signal from the server.
+type ECHRejectionError struct {
+	RetryConfigList []byte
+}
+
+func (e *ECHRejectionError) Error() string {
+	return "tls: server rejected ECH"
+}
+
+var errMalformedECHExt = errors.New("tls: malformed encrypted_client_hello extension")
+var errInvalidECHExt = errors.New("tls: client sent invalid encrypted_client_hello extension")
+
+type echExtType uint8
+
+const (
+	innerECHExt echExtType = 1
+	outerECHExt echExtType = 0
+)
+
+func parseECHExt(ext []byte) (echType echExtType, cs echCipher, configID uint8, encap []byte, payload []byte, err error) {
+	s := cryptobyte.String(ext)
+	var echInt uint8
+	if !s.ReadUint8(&echInt) {
+		err = errMalformedECHExt
+		return
+	}
+	echType = echExtType(echInt)
+	if echType == innerECHExt {
+		if !s.Empty() {
+			err = errMalformedECHExt
+			return
+		}
+		return echType, cs, 0, nil, nil, nil
+	}
+	if echType != outerECHExt {
+		err = errInvalidECHExt
+		return
+	}
+	if !s.ReadUint16(&cs.KDFID) ||
+		!s.ReadUint16(&cs.AEADID) ||
+		!s.ReadUint8(&configID) ||
+		!readUint16LengthPrefixed(&s, &encap) ||
+		!readUint16LengthPrefixed(&s, &payload) ||
+		!s.Empty() {
+		err = errMalformedECHExt
+		return
+	}
+
+	// Copy encap and payload so that they don't alias the raw message.
+	encap = append([]byte(nil), encap...)
+	payload = append([]byte(nil), payload...)
+	return echType, cs, configID, encap, payload, nil
+}
+
+// processECHClientHello attempts to decrypt the ClientHelloInner from the
+// outer ClientHello using the configured EncryptedClientHelloKeys. It returns
+// the ClientHello to continue the handshake with, and a non-nil context if
+// ECH was accepted, or if outer is itself an inner ClientHello.
+func (c *Conn) processECHClientHello(outer *clientHelloMsg) (*clientHelloMsg, *echServerContext, error) {
+	echType, echCiphersuite, configID, encap, payload, err := parseECHExt(outer.encryptedClientHello)
+	if err != nil {
+		if err == errInvalidECHExt {
+			c.sendAlert(alertIllegalParameter)
+		} else {
+			c.sendAlert(alertDecodeError)
+		}
+		return nil, nil, errInvalidECHExt
+	}
+
+	if echType == innerECHExt {
+		// This is a backend server behind a client-facing server that
+		// already decrypted the ClientHello. See RFC 9849, Section 7.2.
+		return outer, &echServerContext{inner: true}, nil
+	}
+
+	for _, echKey := range c.config.EncryptedClientHelloKeys {
+		skip, config, err := parseECHConfig(echKey.Config)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return nil, nil, fmt.Errorf("tls: invalid EncryptedClientHelloKey Config: %s", err)
+		}
+		if skip {
+			continue
+		}
+		if _, ok := hpke.SupportedKEMs[config.KemID]; !ok {
+			c.sendAlert(alertInternalError)
+			return nil, nil, fmt.Errorf("tls: invalid EncryptedClientHelloKey Config: unsupported KEM %#04x", config.KemID)
+		}
+		info := append([]byte("tls ech\x00"), echKey.Config...)
+		hpkeContext, err := hpke.SetupRecipient(config.KemID, echCiphersuite.KDFID, echCiphersuite.AEADID,
+			echKey.PrivateKey, info, encap)
+		if err != nil {
+			// Attempt the next trial decryption.
+			continue
+		}
+
+		encodedInner, err := decryptECHPayload(hpkeContext, outer.raw, payload)
+		if err != nil {
+			// Attempt the next trial decryption.
+			continue
+		}
+
+		// The server_name of the outer ClientHello is not required to match
+		// the public_name of the config, as the client already needed to know
+		// the config to encrypt the payload. That check is only a MAY.
+
+		echInner, err := decodeInnerClientHello(outer, encodedInner)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return nil, nil, errInvalidECHExt
+		}
+
+		c.echAccepted = true
+
+		return echInner, &echServerContext{
+			hpkeContext: hpkeContext,
+			configID:    configID,
+			ciphersuite: echCiphersuite,
+		}, nil
+	}
+
+	return outer, nil, nil
+}
+
+// buildRetryConfigList returns the ECHConfigList of the keys that should be
+// sent as retry configs, or nil if there are none.
+func buildRetryConfigList(keys []EncryptedClientHelloKey) ([]byte, error) {
+	var atLeastOneRetryConfig bool
+	var retryBuilder cryptobyte.Builder
+	retryBuilder.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, c := range keys {
+			if !c.SendAsRetry {
+				continue
+			}
+			atLeastOneRetryConfig = true
+			b.AddBytes(c.Config)
+		}
+	})
+	if !atLeastOneRetryConfig {
+		return nil, nil
+	}
+	return retryBuilder.Bytes()
+}
@@ -0,0 +1,358 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/internal/hpke"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/curve25519"
+)
+
+func marshalTestECHConfig(id uint8, pubKey []byte, publicName string, maxNameLength uint8) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(extensionEncryptedClientHello)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(id)
+		b.AddUint16(hpke.DHKEM_X25519_HKDF_SHA256)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(pubKey)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(hpke.KDF_HKDF_SHA256)
+			b.AddUint16(hpke.AEAD_AES_128_GCM)
+			b.AddUint16(hpke.KDF_HKDF_SHA256)
+			b.AddUint16(hpke.AEAD_ChaCha20Poly1305)
+		})
+		b.AddUint8(maxNameLength)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(publicName))
+		})
+		b.AddUint16(0) // extensions
+	})
+	return b.BytesOrPanic()
+}
+
+func marshalTestECHConfigList(configs ...[]byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, c := range configs {
+			b.AddBytes(c)
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+func newTestECHKey(t *testing.T, id uint8, publicName string) EncryptedClientHelloKey {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(priv); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return EncryptedClientHelloKey{
+		Config:     marshalTestECHConfig(id, pub, publicName, 32),
+		PrivateKey: priv,
+	}
+}
+
+func TestParseECHConfigList(t *testing.T) {
+	pub := make([]byte, 32)
+	pub[0] = 9
+	config := marshalTestECHConfig(7, pub, "public.example", 32)
+
+	configs, err := parseECHConfigList(marshalTestECHConfigList(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("got %d configs, want 1", len(configs))
+	}
+	ec := configs[0]
+	if ec.ConfigID != 7 || ec.KemID != hpke.DHKEM_X25519_HKDF_SHA256 ||
+		!bytes.Equal(ec.PublicKey, pub) || string(ec.PublicName) != "public.example" ||
+		ec.MaxNameLength != 32 || len(ec.SymmetricCipherSuite) != 2 {
+		t.Errorf("unexpected parsed config: %+v", ec)
+	}
+	if !bytes.Equal(ec.raw, config) {
+		t.Errorf("raw config not preserved")
+	}
+
+	// Configs with an unknown version are skipped.
+	unknown := []byte{0xfe, 0x0c, 0x00, 0x02, 0xaa, 0xbb}
+	configs, err = parseECHConfigList(marshalTestECHConfigList(unknown, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].ConfigID != 7 {
+		t.Errorf("expected unknown version to be skipped, got %+v", configs)
+	}
+
+	for i, bad := range [][]byte{
+		nil,
+		{0x00},
+		{0x00, 0x05, 0xfe},
+		marshalTestECHConfigList(config)[:len(config)],
+		marshalTestECHConfigList(config[:len(config)-1]),
+		marshalTestECHConfigList(unknown[:5]),
+	} {
+		if _, err := parseECHConfigList(bad); err == nil {
+			t.Errorf("#%d: expected error for malformed list %x", i, bad)
+		}
+	}
+}
+
+func TestPickECHConfig(t *testing.T) {
+	pub := make([]byte, 32)
+	good := marshalTestECHConfig(1, pub, "public.example", 0)
+	badName := marshalTestECHConfig(2, pub, "not a name", 0)
+	badKey := marshalTestECHConfig(3, pub[:31], "public.example", 0)
+
+	configs, err := parseECHConfigList(marshalTestECHConfigList(badName, badKey, good))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, cs := pickECHConfig(configs)
+	if ec == nil || ec.ConfigID != 1 {
+		t.Fatalf("picked %+v, want config 1", ec)
+	}
+	if cs.KDFID != hpke.KDF_HKDF_SHA256 || cs.AEADID != hpke.AEAD_AES_128_GCM {
+		t.Errorf("picked cipher suite %+v", cs)
+	}
+
+	configs, err = parseECHConfigList(marshalTestECHConfigList(badName, badKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec, _ := pickECHConfig(configs); ec != nil {
+		t.Errorf("picked invalid config %+v", ec)
+	}
+}
+
+func testECHConfigs(t *testing.T) (clientConfig, serverConfig *Config) {
+	key := newTestECHKey(t, 1, "public.example")
+
+	serverConfig = testConfig.Clone()
+	serverConfig.Rand = rand.Reader
+	serverConfig.MinVersion = VersionTLS13
+	serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{key}
+
+	clientConfig = testConfig.Clone()
+	clientConfig.Rand = rand.Reader
+	clientConfig.ServerName = "secret.example"
+	clientConfig.EncryptedClientHelloConfigList = marshalTestECHConfigList(key.Config)
+	return clientConfig, serverConfig
+}
+
+func TestECHHandshake(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientCurves []CurveID
+		serverCurves []CurveID
+		clientName   string
+	}{
+		{name: "Basic"},
+		{name: "HelloRetryRequest", clientCurves: []CurveID{X25519, CurveP256}, serverCurves: []CurveID{CurveP256}},
+		{name: "NoServerName", clientName: "-"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientConfig, serverConfig := testECHConfigs(t)
+			clientConfig.CurvePreferences = test.clientCurves
+			serverConfig.CurvePreferences = test.serverCurves
+			if test.clientName == "-" {
+				clientConfig.ServerName = ""
+			}
+
+			var gotName string
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				gotName = chi.ServerName
+				return nil, nil
+			}
+
+			serverState, clientState, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !clientState.ECHAccepted {
+				t.Errorf("client did not accept ECH")
+			}
+			if !serverState.ECHAccepted {
+				t.Errorf("server did not accept ECH")
+			}
+			if gotName != clientConfig.ServerName {
+				t.Errorf("GetConfigForClient saw server name %q, want %q", gotName, clientConfig.ServerName)
+			}
+			if serverState.ServerName != clientConfig.ServerName {
+				t.Errorf("server saw server name %q, want %q", serverState.ServerName, clientConfig.ServerName)
+			}
+			if clientState.ServerName != clientConfig.ServerName {
+				t.Errorf("client connection state has server name %q, want %q", clientState.ServerName, clientConfig.ServerName)
+			}
+		})
+	}
+}
+
+func TestECHResumption(t *testing.T) {
+	clientConfig, serverConfig := testECHConfigs(t)
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(32)
+
+	for i := 0; i < 2; i++ {
+		serverState, clientState, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake #%d: %v", i, err)
+		}
+		if !clientState.ECHAccepted || !serverState.ECHAccepted {
+			t.Errorf("handshake #%d: ECH was not accepted", i)
+		}
+		if didResume := i == 1; clientState.DidResume != didResume || serverState.DidResume != didResume {
+			t.Errorf("handshake #%d: got DidResume %v (client) and %v (server), want %v",
+				i, clientState.DidResume, serverState.DidResume, didResume)
+		}
+	}
+}
+
+// echRejectedHandshake runs a handshake that's expected to be completed by the
+// server and then aborted by the client, returning the client error.
+func echRejectedHandshake(t *testing.T, clientConfig, serverConfig *Config) error {
+	c, s := localPipe(t)
+	done := make(chan error)
+	go func() {
+		defer s.Close()
+		server := Server(s, serverConfig)
+		if err := server.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		_, err := ioutil.ReadAll(server)
+		if err == nil || !strings.Contains(err.Error(), "encrypted client hello required") {
+			t.Errorf("server got error %v, want an ECH required alert", err)
+		}
+		done <- nil
+	}()
+	cli := Client(c, clientConfig)
+	clientErr := cli.Handshake()
+	c.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	return clientErr
+}
+
+func TestECHRejection(t *testing.T) {
+	clientConfig, serverConfig := testECHConfigs(t)
+
+	// The server rotated its key, and the client has a stale config.
+	newKey := newTestECHKey(t, 2, "public.example")
+	newKey.SendAsRetry = true
+	serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{newKey}
+
+	var sawClientCert bool
+	serverConfig.ClientAuth = RequestClientCert
+	serverConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		sawClientCert = len(rawCerts) > 0
+		return nil
+	}
+	clientConfig.Certificates = testConfig.Certificates[:1]
+
+	var verifiedName string
+	clientConfig.EncryptedClientHelloRejectionVerify = func(cs ConnectionState) error {
+		verifiedName = cs.ServerName
+		return nil
+	}
+	clientConfig.VerifyConnection = func(ConnectionState) error {
+		return errors.New("VerifyConnection must not be called when ECH is rejected")
+	}
+
+	err := echRejectedHandshake(t, clientConfig, serverConfig)
+	var echErr *ECHRejectionError
+	if !errors.As(err, &echErr) {
+		t.Fatalf("got error %v, want an ECHRejectionError", err)
+	}
+	want := marshalTestECHConfigList(newKey.Config)
+	if !bytes.Equal(echErr.RetryConfigList, want) {
+		t.Errorf("got retry configs %x, want %x", echErr.RetryConfigList, want)
+	}
+	if verifiedName != "public.example" {
+		t.Errorf("EncryptedClientHelloRejectionVerify saw server name %q, want %q", verifiedName, "public.example")
+	}
+	if sawClientCert {
+		t.Errorf("client sent a certificate after ECH was rejected")
+	}
+
+	// Retrying with the configs from the server succeeds.
+	clientConfig.EncryptedClientHelloConfigList = echErr.RetryConfigList
+	clientConfig.VerifyConnection = nil
+	serverState, clientState, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clientState.ECHAccepted || !serverState.ECHAccepted {
+		t.Errorf("ECH was not accepted with the retry configs")
+	}
+	if serverState.ServerName != "secret.example" {
+		t.Errorf("server saw server name %q, want %q", serverState.ServerName, "secret.example")
+	}
+}
+
+func TestECHRejectionNoRetryConfigs(t *testing.T) {
+	clientConfig, serverConfig := testECHConfigs(t)
+	serverConfig.EncryptedClientHelloKeys = nil
+	clientConfig.EncryptedClientHelloRejectionVerify = func(ConnectionState) error { return nil }
+
+	var gotName string
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		gotName = chi.ServerName
+		return nil, nil
+	}
+
+	err := echRejectedHandshake(t, clientConfig, serverConfig)
+	var echErr *ECHRejectionError
+	if !errors.As(err, &echErr) {
+		t.Fatalf("got error %v, want an ECHRejectionError", err)
+	}
+	if echErr.RetryConfigList != nil {
+		t.Errorf("got retry configs %x, want none", echErr.RetryConfigList)
+	}
+	if gotName != "public.example" {
+		t.Errorf("GetConfigForClient saw server name %q, want the public name", gotName)
+	}
+}
+
+func TestECHRejectionVerifyError(t *testing.T) {
+	clientConfig, serverConfig := testECHConfigs(t)
+	serverConfig.EncryptedClientHelloKeys = nil
+	clientConfig.EncryptedClientHelloRejectionVerify = func(ConnectionState) error {
+		return errors.New("bad public name certificate")
+	}
+
+	_, _, err := testHandshake(t, clientConfig, serverConfig)
+	if err == nil || !strings.Contains(err.Error(), "bad certificate") {
+		t.Errorf("got error %v, want a bad certificate alert", err)
+	}
+}
+
+func TestECHClientConfigErrors(t *testing.T) {
+	clientConfig, _ := testECHConfigs(t)
+	clientConfig.MaxVersion = VersionTLS12
+	if _, _, _, err := Client(nil, clientConfig).makeClientHello(); err == nil {
+		t.Errorf("expected error with MaxVersion TLS 1.2")
+	}
+
+	clientConfig, _ = testECHConfigs(t)
+	clientConfig.EncryptedClientHelloConfigList = []byte{0, 1, 2}
+	if _, _, _, err := Client(nil, clientConfig).makeClientHello(); err == nil {
+		t.Errorf("expected error with malformed config list")
+	}
+}
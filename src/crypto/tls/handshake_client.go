@@ -6,52 +6,76 @@ package tls
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/internal/hpke"
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
+	"encoding/asn1"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net"
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
 )
 
 type clientHandshakeState struct {
 	c            *Conn
+	ctx          context.Context
 	serverHello  *serverHelloMsg
 	hello        *clientHelloMsg
 	suite        *cipherSuite
 	finishedHash finishedHash
 	masterSecret []byte
-	session      *ClientSessionState
+	session      *SessionState
 }
 
-func (c *Conn) makeClientHello() (*clientHelloMsg, ecdheParameters, error) {
+func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echClientContext, error) {
 	config := c.config
 	if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
-		return nil, nil, errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
+		return nil, nil, nil, errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
 	}
 
 	nextProtosLength := 0
 	for _, proto := range config.NextProtos {
 		if l := len(proto); l == 0 || l > 255 {
-			return nil, nil, errors.New("tls: invalid NextProtos value")
+			return nil, nil, nil, errors.New("tls: invalid NextProtos value")
 		} else {
 			nextProtosLength += 1 + l
 		}
 	}
 	if nextProtosLength > 0xffff {
-		return nil, nil, errors.New("tls: NextProtos values too large")
+		return nil, nil, nil, errors.New("tls: NextProtos values too large")
 	}
 
 	supportedVersions := config.supportedVersions()
+	if config.EncryptedClientHelloConfigList != nil {
+		// ECH requires TLS 1.3. See RFC 9849, Section 6.1.
+		if config.MinVersion != 0 && config.MinVersion < VersionTLS13 {
+			return nil, nil, nil, errors.New("tls: MinVersion must be >= VersionTLS13 if EncryptedClientHelloConfigList is populated")
+		}
+		if config.MaxVersion != 0 && config.MaxVersion < VersionTLS13 {
+			return nil, nil, nil, errors.New("tls: MaxVersion must be >= VersionTLS13 if EncryptedClientHelloConfigList is populated")
+		}
+		var tls13Versions []uint16
+		for _, v := range supportedVersions {
+			if v >= VersionTLS13 {
+				tls13Versions = append(tls13Versions, v)
+			}
+		}
+		supportedVersions = tls13Versions
+	}
 	if len(supportedVersions) == 0 {
-		return nil, nil, errors.New("tls: no supported versions satisfy MinVersion and MaxVersion")
+		return nil, nil, nil, errors.New("tls: no supported versions satisfy MinVersion and MaxVersion")
 	}
 
 	clientHelloVersion := config.maxSupportedVersion()
@@ -66,7 +90,6 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, ecdheParameters, error) {
 		vers:                         clientHelloVersion,
 		compressionMethods:           []uint8{compressionNone},
 		random:                       make([]byte, 32),
-		sessionId:                    make([]byte, 32),
 		ocspStapling:                 true,
 		scts:                         true,
 		serverName:                   hostnameInSNI(config.ServerName),
@@ -101,39 +124,111 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, ecdheParameters, error) {
 
 	_, err := io.ReadFull(config.rand(), hello.random)
 	if err != nil {
-		return nil, nil, errors.New("tls: short read from Rand: " + err.Error())
+		return nil, nil, nil, errors.New("tls: short read from Rand: " + err.Error())
 	}
 
 	// A random session ID is used to detect when the server accepted a ticket
 	// and is resuming a session (see RFC 5077). In TLS 1.3, it's always set as
 	// a compatibility measure (see RFC 8446, Section 4.1.2).
-	if _, err := io.ReadFull(config.rand(), hello.sessionId); err != nil {
-		return nil, nil, errors.New("tls: short read from Rand: " + err.Error())
+	//
+	// The session ID is not set for QUIC connections (see RFC 9001, Section 8.4).
+	if c.quic == nil {
+		hello.sessionId = make([]byte, 32)
+		if _, err := io.ReadFull(config.rand(), hello.sessionId); err != nil {
+			return nil, nil, nil, errors.New("tls: short read from Rand: " + err.Error())
+		}
 	}
 
 	if hello.vers >= VersionTLS12 {
 		hello.supportedSignatureAlgorithms = supportedSignatureAlgorithms
 	}
 
-	var params ecdheParameters
+	var keyShareKeys *keySharePrivateKeys
 	if hello.supportedVersions[0] == VersionTLS13 {
 		hello.cipherSuites = append(hello.cipherSuites, defaultCipherSuitesTLS13()...)
 
 		curveID := config.curvePreferences()[0]
-		if _, ok := curveForCurveID(curveID); curveID != X25519 && !ok {
-			return nil, nil, errors.New("tls: CurvePreferences includes unsupported curve")
+		var ks keyShare
+		keyShareKeys, ks, err = generateKeyShare(config.rand(), curveID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		hello.keyShares = []keyShare{ks}
+		// If both X25519MLKEM768 and X25519 are supported, send an X25519 key
+		// share as well, reusing the X25519 component of the hybrid key
+		// share, so that servers that don't support X25519MLKEM768 don't
+		// need a HelloRetryRequest round-trip.
+		if curveID == X25519MLKEM768 && config.supportsCurve(X25519) {
+			hello.keyShares = append(hello.keyShares, keyShare{
+				group: X25519,
+				data:  keyShareKeys.ecdhe.PublicKey().Bytes(),
+			})
 		}
-		params, err = generateECDHEParameters(config.rand(), curveID)
+	} else {
+		// X25519MLKEM768 can't be negotiated before TLS 1.3, so don't
+		// advertise it.
+		var supportedCurves []CurveID
+		for _, curveID := range hello.supportedCurves {
+			if !isTLS13OnlyKeyExchange(curveID) {
+				supportedCurves = append(supportedCurves, curveID)
+			}
+		}
+		hello.supportedCurves = supportedCurves
+	}
+
+	if c.quic != nil {
+		p, err := c.quicGetTransportParameters()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
-		hello.keyShares = []keyShare{{group: curveID, data: params.PublicKey()}}
+		if p == nil {
+			p = []byte{}
+		}
+		hello.quicTransportParameters = p
 	}
 
-	return hello, params, nil
+	var ech *echClientContext
+	if config.EncryptedClientHelloConfigList != nil {
+		echConfigs, err := parseECHConfigList(config.EncryptedClientHelloConfigList)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		echConfig, echCipherSuite := pickECHConfig(echConfigs)
+		if echConfig == nil {
+			return nil, nil, nil, errors.New("tls: EncryptedClientHelloConfigList contains no valid configs")
+		}
+		ech = &echClientContext{config: echConfig, cipherSuite: echCipherSuite}
+		hello.encryptedClientHello = []byte{byte(innerECHExt)}
+		// The inner ClientHello only offers TLS 1.3, so drop the TLS 1.2
+		// extensions from it.
+		hello.supportedPoints = nil
+		hello.secureRenegotiationSupported = false
+
+		info := append([]byte("tls ech\x00"), ech.config.raw...)
+		ech.encapsulatedKey, ech.hpkeContext, err = hpke.SetupSender(config.rand(),
+			ech.config.KemID, echCipherSuite.KDFID, echCipherSuite.AEADID, ech.config.PublicKey, info)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return hello, keyShareKeys, ech, nil
+}
+
+// echClientContext holds the client state of an Encrypted Client Hello
+// handshake.
+type echClientContext struct {
+	config          *echConfig
+	cipherSuite     echCipher
+	hpkeContext     *hpke.Sender
+	encapsulatedKey []byte
+	innerHello      *clientHelloMsg
+	innerTranscript hash.Hash
+	rejected        bool
+	retryConfigs    []byte
 }
 
-func (c *Conn) clientHandshake() (err error) {
+func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 	if c.config == nil {
 		c.config = defaultConfig()
 	}
@@ -142,11 +237,10 @@ func (c *Conn) clientHandshake() (err error) {
 	// need to be reset.
 	c.didResume = false
 
-	hello, ecdheParams, err := c.makeClientHello()
+	hello, keyShareKeys, ech, err := c.makeClientHello()
 	if err != nil {
 		return err
 	}
-	c.serverName = hello.serverName
 
 	cacheKey, session, earlySecret, binderKey := c.loadSession(hello)
 	if cacheKey != "" && session != nil {
@@ -163,10 +257,45 @@ func (c *Conn) clientHandshake() (err error) {
 		}()
 	}
 
+	if ech != nil {
+		// Split hello into the inner ClientHello, which is encrypted, and
+		// the outer one, which is sent on the wire with the public name.
+		innerHello := *hello
+		ech.innerHello = &innerHello
+
+		hello.serverName = string(ech.config.PublicName)
+		hello.random = make([]byte, 32)
+		if _, err := io.ReadFull(c.config.rand(), hello.random); err != nil {
+			return errors.New("tls: short read from Rand: " + err.Error())
+		}
+		// The PSK is only offered in the inner ClientHello, as it's bound
+		// to the real server name.
+		hello.pskIdentities = nil
+		hello.pskBinders = nil
+
+		if err := computeAndUpdateOuterECHExtension(hello, ech.innerHello, ech, true); err != nil {
+			return err
+		}
+	}
+
+	c.serverName = hello.serverName
+
 	if _, err := c.writeRecord(recordTypeHandshake, hello.marshal()); err != nil {
 		return err
 	}
 
+	if hello.earlyData {
+		suite := cipherSuiteTLS13ByID(session.cipherSuite)
+		transcript := suite.hash.New()
+		if ech != nil {
+			transcript.Write(ech.innerHello.marshal())
+		} else {
+			transcript.Write(hello.marshal())
+		}
+		earlyTrafficSecret := suite.deriveSecret(earlySecret, clientEarlyTrafficLabel, transcript)
+		c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+	}
+
 	msg, err := c.readHandshake()
 	if err != nil {
 		return err
@@ -194,15 +323,22 @@ func (c *Conn) clientHandshake() (err error) {
 		return errors.New("tls: downgrade attempt detected, possibly due to a MitM attack or a broken middlebox")
 	}
 
+	if ech != nil && c.vers != VersionTLS13 {
+		c.sendAlert(alertProtocolVersion)
+		return errors.New("tls: server selected a protocol version lower than TLS 1.3 while Encrypted Client Hello was offered")
+	}
+
 	if c.vers == VersionTLS13 {
 		hs := &clientHandshakeStateTLS13{
-			c:           c,
-			serverHello: serverHello,
-			hello:       hello,
-			ecdheParams: ecdheParams,
-			session:     session,
-			earlySecret: earlySecret,
-			binderKey:   binderKey,
+			c:            c,
+			ctx:          ctx,
+			serverHello:  serverHello,
+			hello:        hello,
+			keyShareKeys: keyShareKeys,
+			session:      session,
+			earlySecret:  earlySecret,
+			binderKey:    binderKey,
+			echContext:   ech,
 		}
 
 		// In TLS 1.3, session tickets are delivered after the handshake.
@@ -211,6 +347,7 @@ func (c *Conn) clientHandshake() (err error) {
 
 	hs := &clientHandshakeState{
 		c:           c,
+		ctx:         ctx,
 		serverHello: serverHello,
 		hello:       hello,
 		session:     session,
@@ -223,19 +360,21 @@ func (c *Conn) clientHandshake() (err error) {
 	// If we had a successful handshake and hs.session is different from
 	// the one already cached - cache a new one.
 	if cacheKey != "" && hs.session != nil && session != hs.session {
-		c.config.ClientSessionCache.Put(cacheKey, hs.session)
+		c.config.ClientSessionCache.Put(cacheKey, &ClientSessionState{session: hs.session})
 	}
 
 	return nil
 }
 
 func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
-	session *ClientSessionState, earlySecret, binderKey []byte) {
+	session *SessionState, earlySecret, binderKey []byte) {
 	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
 		return "", nil, nil, nil
 	}
 
-	hello.ticketSupported = true
+	// Session tickets are a TLS 1.2 extension, and the inner ClientHello of
+	// ECH only offers TLS 1.3.
+	hello.ticketSupported = !bytes.Equal(hello.encryptedClientHello, []byte{byte(innerECHExt)})
 
 	if hello.supportedVersions[0] == VersionTLS13 {
 		// Require DHE on resumption as it guarantees forward secrecy against
@@ -251,16 +390,20 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
 	}
 
 	// Try to resume a previously negotiated TLS session, if available.
-	cacheKey = clientSessionCacheKey(c.conn.RemoteAddr(), c.config)
-	session, ok := c.config.ClientSessionCache.Get(cacheKey)
-	if !ok || session == nil {
+	cacheKey = c.clientSessionCacheKey()
+	if cacheKey == "" {
+		return "", nil, nil, nil
+	}
+	cs, ok := c.config.ClientSessionCache.Get(cacheKey)
+	if !ok || cs == nil || cs.session == nil {
 		return cacheKey, nil, nil, nil
 	}
+	session = cs.session
 
 	// Check that version used for the previous session is still valid.
 	versOk := false
 	for _, v := range hello.supportedVersions {
-		if v == session.vers {
+		if v == session.version {
 			versOk = true
 			break
 		}
@@ -277,7 +420,7 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
 			// The original connection had InsecureSkipVerify, while this doesn't.
 			return cacheKey, nil, nil, nil
 		}
-		serverCert := session.serverCertificates[0]
+		serverCert := session.peerCertificates[0]
 		if c.config.time().After(serverCert.NotAfter) {
 			// Expired certificate, delete the entry.
 			c.config.ClientSessionCache.Put(cacheKey, nil)
@@ -288,19 +431,19 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
 		}
 	}
 
-	if session.vers != VersionTLS13 {
+	if session.version != VersionTLS13 {
 		// In TLS 1.2 the cipher suite must match the resumed session. Ensure we
 		// are still offering it.
 		if mutualCipherSuite(hello.cipherSuites, session.cipherSuite) == nil {
 			return cacheKey, nil, nil, nil
 		}
 
-		hello.sessionTicket = session.sessionTicket
+		hello.sessionTicket = session.ticket
 		return
 	}
 
 	// Check that the session ticket is not expired.
-	if c.config.time().After(session.useBy) {
+	if c.config.time().After(time.Unix(int64(session.useBy), 0)) {
 		c.config.ClientSessionCache.Put(cacheKey, nil)
 		return cacheKey, nil, nil, nil
 	}
@@ -323,19 +466,30 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
 		return cacheKey, nil, nil, nil
 	}
 
+	// 0-RTT is only supported for QUIC connections, and requires the cipher
+	// suite to match exactly and the same application protocol to be offered.
+	// See RFC 8446, Section 4.2.10.
+	if c.quic != nil && session.EarlyData &&
+		mutualCipherSuiteTLS13(hello.cipherSuites, session.cipherSuite) != nil {
+		for _, alpn := range hello.alpnProtocols {
+			if alpn == session.alpnProtocol {
+				hello.earlyData = true
+				break
+			}
+		}
+	}
+
 	// Set the pre_shared_key extension. See RFC 8446, Section 4.2.11.1.
-	ticketAge := uint32(c.config.time().Sub(session.receivedAt) / time.Millisecond)
+	ticketAge := c.config.time().Sub(time.Unix(int64(session.createdAt), 0))
 	identity := pskIdentity{
-		label:               session.sessionTicket,
-		obfuscatedTicketAge: ticketAge + session.ageAdd,
+		label:               session.ticket,
+		obfuscatedTicketAge: uint32(ticketAge/time.Millisecond) + session.ageAdd,
 	}
 	hello.pskIdentities = []pskIdentity{identity}
 	hello.pskBinders = [][]byte{make([]byte, cipherSuite.hash.Size())}
 
 	// Compute the PSK binders. See RFC 8446, Section 4.2.11.2.
-	psk := cipherSuite.expandLabel(session.masterSecret, "resumption",
-		session.nonce, cipherSuite.hash.Size())
-	earlySecret = cipherSuite.extract(psk, nil)
+	earlySecret = cipherSuite.extract(session.secret, nil)
 	binderKey = cipherSuite.deriveSecret(earlySecret, resumptionBinderLabel, nil)
 	transcript := cipherSuite.hash.New()
 	transcript.Write(hello.marshalWithoutBinders())
@@ -539,7 +693,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		certRequested = true
 		hs.finishedHash.Write(certReq.marshal())
 
-		cri := certificateRequestInfoFromMsg(c.vers, certReq)
+		cri := certificateRequestInfoFromMsg(hs.ctx, c.vers, certReq)
 		if chainToSend, err = c.getClientCertificate(cri); err != nil {
 			c.sendAlert(alertInternalError)
 			return err
@@ -718,7 +872,7 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		return false, nil
 	}
 
-	if hs.session.vers != c.vers {
+	if hs.session.version != c.vers {
 		c.sendAlert(alertHandshakeFailure)
 		return false, errors.New("tls: server resumed a session with a different version")
 	}
@@ -729,8 +883,8 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 	}
 
 	// Restore masterSecret, peerCerts, and ocspResponse from previous state
-	hs.masterSecret = hs.session.masterSecret
-	c.peerCertificates = hs.session.serverCertificates
+	hs.masterSecret = hs.session.secret
+	c.peerCertificates = hs.session.peerCertificates
 	c.verifiedChains = hs.session.verifiedChains
 	c.ocspResponse = hs.session.ocspResponse
 	// Let the ServerHello SCTs override the session SCTs from the original
@@ -787,17 +941,11 @@ func (hs *clientHandshakeState) readSessionTicket() error {
 	}
 	hs.finishedHash.Write(sessionTicketMsg.marshal())
 
-	hs.session = &ClientSessionState{
-		sessionTicket:      sessionTicketMsg.ticket,
-		vers:               c.vers,
-		cipherSuite:        hs.suite.id,
-		masterSecret:       hs.masterSecret,
-		serverCertificates: c.peerCertificates,
-		verifiedChains:     c.verifiedChains,
-		receivedAt:         c.config.time(),
-		ocspResponse:       c.ocspResponse,
-		scts:               c.scts,
-	}
+	session := c.sessionState()
+	session.cipherSuite = hs.suite.id
+	session.secret = hs.masterSecret
+	session.ticket = sessionTicketMsg.ticket
+	hs.session = session
 
 	return nil
 }
@@ -832,7 +980,29 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		certs[i] = cert
 	}
 
-	if !c.config.InsecureSkipVerify {
+	// If ECH was offered and rejected, the server authenticated with the
+	// public name, and the handshake will fail after sending the retry
+	// configs. See RFC 9849, Section 6.1.7.
+	echRejected := c.config.EncryptedClientHelloConfigList != nil && !c.echAccepted
+	if echRejected {
+		if c.config.EncryptedClientHelloRejectionVerify == nil {
+			opts := x509.VerifyOptions{
+				Roots:         c.config.RootCAs,
+				CurrentTime:   c.config.time(),
+				DNSName:       c.serverName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			var err error
+			c.verifiedChains, err = certs[0].Verify(opts)
+			if err != nil {
+				c.sendAlert(alertBadCertificate)
+				return err
+			}
+		}
+	} else if !c.config.InsecureSkipVerify {
 		opts := x509.VerifyOptions{
 			Roots:         c.config.RootCAs,
 			CurrentTime:   c.config.time(),
@@ -842,12 +1012,19 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		for _, cert := range certs[1:] {
 			opts.Intermediates.AddCert(cert)
 		}
+		if c.config.CTPolicy != nil {
+			opts.CTPolicy = c.config.CTPolicy
+			opts.SignedCertificateTimestamps = c.deliveredSCTs()
+		}
 		var err error
 		c.verifiedChains, err = certs[0].Verify(opts)
 		if err != nil {
 			c.sendAlert(alertBadCertificate)
 			return err
 		}
+		if err := c.verifyOCSPStaple(); err != nil {
+			return err
+		}
 	}
 
 	switch certs[0].PublicKey.(type) {
@@ -860,6 +1037,16 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 
 	c.peerCertificates = certs
 
+	if echRejected {
+		if c.config.EncryptedClientHelloRejectionVerify != nil {
+			if err := c.config.EncryptedClientHelloRejectionVerify(c.connectionStateLocked()); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return err
+			}
+		}
+		return nil
+	}
+
 	if c.config.VerifyPeerCertificate != nil {
 		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
 			c.sendAlert(alertBadCertificate)
@@ -877,12 +1064,114 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 	return nil
 }
 
+// deliveredSCTs returns the SCTs the server provided in the TLS extension and
+// in the stapled OCSP response, if any. The latter is not verified here, as
+// the SCTs are signed by the logs over the certificate itself.
+func (c *Conn) deliveredSCTs() [][]byte {
+	scts := c.scts
+	if len(c.ocspResponse) == 0 {
+		return scts
+	}
+	resp, err := x509.ParseOCSPResponse(c.ocspResponse, nil)
+	if err != nil {
+		return scts
+	}
+	ocspSCTs, err := resp.SignedCertificateTimestamps()
+	if err != nil {
+		return scts
+	}
+	scts = append([][]byte(nil), scts...)
+	for _, sct := range ocspSCTs {
+		scts = append(scts, sct.Raw)
+	}
+	return scts
+}
+
+// oidExtensionTLSFeature is the TLS Feature extension from RFC 7633, used
+// to mark certificates that must be presented with a stapled OCSP response.
+var oidExtensionTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// requiresOCSPStaple reports whether cert carries a TLS Feature extension
+// listing the status_request extension, and whether the extension parsed.
+func requiresOCSPStaple(cert *x509.Certificate) (mustStaple, ok bool) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionTLSFeature) {
+			continue
+		}
+		features := cryptobyte.String(ext.Value)
+		var seq cryptobyte.String
+		if !features.ReadASN1(&seq, cryptobyte_asn1.SEQUENCE) || !features.Empty() {
+			return false, false
+		}
+		for !seq.Empty() {
+			var feature uint16
+			if !seq.ReadASN1Integer(&feature) {
+				return false, false
+			}
+			if feature == extensionStatusRequest {
+				mustStaple = true
+			}
+		}
+		return mustStaple, true
+	}
+	return false, true
+}
+
+// verifyOCSPStaple checks c.ocspResponse against the first verified chain
+// according to c.config.OCSPStaplePolicy, sending the appropriate alert.
+func (c *Conn) verifyOCSPStaple() error {
+	if c.config.OCSPStaplePolicy == OCSPStapleIgnore {
+		return nil
+	}
+
+	chain := c.verifiedChains[0]
+	leaf, issuer := chain[0], chain[0]
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	mustStaple, ok := requiresOCSPStaple(leaf)
+	if !ok {
+		c.sendAlert(alertBadCertificate)
+		return errors.New("tls: failed to parse TLS Feature extension of server certificate")
+	}
+	if len(c.ocspResponse) == 0 {
+		if mustStaple || c.config.OCSPStaplePolicy == OCSPStapleRequire {
+			c.sendAlert(alertBadCertificateStatusResponse)
+			return errors.New("tls: server did not staple a required OCSP response")
+		}
+		return nil
+	}
+
+	resp, err := x509.ParseOCSPResponseForCert(c.ocspResponse, leaf, issuer)
+	if err != nil {
+		c.sendAlert(alertBadCertificateStatusResponse)
+		return errors.New("tls: invalid stapled OCSP response: " + err.Error())
+	}
+	now := c.config.time()
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		c.sendAlert(alertBadCertificateStatusResponse)
+		return errors.New("tls: stapled OCSP response is not current")
+	}
+	switch resp.Status {
+	case x509.OCSPGood:
+		return nil
+	case x509.OCSPRevoked:
+		c.sendAlert(alertCertificateRevoked)
+		return errors.New("tls: server certificate was revoked")
+	default:
+		c.sendAlert(alertBadCertificateStatusResponse)
+		return errors.New("tls: stapled OCSP response has status " + resp.Status.String())
+	}
+}
+
 // certificateRequestInfoFromMsg generates a CertificateRequestInfo from a TLS
 // <= 1.2 CertificateRequest, making an effort to fill in missing information.
-func certificateRequestInfoFromMsg(vers uint16, certReq *certificateRequestMsg) *CertificateRequestInfo {
+func certificateRequestInfoFromMsg(ctx context.Context, vers uint16, certReq *certificateRequestMsg) *CertificateRequestInfo {
 	cri := &CertificateRequestInfo{
 		AcceptableCAs: certReq.certificateAuthorities,
 		Version:       vers,
+		ctx:           ctx,
 	}
 
 	var rsaAvail, ecAvail bool
@@ -960,11 +1249,16 @@ func (c *Conn) getClientCertificate(cri *CertificateRequestInfo) (*Certificate,
 
 // clientSessionCacheKey returns a key used to cache sessionTickets that could
 // be used to resume previously negotiated TLS sessions with a server.
-func clientSessionCacheKey(serverAddr net.Addr, config *Config) string {
-	if len(config.ServerName) > 0 {
-		return config.ServerName
+// It returns the empty string if there is no suitable key, which is the
+// case for QUIC connections without a ServerName.
+func (c *Conn) clientSessionCacheKey() string {
+	if len(c.config.ServerName) > 0 {
+		return c.config.ServerName
+	}
+	if c.conn != nil {
+		return c.conn.RemoteAddr().String()
 	}
-	return serverAddr.String()
+	return ""
 }
 
 // mutualProtocol finds the mutual Next Protocol Negotiation or ALPN protocol
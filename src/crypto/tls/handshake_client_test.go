@@ -6,8 +6,14 @@ package tls
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/internal/cttest"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/pem"
@@ -24,6 +30,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/cryptobyte"
 )
 
 // Note: see comment in handshake_test.go for details of how the reference
@@ -914,14 +922,14 @@ func testResumption(t *testing.T, version uint16) {
 	}
 
 	getTicket := func() []byte {
-		return clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.sessionTicket
+		return clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.session.ticket
 	}
 	deleteTicket := func() {
 		ticketKey := clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).sessionKey
 		clientConfig.ClientSessionCache.Put(ticketKey, nil)
 	}
 	corruptTicket := func() {
-		clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.masterSecret[0] ^= 0xff
+		clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.session.secret[0] ^= 0xff
 	}
 	randomKey := func() [32]byte {
 		var k [32]byte
@@ -1106,6 +1114,121 @@ func TestLRUClientSessionCache(t *testing.T) {
 	}
 }
 
+// serializingSessionCache is a ClientSessionCache that only keeps the
+// serialized form of each session, as if it were persisted to disk.
+type serializingSessionCache struct {
+	t       *testing.T
+	tickets map[string][]byte
+	states  map[string][]byte
+}
+
+func (c *serializingSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+	ticket, ok := c.tickets[sessionKey]
+	if !ok {
+		return nil, false
+	}
+	state, err := ParseSessionState(c.states[sessionKey])
+	if err != nil {
+		c.t.Errorf("failed to parse stored session: %v", err)
+		return nil, false
+	}
+	cs, err := NewResumptionState(ticket, state)
+	if err != nil {
+		c.t.Errorf("failed to restore session: %v", err)
+		return nil, false
+	}
+	return cs, true
+}
+
+func (c *serializingSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+	if cs == nil {
+		delete(c.tickets, sessionKey)
+		delete(c.states, sessionKey)
+		return
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil {
+		c.t.Errorf("failed to get resumption state: %v", err)
+		return
+	}
+	stateBytes, err := state.Bytes()
+	if err != nil {
+		c.t.Errorf("failed to serialize session: %v", err)
+		return
+	}
+	c.tickets[sessionKey] = ticket
+	c.states[sessionKey] = stateBytes
+}
+
+func TestSerializedSessions(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testSerializedSessions(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testSerializedSessions(t, VersionTLS13) })
+}
+
+func testSerializedSessions(t *testing.T, version uint16) {
+	const extra = "application data"
+
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = version
+	var wrapped, unwrapped int
+	serverConfig.WrapSession = func(cs ConnectionState, ss *SessionState) ([]byte, error) {
+		wrapped++
+		if len(ss.Extra) == 0 {
+			ss.Extra = append(ss.Extra, []byte(extra))
+		}
+		return serverConfig.EncryptTicket(cs, ss)
+	}
+	serverConfig.UnwrapSession = func(identity []byte, cs ConnectionState) (*SessionState, error) {
+		ss, err := serverConfig.DecryptTicket(identity, cs)
+		if ss == nil || err != nil {
+			return ss, err
+		}
+		unwrapped++
+		if len(ss.Extra) != 1 || string(ss.Extra[0]) != extra {
+			t.Errorf("unwrapped session has Extra %q, want %q", ss.Extra, extra)
+		}
+		return ss, nil
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.ClientSessionCache = &serializingSessionCache{
+		t:       t,
+		tickets: make(map[string][]byte),
+		states:  make(map[string][]byte),
+	}
+
+	if _, cs, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	} else if cs.DidResume {
+		t.Fatal("first handshake resumed a session")
+	}
+	if wrapped == 0 {
+		t.Fatal("WrapSession was not called")
+	}
+	_, cs, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.DidResume {
+		t.Fatal("session was not resumed from its serialized form")
+	}
+	if unwrapped != 1 {
+		t.Errorf("UnwrapSession unwrapped %d sessions, want 1", unwrapped)
+	}
+	if len(cs.PeerCertificates) == 0 {
+		t.Error("resumed session has no peer certificates")
+	}
+
+	// An error from UnwrapSession aborts the handshake.
+	serverConfig.UnwrapSession = func(identity []byte, cs ConnectionState) (*SessionState, error) {
+		return nil, errors.New("unwrap failed")
+	}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+		t.Error("handshake succeeded despite an UnwrapSession error")
+	}
+}
+
 func TestKeyLogTLS12(t *testing.T) {
 	var serverBuf, clientBuf bytes.Buffer
 
@@ -2511,3 +2634,288 @@ func testResumptionKeepsOCSPAndSCT(t *testing.T, ver uint16) {
 			serverConfig.Certificates[0].SignedCertificateTimestamps, ccs.SignedCertificateTimestamps)
 	}
 }
+
+func TestOCSPStaplePolicy(t *testing.T) {
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(time.Hour)
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLeaf := func(serial int64, mustStaple bool) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "example.golang"},
+			DNSNames:     []string{"example.golang"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		if mustStaple {
+			template.ExtraExtensions = []pkix.Extension{{
+				Id:    oidExtensionTLSFeature,
+				Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}, // SEQUENCE { INTEGER 5 }
+			}}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, leafKey.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	leaf := newLeaf(2, false)
+	mustStapleLeaf := newLeaf(3, true)
+
+	newResponse := func(cert *x509.Certificate, status x509.OCSPStatus, nextUpdate time.Time) []byte {
+		template := &x509.OCSPResponse{
+			Status:       status,
+			SerialNumber: cert.SerialNumber,
+			ThisUpdate:   now.Add(-2 * time.Hour),
+			NextUpdate:   nextUpdate,
+		}
+		if status == x509.OCSPRevoked {
+			template.RevokedAt = now.Add(-3 * time.Hour)
+		}
+		resp, err := x509.CreateOCSPResponse(rand.Reader, template, ca, ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	good := newResponse(leaf, x509.OCSPGood, now.Add(time.Hour))
+	revoked := newResponse(leaf, x509.OCSPRevoked, now.Add(time.Hour))
+	unknown := newResponse(leaf, x509.OCSPUnknown, now.Add(time.Hour))
+	expired := newResponse(leaf, x509.OCSPGood, now.Add(-time.Hour))
+	otherCert := newResponse(mustStapleLeaf, x509.OCSPGood, now.Add(time.Hour))
+
+	tests := []struct {
+		name       string
+		policy     OCSPStaplePolicy
+		leaf       *x509.Certificate
+		staple     []byte
+		wantErr    string
+		skipVerify bool
+	}{
+		{name: "IgnoreMissing", policy: OCSPStapleIgnore, leaf: leaf},
+		{name: "IgnoreRevoked", policy: OCSPStapleIgnore, leaf: leaf, staple: revoked},
+		{name: "IgnoreMustStaple", policy: OCSPStapleIgnore, leaf: mustStapleLeaf},
+		{name: "IfPresentMissing", policy: OCSPStapleVerifyIfPresent, leaf: leaf},
+		{name: "IfPresentGood", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: good},
+		{name: "IfPresentRevoked", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: revoked, wantErr: "revoked certificate"},
+		{name: "IfPresentUnknown", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: unknown, wantErr: "bad certificate status response"},
+		{name: "IfPresentExpired", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: expired, wantErr: "bad certificate status response"},
+		{name: "IfPresentOtherCert", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: otherCert, wantErr: "bad certificate status response"},
+		{name: "IfPresentMalformed", policy: OCSPStapleVerifyIfPresent, leaf: leaf, staple: []byte("dummy ocsp"), wantErr: "bad certificate status response"},
+		{name: "IfPresentMustStaple", policy: OCSPStapleVerifyIfPresent, leaf: mustStapleLeaf, wantErr: "bad certificate status response"},
+		{name: "RequireMissing", policy: OCSPStapleRequire, leaf: leaf, wantErr: "bad certificate status response"},
+		{name: "RequireGood", policy: OCSPStapleRequire, leaf: leaf, staple: good},
+		{name: "RequireSkipVerify", policy: OCSPStapleRequire, leaf: leaf, staple: revoked, skipVerify: true},
+	}
+	for _, v := range []uint16{VersionTLS12, VersionTLS13} {
+		for _, test := range tests {
+			name := fmt.Sprintf("%s-%x", test.name, v)
+			t.Run(name, func(t *testing.T) {
+				serverConfig := testConfig.Clone()
+				serverConfig.Time = func() time.Time { return now }
+				serverConfig.MaxVersion = v
+				serverConfig.Certificates = []Certificate{{
+					Certificate: [][]byte{test.leaf.Raw},
+					PrivateKey:  leafKey,
+					OCSPStaple:  test.staple,
+				}}
+
+				clientConfig := testConfig.Clone()
+				clientConfig.Time = func() time.Time { return now }
+				clientConfig.MaxVersion = v
+				clientConfig.InsecureSkipVerify = test.skipVerify
+				clientConfig.RootCAs = roots
+				clientConfig.ServerName = "example.golang"
+				clientConfig.OCSPStaplePolicy = test.policy
+
+				_, cs, err := testHandshake(t, clientConfig, serverConfig)
+				if test.wantErr == "" {
+					if err != nil {
+						t.Fatalf("handshake failed: %v", err)
+					}
+					if !bytes.Equal(cs.OCSPResponse, test.staple) {
+						t.Errorf("OCSPResponse = %x, want %x", cs.OCSPResponse, test.staple)
+					}
+					return
+				}
+				if err == nil {
+					t.Fatalf("handshake succeeded, want error containing %q", test.wantErr)
+				}
+				if !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %q, want error containing %q", err, test.wantErr)
+				}
+			})
+		}
+	}
+}
+
+// newTestSCT returns a serialized SCT issued by logKey for cert at ts.
+func newTestSCT(t *testing.T, logKey *ecdsa.PrivateKey, cert *x509.Certificate, ts time.Time) []byte {
+	spki, err := x509.MarshalPKIXPublicKey(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sct, err := cttest.SCT(logKey, spki, cttest.X509Entry(cert.Raw), ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sct
+}
+
+func TestCTPolicy(t *testing.T) {
+	now := time.Now()
+	var keys [4]*ecdsa.PrivateKey
+	for i := range keys {
+		var err error
+		keys[i], err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	caKey, leafKey, log1, log2 := keys[0], keys[1], keys[2], keys[3]
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CT Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.golang"},
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sct1 := newTestSCT(t, log1, leaf, now.Add(-time.Minute))
+	sct2 := newTestSCT(t, log2, leaf, now.Add(-time.Minute))
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(sct2)
+		})
+	})
+	sctList, err := asn1.Marshal(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocspWithSCT, err := x509.CreateOCSPResponse(rand.Reader, &x509.OCSPResponse{
+		Status:       x509.OCSPGood,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   now.Add(-time.Hour),
+		ExtraExtensions: []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5},
+			Value: sctList,
+		}},
+	}, ca, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &x509.CTPolicy{
+		Logs: []*x509.CTLog{
+			{Description: "log1", PublicKey: log1.Public()},
+			{Description: "log2", PublicKey: log2.Public()},
+		},
+		MinimumSCTs: 2,
+	}
+	tests := []struct {
+		name    string
+		scts    [][]byte
+		staple  []byte
+		wantErr bool
+	}{
+		{name: "Extension", scts: [][]byte{sct1, sct2}},
+		{name: "ExtensionAndOCSP", scts: [][]byte{sct1}, staple: ocspWithSCT},
+		{name: "NotEnough", scts: [][]byte{sct1}, wantErr: true},
+		{name: "SameLogTwice", scts: [][]byte{sct1, sct1}, wantErr: true},
+	}
+	for _, v := range []uint16{VersionTLS12, VersionTLS13} {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s-%x", test.name, v), func(t *testing.T) {
+				serverConfig := testConfig.Clone()
+				serverConfig.Time = func() time.Time { return now }
+				serverConfig.MaxVersion = v
+				serverConfig.Certificates = []Certificate{{
+					Certificate:                 [][]byte{leaf.Raw},
+					PrivateKey:                  leafKey,
+					SignedCertificateTimestamps: test.scts,
+					OCSPStaple:                  test.staple,
+				}}
+
+				clientConfig := testConfig.Clone()
+				clientConfig.Time = func() time.Time { return now }
+				clientConfig.MaxVersion = v
+				clientConfig.InsecureSkipVerify = false
+				clientConfig.RootCAs = roots
+				clientConfig.ServerName = "example.golang"
+				clientConfig.CTPolicy = policy
+
+				_, _, err := testHandshake(t, clientConfig, serverConfig)
+				if test.wantErr {
+					if err == nil {
+						t.Fatal("handshake succeeded, want error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("handshake failed: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -5,15 +5,14 @@
 package tls
 
 import (
-	"crypto/elliptic"
+	"crypto/ecdh"
 	"crypto/hmac"
+	"crypto/mlkem"
 	"errors"
 	"hash"
 	"io"
-	"math/big"
 
 	"golang.org/x/crypto/cryptobyte"
-	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/hkdf"
 )
 
@@ -22,6 +21,7 @@ import (
 
 const (
 	resumptionBinderLabel         = "res binder"
+	clientEarlyTrafficLabel       = "c e traffic"
 	clientHandshakeTrafficLabel   = "c hs traffic"
 	serverHandshakeTrafficLabel   = "s hs traffic"
 	clientApplicationTrafficLabel = "c ap traffic"
@@ -29,6 +29,8 @@ const (
 	exporterLabel                 = "exp master"
 	resumptionLabel               = "res master"
 	trafficUpdateLabel            = "traffic upd"
+	echAcceptConfirmationLabel    = "ech accept confirmation"
+	echAcceptConfirmationHRRLabel = "hrr ech accept confirmation"
 )
 
 // expandLabel implements HKDF-Expand-Label from RFC 8446, Section 7.1.
@@ -101,99 +103,97 @@ func (c *cipherSuiteTLS13) exportKeyingMaterial(masterSecret []byte, transcript
 	}
 }
 
-// ecdheParameters implements Diffie-Hellman with either NIST curves or X25519,
-// according to RFC 8446, Section 4.2.8.2.
-type ecdheParameters interface {
-	CurveID() CurveID
-	PublicKey() []byte
-	SharedKey(peerPublicKey []byte) []byte
+// echAcceptConfirmation computes the 8 bytes that signal acceptance of
+// Encrypted Client Hello in the ServerHello random or in the
+// HelloRetryRequest extension, according to RFC 9849, Section 7.2.
+func (c *cipherSuiteTLS13) echAcceptConfirmation(innerRandom []byte, label string, transcript hash.Hash) []byte {
+	return c.expandLabel(c.extract(innerRandom, nil), label, transcript.Sum(nil), 8)
 }
 
-func generateECDHEParameters(rand io.Reader, curveID CurveID) (ecdheParameters, error) {
-	if curveID == X25519 {
-		privateKey := make([]byte, curve25519.ScalarSize)
-		if _, err := io.ReadFull(rand, privateKey); err != nil {
-			return nil, err
+// x25519PublicKeySize is the size of an X25519 public key, which is also
+// the size of the X25519 component of an X25519MLKEM768 key share.
+const x25519PublicKeySize = 32
+
+// keySharePrivateKeys holds the private keys for the key shares sent by the
+// client. curveID is the group of the first key share. If it's
+// X25519MLKEM768, ecdhe is the X25519 component of the hybrid key share,
+// which is also reused for the fallback X25519 key share, if any.
+type keySharePrivateKeys struct {
+	curveID CurveID
+	ecdhe   *ecdh.PrivateKey
+	mlkem   *mlkem.DecapsulationKey768
+}
+
+// generateKeyShare generates the private keys and the corresponding key share
+// for curveID, which may be X25519MLKEM768.
+func generateKeyShare(rand io.Reader, curveID CurveID) (*keySharePrivateKeys, keyShare, error) {
+	if curveID == X25519MLKEM768 {
+		ecdheKey, err := generateECDHEKey(rand, X25519)
+		if err != nil {
+			return nil, keyShare{}, err
 		}
-		publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+		seed := make([]byte, mlkem.SeedSize)
+		if _, err := io.ReadFull(rand, seed); err != nil {
+			return nil, keyShare{}, errors.New("tls: short read from Rand: " + err.Error())
+		}
+		mlkemKey, err := mlkem.NewDecapsulationKey768(seed)
 		if err != nil {
-			return nil, err
+			return nil, keyShare{}, err
 		}
-		return &x25519Parameters{privateKey: privateKey, publicKey: publicKey}, nil
+		// The key share is the ML-KEM-768 encapsulation key followed by the
+		// X25519 public key, per draft-kwiatkowski-tls-ecdhe-mlkem, Section 4.
+		data := append(mlkemKey.EncapsulationKey().Bytes(), ecdheKey.PublicKey().Bytes()...)
+		keys := &keySharePrivateKeys{curveID: curveID, ecdhe: ecdheKey, mlkem: mlkemKey}
+		return keys, keyShare{group: curveID, data: data}, nil
 	}
 
+	if _, ok := curveForCurveID(curveID); !ok {
+		return nil, keyShare{}, errors.New("tls: CurvePreferences includes unsupported curve")
+	}
+	ecdheKey, err := generateECDHEKey(rand, curveID)
+	if err != nil {
+		return nil, keyShare{}, err
+	}
+	keys := &keySharePrivateKeys{curveID: curveID, ecdhe: ecdheKey}
+	return keys, keyShare{group: curveID, data: ecdheKey.PublicKey().Bytes()}, nil
+}
+
+// generateECDHEKey returns a PrivateKey that implements Diffie-Hellman
+// according to RFC 8446, Section 4.2.8.2.
+func generateECDHEKey(rand io.Reader, curveID CurveID) (*ecdh.PrivateKey, error) {
 	curve, ok := curveForCurveID(curveID)
 	if !ok {
 		return nil, errors.New("tls: internal error: unsupported curve")
 	}
-
-	p := &nistParameters{curveID: curveID}
-	var err error
-	p.privateKey, p.x, p.y, err = elliptic.GenerateKey(curve, rand)
-	if err != nil {
-		return nil, err
-	}
-	return p, nil
+	return curve.GenerateKey(rand)
 }
 
-func curveForCurveID(id CurveID) (elliptic.Curve, bool) {
+func curveForCurveID(id CurveID) (ecdh.Curve, bool) {
 	switch id {
+	case X25519:
+		return ecdh.X25519(), true
 	case CurveP256:
-		return elliptic.P256(), true
+		return ecdh.P256(), true
 	case CurveP384:
-		return elliptic.P384(), true
+		return ecdh.P384(), true
 	case CurveP521:
-		return elliptic.P521(), true
+		return ecdh.P521(), true
 	default:
 		return nil, false
 	}
 }
 
-type nistParameters struct {
-	privateKey []byte
-	x, y       *big.Int // public key
-	curveID    CurveID
-}
-
-func (p *nistParameters) CurveID() CurveID {
-	return p.curveID
-}
-
-func (p *nistParameters) PublicKey() []byte {
-	curve, _ := curveForCurveID(p.curveID)
-	return elliptic.Marshal(curve, p.x, p.y)
-}
-
-func (p *nistParameters) SharedKey(peerPublicKey []byte) []byte {
-	curve, _ := curveForCurveID(p.curveID)
-	// Unmarshal also checks whether the given point is on the curve.
-	x, y := elliptic.Unmarshal(curve, peerPublicKey)
-	if x == nil {
-		return nil
-	}
-
-	xShared, _ := curve.ScalarMult(x, y, p.privateKey)
-	sharedKey := make([]byte, (curve.Params().BitSize+7)/8)
-	return xShared.FillBytes(sharedKey)
-}
-
-type x25519Parameters struct {
-	privateKey []byte
-	publicKey  []byte
-}
-
-func (p *x25519Parameters) CurveID() CurveID {
-	return X25519
-}
-
-func (p *x25519Parameters) PublicKey() []byte {
-	return p.publicKey[:]
-}
-
-func (p *x25519Parameters) SharedKey(peerPublicKey []byte) []byte {
-	sharedKey, err := curve25519.X25519(p.privateKey, peerPublicKey)
-	if err != nil {
-		return nil
+func curveIDForCurve(curve ecdh.Curve) (CurveID, bool) {
+	switch curve {
+	case ecdh.X25519():
+		return X25519, true
+	case ecdh.P256():
+		return CurveP256, true
+	case ecdh.P384():
+		return CurveP384, true
+	case ecdh.P521():
+		return CurveP521, true
+	default:
+		return 0, false
 	}
-	return sharedKey
 }
@@ -314,6 +314,87 @@ func TestDialer(t *testing.T) {
 	}
 }
 
+func TestHandshakeContextCancel(t *testing.T) {
+	c, s := localPipe(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		// The server never answers, so the handshake blocks
+		// until the context is canceled.
+		errc <- Client(c, testConfig).HandshakeContext(ctx)
+	}()
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("err = %v; want context.Canceled", err)
+	}
+}
+
+func TestHandshakeContextHierarchy(t *testing.T) {
+	c, s := localPipe(t)
+	clientErr := make(chan error, 1)
+	clientConfig := testConfig.Clone()
+	serverConfig := testConfig.Clone()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := struct{}{}
+	ctx = context.WithValue(ctx, key, true)
+	go func() {
+		defer close(clientErr)
+		defer c.Close()
+		var innerCtx context.Context
+		clientConfig.Certificates = nil
+		clientConfig.GetClientCertificate = func(certificateRequest *CertificateRequestInfo) (*Certificate, error) {
+			if val, ok := certificateRequest.Context().Value(key).(bool); !ok || !val {
+				t.Errorf("GetClientCertificate context was not child of HandshakeContext")
+			}
+			innerCtx = certificateRequest.Context()
+			return &Certificate{
+				Certificate: [][]byte{testRSACertificate},
+				PrivateKey:  testRSAPrivateKey,
+			}, nil
+		}
+		cli := Client(c, clientConfig)
+		err := cli.HandshakeContext(ctx)
+		if err != nil {
+			clientErr <- err
+			return
+		}
+		select {
+		case <-innerCtx.Done():
+		default:
+			t.Errorf("GetClientCertificate context was not canceled after HandshakeContext returned.")
+		}
+	}()
+	var innerCtx context.Context
+	serverConfig.Certificates = nil
+	serverConfig.ClientAuth = RequestClientCert
+	serverConfig.GetCertificate = func(clientHello *ClientHelloInfo) (*Certificate, error) {
+		if val, ok := clientHello.Context().Value(key).(bool); !ok || !val {
+			t.Errorf("GetCertificate context was not child of HandshakeContext")
+		}
+		innerCtx = clientHello.Context()
+		return &Certificate{
+			Certificate: [][]byte{testRSACertificate},
+			PrivateKey:  testRSAPrivateKey,
+		}, nil
+	}
+	conn := Server(s, serverConfig)
+	err := conn.HandshakeContext(ctx)
+	if err != nil {
+		t.Errorf("Unexpected server handshake error: %v", err)
+	}
+	select {
+	case <-innerCtx.Done():
+	default:
+		t.Errorf("GetCertificate context was not canceled after HandshakeContext returned.")
+	}
+	if err := <-clientErr; err != nil {
+		t.Errorf("Unexpected client error: %v", err)
+	}
+}
+
 func isTimeoutError(err error) bool {
 	if ne, ok := err.(net.Error); ok {
 		return ne.Timeout()
@@ -734,7 +815,7 @@ func TestWarningAlertFlood(t *testing.T) {
 }
 
 func TestCloneFuncFields(t *testing.T) {
-	const expectedCount = 6
+	const expectedCount = 9
 	called := 0
 
 	c1 := Config{
@@ -762,6 +843,18 @@ func TestCloneFuncFields(t *testing.T) {
 			called |= 1 << 5
 			return nil
 		},
+		EncryptedClientHelloRejectionVerify: func(ConnectionState) error {
+			called |= 1 << 6
+			return nil
+		},
+		UnwrapSession: func(identity []byte, cs ConnectionState) (*SessionState, error) {
+			called |= 1 << 7
+			return nil, nil
+		},
+		WrapSession: func(cs ConnectionState, ss *SessionState) ([]byte, error) {
+			called |= 1 << 8
+			return nil, nil
+		},
 	}
 
 	c2 := c1.Clone()
@@ -772,6 +865,9 @@ func TestCloneFuncFields(t *testing.T) {
 	c2.GetConfigForClient(nil)
 	c2.VerifyPeerCertificate(nil, nil)
 	c2.VerifyConnection(ConnectionState{})
+	c2.EncryptedClientHelloRejectionVerify(ConnectionState{})
+	c2.UnwrapSession(nil, ConnectionState{})
+	c2.WrapSession(ConnectionState{}, nil)
 
 	if called != (1<<expectedCount)-1 {
 		t.Fatalf("expected %d calls but saw calls %b", expectedCount, called)
@@ -790,7 +886,7 @@ func TestCloneNonFuncFields(t *testing.T) {
 		switch fn := typ.Field(i).Name; fn {
 		case "Rand":
 			f.Set(reflect.ValueOf(io.Reader(os.Stdin)))
-		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate":
+		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate", "EncryptedClientHelloRejectionVerify", "UnwrapSession", "WrapSession":
 			// DeepEqual can't compare functions. If you add a
 			// function field to this list, you must also change
 			// TestCloneFuncFields to ensure that the func field is
@@ -825,6 +921,16 @@ func TestCloneNonFuncFields(t *testing.T) {
 			f.Set(reflect.ValueOf([]CurveID{CurveP256}))
 		case "Renegotiation":
 			f.Set(reflect.ValueOf(RenegotiateOnceAsClient))
+		case "OCSPStaplePolicy":
+			f.Set(reflect.ValueOf(OCSPStapleRequire))
+		case "CTPolicy":
+			f.Set(reflect.ValueOf(&x509.CTPolicy{MinimumSCTs: 2}))
+		case "EncryptedClientHelloConfigList":
+			f.Set(reflect.ValueOf([]byte{'x'}))
+		case "EncryptedClientHelloKeys":
+			f.Set(reflect.ValueOf([]EncryptedClientHelloKey{
+				{Config: []byte{1}, PrivateKey: []byte{1}},
+			}))
 		case "mutex", "autoSessionTicketKeys", "sessionTicketKeys":
 			continue // these are unexported fields that are handled separately
 		default:
@@ -1468,3 +1574,103 @@ func TestPKCS1OnlyCert(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestHandshakeMLKEM(t *testing.T) {
+	tests := []struct {
+		name          string
+		clientCurves  []CurveID
+		serverCurves  []CurveID
+		clientMaxVers uint16
+		wantCurve     CurveID
+		wantHRR       bool
+	}{
+		{
+			name:      "Default",
+			wantCurve: X25519,
+		},
+		{
+			name:         "Both",
+			clientCurves: []CurveID{X25519MLKEM768, X25519},
+			serverCurves: []CurveID{X25519MLKEM768, X25519},
+			wantCurve:    X25519MLKEM768,
+		},
+		{
+			name:         "ClientOnly",
+			clientCurves: []CurveID{X25519MLKEM768, X25519},
+			wantCurve:    X25519,
+		},
+		{
+			name:         "ClientOnlyWithoutX25519",
+			clientCurves: []CurveID{X25519MLKEM768, CurveP256},
+			wantCurve:    CurveP256,
+			wantHRR:      true,
+		},
+		{
+			name:         "ServerOnlyMLKEM",
+			clientCurves: []CurveID{X25519, X25519MLKEM768},
+			serverCurves: []CurveID{X25519MLKEM768},
+			wantCurve:    X25519MLKEM768,
+			wantHRR:      true,
+		},
+		{
+			// The server prefers groups for which the client sent a key share.
+			name:         "ServerPrefersMLKEM",
+			clientCurves: []CurveID{X25519, X25519MLKEM768},
+			serverCurves: []CurveID{X25519MLKEM768, X25519},
+			wantCurve:    X25519,
+		},
+		{
+			name:         "OnlyMLKEM",
+			clientCurves: []CurveID{X25519MLKEM768},
+			serverCurves: []CurveID{X25519MLKEM768},
+			wantCurve:    X25519MLKEM768,
+		},
+		{
+			name:          "TLS12",
+			clientCurves:  []CurveID{X25519MLKEM768, X25519},
+			serverCurves:  []CurveID{X25519MLKEM768, X25519},
+			clientMaxVers: VersionTLS12,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.CurvePreferences = test.clientCurves
+			clientConfig.MaxVersion = test.clientMaxVers
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = test.serverCurves
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.clientMaxVers == VersionTLS12 {
+				if cs.Version != VersionTLS12 {
+					t.Errorf("negotiated version %x, expected %x", cs.Version, VersionTLS12)
+				}
+			}
+			for _, s := range []ConnectionState{ss, cs} {
+				if s.testingOnlyCurveID != test.wantCurve {
+					t.Errorf("negotiated group %v, expected %v", s.testingOnlyCurveID, test.wantCurve)
+				}
+				if s.testingOnlyDidHRR != test.wantHRR {
+					t.Errorf("HelloRetryRequest = %v, expected %v", s.testingOnlyDidHRR, test.wantHRR)
+				}
+			}
+		})
+	}
+}
+
+func TestHandshakeMLKEMOnlyTLS12(t *testing.T) {
+	// X25519MLKEM768 can't be used in TLS 1.2, so a TLS 1.2 server that only
+	// supports it has no group in common with the client.
+	clientConfig := testConfig.Clone()
+	clientConfig.CurvePreferences = []CurveID{X25519MLKEM768}
+	serverConfig := testConfig.Clone()
+	serverConfig.CurvePreferences = []CurveID{X25519MLKEM768}
+	serverConfig.MaxVersion = VersionTLS12
+	serverConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// This file implements parsing and verification of Certificate Transparency
+// Signed Certificate Timestamps, as specified in RFC 6962.
+
+var (
+	oidExtensionSignedCertificateTimestampList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidOCSPExtensionSignedCertificateTimestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// RFC 6962, Section 3.2 values.
+const (
+	sctVersionV1                  = 0
+	sctSignatureTypeCertTimestamp = 0
+	sctEntryTypeX509              = 0
+	sctEntryTypePrecert           = 1
+
+	sctHashSHA256     = 4
+	sctSignatureRSA   = 1
+	sctSignatureECDSA = 3
+)
+
+// A SignedCertificateTimestamp is a promise by a Certificate Transparency log
+// to include a certificate in its log, as specified in RFC 6962, Section 3.2.
+// Only version 1 SCTs are supported.
+type SignedCertificateTimestamp struct {
+	Raw []byte // Complete serialized SCT.
+
+	// LogID is the SHA-256 hash of the log's DER-encoded public key.
+	LogID [32]byte
+
+	// Timestamp is the time at which the log issued the SCT, with
+	// millisecond precision.
+	Timestamp time.Time
+
+	// Extensions contains the opaque CtExtensions of the SCT.
+	Extensions []byte
+
+	// SignatureAlgorithm is either ECDSAWithSHA256 or SHA256WithRSA.
+	SignatureAlgorithm SignatureAlgorithm
+	Signature          []byte
+}
+
+// ParseSignedCertificateTimestamp parses a single serialized SCT, such as
+// an element of tls.ConnectionState.SignedCertificateTimestamps.
+func ParseSignedCertificateTimestamp(data []byte) (*SignedCertificateTimestamp, error) {
+	s := cryptobyte.String(data)
+	sct, err := parseSCT(&s)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Empty() {
+		return nil, errors.New("x509: trailing data after SCT")
+	}
+	return sct, nil
+}
+
+func parseSCT(s *cryptobyte.String) (*SignedCertificateTimestamp, error) {
+	raw := []byte(*s)
+	var (
+		version, hashAlg, sigAlg uint8
+		tsHigh, tsLow            uint32
+		logID                    []byte
+		extensions, signature    cryptobyte.String
+	)
+	if !s.ReadUint8(&version) {
+		return nil, errors.New("x509: malformed SCT")
+	}
+	if version != sctVersionV1 {
+		return nil, fmt.Errorf("x509: unsupported SCT version %d", version)
+	}
+	if !s.ReadBytes(&logID, 32) ||
+		!s.ReadUint32(&tsHigh) || !s.ReadUint32(&tsLow) ||
+		!s.ReadUint16LengthPrefixed(&extensions) ||
+		!s.ReadUint8(&hashAlg) || !s.ReadUint8(&sigAlg) ||
+		!s.ReadUint16LengthPrefixed(&signature) {
+		return nil, errors.New("x509: malformed SCT")
+	}
+
+	sct := &SignedCertificateTimestamp{
+		Raw:        raw[:len(raw)-len(*s)],
+		Extensions: []byte(extensions),
+		Signature:  []byte(signature),
+	}
+	copy(sct.LogID[:], logID)
+	ms := uint64(tsHigh)<<32 | uint64(tsLow)
+	sct.Timestamp = time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+
+	switch {
+	case hashAlg == sctHashSHA256 && sigAlg == sctSignatureECDSA:
+		sct.SignatureAlgorithm = ECDSAWithSHA256
+	case hashAlg == sctHashSHA256 && sigAlg == sctSignatureRSA:
+		sct.SignatureAlgorithm = SHA256WithRSA
+	default:
+		return nil, fmt.Errorf("x509: unsupported SCT signature algorithm %d/%d", hashAlg, sigAlg)
+	}
+	return sct, nil
+}
+
+// ParseSignedCertificateTimestampList parses a SignedCertificateTimestampList
+// as carried in the TLS signed_certificate_timestamp extension, see RFC 6962,
+// Section 3.3. SCTs embedded in certificates and OCSP responses are returned
+// by Certificate.SignedCertificateTimestamps and
+// OCSPResponse.SignedCertificateTimestamps.
+func ParseSignedCertificateTimestampList(data []byte) ([]*SignedCertificateTimestamp, error) {
+	input := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !input.ReadUint16LengthPrefixed(&list) || !input.Empty() || list.Empty() {
+		return nil, errors.New("x509: malformed SCT list")
+	}
+	var scts []*SignedCertificateTimestamp
+	for !list.Empty() {
+		var raw cryptobyte.String
+		if !list.ReadUint16LengthPrefixed(&raw) {
+			return nil, errors.New("x509: malformed SCT list")
+		}
+		sct, err := ParseSignedCertificateTimestamp(raw)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// parseSCTExtension returns the SCTs in an extension identified by oid, which
+// wraps a SignedCertificateTimestampList in an OCTET STRING.
+func parseSCTExtension(extensions []pkix.Extension, oid asn1.ObjectIdentifier) ([]*SignedCertificateTimestamp, error) {
+	for _, ext := range extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		var list []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, errors.New("x509: trailing data after SCT extension")
+		}
+		return ParseSignedCertificateTimestampList(list)
+	}
+	return nil, nil
+}
+
+// SignedCertificateTimestamps returns the SCTs embedded in c by its issuer.
+// If c doesn't have the SCT list extension, it returns nil and no error.
+func (c *Certificate) SignedCertificateTimestamps() ([]*SignedCertificateTimestamp, error) {
+	return parseSCTExtension(c.Extensions, oidExtensionSignedCertificateTimestampList)
+}
+
+// SignedCertificateTimestamps returns the SCTs for the certificate included in
+// resp by the OCSP responder. If resp doesn't have the SCT list extension, it
+// returns nil and no error.
+func (resp *OCSPResponse) SignedCertificateTimestamps() ([]*SignedCertificateTimestamp, error) {
+	return parseSCTExtension(resp.Extensions, oidOCSPExtensionSignedCertificateTimestamp)
+}
+
+// A CTLog is a Certificate Transparency log trusted to issue SCTs.
+type CTLog struct {
+	// Description is an optional human-readable name for the log.
+	Description string
+
+	// PublicKey is the log's public key, an *ecdsa.PublicKey or an
+	// *rsa.PublicKey.
+	PublicKey crypto.PublicKey
+}
+
+// A CTPolicy describes the Certificate Transparency requirements that a leaf
+// certificate must meet. See VerifyOptions.CTPolicy.
+type CTPolicy struct {
+	// Logs is the set of trusted logs. SCTs from other logs are ignored.
+	Logs []*CTLog
+
+	// MinimumSCTs is the number of distinct logs that must have issued a
+	// valid SCT for the leaf certificate. If zero, one is required.
+	MinimumSCTs int
+}
+
+// sctSignedData returns the data covered by the signature of sct for cert.
+// If issuer is not nil, sct is treated as embedded in cert by issuer, and the
+// signature covers the precertificate, see RFC 6962, Section 3.2.
+func sctSignedData(sct *SignedCertificateTimestamp, cert, issuer *Certificate) ([]byte, error) {
+	ms := uint64(sct.Timestamp.Unix())*1000 + uint64(sct.Timestamp.Nanosecond())/uint64(time.Millisecond)
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(sctVersionV1)
+	b.AddUint8(sctSignatureTypeCertTimestamp)
+	b.AddUint32(uint32(ms >> 32))
+	b.AddUint32(uint32(ms))
+	if issuer == nil {
+		b.AddUint16(sctEntryTypeX509)
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(cert.Raw)
+		})
+	} else {
+		tbs, err := precertTBSCertificate(cert.RawTBSCertificate)
+		if err != nil {
+			return nil, err
+		}
+		issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		b.AddUint16(sctEntryTypePrecert)
+		b.AddBytes(issuerKeyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(tbs)
+		})
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sct.Extensions)
+	})
+	return b.Bytes()
+}
+
+// precertTBSCertificate reconstructs the TBSCertificate a log signed for a
+// certificate with embedded SCTs, by removing the SCT list extension.
+func precertTBSCertificate(rawTBS []byte) ([]byte, error) {
+	extensionsTag := cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()
+	errMalformed := errors.New("x509: malformed tbs certificate")
+
+	input := cryptobyte.String(rawTBS)
+	var tbs cryptobyte.String
+	if !input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) || !input.Empty() {
+		return nil, errMalformed
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var elem cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !tbs.ReadAnyASN1Element(&elem, &tag) {
+				b.SetError(errMalformed)
+				return
+			}
+			if tag != extensionsTag {
+				b.AddBytes(elem)
+				continue
+			}
+
+			var wrapper, exts cryptobyte.String
+			if !elem.ReadASN1(&wrapper, extensionsTag) ||
+				!wrapper.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) || !wrapper.Empty() {
+				b.SetError(errMalformed)
+				return
+			}
+			var kept []cryptobyte.String
+			for !exts.Empty() {
+				var ext, body cryptobyte.String
+				var oid asn1.ObjectIdentifier
+				if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+					b.SetError(errMalformed)
+					return
+				}
+				element := ext
+				if !element.ReadASN1(&body, cryptobyte_asn1.SEQUENCE) ||
+					!body.ReadASN1ObjectIdentifier(&oid) {
+					b.SetError(errMalformed)
+					return
+				}
+				if !oid.Equal(oidExtensionSignedCertificateTimestampList) {
+					kept = append(kept, ext)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, ext := range kept {
+						b.AddBytes(ext)
+					}
+				})
+			})
+		}
+	})
+	return b.Bytes()
+}
+
+// checkSCT verifies that sct was issued by log for cert no later than now.
+// issuer is only set for SCTs embedded in cert.
+func checkSCT(sct *SignedCertificateTimestamp, log *CTLog, cert, issuer *Certificate, now time.Time) error {
+	if sct.Timestamp.After(now) {
+		return errors.New("x509: SCT timestamp is in the future")
+	}
+	signed, err := sctSignedData(sct, cert, issuer)
+	if err != nil {
+		return err
+	}
+	return checkSignature(sct.SignatureAlgorithm, signed, sct.Signature, log.PublicKey)
+}
+
+// checkCTPolicy returns the chains whose leaf satisfies opts.CTPolicy. SCTs
+// delivered out of band are valid for any chain, while embedded SCTs depend
+// on the issuer of the leaf in each chain.
+func checkCTPolicy(chains [][]*Certificate, opts *VerifyOptions) ([][]*Certificate, error) {
+	policy := opts.CTPolicy
+	required := policy.MinimumSCTs
+	if required <= 0 {
+		required = 1
+	}
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	logs := make(map[[32]byte]*CTLog, len(policy.Logs))
+	for _, log := range policy.Logs {
+		der, err := MarshalPKIXPublicKey(log.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("x509: invalid public key for CT log %q: %v", log.Description, err)
+		}
+		logs[sha256.Sum256(der)] = log
+	}
+
+	leaf := chains[0][0]
+	delivered := make(map[[32]byte]bool)
+	for _, raw := range opts.SignedCertificateTimestamps {
+		sct, err := ParseSignedCertificateTimestamp(raw)
+		if err != nil {
+			continue
+		}
+		log, ok := logs[sct.LogID]
+		if !ok || checkSCT(sct, log, leaf, nil, now) != nil {
+			continue
+		}
+		delivered[sct.LogID] = true
+	}
+	// A malformed embedded list doesn't invalidate other SCTs.
+	embedded, _ := leaf.SignedCertificateTimestamps()
+
+	var valid [][]*Certificate
+	best := len(delivered)
+	for _, chain := range chains {
+		seen := make(map[[32]byte]bool, len(delivered))
+		for id := range delivered {
+			seen[id] = true
+		}
+		if len(chain) > 1 {
+			for _, sct := range embedded {
+				log, ok := logs[sct.LogID]
+				if !ok || seen[sct.LogID] || checkSCT(sct, log, leaf, chain[1], now) != nil {
+					continue
+				}
+				seen[sct.LogID] = true
+			}
+		}
+		if len(seen) > best {
+			best = len(seen)
+		}
+		if len(seen) >= required {
+			valid = append(valid, chain)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, CertificateInvalidError{leaf, InsufficientSCTs, fmt.Sprintf("have %d, need %d", best, required)}
+	}
+	return valid, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/internal/cttest"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// newTestSCT returns a serialized SCT issued by logKey for cert at ts. If
+// issuer is not nil, the SCT is for a precertificate of cert.
+func newTestSCT(t *testing.T, logKey *ecdsa.PrivateKey, cert, issuer *Certificate, ts time.Time) []byte {
+	t.Helper()
+	spki, err := MarshalPKIXPublicKey(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := cttest.X509Entry(cert.Raw)
+	if issuer != nil {
+		tbs, err := precertTBSCertificate(cert.RawTBSCertificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry = cttest.PrecertEntry(issuer.RawSubjectPublicKeyInfo, tbs)
+	}
+	sct, err := cttest.SCT(logKey, spki, entry, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sct
+}
+
+// sctListExtension returns an extension value wrapping scts in a
+// SignedCertificateTimestampList.
+func sctListExtension(t *testing.T, scts ...[]byte) []byte {
+	t.Helper()
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(sct)
+			})
+		}
+	})
+	value, err := asn1.Marshal(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+type ctTestPKI struct {
+	roots            *CertPool
+	issuer           *Certificate
+	issuerKey        *ecdsa.PrivateKey
+	leaf             *Certificate // with an SCT from log1 embedded
+	log1, log2, log3 *ecdsa.PrivateKey
+	now              time.Time
+}
+
+func newCTTestPKI(t *testing.T) *ctTestPKI {
+	t.Helper()
+	pki := &ctTestPKI{now: time.Now()}
+	for _, key := range []**ecdsa.PrivateKey{&pki.log1, &pki.log2, &pki.log3} {
+		var err error
+		*key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issuer, issuerKey, err := generateCert("CT Test CA", true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.issuer, pki.issuerKey = issuer, issuerKey.(*ecdsa.PrivateKey)
+	pki.roots = NewCertPool()
+	pki.roots.AddCert(pki.issuer)
+
+	// The log signs the certificate as it is without the SCT extension,
+	// which is what remains of the precertificate once the poison extension
+	// is dropped. The leaf is the same certificate issued again, with the
+	// SCT embedded.
+	precert, _, err := generateCert("example.com", false, pki.issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sct := newTestSCT(t, pki.log1, precert, pki.issuer, pki.now.Add(-time.Minute))
+	leafTemplate := *precert
+	leafTemplate.ExtraExtensions = []pkix.Extension{{
+		Id:    oidExtensionSignedCertificateTimestampList,
+		Value: sctListExtension(t, sct),
+	}}
+	pki.leaf, err = reissueCert(&leafTemplate, pki.issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+func TestParseSignedCertificateTimestamp(t *testing.T) {
+	pki := newCTTestPKI(t)
+	ts := pki.now.Add(-time.Minute)
+	raw := newTestSCT(t, pki.log2, pki.leaf, nil, ts)
+
+	sct, err := ParseSignedCertificateTimestamp(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sct.Raw, raw) {
+		t.Errorf("Raw doesn't match the parsed bytes")
+	}
+	if !sct.Timestamp.Equal(ts.Truncate(time.Millisecond)) {
+		t.Errorf("Timestamp = %v, want %v", sct.Timestamp, ts.Truncate(time.Millisecond))
+	}
+	if sct.SignatureAlgorithm != ECDSAWithSHA256 {
+		t.Errorf("SignatureAlgorithm = %v, want %v", sct.SignatureAlgorithm, ECDSAWithSHA256)
+	}
+	if _, err := ParseSignedCertificateTimestamp(append(raw, 0)); err == nil {
+		t.Error("expected error parsing SCT with trailing data")
+	}
+	if _, err := ParseSignedCertificateTimestamp(raw[:len(raw)-1]); err == nil {
+		t.Error("expected error parsing truncated SCT")
+	}
+	v2 := append([]byte{1}, raw[1:]...)
+	if _, err := ParseSignedCertificateTimestamp(v2); err == nil {
+		t.Error("expected error parsing v2 SCT")
+	}
+
+	embedded, err := pki.leaf.SignedCertificateTimestamps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedded) != 1 {
+		t.Fatalf("got %d embedded SCTs, want 1", len(embedded))
+	}
+	if scts, err := pki.issuer.SignedCertificateTimestamps(); scts != nil || err != nil {
+		t.Errorf("certificate without SCTs: got %v, %v", scts, err)
+	}
+
+	// SCTs in an OCSP response use the same encoding.
+	der, err := CreateOCSPResponse(rand.Reader, &OCSPResponse{
+		Status:       OCSPGood,
+		SerialNumber: pki.leaf.SerialNumber,
+		ThisUpdate:   pki.now,
+		ExtraExtensions: []pkix.Extension{{
+			Id:    oidOCSPExtensionSignedCertificateTimestamp,
+			Value: sctListExtension(t, raw, raw),
+		}},
+	}, pki.issuer, pki.issuer, pki.issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ParseOCSPResponse(der, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocspSCTs, err := resp.SignedCertificateTimestamps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocspSCTs) != 2 || !bytes.Equal(ocspSCTs[0].Raw, raw) {
+		t.Errorf("unexpected SCTs in OCSP response: %v", ocspSCTs)
+	}
+
+	if _, err := ParseSignedCertificateTimestampList([]byte{0, 0}); err == nil {
+		t.Error("expected error parsing empty SCT list")
+	}
+}
+
+func TestVerifyCTPolicy(t *testing.T) {
+	pki := newCTTestPKI(t)
+	log := func(key *ecdsa.PrivateKey) *CTLog {
+		return &CTLog{PublicKey: key.Public()}
+	}
+	delivered := newTestSCT(t, pki.log2, pki.leaf, nil, pki.now.Add(-time.Minute))
+	future := newTestSCT(t, pki.log2, pki.leaf, nil, pki.now.Add(time.Minute))
+	wrongCert := newTestSCT(t, pki.log2, pki.issuer, nil, pki.now.Add(-time.Minute))
+	sameLog := newTestSCT(t, pki.log1, pki.leaf, nil, pki.now.Add(-time.Minute))
+
+	tests := []struct {
+		name    string
+		policy  *CTPolicy
+		scts    [][]byte
+		wantErr bool
+	}{
+		{
+			name:   "Embedded",
+			policy: &CTPolicy{Logs: []*CTLog{log(pki.log1)}},
+		},
+		{
+			name:    "UntrustedLog",
+			policy:  &CTPolicy{Logs: []*CTLog{log(pki.log2)}},
+			wantErr: true,
+		},
+		{
+			name:   "Delivered",
+			policy: &CTPolicy{Logs: []*CTLog{log(pki.log2)}},
+			scts:   [][]byte{delivered},
+		},
+		{
+			name:   "EmbeddedAndDelivered",
+			policy: &CTPolicy{Logs: []*CTLog{log(pki.log1), log(pki.log2)}, MinimumSCTs: 2},
+			scts:   [][]byte{delivered},
+		},
+		{
+			name:    "NotEnough",
+			policy:  &CTPolicy{Logs: []*CTLog{log(pki.log1), log(pki.log2), log(pki.log3)}, MinimumSCTs: 3},
+			scts:    [][]byte{delivered},
+			wantErr: true,
+		},
+		{
+			name:    "SameLogCountedOnce",
+			policy:  &CTPolicy{Logs: []*CTLog{log(pki.log1)}, MinimumSCTs: 2},
+			scts:    [][]byte{sameLog},
+			wantErr: true,
+		},
+		{
+			name:    "FutureTimestamp",
+			policy:  &CTPolicy{Logs: []*CTLog{log(pki.log1), log(pki.log2)}, MinimumSCTs: 2},
+			scts:    [][]byte{future},
+			wantErr: true,
+		},
+		{
+			name:    "WrongCertificate",
+			policy:  &CTPolicy{Logs: []*CTLog{log(pki.log1), log(pki.log2)}, MinimumSCTs: 2},
+			scts:    [][]byte{wrongCert},
+			wantErr: true,
+		},
+		{
+			name:   "MalformedIgnored",
+			policy: &CTPolicy{Logs: []*CTLog{log(pki.log1), log(pki.log2)}, MinimumSCTs: 2},
+			scts:   [][]byte{[]byte("dummy sct"), delivered},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chains, err := pki.leaf.Verify(VerifyOptions{
+				Roots:                       pki.roots,
+				CurrentTime:                 pki.now,
+				CTPolicy:                    tt.policy,
+				SignedCertificateTimestamps: tt.scts,
+			})
+			if tt.wantErr {
+				var invalidErr CertificateInvalidError
+				if !errors.As(err, &invalidErr) || invalidErr.Reason != InsufficientSCTs {
+					t.Fatalf("got error %v, want InsufficientSCTs", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chains) != 1 {
+				t.Errorf("got %d chains, want 1", len(chains))
+			}
+		})
+	}
+
+	// The embedded SCT covers the precertificate, so it's not valid when
+	// delivered out of band.
+	embedded, err := pki.leaf.SignedCertificateTimestamps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pki.leaf.Verify(VerifyOptions{
+		Roots:       pki.roots,
+		CurrentTime: pki.now,
+		CTPolicy: &CTPolicy{
+			Logs:        []*CTLog{log(pki.log1)},
+			MinimumSCTs: 2,
+		},
+		SignedCertificateTimestamps: [][]byte{embedded[0].Raw},
+	})
+	if err == nil {
+		t.Error("embedded SCT was counted twice")
+	}
+}
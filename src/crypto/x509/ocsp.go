@@ -0,0 +1,677 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// This file implements the Online Certificate Status Protocol, as specified
+// in RFC 6960.
+
+var oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+var ocspHashOIDs = []struct {
+	hash crypto.Hash
+	oid  asn1.ObjectIdentifier
+}{
+	{crypto.SHA1, asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}},
+	{crypto.SHA256, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+	{crypto.SHA384, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}},
+	{crypto.SHA512, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}},
+}
+
+func ocspHashFromOID(oid asn1.ObjectIdentifier) crypto.Hash {
+	for _, h := range ocspHashOIDs {
+		if h.oid.Equal(oid) {
+			return h.hash
+		}
+	}
+	return 0
+}
+
+func oidFromOCSPHash(hash crypto.Hash) (asn1.ObjectIdentifier, bool) {
+	for _, h := range ocspHashOIDs {
+		if h.hash == hash {
+			return h.oid, true
+		}
+	}
+	return nil, false
+}
+
+// These are internal structures that reflect the ASN.1 structure of OCSP
+// requests and responses. See RFC 6960, Sections 4.1.1 and 4.2.1.
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version       int              `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName pkix.RDNSequence `asn1:"explicit,tag:1,optional"`
+	RequestList   []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// OCSPStatus is the revocation status of a certificate, as reported by an
+// OCSP response.
+type OCSPStatus int
+
+const (
+	// OCSPGood means that the certificate is not revoked.
+	OCSPGood OCSPStatus = iota
+	// OCSPRevoked means that the certificate has been revoked.
+	OCSPRevoked
+	// OCSPUnknown means that the responder doesn't know about the
+	// certificate.
+	OCSPUnknown
+)
+
+func (s OCSPStatus) String() string {
+	switch s {
+	case OCSPGood:
+		return "good"
+	case OCSPRevoked:
+		return "revoked"
+	case OCSPUnknown:
+		return "unknown"
+	}
+	return "OCSPStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// OCSPResponseStatus is the status of an OCSP response as a whole, which
+// indicates whether the responder was able to process the request. See
+// RFC 6960, Section 4.2.1.
+type OCSPResponseStatus int
+
+const (
+	OCSPSuccessful       OCSPResponseStatus = 0
+	OCSPMalformedRequest OCSPResponseStatus = 1
+	OCSPInternalError    OCSPResponseStatus = 2
+	OCSPTryLater         OCSPResponseStatus = 3
+	// Status code 4 is not used.
+	OCSPSigRequired  OCSPResponseStatus = 5
+	OCSPUnauthorized OCSPResponseStatus = 6
+)
+
+func (s OCSPResponseStatus) String() string {
+	switch s {
+	case OCSPSuccessful:
+		return "successful"
+	case OCSPMalformedRequest:
+		return "malformed request"
+	case OCSPInternalError:
+		return "internal error"
+	case OCSPTryLater:
+		return "try later"
+	case OCSPSigRequired:
+		return "signature required"
+	case OCSPUnauthorized:
+		return "unauthorized"
+	}
+	return "OCSPResponseStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// An OCSPResponseError is returned by ParseOCSPResponse when the responder
+// returned an error status instead of a response.
+type OCSPResponseError struct {
+	Status OCSPResponseStatus
+}
+
+func (e OCSPResponseError) Error() string {
+	return "x509: OCSP responder returned status: " + e.Status.String()
+}
+
+// OCSPRequest represents an OCSP request for the status of a single
+// certificate. See RFC 6960, Section 4.1.
+type OCSPRequest struct {
+	// HashAlgorithm is the hash used to compute IssuerNameHash and
+	// IssuerKeyHash.
+	HashAlgorithm crypto.Hash
+	// IssuerNameHash is the hash of the DER encoding of the issuer's
+	// distinguished name.
+	IssuerNameHash []byte
+	// IssuerKeyHash is the hash of the issuer's public key, excluding the
+	// tag and length of the subjectPublicKey BIT STRING.
+	IssuerKeyHash []byte
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+}
+
+// Marshal returns the DER encoding of the request.
+func (req *OCSPRequest) Marshal() ([]byte, error) {
+	hashOID, ok := oidFromOCSPHash(req.HashAlgorithm)
+	if !ok {
+		return nil, errors.New("x509: unsupported OCSP hash algorithm")
+	}
+	if req.SerialNumber == nil {
+		return nil, errors.New("x509: OCSP request contains nil SerialNumber field")
+	}
+	return asn1.Marshal(ocspRequest{
+		ocspTBSRequest{
+			RequestList: []ocspSingleRequest{{
+				Cert: ocspCertID{
+					HashAlgorithm: pkix.AlgorithmIdentifier{
+						Algorithm:  hashOID,
+						Parameters: asn1.NullRawValue,
+					},
+					NameHash:      req.IssuerNameHash,
+					IssuerKeyHash: req.IssuerKeyHash,
+					SerialNumber:  req.SerialNumber,
+				},
+			}},
+		},
+	})
+}
+
+// issuerHashes returns the hashes of the issuer's name and public key that
+// identify it in OCSP requests and responses.
+func issuerHashes(issuer *Certificate, hash crypto.Hash) (nameHash, keyHash []byte, err error) {
+	if !hash.Available() {
+		return nil, nil, ErrUnsupportedAlgorithm
+	}
+	var spki publicKeyInfo
+	if rest, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	} else if len(rest) != 0 {
+		return nil, nil, errors.New("x509: trailing data after issuer public key")
+	}
+
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash = h.Sum(nil)
+	h.Reset()
+	h.Write(issuer.RawSubject)
+	nameHash = h.Sum(nil)
+	return nameHash, keyHash, nil
+}
+
+// CreateOCSPRequest returns a DER-encoded OCSP request for the status of
+// cert, which must have been issued by issuer. If hash is zero, SHA-1 is used
+// to identify the issuer, as it is the only hash that all responders are
+// required to support.
+func CreateOCSPRequest(cert, issuer *Certificate, hash crypto.Hash) ([]byte, error) {
+	if hash == 0 {
+		hash = crypto.SHA1
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, hash)
+	if err != nil {
+		return nil, err
+	}
+	req := &OCSPRequest{
+		HashAlgorithm:  hash,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   cert.SerialNumber,
+	}
+	return req.Marshal()
+}
+
+// ParseOCSPRequest parses a DER-encoded OCSP request. If the request asks
+// for the status of multiple certificates, only the first one is returned.
+func ParseOCSPRequest(der []byte) (*OCSPRequest, error) {
+	var req ocspRequest
+	rest, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("x509: trailing data in OCSP request")
+	}
+	if len(req.TBSRequest.RequestList) == 0 {
+		return nil, errors.New("x509: OCSP request contains no request body")
+	}
+	certID := req.TBSRequest.RequestList[0].Cert
+	hash := ocspHashFromOID(certID.HashAlgorithm.Algorithm)
+	if hash == 0 {
+		return nil, errors.New("x509: OCSP request uses unsupported hash algorithm")
+	}
+	return &OCSPRequest{
+		HashAlgorithm:  hash,
+		IssuerNameHash: certID.NameHash,
+		IssuerKeyHash:  certID.IssuerKeyHash,
+		SerialNumber:   certID.SerialNumber,
+	}, nil
+}
+
+// OCSPResponse represents an OCSP response for the status of a single
+// certificate. See RFC 6960, Section 4.2.
+//
+// It is also used as a template by CreateOCSPResponse.
+type OCSPResponse struct {
+	Raw                []byte // Complete ASN.1 DER content (response status and response bytes).
+	RawTBSResponseData []byte // ResponseData, the signed part of the response.
+
+	// Status is the revocation status of the certificate.
+	Status       OCSPStatus
+	SerialNumber *big.Int
+
+	// ProducedAt is the time at which the responder signed the response.
+	ProducedAt time.Time
+	// ThisUpdate is the time at which the status is known to be correct.
+	ThisUpdate time.Time
+	// NextUpdate is the time by which newer information will be available.
+	// If it is zero, newer information is always available.
+	NextUpdate time.Time
+
+	// RevokedAt and RevocationReason are only set if Status is OCSPRevoked.
+	// RevocationReason is a CRLReason code, as defined in RFC 5280,
+	// Section 5.3.1.
+	RevokedAt        time.Time
+	RevocationReason int
+
+	// Certificate is the delegated responder certificate embedded in the
+	// response, if any. When creating a response, it is embedded if
+	// non-nil.
+	Certificate *Certificate
+
+	Signature          []byte
+	SignatureAlgorithm SignatureAlgorithm
+
+	// IssuerHash is the hash used to identify the certificate issuer.
+	// When creating a response, SHA-1 is used if it is zero.
+	IssuerHash crypto.Hash
+
+	// RawResponderName is the DER encoding of the responder's name, if the
+	// responder is identified by name. Otherwise, ResponderKeyHash is the
+	// SHA-1 hash of the responder's public key.
+	RawResponderName []byte
+	ResponderKeyHash []byte
+
+	// Extensions contains the raw singleExtensions of the response. When
+	// parsing, unsupported critical extensions cause an error.
+	Extensions []pkix.Extension
+
+	// ExtraExtensions contains extensions to be copied, raw, into the
+	// singleExtensions of a response created by CreateOCSPResponse.
+	ExtraExtensions []pkix.Extension
+}
+
+// CheckSignatureFrom checks that the signature on the response is valid
+// from issuer, which may be either the certificate issuer or a responder
+// certificate.
+func (resp *OCSPResponse) CheckSignatureFrom(issuer *Certificate) error {
+	return issuer.CheckSignature(resp.SignatureAlgorithm, resp.RawTBSResponseData, resp.Signature)
+}
+
+// ParseOCSPResponse parses a DER-encoded OCSP response, which must contain a
+// single certificate status.
+//
+// If issuer is not nil, the signature on the response is checked. Either
+// issuer must have signed the response directly, or it must have issued the
+// embedded responder certificate, which must in turn carry the
+// ExtKeyUsageOCSPSigning extended key usage and have signed the response.
+//
+// If the responder returned an error status, the returned error is an
+// OCSPResponseError.
+func ParseOCSPResponse(der []byte, issuer *Certificate) (*OCSPResponse, error) {
+	return ParseOCSPResponseForCert(der, nil, issuer)
+}
+
+// ParseOCSPResponseForCert is like ParseOCSPResponse, but if cert is not nil
+// it selects the status of cert among those included in the response. If
+// issuer is also not nil, the status must identify issuer as the issuer of
+// cert.
+func ParseOCSPResponseForCert(der []byte, cert, issuer *Certificate) (*OCSPResponse, error) {
+	var resp ocspResponse
+	rest, err := asn1.Unmarshal(der, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("x509: trailing data in OCSP response")
+	}
+
+	if status := OCSPResponseStatus(resp.Status); status != OCSPSuccessful {
+		return nil, OCSPResponseError{status}
+	}
+
+	if !resp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return nil, errors.New("x509: unsupported OCSP response type")
+	}
+
+	var basicResp ocspBasicResponse
+	rest, err = asn1.Unmarshal(resp.Response.Response, &basicResp)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("x509: trailing data in OCSP response")
+	}
+
+	responses := basicResp.TBSResponseData.Responses
+	if n := len(responses); n == 0 || cert == nil && n > 1 {
+		return nil, errors.New("x509: OCSP response contains bad number of responses")
+	}
+
+	var singleResp ocspSingleResponse
+	if cert == nil {
+		singleResp = responses[0]
+	} else {
+		match := false
+		for _, r := range responses {
+			if cert.SerialNumber.Cmp(r.CertID.SerialNumber) != 0 {
+				continue
+			}
+			if issuer != nil && !ocspCertIDMatchesIssuer(r.CertID, issuer) {
+				continue
+			}
+			singleResp = r
+			match = true
+			break
+		}
+		if !match {
+			return nil, errors.New("x509: no OCSP response matching the supplied certificate")
+		}
+	}
+
+	ret := &OCSPResponse{
+		Raw:                der,
+		RawTBSResponseData: basicResp.TBSResponseData.Raw,
+		Signature:          basicResp.Signature.RightAlign(),
+		SignatureAlgorithm: getSignatureAlgorithmFromAI(basicResp.SignatureAlgorithm),
+		Extensions:         singleResp.SingleExtensions,
+		SerialNumber:       singleResp.CertID.SerialNumber,
+		ProducedAt:         basicResp.TBSResponseData.ProducedAt,
+		ThisUpdate:         singleResp.ThisUpdate,
+		NextUpdate:         singleResp.NextUpdate,
+	}
+
+	// ResponderID is a CHOICE between an explicitly tagged Name and an
+	// explicitly tagged OCTET STRING. See RFC 6960, Section 4.2.1.
+	rawResponderID := basicResp.TBSResponseData.RawResponderID
+	switch {
+	case rawResponderID.Class == asn1.ClassContextSpecific && rawResponderID.Tag == 1:
+		var rdn pkix.RDNSequence
+		if rest, err := asn1.Unmarshal(rawResponderID.Bytes, &rdn); err != nil || len(rest) != 0 {
+			return nil, errors.New("x509: invalid OCSP responder name")
+		}
+		ret.RawResponderName = rawResponderID.Bytes
+	case rawResponderID.Class == asn1.ClassContextSpecific && rawResponderID.Tag == 2:
+		if rest, err := asn1.Unmarshal(rawResponderID.Bytes, &ret.ResponderKeyHash); err != nil || len(rest) != 0 {
+			return nil, errors.New("x509: invalid OCSP responder key hash")
+		}
+	default:
+		return nil, errors.New("x509: invalid OCSP responder ID")
+	}
+
+	if len(basicResp.Certificates) > 0 {
+		// Responders should send at most one certificate, delegated by the
+		// issuer to sign responses. Some send more, so accept that, but
+		// ignore all but the first.
+		ret.Certificate, err = ParseCertificate(basicResp.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if issuer != nil {
+		if err := checkOCSPResponseSignature(ret, issuer); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ext := range singleResp.SingleExtensions {
+		if ext.Critical {
+			return nil, errors.New("x509: unsupported critical extension in OCSP response")
+		}
+	}
+
+	ret.IssuerHash = ocspHashFromOID(singleResp.CertID.HashAlgorithm.Algorithm)
+	if ret.IssuerHash == 0 {
+		return nil, errors.New("x509: OCSP response uses unsupported issuer hash algorithm")
+	}
+
+	switch {
+	case bool(singleResp.Good):
+		ret.Status = OCSPGood
+	case bool(singleResp.Unknown):
+		ret.Status = OCSPUnknown
+	default:
+		ret.Status = OCSPRevoked
+		ret.RevokedAt = singleResp.Revoked.RevocationTime
+		ret.RevocationReason = int(singleResp.Revoked.Reason)
+	}
+
+	return ret, nil
+}
+
+// checkOCSPResponseSignature checks that resp was signed by issuer, either
+// directly or through a delegated responder certificate.
+func checkOCSPResponseSignature(resp *OCSPResponse, issuer *Certificate) error {
+	responder := resp.Certificate
+	if responder == nil || bytes.Equal(responder.Raw, issuer.Raw) {
+		if err := resp.CheckSignatureFrom(issuer); err != nil {
+			return errors.New("x509: bad OCSP response signature: " + err.Error())
+		}
+		return nil
+	}
+
+	if err := responder.CheckSignatureFrom(issuer); err != nil {
+		return errors.New("x509: OCSP responder certificate not issued by issuer: " + err.Error())
+	}
+	delegated := false
+	for _, eku := range responder.ExtKeyUsage {
+		if eku == ExtKeyUsageOCSPSigning {
+			delegated = true
+			break
+		}
+	}
+	if !delegated {
+		return errors.New("x509: OCSP responder certificate is not authorized to sign OCSP responses")
+	}
+	if err := resp.CheckSignatureFrom(responder); err != nil {
+		return errors.New("x509: bad OCSP response signature: " + err.Error())
+	}
+	return nil
+}
+
+// ocspCertIDMatchesIssuer returns whether id identifies issuer.
+func ocspCertIDMatchesIssuer(id ocspCertID, issuer *Certificate) bool {
+	hash := ocspHashFromOID(id.HashAlgorithm.Algorithm)
+	if hash == 0 {
+		return false
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, hash)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(nameHash, id.NameHash) && bytes.Equal(keyHash, id.IssuerKeyHash)
+}
+
+// CreateOCSPResponse returns a DER-encoded OCSP response for the certificate
+// described by template, which was issued by issuer.
+//
+// The response is signed by priv, which must be the private key of either
+// issuer or responder. If responder is not the issuer, it must be a
+// certificate issued by issuer with the ExtKeyUsageOCSPSigning extended key
+// usage, and template.Certificate should be set to it so that clients can
+// verify the delegation.
+//
+// The following members of template are used: Status, SerialNumber,
+// ProducedAt, ThisUpdate, NextUpdate, RevokedAt, RevocationReason,
+// Certificate, SignatureAlgorithm, IssuerHash, and ExtraExtensions. If
+// ProducedAt is zero, the current time is used.
+func CreateOCSPResponse(rand io.Reader, template *OCSPResponse, issuer, responder *Certificate, priv crypto.Signer) ([]byte, error) {
+	if template == nil {
+		return nil, errors.New("x509: template can not be nil")
+	}
+	if issuer == nil || responder == nil {
+		return nil, errors.New("x509: issuer and responder can not be nil")
+	}
+	if template.SerialNumber == nil {
+		return nil, errors.New("x509: template contains nil SerialNumber field")
+	}
+	if !template.NextUpdate.IsZero() && template.NextUpdate.Before(template.ThisUpdate) {
+		return nil, errors.New("x509: template.ThisUpdate is after template.NextUpdate")
+	}
+
+	issuerHash := template.IssuerHash
+	if issuerHash == 0 {
+		issuerHash = crypto.SHA1
+	}
+	hashOID, ok := oidFromOCSPHash(issuerHash)
+	if !ok {
+		return nil, errors.New("x509: unsupported OCSP issuer hash algorithm")
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, issuerHash)
+	if err != nil {
+		return nil, err
+	}
+
+	singleResp := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  hashOID,
+				Parameters: asn1.NullRawValue,
+			},
+			NameHash:      nameHash,
+			IssuerKeyHash: keyHash,
+			SerialNumber:  template.SerialNumber,
+		},
+		ThisUpdate:       template.ThisUpdate.UTC(),
+		NextUpdate:       template.NextUpdate.UTC(),
+		SingleExtensions: template.ExtraExtensions,
+	}
+	switch template.Status {
+	case OCSPGood:
+		singleResp.Good = true
+	case OCSPUnknown:
+		singleResp.Unknown = true
+	case OCSPRevoked:
+		if template.RevokedAt.IsZero() {
+			return nil, errors.New("x509: template contains zero RevokedAt field for a revoked certificate")
+		}
+		singleResp.Revoked = ocspRevokedInfo{
+			RevocationTime: template.RevokedAt.UTC(),
+			Reason:         asn1.Enumerated(template.RevocationReason),
+		}
+	default:
+		return nil, errors.New("x509: invalid OCSP status")
+	}
+
+	producedAt := template.ProducedAt
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+	tbsResponseData := ocspResponseData{
+		RawResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        1, // byName
+			IsCompound: true,
+			Bytes:      responder.RawSubject,
+		},
+		ProducedAt: producedAt.UTC().Truncate(time.Second),
+		Responses:  []ocspSingleResponse{singleResp},
+	}
+	tbsResponseDataContents, err := asn1.Marshal(tbsResponseData)
+	if err != nil {
+		return nil, err
+	}
+
+	hashFunc, signatureAlgorithm, err := signingParamsForPublicKey(priv.Public(), template.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	input := tbsResponseDataContents
+	if hashFunc != 0 {
+		h := hashFunc.New()
+		h.Write(tbsResponseDataContents)
+		input = h.Sum(nil)
+	}
+	var signerOpts crypto.SignerOpts = hashFunc
+	if template.SignatureAlgorithm.isRSAPSS() {
+		signerOpts = &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       hashFunc,
+		}
+	}
+
+	signature, err := priv.Sign(rand, input, signerOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	basicResp := ocspBasicResponse{
+		TBSResponseData:    tbsResponseData,
+		SignatureAlgorithm: signatureAlgorithm,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	}
+	if template.Certificate != nil {
+		basicResp.Certificates = []asn1.RawValue{{FullBytes: template.Certificate.Raw}}
+	}
+	basicRespContents, err := asn1.Marshal(basicResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspResponse{
+		Status: asn1.Enumerated(OCSPSuccessful),
+		Response: ocspResponseBytes{
+			ResponseType: oidOCSPBasicResponse,
+			Response:     basicRespContents,
+		},
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ocspTestPKI struct {
+	issuer, leaf, responder *Certificate
+	issuerKey, responderKey crypto.Signer
+}
+
+// newOCSPTestPKI returns a CA, a leaf it issued, and a delegated responder
+// with the extended key usages responderEKU.
+func newOCSPTestPKI(t *testing.T, responderEKU []ExtKeyUsage) *ocspTestPKI {
+	t.Helper()
+	issuer, issuerKey, err := generateCert("OCSP Test CA", true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _, err := generateCert("leaf", false, issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, responderKey, err := generateCert("OCSP Responder", false, issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderTemplate := *responder
+	responderTemplate.ExtKeyUsage = responderEKU
+	responder, err = reissueCert(&responderTemplate, issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ocspTestPKI{
+		issuer:       issuer,
+		leaf:         leaf,
+		responder:    responder,
+		issuerKey:    issuerKey.(crypto.Signer),
+		responderKey: responderKey.(crypto.Signer),
+	}
+}
+
+func TestOCSPRequest(t *testing.T) {
+	pki := newOCSPTestPKI(t, nil)
+	for _, hash := range []crypto.Hash{0, crypto.SHA256, crypto.SHA512} {
+		der, err := CreateOCSPRequest(pki.leaf, pki.issuer, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := ParseOCSPRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantHash := hash
+		if wantHash == 0 {
+			wantHash = crypto.SHA1
+		}
+		if req.HashAlgorithm != wantHash {
+			t.Errorf("HashAlgorithm = %v, want %v", req.HashAlgorithm, wantHash)
+		}
+		if req.SerialNumber.Cmp(pki.leaf.SerialNumber) != 0 {
+			t.Errorf("SerialNumber = %v, want %v", req.SerialNumber, pki.leaf.SerialNumber)
+		}
+		nameHash, keyHash, err := issuerHashes(pki.issuer, wantHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(req.IssuerNameHash, nameHash) || !bytes.Equal(req.IssuerKeyHash, keyHash) {
+			t.Errorf("request doesn't identify the issuer")
+		}
+		reencoded, err := req.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reencoded, der) {
+			t.Errorf("re-encoded request doesn't match:\n got: %x\nwant: %x", reencoded, der)
+		}
+	}
+
+	if _, err := ParseOCSPRequest([]byte{0x30, 0x00}); err == nil {
+		t.Error("expected error parsing an empty request")
+	}
+}
+
+func TestOCSPResponse(t *testing.T) {
+	pki := newOCSPTestPKI(t, []ExtKeyUsage{ExtKeyUsageOCSPSigning})
+	thisUpdate := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	nextUpdate := thisUpdate.Add(24 * time.Hour)
+	revokedAt := thisUpdate.Add(-time.Hour)
+	ext := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3}, Value: []byte{0x05, 0x00}}
+
+	tests := []struct {
+		name      string
+		template  OCSPResponse
+		responder *Certificate
+		key       crypto.Signer
+	}{
+		{
+			name: "good",
+			template: OCSPResponse{
+				Status:          OCSPGood,
+				ThisUpdate:      thisUpdate,
+				NextUpdate:      nextUpdate,
+				ExtraExtensions: []pkix.Extension{ext},
+			},
+			responder: pki.issuer,
+			key:       pki.issuerKey,
+		},
+		{
+			name: "revoked",
+			template: OCSPResponse{
+				Status:           OCSPRevoked,
+				ThisUpdate:       thisUpdate,
+				RevokedAt:        revokedAt,
+				RevocationReason: 1, // keyCompromise
+				IssuerHash:       crypto.SHA256,
+			},
+			responder: pki.issuer,
+			key:       pki.issuerKey,
+		},
+		{
+			name: "unknown",
+			template: OCSPResponse{
+				Status:     OCSPUnknown,
+				ThisUpdate: thisUpdate,
+				NextUpdate: nextUpdate,
+			},
+			responder: pki.issuer,
+			key:       pki.issuerKey,
+		},
+		{
+			name: "delegated",
+			template: OCSPResponse{
+				Status:      OCSPGood,
+				ThisUpdate:  thisUpdate,
+				NextUpdate:  nextUpdate,
+				Certificate: pki.responder,
+			},
+			responder: pki.responder,
+			key:       pki.responderKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := tt.template
+			template.SerialNumber = pki.leaf.SerialNumber
+			der, err := CreateOCSPResponse(rand.Reader, &template, pki.issuer, tt.responder, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := ParseOCSPResponseForCert(der, pki.leaf, pki.issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Status != template.Status {
+				t.Errorf("Status = %v, want %v", resp.Status, template.Status)
+			}
+			if resp.SerialNumber.Cmp(template.SerialNumber) != 0 {
+				t.Errorf("SerialNumber = %v, want %v", resp.SerialNumber, template.SerialNumber)
+			}
+			if !resp.ThisUpdate.Equal(template.ThisUpdate) {
+				t.Errorf("ThisUpdate = %v, want %v", resp.ThisUpdate, template.ThisUpdate)
+			}
+			if !resp.NextUpdate.Equal(template.NextUpdate) {
+				t.Errorf("NextUpdate = %v, want %v", resp.NextUpdate, template.NextUpdate)
+			}
+			if !resp.RevokedAt.Equal(template.RevokedAt) {
+				t.Errorf("RevokedAt = %v, want %v", resp.RevokedAt, template.RevokedAt)
+			}
+			if resp.RevocationReason != template.RevocationReason {
+				t.Errorf("RevocationReason = %d, want %d", resp.RevocationReason, template.RevocationReason)
+			}
+			wantHash := template.IssuerHash
+			if wantHash == 0 {
+				wantHash = crypto.SHA1
+			}
+			if resp.IssuerHash != wantHash {
+				t.Errorf("IssuerHash = %v, want %v", resp.IssuerHash, wantHash)
+			}
+			if !bytes.Equal(resp.RawResponderName, tt.responder.RawSubject) {
+				t.Errorf("RawResponderName = %x, want %x", resp.RawResponderName, tt.responder.RawSubject)
+			}
+			if len(resp.Extensions) != len(template.ExtraExtensions) {
+				t.Errorf("got %d extensions, want %d", len(resp.Extensions), len(template.ExtraExtensions))
+			}
+			if template.Certificate != nil {
+				if resp.Certificate == nil || !resp.Certificate.Equal(template.Certificate) {
+					t.Errorf("embedded responder certificate doesn't match")
+				}
+			} else if resp.Certificate != nil {
+				t.Errorf("unexpected embedded responder certificate")
+			}
+			if !bytes.Equal(resp.Raw, der) {
+				t.Errorf("Raw doesn't match the parsed bytes")
+			}
+
+			// Parsing without an issuer skips the signature check.
+			if _, err := ParseOCSPResponse(der, nil); err != nil {
+				t.Errorf("ParseOCSPResponse without issuer: %v", err)
+			}
+		})
+	}
+}
+
+func TestOCSPResponseVerification(t *testing.T) {
+	pki := newOCSPTestPKI(t, nil)
+	other := newOCSPTestPKI(t, []ExtKeyUsage{ExtKeyUsageOCSPSigning})
+	thisUpdate := time.Now()
+
+	template := &OCSPResponse{
+		Status:       OCSPGood,
+		SerialNumber: pki.leaf.SerialNumber,
+		ThisUpdate:   thisUpdate,
+	}
+	der, err := CreateOCSPResponse(rand.Reader, template, pki.issuer, pki.issuer, pki.issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOCSPResponseForCert(der, pki.leaf, other.issuer); err == nil {
+		t.Error("response for a different issuer was accepted")
+	}
+	if _, err := ParseOCSPResponse(der, other.issuer); err == nil ||
+		!strings.Contains(err.Error(), "signature") {
+		t.Errorf("response signed by a different issuer: got %v, want signature error", err)
+	}
+	if _, err := ParseOCSPResponseForCert(der, pki.responder, pki.issuer); err == nil {
+		t.Error("response for a different serial number was accepted")
+	}
+
+	// The responder certificate lacks the OCSPSigning extended key usage.
+	template.Certificate = pki.responder
+	der, err = CreateOCSPResponse(rand.Reader, template, pki.issuer, pki.responder, pki.responderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOCSPResponseForCert(der, pki.leaf, pki.issuer); err == nil ||
+		!strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("unauthorized responder: got %v, want authorization error", err)
+	}
+
+	// The responder certificate was not issued by the certificate issuer.
+	template.Certificate = other.responder
+	der, err = CreateOCSPResponse(rand.Reader, template, pki.issuer, other.responder, other.responderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOCSPResponseForCert(der, pki.leaf, pki.issuer); err == nil {
+		t.Error("response from a responder of a different issuer was accepted")
+	}
+}
+
+func TestOCSPResponseError(t *testing.T) {
+	// An OCSPResponse with status tryLater and no responseBytes.
+	der := []byte{0x30, 0x03, 0x0a, 0x01, 0x03}
+	_, err := ParseOCSPResponse(der, nil)
+	var respErr OCSPResponseError
+	if !errors.As(err, &respErr) || respErr.Status != OCSPTryLater {
+		t.Fatalf("got error %v, want OCSPResponseError with status %v", err, OCSPTryLater)
+	}
+	if got, want := err.Error(), "x509: OCSP responder returned status: try later"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
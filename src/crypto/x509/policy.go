@@ -0,0 +1,373 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"sort"
+)
+
+var oidAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+
+// The policy tree of RFC 5280, Section 6.1.2 can grow exponentially with the
+// length of the chain, so it's represented as a directed acyclic graph, as
+// specified by RFC 9618. Each stratum holds the nodes for one certificate of
+// the path, indexed by the string form of their valid policy.
+
+type policyGraphNode struct {
+	validPolicy       asn1.ObjectIdentifier
+	expectedPolicySet []asn1.ObjectIdentifier
+	// Policy qualifiers are not processed, so qualifier_set is not tracked.
+
+	parents  map[*policyGraphNode]bool
+	children map[*policyGraphNode]bool
+}
+
+func newPolicyGraphNode(valid asn1.ObjectIdentifier, parents []*policyGraphNode) *policyGraphNode {
+	n := &policyGraphNode{
+		validPolicy:       valid,
+		expectedPolicySet: []asn1.ObjectIdentifier{valid},
+		children:          make(map[*policyGraphNode]bool),
+		parents:           make(map[*policyGraphNode]bool),
+	}
+	for _, p := range parents {
+		p.children[n] = true
+		n.parents[p] = true
+	}
+	return n
+}
+
+type policyGraph struct {
+	strata []map[string]*policyGraphNode
+	// parentIndex maps a policy to the nodes at strata[depth-1] that have it
+	// in their expected policy set.
+	parentIndex map[string][]*policyGraphNode
+	depth       int
+}
+
+func newPolicyGraph() *policyGraph {
+	root := newPolicyGraphNode(oidAnyPolicy, nil)
+	return &policyGraph{
+		strata: []map[string]*policyGraphNode{{oidAnyPolicy.String(): root}},
+	}
+}
+
+func (pg *policyGraph) insert(n *policyGraphNode) {
+	pg.strata[pg.depth][n.validPolicy.String()] = n
+}
+
+func (pg *policyGraph) parentsWithExpected(expected asn1.ObjectIdentifier) []*policyGraphNode {
+	if pg.depth == 0 {
+		return nil
+	}
+	return pg.parentIndex[expected.String()]
+}
+
+func (pg *policyGraph) parentWithAnyPolicy() *policyGraphNode {
+	if pg.depth == 0 {
+		return nil
+	}
+	return pg.strata[pg.depth-1][oidAnyPolicy.String()]
+}
+
+func (pg *policyGraph) parents() map[string]*policyGraphNode {
+	if pg.depth == 0 {
+		return nil
+	}
+	return pg.strata[pg.depth-1]
+}
+
+func (pg *policyGraph) leaves() map[string]*policyGraphNode {
+	return pg.strata[pg.depth]
+}
+
+func (pg *policyGraph) leafWithPolicy(policy asn1.ObjectIdentifier) *policyGraphNode {
+	return pg.strata[pg.depth][policy.String()]
+}
+
+func (pg *policyGraph) deleteLeaf(policy asn1.ObjectIdentifier) {
+	n := pg.strata[pg.depth][policy.String()]
+	if n == nil {
+		return
+	}
+	for p := range n.parents {
+		delete(p.children, n)
+	}
+	for c := range n.children {
+		delete(c.parents, n)
+	}
+	delete(pg.strata[pg.depth], policy.String())
+}
+
+// validPolicyNodes returns the nodes whose only parent is an anyPolicy node,
+// excluding anyPolicy nodes themselves.
+func (pg *policyGraph) validPolicyNodes() []*policyGraphNode {
+	var validNodes []*policyGraphNode
+	for i := pg.depth; i >= 0; i-- {
+		for _, n := range pg.strata[i] {
+			if n.validPolicy.Equal(oidAnyPolicy) {
+				continue
+			}
+			if len(n.parents) == 1 {
+				for p := range n.parents {
+					if p.validPolicy.Equal(oidAnyPolicy) {
+						validNodes = append(validNodes, n)
+					}
+				}
+			}
+		}
+	}
+	return validNodes
+}
+
+// prune removes the nodes above the leaves that have no children.
+func (pg *policyGraph) prune() {
+	for i := pg.depth - 1; i > 0; i-- {
+		for key, n := range pg.strata[i] {
+			if len(n.children) == 0 {
+				for p := range n.parents {
+					delete(p.children, n)
+				}
+				delete(pg.strata[i], key)
+			}
+		}
+	}
+}
+
+func (pg *policyGraph) incrDepth() {
+	pg.parentIndex = make(map[string][]*policyGraphNode)
+	for _, n := range pg.strata[pg.depth] {
+		for _, e := range n.expectedPolicySet {
+			pg.parentIndex[e.String()] = append(pg.parentIndex[e.String()], n)
+		}
+	}
+
+	pg.depth++
+	pg.strata = append(pg.strata, make(map[string]*policyGraphNode))
+}
+
+// policiesValid implements the policy processing of RFC 5280, Section 6.1,
+// as updated by RFC 9618, which replaces Sections 6.1.2 (a), 6.1.3 (d), (e)
+// and (f), 6.1.4 (b), and 6.1.5 (g). It returns the user-constrained policy
+// set, sorted, and whether the chain is valid.
+func policiesValid(chain []*Certificate, opts *VerifyOptions) ([]asn1.ObjectIdentifier, bool) {
+	// n is the length of the chain minus the trust anchor.
+	n := len(chain) - 1
+
+	pg := newPolicyGraph()
+	inhibitAnyPolicy, explicitPolicy, policyMapping := n+1, n+1, n+1
+	if opts.RequireExplicitPolicy {
+		explicitPolicy = 0
+	}
+
+	initialUserPolicySet := make(map[string]asn1.ObjectIdentifier)
+	for _, p := range opts.CertificatePolicies {
+		initialUserPolicySet[p.String()] = p
+	}
+	// No acceptable policies is equivalent to anyPolicy.
+	if len(initialUserPolicySet) == 0 {
+		initialUserPolicySet[oidAnyPolicy.String()] = oidAnyPolicy
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		cert := chain[i]
+
+		isSelfIssued := bytes.Equal(cert.RawIssuer, cert.RawSubject)
+
+		// 6.1.3 (e)
+		if len(cert.PolicyIdentifiers) == 0 {
+			pg = nil
+		}
+
+		// 6.1.3 (f)
+		if explicitPolicy == 0 && pg == nil {
+			return nil, false
+		}
+
+		if pg != nil {
+			pg.incrDepth()
+
+			policies := make(map[string]bool)
+
+			// 6.1.3 (d) (1)
+			for _, policy := range cert.PolicyIdentifiers {
+				policies[policy.String()] = true
+
+				if policy.Equal(oidAnyPolicy) {
+					continue
+				}
+
+				// 6.1.3 (d) (1) (i)
+				parents := pg.parentsWithExpected(policy)
+				if len(parents) == 0 {
+					// 6.1.3 (d) (1) (ii)
+					if anyParent := pg.parentWithAnyPolicy(); anyParent != nil {
+						parents = []*policyGraphNode{anyParent}
+					}
+				}
+				if len(parents) > 0 {
+					pg.insert(newPolicyGraphNode(policy, parents))
+				}
+			}
+
+			// 6.1.3 (d) (2)
+			//
+			// The chain goes from the leaf to the trust anchor, while the
+			// specification goes the other way, so the check for a certificate
+			// other than the leaf is i > 0 here and i < n there.
+			if policies[oidAnyPolicy.String()] && (inhibitAnyPolicy > 0 || (i > 0 && isSelfIssued)) {
+				missing := make(map[string][]*policyGraphNode)
+				missingOIDs := make(map[string]asn1.ObjectIdentifier)
+				leaves := pg.leaves()
+				for _, p := range pg.parents() {
+					for _, expected := range p.expectedPolicySet {
+						key := expected.String()
+						if leaves[key] == nil {
+							missing[key] = append(missing[key], p)
+							missingOIDs[key] = expected
+						}
+					}
+				}
+				for key, parents := range missing {
+					pg.insert(newPolicyGraphNode(missingOIDs[key], parents))
+				}
+			}
+
+			// 6.1.3 (d) (3)
+			pg.prune()
+
+			// 6.1.4 (b)
+			if i != 0 && len(cert.PolicyMappings) > 0 {
+				mappings := make(map[string][]asn1.ObjectIdentifier)
+				mappingOIDs := make(map[string]asn1.ObjectIdentifier)
+				for _, mapping := range cert.PolicyMappings {
+					if policyMapping > 0 {
+						// 6.1.4 (a)
+						if mapping.IssuerDomainPolicy.Equal(oidAnyPolicy) || mapping.SubjectDomainPolicy.Equal(oidAnyPolicy) {
+							return nil, false
+						}
+						key := mapping.IssuerDomainPolicy.String()
+						mappings[key] = append(mappings[key], mapping.SubjectDomainPolicy)
+						mappingOIDs[key] = mapping.IssuerDomainPolicy
+					} else {
+						// 6.1.4 (b) (3) (i)
+						pg.deleteLeaf(mapping.IssuerDomainPolicy)
+					}
+				}
+
+				// 6.1.4 (b) (3) (ii)
+				pg.prune()
+
+				for key, subjectPolicies := range mappings {
+					issuerPolicy := mappingOIDs[key]
+					if matching := pg.leafWithPolicy(issuerPolicy); matching != nil {
+						// 6.1.4 (b) (1)
+						matching.expectedPolicySet = subjectPolicies
+					} else if matching := pg.leafWithPolicy(oidAnyPolicy); matching != nil {
+						// 6.1.4 (b) (2)
+						n := newPolicyGraphNode(issuerPolicy, []*policyGraphNode{matching})
+						n.expectedPolicySet = subjectPolicies
+						pg.insert(n)
+					}
+				}
+			}
+		}
+
+		if i != 0 {
+			// 6.1.4 (h)
+			if !isSelfIssued {
+				if explicitPolicy > 0 {
+					explicitPolicy--
+				}
+				if policyMapping > 0 {
+					policyMapping--
+				}
+				if inhibitAnyPolicy > 0 {
+					inhibitAnyPolicy--
+				}
+			}
+
+			// 6.1.4 (i)
+			if (cert.RequireExplicitPolicy > 0 || cert.RequireExplicitPolicyZero) && cert.RequireExplicitPolicy < explicitPolicy {
+				explicitPolicy = cert.RequireExplicitPolicy
+			}
+			if (cert.InhibitPolicyMapping > 0 || cert.InhibitPolicyMappingZero) && cert.InhibitPolicyMapping < policyMapping {
+				policyMapping = cert.InhibitPolicyMapping
+			}
+			// 6.1.4 (j)
+			if (cert.InhibitAnyPolicy > 0 || cert.InhibitAnyPolicyZero) && cert.InhibitAnyPolicy < inhibitAnyPolicy {
+				inhibitAnyPolicy = cert.InhibitAnyPolicy
+			}
+		}
+	}
+
+	// 6.1.5 (a)
+	if explicitPolicy > 0 {
+		explicitPolicy--
+	}
+
+	// 6.1.5 (b)
+	if chain[0].RequireExplicitPolicyZero {
+		explicitPolicy = 0
+	}
+
+	// 6.1.5 (g) (1) and (2)
+	var validPolicyNodeSet []*policyGraphNode
+	if pg != nil {
+		validPolicyNodeSet = pg.validPolicyNodes()
+		// 6.1.5 (g) (3)
+		if currentAny := pg.leafWithPolicy(oidAnyPolicy); currentAny != nil {
+			validPolicyNodeSet = append(validPolicyNodeSet, currentAny)
+		}
+	}
+
+	// 6.1.5 (g) (4)
+	authorityConstrainedPolicySet := make(map[string]asn1.ObjectIdentifier)
+	for _, n := range validPolicyNodeSet {
+		authorityConstrainedPolicySet[n.validPolicy.String()] = n.validPolicy
+	}
+
+	// 6.1.5 (g) (5)
+	userConstrainedPolicySet := make(map[string]asn1.ObjectIdentifier)
+	for key, p := range authorityConstrainedPolicySet {
+		userConstrainedPolicySet[key] = p
+	}
+
+	// 6.1.5 (g) (6)
+	if _, ok := initialUserPolicySet[oidAnyPolicy.String()]; !ok || len(initialUserPolicySet) != 1 {
+		// 6.1.5 (g) (6) (i)
+		for key := range userConstrainedPolicySet {
+			if _, ok := initialUserPolicySet[key]; !ok {
+				delete(userConstrainedPolicySet, key)
+			}
+		}
+		// 6.1.5 (g) (6) (ii)
+		if _, ok := authorityConstrainedPolicySet[oidAnyPolicy.String()]; ok {
+			for key, p := range initialUserPolicySet {
+				userConstrainedPolicySet[key] = p
+			}
+		}
+	}
+
+	if explicitPolicy == 0 && len(userConstrainedPolicySet) == 0 {
+		return nil, false
+	}
+
+	policies := make([]asn1.ObjectIdentifier, 0, len(userConstrainedPolicySet))
+	for _, p := range userConstrainedPolicySet {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return policies, true
+}
@@ -0,0 +1,330 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	testPolicyA = asn1.ObjectIdentifier{1, 2, 3, 1}
+	testPolicyB = asn1.ObjectIdentifier{1, 2, 3, 2}
+)
+
+// newPolicyTestChain returns a root, an intermediate carrying the policy
+// fields of intermediate, and a leaf asserting leafPolicies, along with the
+// time to verify them at.
+func newPolicyTestChain(t *testing.T, intermediate *Certificate, leafPolicies ...asn1.ObjectIdentifier) (root, inter, leaf *Certificate, now time.Time) {
+	t.Helper()
+	root, rootKey, err := generateCert("Policy Test Root", true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inter, interKey, err := generateCert("Policy Test Intermediate", true, root, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interTemplate := *inter
+	interTemplate.PolicyIdentifiers = intermediate.PolicyIdentifiers
+	interTemplate.PolicyMappings = intermediate.PolicyMappings
+	interTemplate.RequireExplicitPolicy = intermediate.RequireExplicitPolicy
+	interTemplate.RequireExplicitPolicyZero = intermediate.RequireExplicitPolicyZero
+	interTemplate.InhibitPolicyMapping = intermediate.InhibitPolicyMapping
+	interTemplate.InhibitPolicyMappingZero = intermediate.InhibitPolicyMappingZero
+	interTemplate.InhibitAnyPolicy = intermediate.InhibitAnyPolicy
+	interTemplate.InhibitAnyPolicyZero = intermediate.InhibitAnyPolicyZero
+	inter, err = reissueCert(&interTemplate, root, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, _, err = generateCert("leaf", false, inter, interKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := *leaf
+	leafTemplate.PolicyIdentifiers = leafPolicies
+	leaf, err = reissueCert(&leafTemplate, inter, interKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, inter, leaf, time.Now()
+}
+
+func TestPolicyExtensions(t *testing.T) {
+	template := &Certificate{
+		PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA},
+		PolicyMappings: []PolicyMapping{
+			{IssuerDomainPolicy: testPolicyA, SubjectDomainPolicy: testPolicyB},
+		},
+		RequireExplicitPolicy:     0,
+		RequireExplicitPolicyZero: true,
+		InhibitPolicyMapping:      2,
+		InhibitAnyPolicy:          1,
+	}
+	_, inter, _, _ := newPolicyTestChain(t, template)
+	if !reflect.DeepEqual(inter.PolicyMappings, template.PolicyMappings) {
+		t.Errorf("PolicyMappings = %v, want %v", inter.PolicyMappings, template.PolicyMappings)
+	}
+	if inter.RequireExplicitPolicy != 0 || !inter.RequireExplicitPolicyZero {
+		t.Errorf("RequireExplicitPolicy = %d, %v, want 0, true", inter.RequireExplicitPolicy, inter.RequireExplicitPolicyZero)
+	}
+	if inter.InhibitPolicyMapping != 2 || inter.InhibitPolicyMappingZero {
+		t.Errorf("InhibitPolicyMapping = %d, %v, want 2, false", inter.InhibitPolicyMapping, inter.InhibitPolicyMappingZero)
+	}
+	if inter.InhibitAnyPolicy != 1 || inter.InhibitAnyPolicyZero {
+		t.Errorf("InhibitAnyPolicy = %d, %v, want 1, false", inter.InhibitAnyPolicy, inter.InhibitAnyPolicyZero)
+	}
+	if len(inter.UnhandledCriticalExtensions) != 0 {
+		t.Errorf("unexpected unhandled critical extensions: %v", inter.UnhandledCriticalExtensions)
+	}
+
+	_, plain, _, _ := newPolicyTestChain(t, &Certificate{})
+	if plain.RequireExplicitPolicy != 0 || plain.RequireExplicitPolicyZero ||
+		plain.InhibitAnyPolicy != 0 || plain.InhibitAnyPolicyZero {
+		t.Errorf("certificate without policy constraints has them set")
+	}
+}
+
+func TestVerifyCertificatePolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		intermediate Certificate
+		leafPolicies []asn1.ObjectIdentifier
+		acceptable   []asn1.ObjectIdentifier
+		explicit     bool
+		want         []asn1.ObjectIdentifier
+		wantErr      bool
+	}{
+		{
+			name: "NoPolicies",
+		},
+		{
+			name:     "NoPoliciesExplicit",
+			explicit: true,
+			wantErr:  true,
+		},
+		{
+			name:         "Asserted",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			want:         []asn1.ObjectIdentifier{testPolicyA},
+		},
+		{
+			name:         "AssertedExplicit",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA, testPolicyB}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			acceptable:   []asn1.ObjectIdentifier{testPolicyA},
+			explicit:     true,
+			want:         []asn1.ObjectIdentifier{testPolicyA},
+		},
+		{
+			name:         "NotAcceptableExplicit",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			acceptable:   []asn1.ObjectIdentifier{testPolicyB},
+			explicit:     true,
+			wantErr:      true,
+		},
+		{
+			// Without an explicit policy requirement, the chain is valid but
+			// for no acceptable policy.
+			name:         "NotAcceptable",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			acceptable:   []asn1.ObjectIdentifier{testPolicyB},
+			want:         []asn1.ObjectIdentifier{},
+		},
+		{
+			name:         "NotAssertedByIntermediate",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyB}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			explicit:     true,
+			wantErr:      true,
+		},
+		{
+			name:         "AnyPolicyIntermediate",
+			intermediate: Certificate{PolicyIdentifiers: []asn1.ObjectIdentifier{oidAnyPolicy}},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyB},
+			acceptable:   []asn1.ObjectIdentifier{testPolicyB},
+			explicit:     true,
+			want:         []asn1.ObjectIdentifier{testPolicyB},
+		},
+		{
+			name: "InhibitAnyPolicy",
+			intermediate: Certificate{
+				PolicyIdentifiers:    []asn1.ObjectIdentifier{testPolicyA},
+				InhibitAnyPolicyZero: true,
+			},
+			leafPolicies: []asn1.ObjectIdentifier{oidAnyPolicy},
+			explicit:     true,
+			wantErr:      true,
+		},
+		{
+			name: "RequiredByIntermediate",
+			intermediate: Certificate{
+				PolicyIdentifiers:         []asn1.ObjectIdentifier{testPolicyA},
+				RequireExplicitPolicyZero: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Mapped",
+			intermediate: Certificate{
+				PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA},
+				PolicyMappings: []PolicyMapping{
+					{IssuerDomainPolicy: testPolicyA, SubjectDomainPolicy: testPolicyB},
+				},
+			},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyB},
+			acceptable:   []asn1.ObjectIdentifier{testPolicyA},
+			explicit:     true,
+			want:         []asn1.ObjectIdentifier{testPolicyA},
+		},
+		{
+			name: "MappedToAnyPolicy",
+			intermediate: Certificate{
+				PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA},
+				PolicyMappings: []PolicyMapping{
+					{IssuerDomainPolicy: testPolicyA, SubjectDomainPolicy: oidAnyPolicy},
+				},
+			},
+			leafPolicies: []asn1.ObjectIdentifier{testPolicyA},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, inter, leaf, now := newPolicyTestChain(t, &tt.intermediate, tt.leafPolicies...)
+			opts := VerifyOptions{
+				Roots:                 NewCertPool(),
+				Intermediates:         NewCertPool(),
+				CurrentTime:           now,
+				CertificatePolicies:   tt.acceptable,
+				RequireExplicitPolicy: tt.explicit,
+			}
+			opts.Roots.AddCert(root)
+			opts.Intermediates.AddCert(inter)
+
+			chains, err := leaf.Verify(opts)
+			if tt.wantErr {
+				var invalidErr CertificateInvalidError
+				if !errors.As(err, &invalidErr) || invalidErr.Reason != NoValidPolicies {
+					t.Fatalf("got error %v, want NoValidPolicies", err)
+				}
+				if _, _, err := leaf.VerifyWithPolicies(opts); err == nil {
+					t.Error("VerifyWithPolicies succeeded for an invalid chain")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chains) != 1 {
+				t.Fatalf("got %d chains, want 1", len(chains))
+			}
+			chains, policies, err := leaf.VerifyWithPolicies(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chains) != 1 || len(policies) != 1 {
+				t.Fatalf("got %d chains and %d policy sets, want 1", len(chains), len(policies))
+			}
+			if len(policies[0]) != len(tt.want) {
+				t.Fatalf("VerifyWithPolicies policies = %v, want %v", policies[0], tt.want)
+			}
+			for i := range policies[0] {
+				if !policies[0][i].Equal(tt.want[i]) {
+					t.Fatalf("VerifyWithPolicies policies = %v, want %v", policies[0], tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNeedPolicyProcessing(t *testing.T) {
+	root, inter, leaf, _ := newPolicyTestChain(t, &Certificate{
+		PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA},
+		InhibitAnyPolicy:  1,
+	})
+	chains := [][]*Certificate{{leaf, inter, root}}
+	if needPolicyProcessing(chains, &VerifyOptions{CertificatePolicies: []asn1.ObjectIdentifier{testPolicyB}}) {
+		t.Error("policy processing needed without explicit policy or mappings")
+	}
+	if !needPolicyProcessing(chains, &VerifyOptions{RequireExplicitPolicy: true}) {
+		t.Error("policy processing not needed with RequireExplicitPolicy")
+	}
+
+	root, inter, leaf, _ = newPolicyTestChain(t, &Certificate{
+		PolicyIdentifiers: []asn1.ObjectIdentifier{testPolicyA},
+		PolicyMappings: []PolicyMapping{
+			{IssuerDomainPolicy: testPolicyA, SubjectDomainPolicy: testPolicyB},
+		},
+	})
+	if !needPolicyProcessing([][]*Certificate{{leaf, inter, root}}, &VerifyOptions{}) {
+		t.Error("policy processing not needed with policy mappings")
+	}
+
+	// The policy constraints of the trust anchor aren't processed.
+	root.RequireExplicitPolicyZero = true
+	if needPolicyProcessing([][]*Certificate{{root}}, &VerifyOptions{}) {
+		t.Error("policy processing needed for the constraints of the trust anchor")
+	}
+}
+
+func TestMalformedPolicyExtensions(t *testing.T) {
+	root, rootKey, err := generateCert("Policy Test Root", true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := []struct {
+		name string
+		id   asn1.ObjectIdentifier
+		der  []byte
+	}{
+		{"EmptyPolicyMappings", oidExtensionPolicyMappings, []byte{0x30, 0x00}},
+		{"TruncatedPolicyMappings", oidExtensionPolicyMappings, []byte{0x30, 0x05, 0x30}},
+		{"EmptyPolicyConstraints", oidExtensionPolicyConstraints, []byte{0x30, 0x00}},
+		{"NegativePolicyConstraints", oidExtensionPolicyConstraints, []byte{0x30, 0x03, 0x80, 0x01, 0xff}},
+		{"TrailingPolicyConstraints", oidExtensionPolicyConstraints, []byte{0x30, 0x03, 0x80, 0x01, 0x01, 0x00}},
+		{"NegativeInhibitAnyPolicy", oidExtensionInhibitAnyPolicy, []byte{0x02, 0x01, 0xff}},
+		{"NotAnInteger", oidExtensionInhibitAnyPolicy, []byte{0x04, 0x01, 0x01}},
+	}
+	for _, tt := range malformed {
+		for _, critical := range []bool{false, true} {
+			inter, _, err := generateCert("Policy Test Intermediate", true, root, rootKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := *inter
+			template.ExtraExtensions = []pkix.Extension{{Id: tt.id, Critical: critical, Value: tt.der}}
+			// These certificates parsed before the policy extensions were
+			// processed, so they must keep parsing.
+			cert, err := reissueCert(&template, root, rootKey)
+			if err != nil {
+				t.Errorf("%s (critical %v): %v", tt.name, critical, err)
+				continue
+			}
+			if cert.PolicyMappings != nil || cert.RequireExplicitPolicy != 0 || cert.RequireExplicitPolicyZero ||
+				cert.InhibitPolicyMapping != 0 || cert.InhibitPolicyMappingZero ||
+				cert.InhibitAnyPolicy != 0 || cert.InhibitAnyPolicyZero {
+				t.Errorf("%s (critical %v): policy fields set from a malformed extension", tt.name, critical)
+			}
+			unhandled := len(cert.UnhandledCriticalExtensions) == 1 && cert.UnhandledCriticalExtensions[0].Equal(tt.id)
+			if critical && !unhandled {
+				t.Errorf("%s: UnhandledCriticalExtensions = %v, want [%v]", tt.name, cert.UnhandledCriticalExtensions, tt.id)
+			}
+			if !critical && len(cert.UnhandledCriticalExtensions) != 0 {
+				t.Errorf("%s: UnhandledCriticalExtensions = %v, want none", tt.name, cert.UnhandledCriticalExtensions)
+			}
+		}
+	}
+}
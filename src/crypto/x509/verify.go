@@ -6,6 +6,7 @@ package x509
 
 import (
 	"bytes"
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"net"
@@ -65,6 +66,15 @@ const (
 	// CANotAuthorizedForExtKeyUsage results when an intermediate or root
 	// certificate does not permit a requested extended key usage.
 	CANotAuthorizedForExtKeyUsage
+	// InsufficientSCTs results when the leaf certificate doesn't have
+	// enough valid Signed Certificate Timestamps to satisfy
+	// VerifyOptions.CTPolicy.
+	InsufficientSCTs
+	// NoValidPolicies results when a chain fails certificate policy
+	// processing, because an explicit policy is required but no acceptable
+	// policy is valid for the whole chain, or because of an invalid policy
+	// mapping.
+	NoValidPolicies
 )
 
 // CertificateInvalidError results when an odd error occurs. Users of this
@@ -95,6 +105,10 @@ func (e CertificateInvalidError) Error() string {
 		return "x509: issuer has name constraints but leaf doesn't have a SAN extension"
 	case UnconstrainedName:
 		return "x509: issuer has name constraints but leaf contains unknown or unconstrained name: " + e.Detail
+	case InsufficientSCTs:
+		return "x509: certificate does not have enough valid Signed Certificate Timestamps: " + e.Detail
+	case NoValidPolicies:
+		return "x509: no valid certificate policy for the chain"
 	}
 	return "x509: unknown error"
 }
@@ -220,6 +234,37 @@ type VerifyOptions struct {
 	// certificates from consuming excessive amounts of CPU time when
 	// validating. It does not apply to the platform verifier.
 	MaxConstraintComparisions int
+
+	// CTPolicy, if not nil, requires the leaf certificate to have enough
+	// valid Signed Certificate Timestamps from the logs in the policy. SCTs
+	// embedded in the leaf are checked against the issuer in each chain, and
+	// chains for which the policy isn't met are discarded. It does not apply
+	// to the platform verifier.
+	CTPolicy *CTPolicy
+
+	// SignedCertificateTimestamps are serialized SCTs for the leaf
+	// certificate delivered outside of it, for example in a TLS extension or
+	// in a stapled OCSP response. They are only used if CTPolicy is set.
+	// SCTs that can't be parsed are ignored.
+	SignedCertificateTimestamps [][]byte
+
+	// CertificatePolicies is the set of acceptable certificate policies, the
+	// user-initial-policy-set of RFC 5280, Section 6.1.1. If empty, any
+	// policy is acceptable.
+	//
+	// As specified by RFC 5280, chains are only rejected for not being valid
+	// for an acceptable policy when an explicit policy is required, either
+	// by RequireExplicitPolicy or by the policy constraints of a certificate
+	// in the chain. Use Certificate.VerifyWithPolicies to retrieve the
+	// acceptable policies each returned chain is valid for. Certificate
+	// policies are not checked by the platform verifier.
+	CertificatePolicies []asn1.ObjectIdentifier
+
+	// RequireExplicitPolicy, if true, requires every chain to be valid for
+	// at least one of the CertificatePolicies, or for any policy if
+	// CertificatePolicies is empty. It's the initial-explicit-policy input of
+	// RFC 5280, Section 6.1.1.
+	RequireExplicitPolicy bool
 }
 
 const (
@@ -754,32 +799,50 @@ func (c *Certificate) isValid(certType int, currentChain []*Certificate, opts *V
 //
 // WARNING: this function doesn't do any revocation checking.
 func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err error) {
+	chains, _, err = c.verify(&opts, false)
+	return chains, err
+}
+
+// VerifyWithPolicies is like Verify, but also returns, for each chain, the
+// user-constrained policy set of RFC 5280, Section 6.1.5: the policies, among
+// opts.CertificatePolicies, that are asserted by every certificate in the
+// chain, taking policy mappings into account. A set contains the anyPolicy
+// identifier (2.5.29.32.0) if the chain is valid for any policy.
+//
+// If the platform verifier is used, certificate policies are not processed
+// and policies is nil.
+func (c *Certificate) VerifyWithPolicies(opts VerifyOptions) (chains [][]*Certificate, policies [][]asn1.ObjectIdentifier, err error) {
+	return c.verify(&opts, true)
+}
+
+func (c *Certificate) verify(opts *VerifyOptions, wantPolicies bool) (chains [][]*Certificate, policies [][]asn1.ObjectIdentifier, err error) {
 	// Platform-specific verification needs the ASN.1 contents so
 	// this makes the behavior consistent across platforms.
 	if len(c.Raw) == 0 {
-		return nil, errNotParsed
+		return nil, nil, errNotParsed
 	}
 	if opts.Intermediates != nil {
 		for _, intermediate := range opts.Intermediates.certs {
 			if len(intermediate.Raw) == 0 {
-				return nil, errNotParsed
+				return nil, nil, errNotParsed
 			}
 		}
 	}
 
 	// Use Windows's own verification and chain building.
 	if opts.Roots == nil && runtime.GOOS == "windows" {
-		return c.systemVerify(&opts)
+		chains, err = c.systemVerify(opts)
+		return chains, nil, err
 	}
 
 	if opts.Roots == nil {
 		opts.Roots = systemRootsPool()
 		if opts.Roots == nil {
-			return nil, SystemRootsError{systemRootsErr}
+			return nil, nil, SystemRootsError{systemRootsErr}
 		}
 	}
 
-	err = c.isValid(leafCertificate, nil, &opts)
+	err = c.isValid(leafCertificate, nil, opts)
 	if err != nil {
 		return
 	}
@@ -795,8 +858,8 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 	if opts.Roots.contains(c) {
 		candidateChains = append(candidateChains, []*Certificate{c})
 	} else {
-		if candidateChains, err = c.buildChains(nil, []*Certificate{c}, nil, &opts); err != nil {
-			return nil, err
+		if candidateChains, err = c.buildChains(nil, []*Certificate{c}, nil, opts); err != nil {
+			return nil, nil, err
 		}
 	}
 
@@ -805,24 +868,78 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 		keyUsages = []ExtKeyUsage{ExtKeyUsageServerAuth}
 	}
 
-	// If any key usage is acceptable then we're done.
+	// If any key usage is acceptable then all candidates qualify.
 	for _, usage := range keyUsages {
 		if usage == ExtKeyUsageAny {
-			return candidateChains, nil
+			chains = candidateChains
+			break
 		}
 	}
 
-	for _, candidate := range candidateChains {
-		if checkChainForKeyUsage(candidate, keyUsages) {
-			chains = append(chains, candidate)
+	if chains == nil {
+		for _, candidate := range candidateChains {
+			if checkChainForKeyUsage(candidate, keyUsages) {
+				chains = append(chains, candidate)
+			}
 		}
 	}
 
 	if len(chains) == 0 {
-		return nil, CertificateInvalidError{c, IncompatibleUsage, ""}
+		return nil, nil, CertificateInvalidError{c, IncompatibleUsage, ""}
+	}
+
+	if opts.CTPolicy != nil {
+		chains, err = checkCTPolicy(chains, opts)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return chains, nil
+	return checkChainPolicies(chains, opts, wantPolicies)
+}
+
+// checkChainPolicies returns the chains that pass certificate policy
+// processing, or an error if there are none. If wantPolicies is true, it
+// also returns the user-constrained policy set of each returned chain.
+func checkChainPolicies(chains [][]*Certificate, opts *VerifyOptions, wantPolicies bool) ([][]*Certificate, [][]asn1.ObjectIdentifier, error) {
+	if !wantPolicies && !needPolicyProcessing(chains, opts) {
+		return chains, nil, nil
+	}
+	var valid [][]*Certificate
+	var policies [][]asn1.ObjectIdentifier
+	for _, chain := range chains {
+		if p, ok := policiesValid(chain, opts); ok {
+			valid = append(valid, chain)
+			policies = append(policies, p)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, nil, CertificateInvalidError{chains[0][0], NoValidPolicies, ""}
+	}
+	if !wantPolicies {
+		policies = nil
+	}
+	return valid, policies, nil
+}
+
+// needPolicyProcessing reports whether policy processing can reject any of
+// chains. Without an explicit policy requirement, either from opts or from
+// the policy constraints of a certificate, and without policy mappings, the
+// explicit_policy counter of RFC 5280, Section 6.1 never reaches zero and
+// every chain is valid.
+func needPolicyProcessing(chains [][]*Certificate, opts *VerifyOptions) bool {
+	if opts.RequireExplicitPolicy {
+		return true
+	}
+	for _, chain := range chains {
+		// The trust anchor isn't processed.
+		for _, cert := range chain[:len(chain)-1] {
+			if len(cert.PolicyMappings) > 0 || cert.RequireExplicitPolicy > 0 || cert.RequireExplicitPolicyZero {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func appendToFreshChain(chain []*Certificate, cert *Certificate) []*Certificate {
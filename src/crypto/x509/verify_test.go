@@ -1873,6 +1873,16 @@ func generateCert(cn string, isCA bool, issuer *Certificate, issuerKey crypto.Pr
 	return cert, priv, nil
 }
 
+// reissueCert signs template, usually a certificate from generateCert with
+// some fields changed, under issuer while keeping the template's public key.
+func reissueCert(template, issuer *Certificate, issuerKey crypto.PrivateKey) (*Certificate, error) {
+	derBytes, err := CreateCertificate(rand.Reader, template, issuer, template.PublicKey, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificate(derBytes)
+}
+
 func TestPathologicalChain(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping generation of a long chain of certificates in short mode")
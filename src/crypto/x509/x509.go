@@ -756,6 +756,48 @@ type Certificate struct {
 	CRLDistributionPoints []string
 
 	PolicyIdentifiers []asn1.ObjectIdentifier
+
+	// PolicyMappings contains the policy mappings of a CA certificate, from
+	// the RFC 5280, Section 4.2.1.5 extension.
+	PolicyMappings []PolicyMapping
+
+	// InhibitAnyPolicy is the number of additional non-self-issued
+	// certificates that may follow this one in a path before the anyPolicy
+	// identifier stops matching other policies, from the RFC 5280, Section
+	// 4.2.1.14 extension.
+	InhibitAnyPolicy int
+	// InhibitAnyPolicyZero indicates that InhibitAnyPolicy==0 should be
+	// interpreted as an actual value of zero. Otherwise, that combination is
+	// interpreted as InhibitAnyPolicy not being set.
+	InhibitAnyPolicyZero bool
+
+	// InhibitPolicyMapping is the number of additional non-self-issued
+	// certificates that may follow this one in a path before policy mapping
+	// is no longer permitted, from the RFC 5280, Section 4.2.1.11 policy
+	// constraints extension.
+	InhibitPolicyMapping int
+	// InhibitPolicyMappingZero indicates that InhibitPolicyMapping==0 should
+	// be interpreted as an actual value of zero. Otherwise, that combination
+	// is interpreted as InhibitPolicyMapping not being set.
+	InhibitPolicyMappingZero bool
+
+	// RequireExplicitPolicy is the number of additional non-self-issued
+	// certificates that may follow this one in a path before every
+	// certificate must carry an acceptable policy, from the RFC 5280, Section
+	// 4.2.1.11 policy constraints extension.
+	RequireExplicitPolicy int
+	// RequireExplicitPolicyZero indicates that RequireExplicitPolicy==0
+	// should be interpreted as an actual value of zero. Otherwise, that
+	// combination is interpreted as RequireExplicitPolicy not being set.
+	RequireExplicitPolicyZero bool
+}
+
+// PolicyMapping represents a policy mapping entry in the policyMappings
+// extension. It declares that IssuerDomainPolicy, a policy of the issuing CA,
+// is considered equivalent to SubjectDomainPolicy, a policy of the subject CA.
+type PolicyMapping struct {
+	IssuerDomainPolicy  asn1.ObjectIdentifier
+	SubjectDomainPolicy asn1.ObjectIdentifier
 }
 
 // ErrUnsupportedAlgorithm results from attempting to perform an operation that
@@ -942,6 +984,12 @@ type policyInformation struct {
 	// policyQualifiers omitted
 }
 
+// RFC 5280, 4.2.1.11
+type policyConstraints struct {
+	RequireExplicitPolicy int `asn1:"optional,tag:0,default:-1"`
+	InhibitPolicyMapping  int `asn1:"optional,tag:1,default:-1"`
+}
+
 const (
 	nameTypeEmail = 1
 	nameTypeDNS   = 2
@@ -1331,6 +1379,58 @@ func parseNameConstraintsExtension(out *Certificate, e pkix.Extension) (unhandle
 	return unhandled, nil
 }
 
+// The policy extensions used to be ignored, so a malformed one is treated
+// like an unknown extension rather than failing the parse: it's recorded in
+// UnhandledCriticalExtensions if critical, which makes verification fail, and
+// skipped otherwise.
+
+// parsePolicyMappingsExtension reports whether der is a valid policy mappings
+// extension and, if so, sets out.PolicyMappings.
+func parsePolicyMappingsExtension(out *Certificate, der []byte) bool {
+	var mappings []PolicyMapping
+	if rest, err := asn1.Unmarshal(der, &mappings); err != nil || len(rest) != 0 || len(mappings) == 0 {
+		return false
+	}
+	out.PolicyMappings = mappings
+	return true
+}
+
+// parsePolicyConstraintsExtension reports whether der is a valid policy
+// constraints extension and, if so, sets the fields of out it specifies.
+func parsePolicyConstraintsExtension(out *Certificate, der []byte) bool {
+	var constraints policyConstraints
+	if rest, err := asn1.Unmarshal(der, &constraints); err != nil || len(rest) != 0 {
+		return false
+	}
+	if constraints.RequireExplicitPolicy == -1 && constraints.InhibitPolicyMapping == -1 {
+		return false
+	}
+	if constraints.RequireExplicitPolicy < -1 || constraints.InhibitPolicyMapping < -1 {
+		return false
+	}
+	if constraints.RequireExplicitPolicy >= 0 {
+		out.RequireExplicitPolicy = constraints.RequireExplicitPolicy
+		out.RequireExplicitPolicyZero = out.RequireExplicitPolicy == 0
+	}
+	if constraints.InhibitPolicyMapping >= 0 {
+		out.InhibitPolicyMapping = constraints.InhibitPolicyMapping
+		out.InhibitPolicyMappingZero = out.InhibitPolicyMapping == 0
+	}
+	return true
+}
+
+// parseInhibitAnyPolicyExtension reports whether der is a valid inhibit
+// anyPolicy extension and, if so, sets out.InhibitAnyPolicy.
+func parseInhibitAnyPolicyExtension(out *Certificate, der []byte) bool {
+	var skipCerts int
+	if rest, err := asn1.Unmarshal(der, &skipCerts); err != nil || len(rest) != 0 || skipCerts < 0 {
+		return false
+	}
+	out.InhibitAnyPolicy = skipCerts
+	out.InhibitAnyPolicyZero = out.InhibitAnyPolicy == 0
+	return true
+}
+
 func parseCertificate(in *certificate) (*Certificate, error) {
 	out := new(Certificate)
 	out.Raw = in.Raw
@@ -1517,6 +1617,18 @@ func parseCertificate(in *certificate) (*Certificate, error) {
 					out.PolicyIdentifiers[i] = policy.Policy
 				}
 
+			case 33:
+				// RFC 5280 4.2.1.5: Policy Mappings
+				unhandled = !parsePolicyMappingsExtension(out, e.Value)
+
+			case 36:
+				// RFC 5280 4.2.1.11: Policy Constraints
+				unhandled = !parsePolicyConstraintsExtension(out, e.Value)
+
+			case 54:
+				// RFC 5280 4.2.1.14: Inhibit anyPolicy
+				unhandled = !parseInhibitAnyPolicyExtension(out, e.Value)
+
 			default:
 				// Unknown extensions are recorded if critical.
 				unhandled = true
@@ -1630,6 +1742,9 @@ var (
 	oidExtensionBasicConstraints      = []int{2, 5, 29, 19}
 	oidExtensionSubjectAltName        = []int{2, 5, 29, 17}
 	oidExtensionCertificatePolicies   = []int{2, 5, 29, 32}
+	oidExtensionPolicyMappings        = []int{2, 5, 29, 33}
+	oidExtensionPolicyConstraints     = []int{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy      = []int{2, 5, 29, 54}
 	oidExtensionNameConstraints       = []int{2, 5, 29, 30}
 	oidExtensionCRLDistributionPoints = []int{2, 5, 29, 31}
 	oidExtensionAuthorityInfoAccess   = []int{1, 3, 6, 1, 5, 5, 7, 1, 1}
@@ -1687,7 +1802,7 @@ func isIA5String(s string) error {
 }
 
 func buildExtensions(template *Certificate, subjectIsEmpty bool, authorityKeyId []byte, subjectKeyId []byte) (ret []pkix.Extension, err error) {
-	ret = make([]pkix.Extension, 10 /* maximum number of elements. */)
+	ret = make([]pkix.Extension, 13 /* maximum number of elements. */)
 	n := 0
 
 	if template.KeyUsage != 0 &&
@@ -1820,6 +1935,47 @@ func buildExtensions(template *Certificate, subjectIsEmpty bool, authorityKeyId
 		n++
 	}
 
+	if len(template.PolicyMappings) > 0 &&
+		!oidInExtensions(oidExtensionPolicyMappings, template.ExtraExtensions) {
+		ret[n].Id = oidExtensionPolicyMappings
+		ret[n].Critical = true
+		ret[n].Value, err = asn1.Marshal(template.PolicyMappings)
+		if err != nil {
+			return
+		}
+		n++
+	}
+
+	requireExplicitPolicy := template.RequireExplicitPolicy
+	if requireExplicitPolicy == 0 && !template.RequireExplicitPolicyZero {
+		requireExplicitPolicy = -1
+	}
+	inhibitPolicyMapping := template.InhibitPolicyMapping
+	if inhibitPolicyMapping == 0 && !template.InhibitPolicyMappingZero {
+		inhibitPolicyMapping = -1
+	}
+	if (requireExplicitPolicy >= 0 || inhibitPolicyMapping >= 0) &&
+		!oidInExtensions(oidExtensionPolicyConstraints, template.ExtraExtensions) {
+		ret[n].Id = oidExtensionPolicyConstraints
+		ret[n].Critical = true
+		ret[n].Value, err = asn1.Marshal(policyConstraints{requireExplicitPolicy, inhibitPolicyMapping})
+		if err != nil {
+			return
+		}
+		n++
+	}
+
+	if (template.InhibitAnyPolicy > 0 || template.InhibitAnyPolicyZero) &&
+		!oidInExtensions(oidExtensionInhibitAnyPolicy, template.ExtraExtensions) {
+		ret[n].Id = oidExtensionInhibitAnyPolicy
+		ret[n].Critical = true
+		ret[n].Value, err = asn1.Marshal(template.InhibitAnyPolicy)
+		if err != nil {
+			return
+		}
+		n++
+	}
+
 	if (len(template.PermittedDNSDomains) > 0 || len(template.ExcludedDNSDomains) > 0 ||
 		len(template.PermittedIPRanges) > 0 || len(template.ExcludedIPRanges) > 0 ||
 		len(template.PermittedEmailAddresses) > 0 || len(template.ExcludedEmailAddresses) > 0 ||
@@ -2062,6 +2218,10 @@ var emptyASN1Subject = []byte{0x30, 0}
 //  - PermittedIPRanges
 //  - PermittedURIDomains
 //  - PolicyIdentifiers
+//  - PolicyMappings
+//  - InhibitAnyPolicy, InhibitAnyPolicyZero
+//  - InhibitPolicyMapping, InhibitPolicyMappingZero
+//  - RequireExplicitPolicy, RequireExplicitPolicyZero
 //  - SerialNumber
 //  - SignatureAlgorithm
 //  - Subject
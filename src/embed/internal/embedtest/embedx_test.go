@@ -0,0 +1,29 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package embedtest_test
+
+import (
+	"embed"
+	"testing"
+)
+
+//go:embed testdata/h*.txt
+var global embed.FS
+
+//go:embed testdata/ken.txt
+var ken string
+
+func TestXGlobal(t *testing.T) {
+	data, err := global.ReadFile("testdata/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello, world\n" {
+		t.Errorf("hello.txt = %q, want %q", data, "hello, world\n")
+	}
+	if want := "If a program is too slow, it must have a loop.\n"; ken != want {
+		t.Errorf("ken = %q, want %q", ken, want)
+	}
+}
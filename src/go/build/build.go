@@ -435,13 +435,25 @@ type Package struct {
 	Imports   []string                    // import paths from GoFiles, CgoFiles
 	ImportPos map[string][]token.Position // line information for Imports
 
+	// //go:embed patterns found in Go source files
+	// For example, if a source file says
+	//	//go:embed a* b.c
+	// then the list will contain those two strings as separate entries.
+	// (See package embed for more details about //go:embed.)
+	EmbedPatterns   []string                    // patterns from GoFiles, CgoFiles
+	EmbedPatternPos map[string][]token.Position // line information for EmbedPatterns
+
 	// Test information
-	TestGoFiles    []string                    // _test.go files in package
-	TestImports    []string                    // import paths from TestGoFiles
-	TestImportPos  map[string][]token.Position // line information for TestImports
-	XTestGoFiles   []string                    // _test.go files outside package
-	XTestImports   []string                    // import paths from XTestGoFiles
-	XTestImportPos map[string][]token.Position // line information for XTestImports
+	TestGoFiles          []string                    // _test.go files in package
+	TestImports          []string                    // import paths from TestGoFiles
+	TestImportPos        map[string][]token.Position // line information for TestImports
+	TestEmbedPatterns    []string                    // patterns from TestGoFiles
+	TestEmbedPatternPos  map[string][]token.Position // line information for TestEmbedPatterns
+	XTestGoFiles         []string                    // _test.go files outside package
+	XTestImports         []string                    // import paths from XTestGoFiles
+	XTestImportPos       map[string][]token.Position // line information for XTestImports
+	XTestEmbedPatterns   []string                    // patterns from XTestGoFiles
+	XTestEmbedPatternPos map[string][]token.Position // line information for XTestEmbedPatterns
 }
 
 // IsCommand reports whether the package is considered a
@@ -787,6 +799,9 @@ Found:
 	imported := make(map[string][]token.Position)
 	testImported := make(map[string][]token.Position)
 	xTestImported := make(map[string][]token.Position)
+	embedPos := make(map[string][]token.Position)
+	testEmbedPos := make(map[string][]token.Position)
+	xTestEmbedPos := make(map[string][]token.Position)
 	allTags := make(map[string]bool)
 	fset := token.NewFileSet()
 	for _, d := range dirs {
@@ -916,6 +931,7 @@ Found:
 		}
 		var fileImports []importPos
 		isCgo := false
+		isEmbed := false
 		for _, decl := range pf.Decls {
 			d, ok := decl.(*ast.GenDecl)
 			if !ok {
@@ -932,6 +948,9 @@ Found:
 					panic(fmt.Sprintf("%s: parser returned invalid quoted string: <%s>", filename, quoted))
 				}
 				fileImports = append(fileImports, importPos{path, spec.Pos()})
+				if path == "embed" {
+					isEmbed = true
+				}
 				if path == "C" {
 					if isTest {
 						badFile(fmt.Errorf("use of cgo in test %s not supported", filename))
@@ -951,27 +970,42 @@ Found:
 			}
 		}
 
+		// Record //go:embed patterns, which can only appear
+		// in files that import "embed".
+		var fileEmbeds []fileEmbed
+		if isEmbed {
+			fileEmbeds, err = ctxt.readEmbeds(filename)
+			if err != nil {
+				badFile(err)
+				continue
+			}
+		}
+
 		var fileList *[]string
-		var importMap map[string][]token.Position
+		var importMap, embedMap map[string][]token.Position
 		switch {
 		case isCgo:
 			allTags["cgo"] = true
 			if ctxt.CgoEnabled {
 				fileList = &p.CgoFiles
 				importMap = imported
+				embedMap = embedPos
 			} else {
-				// Ignore imports from cgo files if cgo is disabled.
+				// Ignore imports and embeds from cgo files if cgo is disabled.
 				fileList = &p.IgnoredGoFiles
 			}
 		case isXTest:
 			fileList = &p.XTestGoFiles
 			importMap = xTestImported
+			embedMap = xTestEmbedPos
 		case isTest:
 			fileList = &p.TestGoFiles
 			importMap = testImported
+			embedMap = testEmbedPos
 		default:
 			fileList = &p.GoFiles
 			importMap = imported
+			embedMap = embedPos
 		}
 		*fileList = append(*fileList, name)
 		if importMap != nil {
@@ -979,6 +1013,11 @@ Found:
 				importMap[imp.path] = append(importMap[imp.path], fset.Position(imp.pos))
 			}
 		}
+		if embedMap != nil {
+			for _, emb := range fileEmbeds {
+				embedMap[emb.pattern] = append(embedMap[emb.pattern], emb.pos)
+			}
+		}
 	}
 
 	for tag := range allTags {
@@ -986,9 +1025,12 @@ Found:
 	}
 	sort.Strings(p.AllTags)
 
-	p.Imports, p.ImportPos = cleanImports(imported)
-	p.TestImports, p.TestImportPos = cleanImports(testImported)
-	p.XTestImports, p.XTestImportPos = cleanImports(xTestImported)
+	p.Imports, p.ImportPos = cleanDecls(imported)
+	p.TestImports, p.TestImportPos = cleanDecls(testImported)
+	p.XTestImports, p.XTestImportPos = cleanDecls(xTestImported)
+	p.EmbedPatterns, p.EmbedPatternPos = cleanDecls(embedPos)
+	p.TestEmbedPatterns, p.TestEmbedPatternPos = cleanDecls(testEmbedPos)
+	p.XTestEmbedPatterns, p.XTestEmbedPatternPos = cleanDecls(xTestEmbedPos)
 
 	// add the .S/.sx files only if we are using cgo
 	// (which means gcc will compile them).
@@ -1372,7 +1414,24 @@ func (ctxt *Context) matchFile(dir, name string, allTags map[string]bool, binary
 	return
 }
 
-func cleanImports(m map[string][]token.Position) ([]string, map[string][]token.Position) {
+// readEmbeds reads the named Go source file in full
+// and returns the //go:embed patterns it contains.
+func (ctxt *Context) readEmbeds(filename string) ([]fileEmbed, error) {
+	f, err := ctxt.openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", filename, err)
+	}
+	return readGoEmbed(data, filename)
+}
+
+// cleanDecls returns the sorted keys of m, which maps
+// import paths or //go:embed patterns to their positions, along with m.
+func cleanDecls(m map[string][]token.Position) ([]string, map[string][]token.Position) {
 	all := make([]string, 0, len(m))
 	for path := range m {
 		all = append(all, path)
@@ -101,6 +101,11 @@ var depsRules = `
 
 	reflect !< sort;
 
+	RUNTIME, unicode/utf8
+	< path;
+
+	unicode !< path;
+
 	# SYSCALL is RUNTIME plus the packages necessary for basic system calls.
 	RUNTIME, unicode/utf8, unicode/utf16, io
 	< internal/syscall/windows/sysdll, syscall/js
@@ -129,6 +134,10 @@ var depsRules = `
 	MATH
 	< math/rand;
 
+	# runtime/metrics only reads values exported by the runtime.
+	MATH
+	< runtime/metrics;
+
 	MATH, unicode/utf8
 	< strconv;
 
@@ -137,15 +146,23 @@ var depsRules = `
 	# STR is basic string and buffer manipulation.
 	RUNTIME, io, unicode/utf8, unicode/utf16, unicode
 	< bytes, strings
-	< bufio, path;
+	< bufio;
 
 	bufio, path, strconv
 	< STR;
 
+	# io/fs is the abstract file system interface used by os and friends.
+	TIME, io, path, sort
+	< io/fs;
+
+	# embed holds files embedded by //go:embed; it is an io/fs implementation.
+	io/fs
+	< embed;
+
 	# OS is basic OS access, including helpers (path/filepath, os/exec, etc).
 	# OS includes string routines, but those must be layered above package os.
 	# OS does not include reflection.
-	TIME, io, sort
+	io/fs
 	< internal/testlog
 	< internal/poll
 	< os
@@ -309,6 +326,12 @@ var depsRules = `
 	sync
 	< internal/singleflight;
 
+	sync
+	< internal/intern;
+
+	errors, internal/bytealg, internal/intern, strconv
+	< net/netip;
+
 	os
 	< golang.org/x/net/dns/dnsmessage,
 	  golang.org/x/net/lif,
@@ -328,6 +351,7 @@ var depsRules = `
 	internal/singleflight,
 	internal/race,
 	math/rand,
+	net/netip,
 	os
 	< net;
 
@@ -342,6 +366,7 @@ var depsRules = `
 
 	# logging - most packages should not import; http and up is allowed
 	FMT
+	< log/internal
 	< log;
 
 	log !< crypto/tls, database/sql, go/importer, testing;
@@ -349,6 +374,10 @@ var depsRules = `
 	FMT, log, net
 	< log/syslog;
 
+	context, encoding/json, log
+	< log/slog/internal, log/slog/internal/buffer
+	< log/slog;
+
 	NET, log
 	< net/mail;
 
@@ -360,7 +389,7 @@ var depsRules = `
 	< crypto/internal/subtle
 	< crypto/cipher
 	< crypto/aes, crypto/des, crypto/hmac, crypto/md5, crypto/rc4,
-	  crypto/sha1, crypto/sha256, crypto/sha512
+	  crypto/sha1, crypto/sha256, crypto/sha512, crypto/internal/sha3
 	< CRYPTO;
 
 	CGO, fmt, net !< CRYPTO;
@@ -369,6 +398,7 @@ var depsRules = `
 	CRYPTO, FMT, math/big
 	< crypto/rand
 	< crypto/internal/randutil
+	< crypto/internal/bigmod
 	< crypto/ed25519/internal/edwards25519
 	< crypto/ed25519
 	< encoding/asn1
@@ -376,6 +406,7 @@ var depsRules = `
 	< golang.org/x/crypto/cryptobyte
 	< golang.org/x/crypto/curve25519
 	< crypto/dsa, crypto/elliptic, crypto/rsa
+	< crypto/ecdh, crypto/mlkem
 	< crypto/ecdsa
 	< CRYPTO-MATH;
 
@@ -388,6 +419,7 @@ var depsRules = `
 	< golang.org/x/crypto/poly1305
 	< golang.org/x/crypto/chacha20poly1305
 	< golang.org/x/crypto/hkdf
+	< crypto/internal/hpke
 	< crypto/x509/internal/macos
 	< crypto/x509/pkix
 	< crypto/x509
@@ -401,6 +433,10 @@ var depsRules = `
 	crypto/tls
 	< net/smtp;
 
+	# Certificate Transparency test helpers.
+	CRYPTO-MATH
+	< crypto/internal/cttest;
+
 	# HTTP, King of Dependencies.
 
 	FMT
@@ -417,11 +453,18 @@ var depsRules = `
 	NET, crypto/tls
 	< net/http/httptrace;
 
+	encoding/binary
+	< net/http/internal/quic/quicwire;
+
+	crypto/tls, math/rand, net/http/internal/quic/quicwire
+	< net/http/internal/quic;
+
 	compress/gzip,
 	golang.org/x/net/http/httpguts,
 	golang.org/x/net/http/httpproxy,
 	golang.org/x/net/http2/hpack,
 	net/http/internal,
+	net/http/internal/quic,
 	net/http/httptrace,
 	mime/multipart,
 	log
@@ -459,7 +502,8 @@ var depsRules = `
 
 	# Test-only
 	log
-	< testing/iotest;
+	< testing/iotest
+	< testing/fstest;
 
 	FMT, flag, math/rand
 	< testing/quick;
@@ -467,7 +511,10 @@ var depsRules = `
 	FMT, flag, runtime/debug, runtime/trace
 	< testing;
 
-	internal/testlog, runtime/pprof, regexp
+	FMT, context, crypto/sha256, encoding/json, go/parser, math/rand, os/exec
+	< internal/fuzz;
+
+	internal/fuzz, internal/testlog, os/signal, runtime/pprof, regexp
 	< testing/internal/testdeps;
 
 	OS, flag, testing, internal/cfg
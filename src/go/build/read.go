@@ -7,7 +7,12 @@ package build
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"go/token"
 	"io"
+	"strconv"
+	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -245,3 +250,163 @@ func readImports(f io.Reader, reportSyntaxError bool, imports *[]string) ([]byte
 
 	return r.buf, r.err
 }
+
+// A fileEmbed is a single //go:embed pattern and its position.
+type fileEmbed struct {
+	pattern string
+	pos     token.Position
+}
+
+// readGoEmbed scans the Go source data for //go:embed comments
+// and returns the patterns they list, with their positions.
+// A //go:embed comment is only recognized at the start of a line
+// (after leading spaces and tabs), outside of string literals
+// and block comments.
+func readGoEmbed(data []byte, filename string) ([]fileEmbed, error) {
+	var list []fileEmbed
+	line, col := 1, 1
+	startLine := true
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line, col = line+1, 1
+			startLine = true
+			i++
+			continue
+
+		case c == ' ' || c == '\t' || c == '\r':
+			// Leave startLine unchanged.
+
+		case c == '"' || c == '\'':
+			// Interpreted string or rune literal.
+			startLine = false
+			for i, col = i+1, col+1; i < len(data) && data[i] != c && data[i] != '\n'; i, col = i+1, col+1 {
+				if data[i] == '\\' && i+1 < len(data) && data[i+1] != '\n' {
+					i, col = i+1, col+1
+				}
+			}
+
+		case c == '`':
+			// Raw string literal; may span lines.
+			startLine = false
+			for i, col = i+1, col+1; i < len(data) && data[i] != '`'; i, col = i+1, col+1 {
+				if data[i] == '\n' {
+					line, col = line+1, 0
+				}
+			}
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			// Block comment; may span lines.
+			startLine = false
+			for i, col = i+2, col+2; i < len(data) && !(data[i] == '*' && i+1 < len(data) && data[i+1] == '/'); i, col = i+1, col+1 {
+				if data[i] == '\n' {
+					line, col = line+1, 0
+				}
+			}
+			i, col = i+1, col+1
+
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			// Line comment.
+			end := i
+			for end < len(data) && data[end] != '\n' {
+				end++
+			}
+			text := string(data[i:end])
+			if startLine && strings.HasPrefix(text, "//go:embed") && (len(text) == len("//go:embed") || text[len("//go:embed")] == ' ' || text[len("//go:embed")] == '\t') {
+				pos := token.Position{
+					Filename: filename,
+					Offset:   i + len("//go:embed"),
+					Line:     line,
+					Column:   col + len("//go:embed"),
+				}
+				embs, err := parseGoEmbed(text[len("//go:embed"):], pos)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", pos, err)
+				}
+				list = append(list, embs...)
+			}
+			col += end - i
+			i = end
+			continue
+
+		default:
+			startLine = false
+		}
+		i, col = i+1, col+1
+	}
+	return list, nil
+}
+
+// parseGoEmbed parses the text following "//go:embed" to extract the glob patterns.
+// It accepts unquoted space-separated patterns as well as double-quoted and back-quoted Go strings.
+// This is based on a similar function in cmd/compile/internal/gc/embed.go;
+// the two should be kept in sync.
+func parseGoEmbed(args string, pos token.Position) ([]fileEmbed, error) {
+	trimBytes := func(n int) {
+		pos.Offset += n
+		pos.Column += n
+		args = args[n:]
+	}
+	trimSpace := func() {
+		trim := strings.TrimLeftFunc(args, unicode.IsSpace)
+		trimBytes(len(args) - len(trim))
+	}
+
+	var list []fileEmbed
+	for trimSpace(); args != ""; trimSpace() {
+		var path string
+		pathPos := pos
+	Switch:
+		switch args[0] {
+		default:
+			i := len(args)
+			for j, c := range args {
+				if unicode.IsSpace(c) {
+					i = j
+					break
+				}
+			}
+			path = args[:i]
+			trimBytes(i)
+
+		case '`':
+			i := strings.Index(args[1:], "`")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			path = args[1 : 1+i]
+			trimBytes(1 + i + 1)
+
+		case '"':
+			i := 1
+			for ; i < len(args); i++ {
+				if args[i] == '\\' {
+					i++
+					continue
+				}
+				if args[i] == '"' {
+					q, err := strconv.Unquote(args[:i+1])
+					if err != nil {
+						return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args[:i+1])
+					}
+					path = q
+					trimBytes(i + 1)
+					break Switch
+				}
+			}
+			if i >= len(args) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+		}
+
+		if args != "" {
+			r, _ := utf8.DecodeRuneInString(args)
+			if !unicode.IsSpace(r) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+		}
+		list = append(list, fileEmbed{path, pathPos})
+	}
+	return list, nil
+}
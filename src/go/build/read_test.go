@@ -5,6 +5,7 @@
 package build
 
 import (
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -221,3 +222,67 @@ func TestReadFailuresIgnored(t *testing.T) {
 	}
 	testRead(t, tests, func(r io.Reader) ([]byte, error) { return readImports(r, false, nil) })
 }
+
+var readEmbedTests = []struct {
+	in  string
+	out []string
+}{
+	{
+		"package p\n",
+		nil,
+	},
+	{
+		"package p\nimport \"embed\"\nvar i int\n//go:embed x y z\nvar files embed.FS",
+		[]string{
+			`test:4:12:x`,
+			`test:4:14:y`,
+			`test:4:16:z`,
+		},
+	},
+	{
+		"package p\nimport \"embed\"\nvar i int\n//go:embed x \"\\x79\" `z`\nvar files embed.FS",
+		[]string{
+			`test:4:12:x`,
+			`test:4:14:y`,
+			`test:4:21:z`,
+		},
+	},
+	{
+		"package p\nimport \"embed\"\nvar i int\n\t //go:embed x y\n//go:embed z\nvar files embed.FS",
+		[]string{
+			`test:4:14:x`,
+			`test:4:16:y`,
+			`test:5:12:z`,
+		},
+	},
+	{
+		"package p\nimport \"embed\"\nvar s = \"//go:embed x\"\nvar r = " + quote + "\n//go:embed y\n" + quote + "\n/*\n//go:embed z\n*/\nvar i int // go:embed w\n",
+		nil,
+	},
+	{
+		"package p\nimport \"embed\"\nvar i int\n//go:embedx y\nvar files embed.FS",
+		nil,
+	},
+}
+
+func TestReadEmbed(t *testing.T) {
+	for i, tt := range readEmbedTests {
+		embs, err := readGoEmbed([]byte(tt.in), "test")
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, e := range embs {
+			got = append(got, fmt.Sprintf("%s:%d:%d:%s", e.pos.Filename, e.pos.Line, e.pos.Column, e.pattern))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.out, "\n") {
+			t.Errorf("#%d: embeds:\n%s\nwant:\n%s", i, strings.Join(got, "\n"), strings.Join(tt.out, "\n"))
+		}
+	}
+
+	_, err := readGoEmbed([]byte("package p\nimport \"embed\"\n//go:embed \"x\nvar files embed.FS"), "test")
+	if err == nil || !strings.Contains(err.Error(), "invalid quoted string") {
+		t.Errorf("readGoEmbed with unterminated quote: err=%v, want invalid quoted string", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"math/bits"
+	"sort"
+	"sync/atomic"
+)
+
+// coverage gives access to the coverage counters of the package under test.
+//
+// The counters are the ones maintained by the code inserted by cmd/cover.
+// Each counter records how many times a basic block has run, so the counters
+// are reset before each input, then read back and reduced to a byte per
+// counter with bucketizeCount. The reduced form is what the worker and
+// coordinator compare and exchange.
+type coverage struct {
+	counters [][]uint32
+	n        int
+}
+
+// newCoverage returns a coverage reader for the counters in m,
+// which maps file names to counter slices.
+func newCoverage(m map[string][]uint32) *coverage {
+	files := make([]string, 0, len(m))
+	for f := range m {
+		files = append(files, f)
+	}
+	// Keep a stable order so that snapshots taken by different
+	// processes running the same binary line up.
+	sort.Strings(files)
+	c := &coverage{}
+	for _, f := range files {
+		c.counters = append(c.counters, m[f])
+		c.n += len(m[f])
+	}
+	return c
+}
+
+// len returns the length of a snapshot.
+func (c *coverage) len() int {
+	return c.n
+}
+
+// reset sets all counters to zero.
+func (c *coverage) reset() {
+	for _, counters := range c.counters {
+		for i := range counters {
+			atomic.StoreUint32(&counters[i], 0)
+		}
+	}
+}
+
+// snapshot writes the bucketized counters to dst, which must have
+// length c.len(), and returns it.
+func (c *coverage) snapshot(dst []byte) []byte {
+	i := 0
+	for _, counters := range c.counters {
+		for j := range counters {
+			dst[i] = bucketizeCount(atomic.LoadUint32(&counters[j]))
+			i++
+		}
+	}
+	return dst
+}
+
+// bucketizeCount maps a hit count to a single bit, so that a change in the
+// rough magnitude of a count is noticed but small variations are not. The
+// buckets are the same as those used by AFL: 1, 2, 3, 4-7, 8-15, 16-31,
+// 32-127 and 128+.
+func bucketizeCount(n uint32) byte {
+	switch {
+	case n == 0:
+		return 0
+	case n <= 3:
+		return 1 << (n - 1)
+	case n <= 7:
+		return 1 << 3
+	case n <= 15:
+		return 1 << 4
+	case n <= 31:
+		return 1 << 5
+	case n <= 127:
+		return 1 << 6
+	default:
+		return 1 << 7
+	}
+}
+
+// hasNewCoverage reports whether snapshot has any bit set
+// that is not set in base.
+func hasNewCoverage(base, snapshot []byte) bool {
+	if len(base) != len(snapshot) {
+		return len(snapshot) > 0
+	}
+	for i := range snapshot {
+		if snapshot[i]&^base[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeCoverage sets in base all the bits set in snapshot.
+func mergeCoverage(base, snapshot []byte) {
+	for i := range snapshot {
+		base[i] |= snapshot[i]
+	}
+}
+
+// countCoverageBits returns the number of bits set in cov.
+func countCoverageBits(cov []byte) int {
+	n := 0
+	for _, b := range cov {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestUnmarshalMarshal(t *testing.T) {
+	var tests = []struct {
+		in string
+		ok bool
+	}{
+		{
+			in: "int(1234)",
+			ok: false, // missing version
+		},
+		{
+			in: `go test fuzz v1
+string("a"bcad")`,
+			ok: false, // malformed
+		},
+		{
+			in: `go test fuzz v1
+int()`,
+			ok: false, // empty value
+		},
+		{
+			in: `go test fuzz v1
+uint(-32)`,
+			ok: false, // invalid negative uint
+		},
+		{
+			in: `go test fuzz v1
+int8(1234456)`,
+			ok: false, // int8 too large
+		},
+		{
+			in: `go test fuzz v1
+int(20*5)`,
+			ok: false, // expression in int value
+		},
+		{
+			in: `go test fuzz v1
+int(--5)`,
+			ok: false, // expression in int value
+		},
+		{
+			in: `go test fuzz v1
+bool(0)`,
+			ok: false, // malformed bool
+		},
+		{
+			in: `go test fuzz v1
+byte('aa)`,
+			ok: false, // malformed byte
+		},
+		{
+			in: `go test fuzz v1
+byte('☃')`,
+			ok: false, // byte out of range
+		},
+		{
+			in: `go test fuzz v1
+string("has final newline")
+`,
+			ok: true, // has final newline
+		},
+		{
+			in: `go test fuzz v1
+string("extra")
+[]byte("spacing")
+    `,
+			ok: true, // extra spaces in the final newline
+		},
+		{
+			in: `go test fuzz v1
+float64(0)
+float32(0)`,
+			ok: true, // will be an integer literal since there is no decimal
+		},
+		{
+			in: `go test fuzz v1
+int(-23)
+int8(-2)
+int64(2342425)
+uint(1)
+uint16(234)
+uint32(352342)
+uint64(123)
+rune('œ')
+byte('K')
+byte('ÿ')
+[]byte("hello¿")
+[]byte("a")
+bool(true)
+string("hello\\xbd\\xb2=\\xbc ⌘")
+float64(-12.5)
+float32(2.5)`,
+			ok: true,
+		},
+		{
+			in: `go test fuzz v1
+int32(-1)
+math.Float64frombits(0x7ff8000000000001)
+math.Float32frombits(0xff800000)
+math.Float64frombits(0x8000000000000000)`,
+			ok: true, // values with no literal form
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			vals, err := unmarshalCorpusFile([]byte(test.in))
+			if test.ok && err != nil {
+				t.Fatalf("unmarshal unexpected error: %v", err)
+			} else if !test.ok && err == nil {
+				t.Fatalf("unmarshal unexpected success")
+			}
+			if !test.ok {
+				return // skip the rest of the test
+			}
+			newB := marshalCorpusFile(vals...)
+			newVals, err := unmarshalCorpusFile(newB)
+			if err != nil {
+				t.Fatalf("unmarshal unexpected error: %v", err)
+			}
+			if len(vals) != len(newVals) {
+				t.Fatalf("got %d values after round trip, want %d", len(newVals), len(vals))
+			}
+			for i := range vals {
+				if !sameValue(vals[i], newVals[i]) {
+					t.Errorf("value %d: got %#v after round trip, want %#v", i, newVals[i], vals[i])
+				}
+			}
+		})
+	}
+}
+
+// sameValue is like reflect.DeepEqual, but compares floating-point values
+// bit for bit, so that NaNs and negative zero are compared exactly.
+func sameValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return ok && math.Float64bits(a) == math.Float64bits(b)
+	case float32:
+		b, ok := b.(float32)
+		return ok && math.Float32bits(a) == math.Float32bits(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestMarshalUnmarshalTypes(t *testing.T) {
+	vals := []interface{}{
+		[]byte("\x00\xff"),
+		"\xbd invalid utf-8",
+		true,
+		byte(200),
+		rune(-1),
+		rune(0x10ffff + 1),
+		'⌘',
+		int(math.MinInt32),
+		int8(math.MinInt8),
+		int16(math.MaxInt16),
+		int64(math.MinInt64),
+		uint(math.MaxUint32),
+		uint16(math.MaxUint16),
+		uint32(math.MaxUint32),
+		uint64(math.MaxUint64),
+		float32(math.Inf(1)),
+		float32(-1.5),
+		math.NaN(),
+		math.Copysign(0, -1),
+		math.MaxFloat64,
+		math.SmallestNonzeroFloat64,
+	}
+	data := marshalCorpusFile(vals...)
+	got, err := unmarshalCorpusFile(data)
+	if err != nil {
+		t.Fatalf("unmarshal %q: %v", data, err)
+	}
+	if len(got) != len(vals) {
+		t.Fatalf("got %d values, want %d", len(got), len(vals))
+	}
+	for i := range vals {
+		if !sameValue(vals[i], got[i]) {
+			t.Errorf("value %d: got %#v, want %#v", i, got[i], vals[i])
+		}
+	}
+}
+
+func BenchmarkMarshalCorpusFile(b *testing.B) {
+	buf := make([]byte, 1024*1024)
+	for i := 0; i < len(buf); i++ {
+		buf[i] = byte(i)
+	}
+
+	for sz := 1; sz <= len(buf); sz <<= 1 {
+		sz := sz
+		b.Run(strconv.Itoa(sz), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.SetBytes(int64(sz))
+				marshalCorpusFile(buf[:sz])
+			}
+		})
+	}
+}
@@ -0,0 +1,668 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fuzz provides common fuzzing functionality for tests built with
+// "go test" and for programs that use fuzzing functionality in the testing
+// package.
+package fuzz
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// CoordinateFuzzingOpts is a set of arguments for CoordinateFuzzing.
+// The zero value is valid for each field unless specified otherwise.
+type CoordinateFuzzingOpts struct {
+	// Log is a writer for logging progress messages and warnings.
+	// If nil, io.Discard will be used instead.
+	Log io.Writer
+
+	// Timeout is the amount of wall clock time to spend fuzzing after the corpus
+	// has loaded. If zero, there will be no time limit.
+	Timeout time.Duration
+
+	// Limit is the number of random values to generate and test. If zero,
+	// there will be no limit on the number of generated values.
+	Limit int64
+
+	// MinimizeTimeout is the amount of wall clock time to spend minimizing
+	// a failing input. If zero, failing inputs are not minimized.
+	MinimizeTimeout time.Duration
+
+	// Parallel is the number of worker processes to run in parallel. If zero,
+	// CoordinateFuzzing will run GOMAXPROCS workers.
+	Parallel int
+
+	// Seed is a list of seed values added by the fuzz target with testing.F.Add
+	// and in testdata.
+	Seed []CorpusEntry
+
+	// Types is the list of types which make up a corpus entry.
+	// Types must be set and must match values in Seed.
+	Types []reflect.Type
+
+	// CorpusDir is a directory where files containing values that crash the
+	// code being tested may be written. CorpusDir must be set.
+	CorpusDir string
+
+	// CacheDir is a directory containing additional "interesting" values.
+	// The fuzzer may derive new values from these, and may write new values here.
+	CacheDir string
+}
+
+// CoordinateFuzzing creates several worker processes and communicates with
+// them to test random inputs that could trigger crashes and expose bugs.
+// The worker processes run the same binary in the same directory with the
+// same environment variables as the coordinator process. Workers also run
+// with the same arguments as the coordinator, except with the -test.fuzzworker
+// flag prepended to the argument list.
+//
+// If a crash occurs, the function will return an error containing information
+// about the crash, which can be reported to the user.
+func CoordinateFuzzing(ctx context.Context, opts CoordinateFuzzingOpts) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkFuzzingSupported(); err != nil {
+		return err
+	}
+	if opts.Log == nil {
+		opts.Log = ioutil.Discard
+	}
+	if opts.Parallel == 0 {
+		opts.Parallel = runtime.GOMAXPROCS(0)
+	}
+	if opts.Limit > 0 && int64(opts.Parallel) > opts.Limit {
+		// Don't start more workers than we need.
+		opts.Parallel = int(opts.Limit)
+	}
+
+	c, err := newCoordinator(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// fuzzCtx is used to stop workers, for example, after finding a crasher.
+	fuzzCtx, cancelWorkers := context.WithCancel(ctx)
+	defer cancelWorkers()
+	doneC := ctx.Done()
+
+	// Start workers.
+	dir := "" // same as self
+	binPath := os.Args[0]
+	args := append([]string{"-test.fuzzworker"}, os.Args[1:]...)
+	env := os.Environ() // same as self
+
+	errC := make(chan error)
+	workers := make([]*worker, opts.Parallel)
+	defer func() {
+		for _, w := range workers {
+			if w != nil {
+				w.close()
+			}
+		}
+	}()
+	for i := range workers {
+		var err error
+		workers[i], err = newWorker(c, dir, binPath, args, env)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range workers {
+		w := workers[i]
+		go func() {
+			err := w.coordinate(fuzzCtx)
+			if fuzzCtx.Err() != nil || isInterruptError(err) {
+				err = nil
+			}
+			cleanErr := w.cleanup()
+			if err == nil {
+				err = cleanErr
+			}
+			errC <- err
+		}()
+	}
+
+	// Main event loop.
+	// Do not return until all workers have terminated. We avoid a deadlock by
+	// receiving messages from workers even after ctx is cancelled.
+	activeWorkers := len(workers)
+	statTicker := time.NewTicker(3 * time.Second)
+	defer statTicker.Stop()
+	defer c.logStats()
+
+	c.logStats()
+	var crash *crashError
+	stop := func(e error) {
+		cancelWorkers()
+		doneC = nil
+		if err == nil {
+			err = e
+		}
+	}
+	for {
+		var inputC chan fuzzInput
+		input, ok := c.peekInput()
+		if ok && crash == nil && doneC != nil {
+			inputC = c.inputC
+		}
+
+		select {
+		case <-doneC:
+			// Interrupted, cancelled, or timed out.
+			// stop sets doneC to nil so we don't busy wait here.
+			stop(ctx.Err())
+
+		case werr := <-errC:
+			// A worker terminated, possibly after encountering a fatal error.
+			stop(werr)
+			activeWorkers--
+			if activeWorkers == 0 {
+				if crash != nil {
+					return c.reportCrash(ctx, workers, crash)
+				}
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					// Running out of time, or being interrupted, is the
+					// normal way for fuzzing to end.
+					return nil
+				}
+				return err
+			}
+
+		case result := <-c.resultC:
+			// Received response from worker.
+			if doneC == nil {
+				// Already stopping; ignore the result.
+				break
+			}
+			c.updateStats(result)
+			if result.crasherData != nil {
+				// Found a crasher. Stop the workers and minimize
+				// it once they have all exited.
+				crash = &crashError{data: result.crasherData, errMsg: result.crasherMsg}
+				stop(nil)
+				break
+			}
+			if result.warmup {
+				c.warmupDone++
+				if c.warmupDone == c.warmupTotal {
+					fmt.Fprintf(c.opts.Log, "fuzz: elapsed: %s, gathering baseline coverage: %d/%d completed, now fuzzing with %d workers\n", c.elapsed(), c.warmupDone, c.warmupTotal, len(workers))
+				}
+			}
+			if hasNewCoverage(c.coverage, result.coverageData) {
+				c.updateCoverage(result.coverageData)
+				if !result.warmup {
+					// This is a new value that expanded coverage. Add it
+					// to the corpus and save it in the cache so later runs
+					// can start from it.
+					c.interestingCount++
+					entry := result.entry
+					entry.Path = filepath.Join(c.opts.CacheDir, fmt.Sprintf("%x", sha256.Sum256(entry.Data))[:16])
+					if err := writeToCorpus(entry.Data, c.opts.CacheDir); err != nil {
+						stop(err)
+						break
+					}
+					c.corpus.entries = append(c.corpus.entries, entry)
+				}
+			}
+			if c.opts.Limit > 0 && c.count >= c.opts.Limit {
+				// Fuzzing has run for the requested number of inputs.
+				stop(nil)
+			}
+
+		case inputC <- input:
+			// Sent the next input to a worker.
+			c.sentInput(input)
+
+		case <-statTicker.C:
+			c.logStats()
+		}
+	}
+}
+
+// crashError wraps a crasher written to the seed corpus. It saves the name
+// of the file where the input causing the crasher was saved. The testing
+// framework uses this to report a command to re-run that specific input.
+type crashError struct {
+	data   []byte
+	errMsg string
+	path   string
+}
+
+func (e *crashError) Error() string {
+	return e.errMsg
+}
+
+// CrashPath returns the path of the file the crashing input was written to.
+func (e *crashError) CrashPath() string {
+	return e.path
+}
+
+// reportCrash minimizes the input in crash if possible, writes it to the
+// seed corpus, and returns an error describing it.
+func (c *coordinator) reportCrash(ctx context.Context, workers []*worker, crash *crashError) error {
+	if c.opts.MinimizeTimeout > 0 && ctx.Err() == nil {
+		if vals, err := unmarshalCorpusFile(crash.data); err == nil && isMinimizable(vals) {
+			fmt.Fprintf(c.opts.Log, "fuzz: elapsed: %s, minimizing\n", c.elapsed())
+			data, errMsg, err := workers[0].minimize(ctx, crash.data, crash.errMsg, c.opts.MinimizeTimeout)
+			if err != nil {
+				// Minimization is best effort; report the
+				// original input if it could not complete.
+				fmt.Fprintf(c.opts.Log, "fuzz: minimizing failed: %v\n", err)
+			} else {
+				crash.data, crash.errMsg = data, errMsg
+			}
+			workers[0].cleanup()
+		}
+	}
+	if err := writeToCorpus(crash.data, c.opts.CorpusDir); err != nil {
+		return err
+	}
+	crash.path = filepath.Join(c.opts.CorpusDir, fmt.Sprintf("%x", sha256.Sum256(crash.data))[:16])
+	return crash
+}
+
+// CorpusEntry represents an individual input for fuzzing.
+//
+// We must use an equivalent type in the testing and testing/internal/testdeps
+// packages, but testing can't import this package directly, and we don't want
+// to export this type from testing. Instead, we use the same struct type and
+// use a type alias (not a defined type) for convenience.
+type CorpusEntry = struct {
+	// Name is the name of the corpus file, if the entry was loaded from the
+	// seed corpus. It can be used with -run. For entries added with f.Add and
+	// entries generated by the mutator, Name is empty and Data is
+	// populated.
+	Name string
+
+	// Path is the path of the corpus file, if the entry was loaded from disk.
+	Path string
+
+	// Data is the raw input data, in the corpus file encoding.
+	Data []byte
+
+	// Values is the unmarshaled values from a corpus file.
+	Values []interface{}
+
+	// IsSeed indicates whether this entry is part of the seed corpus.
+	IsSeed bool
+}
+
+type fuzzInput struct {
+	// entry is the value to test initially. The worker will randomly mutate
+	// values from this starting point.
+	entry CorpusEntry
+
+	// warmup indicates whether this is a warmup input before fuzzing begins.
+	// If true, the input should not be mutated.
+	warmup bool
+
+	// coverageData reflects the coordinator's current coverage data.
+	coverageData []byte
+
+	// timeout is the time to spend fuzzing variations of this input,
+	// not including starting or cleaning up.
+	timeout time.Duration
+
+	// limit is the maximum number of calls to the fuzz function the worker may
+	// make. The worker may make fewer calls, for example, if it finds an
+	// error early. If limit is zero, there is no limit on calls to the
+	// fuzz function.
+	limit int64
+}
+
+type fuzzResult struct {
+	// entry is an interesting value or a crasher.
+	entry CorpusEntry
+
+	// crasherData is set if the worker found a crasher. It holds the input
+	// in the corpus file encoding.
+	crasherData []byte
+
+	// crasherMsg is the error text or crash output for the crasher.
+	crasherMsg string
+
+	// coverageData is set if the worker found new coverage.
+	coverageData []byte
+
+	// warmup indicates whether this result was for a warmup input.
+	warmup bool
+
+	// count is the number of values the worker actually tested.
+	count int64
+
+	// limit is the limit of the input this result is for, so the
+	// coordinator can account for executions it no longer waits on.
+	limit int64
+
+	// totalDuration is the time the worker spent testing inputs.
+	totalDuration time.Duration
+}
+
+// coordinator holds channels that workers can use to communicate with
+// the coordinator.
+type coordinator struct {
+	opts CoordinateFuzzingOpts
+
+	// startTime is the time we started the workers after loading the corpus.
+	// Used for logging.
+	startTime time.Time
+
+	// inputC is sent values to fuzz by the coordinator. Any worker may receive
+	// values from this channel. Workers send results to resultC.
+	inputC chan fuzzInput
+
+	// resultC is sent results of fuzzing by workers. The coordinator
+	// receives these. Multiple types of messages are allowed.
+	resultC chan fuzzResult
+
+	// count is the number of values fuzzed so far.
+	count int64
+
+	// interestingCount is the number of unique interesting values which have
+	// been found this execution.
+	interestingCount int64
+
+	// corpus is a set of interesting values, including the seed corpus and
+	// generated values that workers reported as interesting.
+	corpus corpus
+
+	// corpusIndex is the next value to read from corpus.
+	corpusIndex int
+
+	// warmupTotal is the number of corpus entries that are run unmodified
+	// before fuzzing begins. warmupSent and warmupDone count the ones
+	// sent to workers so far, and the ones whose results have come back.
+	warmupTotal, warmupSent, warmupDone int
+
+	// countWaiting is the number of fuzzing executions the coordinator is
+	// waiting on workers to complete.
+	countWaiting int64
+
+	// coverage is the union of the coverage of all inputs tested so far.
+	coverage []byte
+
+	rand *rand.Rand
+}
+
+type corpus struct {
+	entries []CorpusEntry
+}
+
+func newCoordinator(opts CoordinateFuzzingOpts) (*coordinator, error) {
+	// Make sure all of the seed corpus entries have marshalled data.
+	for i := range opts.Seed {
+		if opts.Seed[i].Data == nil && opts.Seed[i].Values != nil {
+			opts.Seed[i].Data = marshalCorpusFile(opts.Seed[i].Values...)
+		}
+	}
+	c := &coordinator{
+		opts:      opts,
+		startTime: time.Now(),
+		inputC:    make(chan fuzzInput),
+		resultC:   make(chan fuzzResult),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := c.readCache(); err != nil {
+		return nil, err
+	}
+	for _, e := range opts.Seed {
+		if e.Data != nil {
+			c.corpus.entries = append(c.corpus.entries, e)
+		}
+	}
+	if len(c.corpus.entries) == 0 {
+		// No seeds at all: start from the zero values of the types.
+		var vals []interface{}
+		for _, t := range opts.Types {
+			vals = append(vals, zeroValue(t))
+		}
+		data := marshalCorpusFile(vals...)
+		c.corpus.entries = append(c.corpus.entries, CorpusEntry{Data: data, Values: vals})
+	}
+	c.warmupTotal = len(c.corpus.entries)
+	return c, nil
+}
+
+// peekInput returns the next value that should be sent to workers.
+// If the number of executions is limited, the returned value includes
+// a limit for one worker. If there are no executions left, peekInput returns
+// a zero value and false.
+func (c *coordinator) peekInput() (fuzzInput, bool) {
+	if c.opts.Limit > 0 && c.count+c.countWaiting >= c.opts.Limit {
+		// Already making the maximum number of calls to the fuzz function.
+		// Don't send more inputs right now.
+		return fuzzInput{}, false
+	}
+	if c.warmupSent < c.warmupTotal {
+		// Run each corpus entry as-is first, to gather the coverage
+		// they already reach.
+		return fuzzInput{
+			entry:   c.corpus.entries[c.warmupSent],
+			warmup:  true,
+			timeout: workerFuzzDuration,
+			limit:   1,
+		}, true
+	}
+	if c.warmupDone < c.warmupTotal {
+		// Wait for baseline coverage before mutating anything, so that
+		// the first mutations aren't all reported as interesting.
+		return fuzzInput{}, false
+	}
+
+	entry := c.corpus.entries[c.corpusIndex]
+	c.corpusIndex = (c.corpusIndex + 1) % len(c.corpus.entries)
+	input := fuzzInput{
+		entry:        entry,
+		coverageData: c.coverage,
+		timeout:      workerFuzzDuration,
+	}
+	if c.opts.Limit > 0 {
+		input.limit = c.opts.Limit / int64(c.opts.Parallel)
+		if c.opts.Limit%int64(c.opts.Parallel) > 0 {
+			input.limit++
+		}
+		if remaining := c.opts.Limit - c.count - c.countWaiting; input.limit > remaining {
+			input.limit = remaining
+		}
+	}
+	return input, true
+}
+
+// sentInput updates internal counters after an input is sent to c.inputC.
+func (c *coordinator) sentInput(input fuzzInput) {
+	if input.warmup {
+		c.warmupSent++
+	}
+	c.countWaiting += input.limit
+}
+
+func (c *coordinator) updateStats(result fuzzResult) {
+	c.count += result.count
+	c.countWaiting -= result.limit
+}
+
+func (c *coordinator) updateCoverage(newCoverage []byte) {
+	if c.coverage == nil {
+		c.coverage = make([]byte, len(newCoverage))
+	}
+	if len(newCoverage) != len(c.coverage) {
+		panic(fmt.Sprintf("number of coverage counters changed at runtime: %d, expected %d", len(newCoverage), len(c.coverage)))
+	}
+	mergeCoverage(c.coverage, newCoverage)
+}
+
+func (c *coordinator) elapsed() time.Duration {
+	return time.Since(c.startTime).Round(1 * time.Second)
+}
+
+func (c *coordinator) logStats() {
+	elapsed := c.elapsed()
+	if c.warmupDone < c.warmupTotal {
+		fmt.Fprintf(c.opts.Log, "fuzz: elapsed: %s, gathering baseline coverage: %d/%d completed\n", elapsed, c.warmupDone, c.warmupTotal)
+		return
+	}
+	rate := float64(c.count) / time.Since(c.startTime).Seconds()
+	fmt.Fprintf(c.opts.Log, "fuzz: elapsed: %s, execs: %d (%.0f/sec), new interesting: %d (total: %d, coverage bits: %d)\n", elapsed, c.count, rate, c.interestingCount, len(c.corpus.entries), countCoverageBits(c.coverage))
+}
+
+// readCache loads the interesting values saved in the cache directory by
+// previous runs and adds them to the corpus.
+func (c *coordinator) readCache() error {
+	entries, err := ReadCorpus(c.opts.CacheDir, c.opts.Types)
+	if err != nil {
+		if _, ok := err.(*MalformedCorpusError); !ok {
+			return err
+		}
+		// Cached entries that no longer match the fuzz target's
+		// arguments are left over from an earlier version of the
+		// test. They are of no use, so ignore them.
+	}
+	c.corpus.entries = append(c.corpus.entries, entries...)
+	return nil
+}
+
+// MalformedCorpusError is an error found while reading the corpus from the
+// filesystem. All of the errors are stored in the errs list. The testing
+// framework uses this to report malformed files in testdata.
+type MalformedCorpusError struct {
+	errs []error
+}
+
+func (e *MalformedCorpusError) Error() string {
+	var msgs []string
+	for _, s := range e.errs {
+		msgs = append(msgs, s.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ReadCorpus reads the corpus from the provided dir. The returned corpus
+// entries are guaranteed to match the given types. Any malformed files will
+// be saved in a MalformedCorpusError and returned, along with the most recent
+// error.
+func ReadCorpus(dir string, types []reflect.Type) ([]CorpusEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil // No corpus to read
+	} else if err != nil {
+		return nil, fmt.Errorf("reading seed corpus from testdata: %v", err)
+	}
+	var corpus []CorpusEntry
+	var errs []error
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		filename := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read corpus file: %v", err)
+		}
+		var vals []interface{}
+		vals, err = readCorpusData(data, types)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %v", filename, err))
+			continue
+		}
+		corpus = append(corpus, CorpusEntry{Name: file.Name(), Path: filename, Data: data, Values: vals, IsSeed: true})
+	}
+	if len(errs) > 0 {
+		return corpus, &MalformedCorpusError{errs: errs}
+	}
+	return corpus, nil
+}
+
+func readCorpusData(data []byte, types []reflect.Type) ([]interface{}, error) {
+	vals, err := unmarshalCorpusFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %v", err)
+	}
+	if err = CheckCorpus(vals, types); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// CheckCorpus verifies that the types in vals match the expected types
+// provided.
+func CheckCorpus(vals []interface{}, types []reflect.Type) error {
+	if len(vals) != len(types) {
+		return fmt.Errorf("wrong number of values in corpus entry: %d, want %d", len(vals), len(types))
+	}
+	valsT := make([]reflect.Type, len(vals))
+	for valsI, v := range vals {
+		valsT[valsI] = reflect.TypeOf(v)
+	}
+	for i := range types {
+		if valsT[i] != types[i] {
+			return fmt.Errorf("mismatched types in corpus entry: %v, want %v", valsT, types)
+		}
+	}
+	return nil
+}
+
+// writeToCorpus atomically writes the given bytes to a new file in testdata.
+// If the directory does not exist, it will create one. If the file already
+// exists, writeToCorpus will not rewrite it.
+func writeToCorpus(b []byte, dir string) error {
+	sum := fmt.Sprintf("%x", sha256.Sum256(b))[:16]
+	path := filepath.Join(dir, sum)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	// Write to a temporary file and rename it into place, so that
+	// an interrupted write never leaves a truncated input behind.
+	tmp, err := ioutil.TempFile(dir, sum+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// zeroValue returns the zero value of t, which must be one of the types
+// supported by the fuzzer.
+func zeroValue(t reflect.Type) interface{} {
+	if t.Kind() == reflect.Slice {
+		// Start from an empty slice rather than nil, since the
+		// mutator and the corpus encoding treat them the same.
+		return []byte{}
+	}
+	return reflect.Zero(t).Interface()
+}
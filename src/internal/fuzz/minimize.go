@@ -0,0 +1,205 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+// isMinimizable reports whether minimizeInput knows how to make any of
+// the values in vals smaller.
+func isMinimizable(vals []interface{}) bool {
+	for _, v := range vals {
+		switch v.(type) {
+		case []byte, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return true
+		}
+	}
+	return false
+}
+
+// minimizeInput tries to find a smaller version of vals that still fails.
+//
+// try is called with each candidate and reports whether the candidate still
+// fails; a candidate for which try returns true replaces the current input.
+// shouldStop is checked between candidates and ends minimization early when
+// it returns true. vals is modified in place and holds the smallest failing
+// input found when minimizeInput returns.
+func minimizeInput(vals []interface{}, try func([]interface{}) bool, shouldStop func() bool) {
+	for i, v := range vals {
+		switch v := v.(type) {
+		case []byte:
+			vals[i] = minimizeBytes(v, func(b []byte) bool {
+				vals[i] = b
+				return try(vals)
+			}, shouldStop)
+		case string:
+			vals[i] = string(minimizeBytes([]byte(v), func(b []byte) bool {
+				vals[i] = string(b)
+				return try(vals)
+			}, shouldStop))
+		case int, int8, int16, int32, int64:
+			vals[i] = fromInt64(minimizeInteger(toInt64(v), func(n int64) bool {
+				vals[i] = fromInt64(n, v)
+				return try(vals)
+			}, shouldStop), v)
+		case uint, uint8, uint16, uint32, uint64:
+			vals[i] = fromUint64(minimizeUnsigned(toUint64(v), func(n uint64) bool {
+				vals[i] = fromUint64(n, v)
+				return try(vals)
+			}, shouldStop), v)
+		}
+		if shouldStop() {
+			return
+		}
+	}
+}
+
+// minimizeBytes tries to remove bytes from v and returns the smallest
+// accepted candidate, or v itself. try is called with each candidate and
+// reports whether it was accepted; an accepted candidate becomes the basis
+// for further candidates. Candidates never share storage with each other,
+// so try may keep the ones it accepts.
+func minimizeBytes(v []byte, try func([]byte) bool, shouldStop func() bool) []byte {
+	// First, try to cut the tail, in chunks of decreasing size.
+	for n := 1024; n != 0; n /= 2 {
+		for len(v) > n {
+			if shouldStop() {
+				return v
+			}
+			candidate := append([]byte(nil), v[:len(v)-n]...)
+			if !try(candidate) {
+				break
+			}
+			v = candidate
+		}
+	}
+
+	// Then, try to remove each individual byte.
+	for i := 0; i < len(v); i++ {
+		if shouldStop() {
+			return v
+		}
+		candidate := make([]byte, 0, len(v)-1)
+		candidate = append(candidate, v[:i]...)
+		candidate = append(candidate, v[i+1:]...)
+		if !try(candidate) {
+			continue
+		}
+		v = candidate
+		// v[i] is now a different byte, so try again at the same index.
+		i--
+	}
+
+	// Finally, try to remove each range of bytes v[i:j].
+	for i := 0; i < len(v)-1; i++ {
+		for j := len(v); j > i+1; {
+			if shouldStop() {
+				return v
+			}
+			candidate := make([]byte, 0, len(v)-(j-i))
+			candidate = append(candidate, v[:i]...)
+			candidate = append(candidate, v[j:]...)
+			if try(candidate) {
+				v = candidate
+				j = len(v)
+				continue
+			}
+			j--
+		}
+	}
+	return v
+}
+
+// minimizeInteger tries to move v towards zero and returns the accepted
+// value closest to zero, or v itself.
+func minimizeInteger(v int64, try func(int64) bool, shouldStop func() bool) int64 {
+	if v == 0 || shouldStop() {
+		return v
+	}
+	if try(0) {
+		return 0
+	}
+	for v/2 != 0 && !shouldStop() && try(v/2) {
+		v /= 2
+	}
+	return v
+}
+
+// minimizeUnsigned is like minimizeInteger for unsigned values.
+func minimizeUnsigned(v uint64, try func(uint64) bool, shouldStop func() bool) uint64 {
+	if v == 0 || shouldStop() {
+		return v
+	}
+	if try(0) {
+		return 0
+	}
+	for v/2 != 0 && !shouldStop() && try(v/2) {
+		v /= 2
+	}
+	return v
+}
+
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	panic("unreachable")
+}
+
+// fromInt64 converts n to the type of the integer typ.
+func fromInt64(n int64, typ interface{}) interface{} {
+	switch typ.(type) {
+	case int:
+		return int(n)
+	case int8:
+		return int8(n)
+	case int16:
+		return int16(n)
+	case int32:
+		return int32(n)
+	case int64:
+		return n
+	}
+	panic("unreachable")
+}
+
+func toUint64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint:
+		return uint64(v)
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	panic("unreachable")
+}
+
+// fromUint64 converts n to the type of the unsigned integer typ.
+func fromUint64(n uint64, typ interface{}) interface{} {
+	switch typ.(type) {
+	case uint:
+		return uint(n)
+	case uint8:
+		return uint8(n)
+	case uint16:
+		return uint16(n)
+	case uint32:
+		return uint32(n)
+	case uint64:
+		return n
+	}
+	panic("unreachable")
+}
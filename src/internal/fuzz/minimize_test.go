@@ -0,0 +1,111 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMinimizeInput(t *testing.T) {
+	never := func() bool { return false }
+	type testcase struct {
+		name     string
+		fn       func([]interface{}) bool
+		input    []interface{}
+		expected []interface{}
+	}
+	cases := []testcase{
+		{
+			name: "ones_byte",
+			fn: func(vals []interface{}) bool {
+				b := vals[0].([]byte)
+				return bytes.Count(b, []byte{1}) >= 3
+			},
+			input:    []interface{}{[]byte{0, 0, 1, 0, 1, 1, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0}},
+			expected: []interface{}{[]byte{1, 1, 1}},
+		},
+		{
+			name: "ones_string",
+			fn: func(vals []interface{}) bool {
+				s := vals[0].(string)
+				return bytes.Count([]byte(s), []byte{'1'}) >= 2
+			},
+			input:    []interface{}{"001010001000000000000000000"},
+			expected: []interface{}{"11"},
+		},
+		{
+			name: "long_tail",
+			fn: func(vals []interface{}) bool {
+				b := vals[0].([]byte)
+				return len(b) > 0 && b[0] == 'x'
+			},
+			input:    []interface{}{append([]byte("x"), make([]byte, 5000)...)},
+			expected: []interface{}{[]byte("x")},
+		},
+		{
+			name: "int",
+			fn: func(vals []interface{}) bool {
+				return vals[0].(int) > 100
+			},
+			input:    []interface{}{int(12345)},
+			expected: []interface{}{int(192)},
+		},
+		{
+			name: "int8_negative",
+			fn: func(vals []interface{}) bool {
+				return vals[0].(int8) < 0
+			},
+			input:    []interface{}{int8(-100)},
+			expected: []interface{}{int8(-1)},
+		},
+		{
+			name: "uint",
+			fn: func(vals []interface{}) bool {
+				return vals[0].(uint16)&1 == 1
+			},
+			input:    []interface{}{uint16(0xffff)},
+			expected: []interface{}{uint16(1)},
+		},
+		{
+			name: "multiple",
+			fn: func(vals []interface{}) bool {
+				return len(vals[0].(string)) > 1 && vals[1].(bool)
+			},
+			input:    []interface{}{"abcdef", true},
+			expected: []interface{}{"ab", true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := append([]interface{}(nil), tc.input...)
+			minimizeInput(vals, tc.fn, never)
+			if !reflect.DeepEqual(vals, tc.expected) {
+				t.Errorf("got %#v, want %#v", vals, tc.expected)
+			}
+		})
+	}
+}
+
+// TestMinimizeInputStop checks that minimization ends as soon as shouldStop
+// reports true, and leaves a failing input behind.
+func TestMinimizeInputStop(t *testing.T) {
+	tries := 0
+	try := func(vals []interface{}) bool {
+		tries++
+		return len(vals[0].([]byte)) > 0
+	}
+	stop := func() bool { return tries >= 3 }
+	vals := []interface{}{make([]byte, 100)}
+	minimizeInput(vals, try, stop)
+	if tries != 3 {
+		t.Errorf("minimizeInput made %d tries, want 3", tries)
+	}
+	if len(vals[0].([]byte)) == 0 {
+		t.Errorf("minimizeInput kept an input that does not fail")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// maxMutatedLength is the largest []byte or string that a mutation will
+// grow a value to. Values that are already larger are still mutated, but
+// never grown.
+const maxMutatedLength = 1 << 20
+
+type mutator struct {
+	r       *rand.Rand
+	scratch []byte // scratch slice to avoid additional allocations
+}
+
+func newMutator(seed int64) *mutator {
+	return &mutator{r: rand.New(rand.NewSource(seed))}
+}
+
+func (m *mutator) rand(n int) int {
+	return m.r.Intn(n)
+}
+
+func (m *mutator) randByteOrder() binary.ByteOrder {
+	if m.r.Intn(2) == 0 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// chooseLen chooses length of range mutation in range [1,n]. It gives
+// preference to shorter ranges.
+func (m *mutator) chooseLen(n int) int {
+	switch x := m.rand(100); {
+	case x < 90:
+		return m.rand(min(8, n)) + 1
+	case x < 99:
+		return m.rand(min(32, n)) + 1
+	default:
+		return m.rand(n) + 1
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// mutate performs several mutations on the provided values.
+func (m *mutator) mutate(vals []interface{}) {
+	// Pick a random value to mutate, then apply a small number of
+	// mutations to it. Stacking mutations helps the fuzzer reach inputs
+	// that need more than one change to become interesting.
+	i := m.rand(len(vals))
+	for n := 1 + m.rand(4); n > 0; n-- {
+		vals[i] = m.mutateValue(vals[i])
+	}
+}
+
+func (m *mutator) mutateValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int(m.mutateInt(int64(v), maxInt))
+	case int8:
+		return int8(m.mutateInt(int64(v), math.MaxInt8))
+	case int16:
+		return int16(m.mutateInt(int64(v), math.MaxInt16))
+	case int32:
+		return int32(m.mutateInt(int64(v), math.MaxInt32))
+	case int64:
+		return m.mutateInt(v, math.MaxInt64)
+	case uint:
+		return uint(m.mutateUInt(uint64(v), maxUint))
+	case uint8:
+		return uint8(m.mutateUInt(uint64(v), math.MaxUint8))
+	case uint16:
+		return uint16(m.mutateUInt(uint64(v), math.MaxUint16))
+	case uint32:
+		return uint32(m.mutateUInt(uint64(v), math.MaxUint32))
+	case uint64:
+		return m.mutateUInt(v, math.MaxUint64)
+	case float32:
+		return float32(m.mutateFloat(float64(v), math.MaxFloat32))
+	case float64:
+		return m.mutateFloat(v, math.MaxFloat64)
+	case bool:
+		// Flip the value.
+		return !v
+	case string:
+		m.scratch = append(m.scratch[:0], v...)
+		m.scratch = m.mutateBytes(m.scratch)
+		return string(m.scratch)
+	case []byte:
+		m.scratch = append(m.scratch[:0], v...)
+		m.scratch = m.mutateBytes(m.scratch)
+		return append([]byte(nil), m.scratch...)
+	default:
+		panic(fmt.Sprintf("type not supported for mutating: %T", v))
+	}
+}
+
+const (
+	maxUint = uint64(^uint(0))
+	maxInt  = int64(maxUint >> 1)
+)
+
+func (m *mutator) mutateInt(v, maxValue int64) int64 {
+	var max int64
+	for {
+		max = 100
+		switch m.rand(3) {
+		case 0:
+			// Add a random number
+			if v >= maxValue {
+				continue
+			}
+			if v > 0 && maxValue-v < max {
+				// Don't let v exceed maxValue
+				max = maxValue - v
+			}
+			v += int64(1 + m.rand(int(max)))
+			return v
+		case 1:
+			// Subtract a random number
+			if v <= -maxValue {
+				continue
+			}
+			if v < 0 && maxValue+v < max {
+				// Don't let v drop below -maxValue
+				max = maxValue + v
+			}
+			v -= int64(1 + m.rand(int(max)))
+			return v
+		case 2:
+			// Replace with an interesting value.
+			return clampInt(interesting64[m.rand(len(interesting64))], maxValue)
+		}
+	}
+}
+
+func clampInt(v, maxValue int64) int64 {
+	if v > maxValue {
+		return maxValue
+	}
+	if v < -maxValue-1 {
+		return -maxValue - 1
+	}
+	return v
+}
+
+func (m *mutator) mutateUInt(v, maxValue uint64) uint64 {
+	var max uint64
+	for {
+		max = 100
+		switch m.rand(3) {
+		case 0:
+			// Add a random number
+			if v >= maxValue {
+				continue
+			}
+			if v > 0 && maxValue-v < max {
+				// Don't let v exceed maxValue
+				max = maxValue - v
+			}
+
+			v += uint64(1 + m.rand(int(max)))
+			return v
+		case 1:
+			// Subtract a random number
+			if v <= 0 {
+				continue
+			}
+			if v < max {
+				// Don't let v drop below 0
+				max = v
+			}
+			v -= uint64(1 + m.rand(int(max)))
+			return v
+		case 2:
+			// Replace with an interesting value.
+			u := uint64(interesting64[m.rand(len(interesting64))])
+			if u > maxValue {
+				u &= maxValue
+			}
+			return u
+		}
+	}
+}
+
+func (m *mutator) mutateFloat(v, maxValue float64) float64 {
+	var max float64
+	for {
+		switch m.rand(4) {
+		case 0:
+			// Add a random number
+			if v >= maxValue {
+				continue
+			}
+			max = 100
+			if v > 0 && maxValue-v < max {
+				// Don't let v exceed maxValue
+				max = maxValue - v
+			}
+			v += float64(1 + m.rand(int(max)))
+			return v
+		case 1:
+			// Subtract a random number
+			if v <= -maxValue {
+				continue
+			}
+			max = 100
+			if v < 0 && maxValue+v < max {
+				// Don't let v drop below -maxValue
+				max = maxValue + v
+			}
+			v -= float64(1 + m.rand(int(max)))
+			return v
+		case 2:
+			// Multiply by a random number
+			absV := math.Abs(v)
+			if v == 0 || absV >= maxValue {
+				continue
+			}
+			max = 10
+			if maxValue/absV < max {
+				// Don't let v go beyond the minimum or maximum value
+				max = maxValue / absV
+			}
+			v *= float64(1 + m.rand(int(max)))
+			return v
+		case 3:
+			// Divide by a random number
+			if v == 0 {
+				continue
+			}
+			v /= float64(1 + m.rand(10))
+			return v
+		}
+	}
+}
+
+// A byteSliceMutator applies a single mutation to b and returns the result,
+// which may share storage with b. It returns nil if the mutation does not
+// apply to b, for example because b is empty.
+type byteSliceMutator func(m *mutator, b []byte) []byte
+
+var byteSliceMutators = []byteSliceMutator{
+	byteSliceRemoveBytes,
+	byteSliceInsertRandomBytes,
+	byteSliceDuplicateBytes,
+	byteSliceOverwriteBytes,
+	byteSliceBitFlip,
+	byteSliceXORByte,
+	byteSliceSwapByte,
+	byteSliceArithmeticUint8,
+	byteSliceArithmeticUint16,
+	byteSliceArithmeticUint32,
+	byteSliceArithmeticUint64,
+	byteSliceOverwriteInterestingUint8,
+	byteSliceOverwriteInterestingUint16,
+	byteSliceOverwriteInterestingUint32,
+	byteSliceInsertConstantBytes,
+	byteSliceOverwriteConstantBytes,
+	byteSliceShuffleBytes,
+	byteSliceSwapBytes,
+}
+
+// mutateBytes applies one randomly chosen mutation to b and returns the
+// result. b must not be retained by the caller, since its storage may be
+// reused.
+func (m *mutator) mutateBytes(b []byte) []byte {
+	for {
+		mut := byteSliceMutators[m.rand(len(byteSliceMutators))]
+		if mutated := mut(m, b); mutated != nil {
+			return mutated
+		}
+	}
+}
+
+var (
+	interesting8  = []int8{-128, -1, 0, 1, 16, 32, 64, 100, 127}
+	interesting16 = []int16{-32768, -129, 128, 255, 256, 512, 1000, 1024, 4096, 32767}
+	interesting32 = []int32{-2147483648, -100663046, -32769, 32768, 65535, 65536, 100663045, 2147483647}
+	interesting64 = []int64{-9223372036854775808, -2147483649, 2147483648, 4294967295, 4294967296, 9223372036854775807}
+)
+
+func init() {
+	for _, v := range interesting8 {
+		interesting16 = append(interesting16, int16(v))
+	}
+	for _, v := range interesting16 {
+		interesting32 = append(interesting32, int32(v))
+	}
+	for _, v := range interesting32 {
+		interesting64 = append(interesting64, int64(v))
+	}
+}
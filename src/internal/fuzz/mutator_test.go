@@ -0,0 +1,83 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMutateValueTypes(t *testing.T) {
+	vals := []interface{}{
+		[]byte(""), []byte("hello"), "", "world", true,
+		int(0), int8(-1), int16(2), int32(-3), int64(4),
+		uint(0), uint8(1), uint16(2), uint32(3), uint64(4),
+		float32(0), float64(-1.5),
+	}
+	m := newMutator(1)
+	for _, v := range vals {
+		t.Run(fmt.Sprintf("%T", v), func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				got := m.mutateValue(v)
+				if reflect.TypeOf(got) != reflect.TypeOf(v) {
+					t.Fatalf("mutating %#v: got type %T", v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateDoesNotModifyInput(t *testing.T) {
+	orig := []byte("the quick brown fox jumps over the lazy dog")
+	in := append([]byte(nil), orig...)
+	m := newMutator(1)
+	changed := 0
+	for i := 0; i < 1000; i++ {
+		vals := []interface{}{in}
+		m.mutate(vals)
+		if !bytes.Equal(in, orig) {
+			t.Fatalf("mutate modified its input: %q", in)
+		}
+		if !bytes.Equal(vals[0].([]byte), orig) {
+			changed++
+		}
+	}
+	// Some mutation sequences cancel each other out, but most should
+	// produce a different input.
+	if changed < 900 {
+		t.Errorf("only %d of 1000 mutations changed the input", changed)
+	}
+}
+
+func TestByteSliceMutators(t *testing.T) {
+	m := newMutator(1)
+	for i, mut := range byteSliceMutators {
+		for _, in := range [][]byte{nil, {1}, {1, 2}, []byte("0123456789abcdef")} {
+			for j := 0; j < 100; j++ {
+				b := append([]byte(nil), in...)
+				if got := mut(m, b); got != nil && len(got) >= maxMutatedLength {
+					t.Fatalf("mutator %d: got length %d", i, len(got))
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkMutatorBytes(b *testing.B) {
+	for _, size := range []int{1, 10, 100, 1000, 10000, 100000} {
+		size := size
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			buf := make([]byte, size)
+			m := newMutator(1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vals := []interface{}{buf}
+				m.mutate(vals)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package fuzz
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func checkFuzzingSupported() error {
+	return fmt.Errorf("fuzzing is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func setWorkerComm(cmd *exec.Cmd, comm workerComm) {
+	panic("not implemented")
+}
+
+func getWorkerComm() (comm workerComm, err error) {
+	return workerComm{}, checkFuzzingSupported()
+}
+
+func isInterruptError(err error) bool {
+	return false
+}
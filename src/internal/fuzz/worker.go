@@ -0,0 +1,833 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// workerFuzzDuration is the amount of time a worker can spend testing random
+	// variations of an input given by the coordinator.
+	workerFuzzDuration = 100 * time.Millisecond
+
+	// workerTimeoutDuration is the amount of time a worker can go without
+	// responding to the coordinator before being stopped.
+	workerTimeoutDuration = 10 * time.Second
+
+	// workerOutputLimit is the amount of a worker's most recent output
+	// that is kept for crash reports.
+	workerOutputLimit = 32 << 10
+)
+
+// worker manages a worker process running a test binary. The worker object
+// exists only in the coordinator (the process started by 'go test -fuzz').
+// workerClient is used by the coordinator to send RPCs to the worker process,
+// which handles them with workerServer.
+type worker struct {
+	dir     string   // working directory, same as package directory
+	binPath string   // path to test executable
+	args    []string // arguments for test executable
+	env     []string // environment for test executable
+
+	coordinator *coordinator
+
+	// memFile holds the input the worker process is currently testing.
+	// The worker writes each input to it before calling the fuzz
+	// function, so if the process dies, the input that killed it can
+	// still be read. The file is inherited by each worker process that
+	// is started, and kept open across restarts.
+	memFile *os.File
+
+	cmd     *exec.Cmd     // current worker process
+	client  *workerClient // used to communicate with worker process
+	out     *tailBuffer   // output of the current worker process
+	waitErr error         // last error returned by wait, set before termC is closed.
+	termC   chan struct{} // closed by wait when worker process terminates
+
+	// hung is set when the worker process is stopped because it took too
+	// long to respond.
+	hung bool
+}
+
+func newWorker(c *coordinator, dir, binPath string, args, env []string) (*worker, error) {
+	f, err := ioutil.TempFile("", "fuzz-input-")
+	if err != nil {
+		return nil, err
+	}
+	// Only the open file descriptor is needed from now on.
+	os.Remove(f.Name())
+	return &worker{
+		dir:         dir,
+		binPath:     binPath,
+		args:        args,
+		env:         env[:len(env):len(env)], // copy on append to ensure workers don't overwrite each other.
+		coordinator: c,
+		memFile:     f,
+	}, nil
+}
+
+// cleanup stops the worker process, if it is running.
+func (w *worker) cleanup() error {
+	if w.termC == nil {
+		return nil
+	}
+	err := w.stop()
+	if err != nil && isInterruptError(err) {
+		err = nil
+	}
+	return err
+}
+
+// close releases resources held by the worker. The worker process
+// must not be running.
+func (w *worker) close() error {
+	return w.memFile.Close()
+}
+
+// coordinate runs the test binary to perform fuzzing.
+//
+// coordinate loops until ctx is cancelled or a fatal error is encountered.
+// If a test process terminates unexpectedly while fuzzing, coordinate will
+// attempt to restart and continue unless the termination can be attributed
+// to an interruption (from a timer or the user).
+//
+// While looping, coordinate receives inputs from the coordinator, passes
+// those inputs to the worker process, then passes the results back to
+// the coordinator.
+func (w *worker) coordinate(ctx context.Context) error {
+	// Main event loop.
+	for {
+		// Start or restart the worker if it's not running.
+		if w.termC == nil {
+			if err := w.startAndPing(ctx); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			// Worker was told to stop.
+			w.stop()
+			return ctx.Err()
+
+		case <-w.termC:
+			// Worker process terminated unexpectedly while waiting for input.
+			err := w.stop()
+			if isInterruptError(err) {
+				// Worker was interrupted by a signal that was not sent
+				// by the coordinator, most likely by the user.
+				return err
+			}
+			return fmt.Errorf("fuzzing process terminated unexpectedly while waiting for input: %v\n%s", err, w.out.Bytes())
+
+		case input := <-w.coordinator.inputC:
+			// Received input from coordinator.
+			args := fuzzArgs{
+				Entry:    input.entry.Data,
+				Warmup:   input.warmup,
+				Coverage: input.coverageData,
+				Limit:    input.limit,
+				Timeout:  input.timeout,
+			}
+			w.resetMem()
+			var resp fuzzResponse
+			err := w.call(ctx, input.timeout+workerTimeoutDuration, func() error {
+				return w.client.call(call{Fuzz: &args}, &resp)
+			})
+			result := fuzzResult{
+				warmup:        input.warmup,
+				limit:         input.limit,
+				count:         resp.Count,
+				totalDuration: resp.TotalDuration,
+			}
+			switch {
+			case err != nil:
+				// Error communicating with worker.
+				stopErr := w.stop()
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if isInterruptError(stopErr) {
+					// Worker interrupted by a signal not sent by the
+					// coordinator. Stop fuzzing.
+					return stopErr
+				}
+				// Unexpected termination. The input that killed the
+				// worker is in memFile.
+				data := w.readMem()
+				if data == nil {
+					data = input.entry.Data
+				}
+				result.crasherData = data
+				result.crasherMsg = w.crashMessage(stopErr)
+			case resp.InternalErr != "":
+				return errors.New(resp.InternalErr)
+			case resp.Err != "":
+				result.crasherData = resp.CrasherData
+				result.crasherMsg = resp.Err
+			case resp.Coverage != nil:
+				result.coverageData = resp.Coverage
+				result.entry = CorpusEntry{Data: resp.InterestingData}
+				if input.warmup {
+					result.entry = input.entry
+				}
+			}
+			w.coordinator.resultC <- result
+		}
+	}
+}
+
+// minimize asks a worker process to find a smaller input that still
+// fails, starting from data, which failed with errMsg. It returns the
+// smallest failing input found and its failure.
+//
+// Inputs that crash the worker process are handled by restarting the
+// worker and continuing from the input that crashed it, until timeout
+// has elapsed.
+func (w *worker) minimize(ctx context.Context, data []byte, errMsg string, timeout time.Duration) ([]byte, string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 || ctx.Err() != nil {
+			return data, errMsg, nil
+		}
+		if w.termC == nil {
+			if err := w.startAndPing(ctx); err != nil {
+				return nil, "", err
+			}
+		}
+
+		args := minimizeArgs{Entry: data, Timeout: remaining}
+		w.resetMem()
+		var resp minimizeResponse
+		err := w.call(ctx, remaining+workerTimeoutDuration, func() error {
+			return w.client.call(call{Minimize: &args}, &resp)
+		})
+		if err == nil {
+			if resp.InternalErr != "" {
+				return nil, "", errors.New(resp.InternalErr)
+			}
+			if resp.Err != "" {
+				data, errMsg = resp.Data, resp.Err
+			}
+			return data, errMsg, nil
+		}
+
+		// The worker died while testing a candidate. That candidate
+		// is the smallest failing input found so far, so continue
+		// from it with a new worker.
+		stopErr := w.stop()
+		if ctx.Err() != nil {
+			return data, errMsg, nil
+		}
+		if isInterruptError(stopErr) {
+			return nil, "", stopErr
+		}
+		mem := w.readMem()
+		if mem == nil || bytes.Equal(mem, data) {
+			// No progress was made.
+			return data, w.crashMessage(stopErr), nil
+		}
+		data, errMsg = mem, w.crashMessage(stopErr)
+	}
+}
+
+// call runs f, which makes a call to the worker process, and stops the
+// worker if ctx is cancelled or if the call does not complete within
+// timeout. Stopping the worker makes f return an error.
+func (w *worker) call(ctx context.Context, timeout time.Duration, f func() error) error {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			w.cmd.Process.Kill()
+		case <-t.C:
+			w.hung = true
+			w.cmd.Process.Kill()
+		}
+	}()
+	err := f()
+	close(done)
+	<-exited
+	return err
+}
+
+// crashMessage describes the unexpected termination of the worker
+// process, which ended with err, including its recent output.
+func (w *worker) crashMessage(err error) string {
+	var msg string
+	switch {
+	case w.hung:
+		msg = fmt.Sprintf("fuzzing process hung or terminated unexpectedly: no response after %v", workerTimeoutDuration)
+	case err == nil:
+		msg = "fuzzing process exited unexpectedly"
+	default:
+		msg = fmt.Sprintf("fuzzing process terminated unexpectedly: %v", err)
+	}
+	if out := bytes.TrimSpace(w.out.Bytes()); len(out) > 0 {
+		msg += "\n" + string(out)
+	}
+	return msg
+}
+
+// resetMem clears the input in memFile, so that readMem does not
+// return an input from an earlier call.
+func (w *worker) resetMem() {
+	var hdr [8]byte
+	w.memFile.WriteAt(hdr[:], 0)
+}
+
+// readMem returns the input the worker process last wrote to memFile,
+// or nil if there is none.
+func (w *worker) readMem() []byte {
+	return readMemFile(w.memFile)
+}
+
+// startAndPing starts the worker process and sends it a message to make sure
+// it can communicate.
+//
+// startAndPing returns an error if any part of this didn't work, including if
+// the context is expired or the worker process was interrupted before it
+// responded. Errors that happen after start but before the ping response
+// likely indicate that the worker did not call F.Fuzz or called F.Fail first.
+// We don't record crashers for these errors.
+func (w *worker) startAndPing(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+	err := w.call(ctx, workerTimeoutDuration, func() error {
+		return w.client.call(call{Ping: &pingArgs{}}, &pingResponse{})
+	})
+	if err != nil {
+		stopErr := w.stop()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isInterruptError(stopErr) {
+			return stopErr
+		}
+		if out := bytes.TrimSpace(w.out.Bytes()); len(out) > 0 {
+			return fmt.Errorf("fuzzing process terminated without fuzzing: %v\n%s", stopErr, out)
+		}
+		return fmt.Errorf("fuzzing process terminated without fuzzing: %v", stopErr)
+	}
+	return nil
+}
+
+// start runs a new worker process.
+//
+// If the process couldn't be started, start returns an error. Start won't
+// return later termination errors from the process if they occur.
+//
+// If the process starts successfully, start returns nil. stop must be called
+// once later to clean up, even if the process terminates on its own.
+//
+// When the process terminates, w.waitErr is set to the error (if any), and
+// w.termC is closed.
+func (w *worker) start() (err error) {
+	if w.termC != nil {
+		panic("worker already started")
+	}
+	w.waitErr = nil
+	w.hung = false
+
+	cmd := exec.Command(w.binPath, w.args...)
+	cmd.Dir = w.dir
+	cmd.Env = w.env
+	w.out = new(tailBuffer)
+	cmd.Stdout = w.out
+	cmd.Stderr = w.out
+
+	// Create the "fuzz_in" and "fuzz_out" pipes so we can communicate with
+	// the worker. We don't use stdin and stdout, since the test binary may
+	// do something else with those.
+	//
+	// Each pipe has a reader and a writer. The coordinator writes to fuzzInW
+	// and reads from fuzzOutR. The worker inherits fuzzInR and fuzzOutW.
+	// The coordinator closes fuzzInR and fuzzOutW after starting the worker,
+	// since we have no further need of them.
+	fuzzInR, fuzzInW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer fuzzInR.Close()
+	fuzzOutR, fuzzOutW, err := os.Pipe()
+	if err != nil {
+		fuzzInW.Close()
+		return err
+	}
+	defer fuzzOutW.Close()
+	setWorkerComm(cmd, workerComm{fuzzIn: fuzzInR, fuzzOut: fuzzOutW, mem: w.memFile})
+
+	// Start the worker process.
+	if err := cmd.Start(); err != nil {
+		fuzzInW.Close()
+		fuzzOutR.Close()
+		return err
+	}
+
+	// Worker started successfully.
+	// After this, w.client owns fuzzInW and fuzzOutR, so w.client.Close must be
+	// called later by stop.
+	w.cmd = cmd
+	w.termC = make(chan struct{})
+	w.client = newWorkerClient(workerComm{fuzzIn: fuzzInW, fuzzOut: fuzzOutR})
+
+	go func() {
+		w.waitErr = w.cmd.Wait()
+		close(w.termC)
+	}()
+
+	return nil
+}
+
+// stop tells the worker process to exit by closing w.client, then blocks
+// until it terminates. If the worker doesn't terminate after a short time,
+// stop kills it.
+//
+// stop returns the error the process terminated with, if any (same as
+// w.waitErr).
+//
+// stop must be called at least once after start returns successfully, even if
+// the worker process terminates unexpectedly.
+func (w *worker) stop() error {
+	if w.termC == nil {
+		panic("worker was not started successfully")
+	}
+	select {
+	case <-w.termC:
+		// Worker already terminated.
+	default:
+		// Worker is still running. Closing fuzz_in tells it to exit
+		// once it finishes what it is doing.
+		w.client.closeInput()
+		t := time.NewTimer(time.Second)
+		select {
+		case <-w.termC:
+		case <-t.C:
+			w.cmd.Process.Kill()
+			<-w.termC
+		}
+		t.Stop()
+	}
+	w.client.Close()
+	w.cmd = nil
+	w.termC = nil
+	return w.waitErr
+}
+
+// RunFuzzWorker is called in a worker process to communicate with the
+// coordinator process in order to fuzz random inputs. RunFuzzWorker loops
+// until the coordinator tells it to stop.
+//
+// fn is a wrapper on the fuzz function. It may return an error to indicate
+// a given input "crashed". The coordinator will also record a crasher if
+// the function times out or terminates the process.
+//
+// counters are the coverage counters of the package under test, as
+// maintained by the code that cmd/cover inserts. They may be nil, in which
+// case inputs are never reported as interesting.
+//
+// RunFuzzWorker returns an error if it could not communicate with the
+// coordinator process.
+func RunFuzzWorker(ctx context.Context, fn func(CorpusEntry) error, counters map[string][]uint32) error {
+	comm, err := getWorkerComm()
+	if err != nil {
+		return err
+	}
+	srv := &workerServer{
+		workerComm: comm,
+		fuzzFn:     fn,
+		cov:        newCoverage(counters),
+		m:          newMutator(time.Now().UnixNano()),
+	}
+	return srv.serve(ctx)
+}
+
+// call is serialized and sent from the coordinator on fuzz_in. It acts as
+// a minimalist RPC mechanism. Exactly one of its fields must be set to indicate
+// which method to call.
+type call struct {
+	Ping     *pingArgs
+	Fuzz     *fuzzArgs
+	Minimize *minimizeArgs
+}
+
+// pingArgs contains arguments to workerServer.ping.
+type pingArgs struct{}
+
+// pingResponse contains results from workerServer.ping.
+type pingResponse struct{}
+
+// fuzzArgs contains arguments to workerServer.fuzz.
+type fuzzArgs struct {
+	// Entry is the input to mutate, in the corpus file encoding.
+	Entry []byte
+
+	// Warmup indicates whether Entry should be tested as-is, without
+	// mutation, to collect the coverage it reaches.
+	Warmup bool
+
+	// Coverage is the coverage seen by the coordinator so far. Only inputs
+	// that expand on it are reported as interesting.
+	Coverage []byte
+
+	// Limit is the maximum number of values to test, without spending more time
+	// than Timeout. If zero, there is no limit.
+	Limit int64
+
+	// Timeout is the time to spend fuzzing, not including starting or
+	// cleaning up.
+	Timeout time.Duration
+}
+
+// fuzzResponse contains results from workerServer.fuzz.
+type fuzzResponse struct {
+	// Count is the number of values tested.
+	Count int64
+
+	// TotalDuration is the time spent testing values.
+	TotalDuration time.Duration
+
+	// InterestingData is a mutated input that expanded coverage, if any.
+	InterestingData []byte
+
+	// Coverage is the coverage of InterestingData, or of the warmup input.
+	Coverage []byte
+
+	// Err is the error string caused by the value in CrasherData, if any.
+	Err string
+
+	// CrasherData is the input that caused Err.
+	CrasherData []byte
+
+	// InternalErr is set if there was an internal error in the worker that
+	// prevented it from testing values.
+	InternalErr string
+}
+
+// minimizeArgs contains arguments to workerServer.minimize.
+type minimizeArgs struct {
+	// Entry is the failing input to minimize, in the corpus file encoding.
+	Entry []byte
+
+	// Timeout is the time to spend minimizing.
+	Timeout time.Duration
+}
+
+// minimizeResponse contains results from workerServer.minimize.
+type minimizeResponse struct {
+	// Data is the smallest failing input found, if it is smaller than the
+	// input that was given.
+	Data []byte
+
+	// Err is the error string caused by Data. It is empty if no smaller
+	// failing input was found.
+	Err string
+
+	// Count is the number of values tested.
+	Count int64
+
+	// InternalErr is set if there was an internal error in the worker that
+	// prevented it from minimizing.
+	InternalErr string
+}
+
+// workerComm holds pipes and shared memory used for communication
+// between the coordinator process (client) and a worker process (server).
+type workerComm struct {
+	fuzzIn, fuzzOut *os.File
+	mem             *os.File // input being tested; see worker.memFile
+}
+
+// workerServer is a minimalist RPC server, run by fuzz worker processes.
+// It allows the coordinator process (using workerClient) to call methods in a
+// worker process. This system allows the coordinator to run multiple worker
+// processes in parallel and to collect inputs that caused crashes from shared
+// memory after a worker process terminates unexpectedly.
+type workerServer struct {
+	workerComm
+	m   *mutator
+	cov *coverage
+
+	// coverage is the union of the coverage of every input this worker
+	// has tested, and of the coverage the coordinator has reported.
+	coverage []byte
+
+	// fuzzFn runs the worker's fuzz function on the given input and returns
+	// an error if it finds a crasher (the process may also exit or crash).
+	fuzzFn func(CorpusEntry) error
+}
+
+// serve reads serialized RPC messages on fuzzIn. When serve receives a message,
+// it calls the corresponding method, then sends the serialized result back
+// on fuzzOut.
+//
+// serve handles RPC calls synchronously; it will not attempt to read a message
+// until the previous call has finished.
+//
+// serve returns errors that occurred when communicating over pipes. serve
+// does not return errors from method calls; those are passed through serialized
+// responses.
+func (ws *workerServer) serve(ctx context.Context) error {
+	enc := json.NewEncoder(ws.fuzzOut)
+	dec := json.NewDecoder(ws.fuzzIn)
+	for {
+		var c call
+		if err := dec.Decode(&c); err == io.EOF || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var resp interface{}
+		switch {
+		case c.Fuzz != nil:
+			resp = ws.fuzz(*c.Fuzz)
+		case c.Minimize != nil:
+			resp = ws.minimize(*c.Minimize)
+		case c.Ping != nil:
+			resp = pingResponse{}
+		default:
+			return errors.New("no arguments provided for any call")
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// fuzz runs the test function on random variations of the input value in
+// args for a limited duration or number of iterations.
+//
+// fuzz returns early if it finds an input that crashes the fuzz function
+// or an input that expands coverage.
+//
+// fuzz does not modify the input in args.
+func (ws *workerServer) fuzz(args fuzzArgs) (resp fuzzResponse) {
+	start := time.Now()
+	defer func() { resp.TotalDuration = time.Since(start) }()
+
+	vals, err := unmarshalCorpusFile(args.Entry)
+	if err != nil {
+		resp.InternalErr = err.Error()
+		return resp
+	}
+	if ws.coverage == nil {
+		ws.coverage = make([]byte, ws.cov.len())
+	}
+	if len(args.Coverage) == len(ws.coverage) {
+		mergeCoverage(ws.coverage, args.Coverage)
+	}
+	snapshot := make([]byte, ws.cov.len())
+
+	if args.Warmup {
+		resp.Count = 1
+		if err := ws.run(args.Entry, vals); err != nil {
+			resp.Err = err.Error()
+			resp.CrasherData = args.Entry
+			return resp
+		}
+		ws.cov.snapshot(snapshot)
+		mergeCoverage(ws.coverage, snapshot)
+		resp.Coverage = snapshot
+		return resp
+	}
+
+	deadline := start.Add(args.Timeout)
+	mvals := make([]interface{}, len(vals))
+	for args.Limit == 0 || resp.Count < args.Limit {
+		if resp.Count > 0 && time.Now().After(deadline) {
+			break
+		}
+		copy(mvals, vals)
+		ws.m.mutate(mvals)
+		data := marshalCorpusFile(mvals...)
+		resp.Count++
+		if err := ws.run(data, mvals); err != nil {
+			resp.Err = err.Error()
+			resp.CrasherData = data
+			return resp
+		}
+		if hasNewCoverage(ws.coverage, ws.cov.snapshot(snapshot)) {
+			mergeCoverage(ws.coverage, snapshot)
+			resp.InterestingData = data
+			resp.Coverage = snapshot
+			return resp
+		}
+	}
+	return resp
+}
+
+// minimize tries to find a smaller input than the one in args that
+// still makes the fuzz function fail, until args.Timeout elapses.
+func (ws *workerServer) minimize(args minimizeArgs) (resp minimizeResponse) {
+	vals, err := unmarshalCorpusFile(args.Entry)
+	if err != nil {
+		resp.InternalErr = err.Error()
+		return resp
+	}
+	deadline := time.Now().Add(args.Timeout)
+	shouldStop := func() bool {
+		return time.Now().After(deadline)
+	}
+	try := func(candidate []interface{}) bool {
+		resp.Count++
+		err := ws.run(marshalCorpusFile(candidate...), candidate)
+		if err == nil {
+			return false
+		}
+		resp.Err = err.Error()
+		return true
+	}
+	minimizeInput(vals, try, shouldStop)
+	if resp.Err != "" {
+		resp.Data = marshalCorpusFile(vals...)
+	}
+	return resp
+}
+
+// run records data in shared memory, so the coordinator can find it if the
+// process dies, then calls the fuzz function with vals, collecting coverage.
+func (ws *workerServer) run(data []byte, vals []interface{}) error {
+	writeMemFile(ws.mem, data)
+	ws.cov.reset()
+	return ws.fuzzFn(CorpusEntry{Values: vals})
+}
+
+// writeMemFile records data as the input being tested in f. The length
+// is written last, so a reader never sees a length with missing data.
+func writeMemFile(f *os.File, data []byte) {
+	var hdr [8]byte
+	f.WriteAt(data, int64(len(hdr)))
+	binary.LittleEndian.PutUint64(hdr[:], uint64(len(data)))
+	f.WriteAt(hdr[:], 0)
+}
+
+// readMemFile returns the input recorded in f by writeMemFile,
+// or nil if there is none.
+func readMemFile(f *os.File) []byte {
+	var hdr [8]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return nil
+	}
+	n := binary.LittleEndian.Uint64(hdr[:])
+	if n == 0 || n > 1<<30 {
+		return nil
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, int64(len(hdr))); err != nil {
+		return nil
+	}
+	return data
+}
+
+// workerClient is a minimalist RPC client. The coordinator process uses a
+// workerClient to call methods in each worker process (handled by
+// workerServer).
+type workerClient struct {
+	workerComm
+	enc *json.Encoder
+	dec *json.Decoder
+
+	mu                  sync.Mutex
+	inClosed, outClosed bool
+}
+
+func newWorkerClient(comm workerComm) *workerClient {
+	return &workerClient{
+		workerComm: comm,
+		enc:        json.NewEncoder(comm.fuzzIn),
+		dec:        json.NewDecoder(comm.fuzzOut),
+	}
+}
+
+// closeInput closes fuzz_in. This signals to the server that there are no
+// more calls, and it should exit.
+func (wc *workerClient) closeInput() error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.inClosed {
+		return nil
+	}
+	wc.inClosed = true
+	return wc.fuzzIn.Close()
+}
+
+// Close shuts down the connection to the RPC server (the worker process).
+// It should be called once the worker process has terminated.
+func (wc *workerClient) Close() error {
+	err := wc.closeInput()
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.outClosed {
+		return err
+	}
+	wc.outClosed = true
+	if cerr := wc.fuzzOut.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// call sends c to the worker process and decodes its response into resp.
+func (wc *workerClient) call(c call, resp interface{}) error {
+	if err := wc.enc.Encode(c); err != nil {
+		return err
+	}
+	return wc.dec.Decode(resp)
+}
+
+// tailBuffer is an io.Writer that keeps the last workerOutputLimit
+// bytes written to it. It is safe for concurrent use.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if len(p) > workerOutputLimit {
+		p = p[len(p)-workerOutputLimit:]
+	}
+	if over := len(b.buf) + len(p) - workerOutputLimit; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+// Bytes returns a copy of the retained output.
+func (b *tailBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
@@ -593,6 +593,26 @@ func (fd *FD) ReadFrom(buf []byte) (int, syscall.Sockaddr, error) {
 	return n, sa, nil
 }
 
+// ReadFromInet4 wraps the recvfrom network call for IPv4.
+// Unlike on Unix systems, it is implemented in terms of ReadFrom.
+func (fd *FD) ReadFromInet4(buf []byte, from *syscall.SockaddrInet4) (int, error) {
+	n, sa, err := fd.ReadFrom(buf)
+	if sa4, ok := sa.(*syscall.SockaddrInet4); ok {
+		*from = *sa4
+	}
+	return n, err
+}
+
+// ReadFromInet6 wraps the recvfrom network call for IPv6.
+// Unlike on Unix systems, it is implemented in terms of ReadFrom.
+func (fd *FD) ReadFromInet6(buf []byte, from *syscall.SockaddrInet6) (int, error) {
+	n, sa, err := fd.ReadFrom(buf)
+	if sa6, ok := sa.(*syscall.SockaddrInet6); ok {
+		*from = *sa6
+	}
+	return n, err
+}
+
 // Write implements io.Writer.
 func (fd *FD) Write(buf []byte) (int, error) {
 	if err := fd.writeLock(); err != nil {
@@ -791,6 +811,16 @@ func (fd *FD) WriteTo(buf []byte, sa syscall.Sockaddr) (int, error) {
 	return ntotal, nil
 }
 
+// WriteToInet4 wraps the sendto network call for IPv4.
+func (fd *FD) WriteToInet4(buf []byte, sa *syscall.SockaddrInet4) (int, error) {
+	return fd.WriteTo(buf, sa)
+}
+
+// WriteToInet6 wraps the sendto network call for IPv6.
+func (fd *FD) WriteToInet6(buf []byte, sa *syscall.SockaddrInet6) (int, error) {
+	return fd.WriteTo(buf, sa)
+}
+
 // Call ConnectEx. This doesn't need any locking, since it is only
 // called when the descriptor is first created. This is here rather
 // than in the net package so that it can use fd.wop.
@@ -0,0 +1,108 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs_test
+
+import (
+	"errors"
+	. "io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var walkTree = fstest.MapFS{
+	"a":       {},
+	"b/c":     {},
+	"d/x":     {},
+	"d/y/z":   {},
+	"d/y/zz":  {},
+	"e/empty": {Mode: ModeDir},
+}
+
+func TestWalkDir(t *testing.T) {
+	var visited []string
+	err := WalkDir(walkTree, ".", func(path string, d DirEntry, err error) error {
+		if err != nil {
+			t.Errorf("walk %s: unexpected error %v", path, err)
+			return err
+		}
+		if path == "." {
+			if !d.IsDir() {
+				t.Errorf("walk .: IsDir() = false, want true")
+			}
+		} else if d.Name() != pathBase(path) {
+			t.Errorf("walk %s: Name() = %q, want %q", path, d.Name(), pathBase(path))
+		}
+		visited = append(visited, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	want := []string{".", "a", "b", "b/c", "d", "d/x", "d/y", "d/y/z", "d/y/zz", "e", "e/empty"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("WalkDir visited %v\nwant %v", visited, want)
+	}
+}
+
+func TestWalkDirSkipDir(t *testing.T) {
+	var visited []string
+	err := WalkDir(walkTree, ".", func(path string, d DirEntry, err error) error {
+		visited = append(visited, path)
+		switch path {
+		case "d":
+			return SkipDir // skip directory contents
+		case "b/c":
+			return SkipDir // skip rest of parent directory
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	want := []string{".", "a", "b", "b/c", "d", "e", "e/empty"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("WalkDir visited %v\nwant %v", visited, want)
+	}
+}
+
+func TestWalkDirErrors(t *testing.T) {
+	errStop := errors.New("stop")
+	var visited []string
+	err := WalkDir(walkTree, ".", func(path string, d DirEntry, err error) error {
+		visited = append(visited, path)
+		if path == "b" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("WalkDir = %v, want %v", err, errStop)
+	}
+	if want := []string{".", "a", "b"}; !reflect.DeepEqual(visited, want) {
+		t.Errorf("WalkDir visited %v\nwant %v", visited, want)
+	}
+
+	var gotErr error
+	err = WalkDir(walkTree, "missing", func(path string, d DirEntry, err error) error {
+		if d != nil {
+			t.Errorf("walk %s: d = %v, want nil", path, d)
+		}
+		gotErr = err
+		return nil
+	})
+	if err != nil || !errors.Is(gotErr, ErrNotExist) {
+		t.Errorf("WalkDir(missing) = %v, callback saw %v; want nil, ErrNotExist", err, gotErr)
+	}
+}
+
+func pathBase(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' {
+			return path[i+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slog_test
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// removeTime removes the time attribute so that example output is stable.
+func removeTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return a
+}
+
+func ExampleGroup() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: removeTime}))
+	logger.Info("finished",
+		slog.Group("req",
+			slog.String("method", "GET"),
+			slog.String("url", "/index.html")),
+		slog.Int("status", 200),
+		slog.Duration("duration", 15*time.Millisecond))
+
+	// Output:
+	// level=INFO msg=finished req.method=GET req.url=/index.html status=200 duration=15ms
+}
+
+func ExampleLogger_With() {
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: removeTime})
+	logger := slog.New(h).With("service", "api").WithGroup("req")
+	logger.Warn("slow request", "path", "/search", "ms", 340)
+
+	// Output:
+	// {"level":"WARN","msg":"slow request","service":"api","req":{"path":"/search","ms":340}}
+}
+
+func ExampleLevelVar() {
+	var level slog.LevelVar // INFO by default
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       &level,
+		ReplaceAttr: removeTime,
+	}))
+	logger.Debug("hidden")
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+
+	// Output:
+	// level=DEBUG msg=shown
+}
+
+// A token is a secret value that should not be logged in full.
+type token string
+
+// LogValue implements slog.LogValuer.
+// It avoids revealing the token.
+func (token) LogValue() slog.Value {
+	return slog.StringValue("REDACTED")
+}
+
+func ExampleLogValuer_secret() {
+	t := token("shhhh!")
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: removeTime}))
+	logger.Info("permission granted", "user", "Perry", "token", t)
+
+	// Output:
+	// level=INFO msg="permission granted" user=Perry token=REDACTED
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAttrs(t *testing.T) {
+	as := []Attr{Int("k1", 1), String("k2", "foo"), Int("k3", 3),
+		Int64("k4", -1), Float64("f", 3.1), Uint64("u", 999)}
+	r := newRecordWithAttrs(as)
+	if g, w := r.NumAttrs(), len(as); g != w {
+		t.Errorf("NumAttrs: got %d, want %d", g, w)
+	}
+	if got := attrsSlice(r); !attrsEqual(got, as) {
+		t.Errorf("got %v, want %v", got, as)
+	}
+
+	// Early return.
+	// Hit both loops in Record.Attrs: front and back.
+	for _, stop := range []int{2, 6} {
+		var got []Attr
+		r.Attrs(func(a Attr) bool {
+			got = append(got, a)
+			return len(got) < stop
+		})
+		want := as[:stop]
+		if !attrsEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRecordSource(t *testing.T) {
+	// Zero call depth is used by the log package, which passes pc == 0.
+	r := NewRecord(time.Time{}, 0, "", 0)
+	got := r.source()
+	if got.File != "" || got.Line != 0 {
+		t.Errorf("got %+v, want zero Source", got)
+	}
+
+	r = NewRecord(time.Time{}, 0, "", callerPC(2))
+	got = r.source()
+	if !strings.HasSuffix(got.File, "record_test.go") {
+		t.Errorf("file: got %q, want suffix record_test.go", got.File)
+	}
+	if !strings.HasSuffix(got.Function, "TestRecordSource") {
+		t.Errorf("function: got %q, want suffix TestRecordSource", got.Function)
+	}
+}
+
+func TestAliasingAndClone(t *testing.T) {
+	intAttrs := func(from, to int) []Attr {
+		var as []Attr
+		for i := from; i < to; i++ {
+			as = append(as, Int("k", i))
+		}
+		return as
+	}
+
+	check := func(r Record, want []Attr) {
+		t.Helper()
+		got := attrsSlice(r)
+		if !attrsEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// Create a record whose Attrs overflow the inline array,
+	// creating a slice in r.back.
+	r1 := NewRecord(time.Time{}, 0, "", 0)
+	r1.AddAttrs(intAttrs(0, nAttrsInline+1)...)
+	// Ensure that r1.back's capacity exceeds its length.
+	b := make([]Attr, len(r1.back), len(r1.back)+1)
+	copy(b, r1.back)
+	r1.back = b
+	// Make a copy that shares state.
+	r2 := r1
+	// Adding to both should not panic.
+	r1.AddAttrs(Int("p", 0))
+	r2.AddAttrs(Int("p", 1))
+	check(r1, append(intAttrs(0, nAttrsInline+1), Int("p", 0)))
+	r1 = r2
+	check(r1, append(intAttrs(0, nAttrsInline+1), Int("p", 1)))
+
+	// Adding to a clone is fine.
+	r2 = r1.Clone()
+	check(r2, append(intAttrs(0, nAttrsInline+1), Int("p", 1)))
+	r2.AddAttrs(Int("p", 2))
+	check(r1, append(intAttrs(0, nAttrsInline+1), Int("p", 1))) // r1 is unchanged
+	check(r2, append(intAttrs(0, nAttrsInline+1), Int("p", 1), Int("p", 2)))
+}
+
+func TestRecordAddEmptyGroups(t *testing.T) {
+	r := NewRecord(time.Time{}, 0, "", 0)
+	r.Add("a", 1, Group("g"), "b", 2, Group("h", Group("i")))
+	want := []Attr{Int("a", 1), Int("b", 2)}
+	if got := attrsSlice(r); !attrsEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRecordAddBadKey(t *testing.T) {
+	r := NewRecord(time.Time{}, 0, "", 0)
+	r.Add("a", 1, 2, "c")
+	want := []Attr{Int("a", 1), Int(badKey, 2), String(badKey, "c")}
+	if got := attrsSlice(r); !attrsEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func newRecordWithAttrs(as []Attr) Record {
+	r := NewRecord(time.Now(), LevelInfo, "", 0)
+	r.AddAttrs(as...)
+	return r
+}
+
+func attrsSlice(r Record) []Attr {
+	s := make([]Attr, 0, r.NumAttrs())
+	r.Attrs(func(a Attr) bool { s = append(s, a); return true })
+	return s
+}
+
+func BenchmarkAddAttrs(b *testing.B) {
+	var r Record
+	for i := 0; i < b.N; i++ {
+		r = NewRecord(time.Time{}, LevelInfo, "msg", 0)
+		r.AddAttrs(
+			String("string", "x"),
+			Int("int", 1),
+			Float64("float", 3.1),
+			Bool("bool", true),
+			Duration("duration", time.Second),
+			Any("any", nil),
+		)
+	}
+	_ = r
+}
@@ -26,6 +26,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -369,6 +370,46 @@ func TestFileServerSortsNames(t *testing.T) {
 	}
 }
 
+func TestFileServerFS(t *testing.T) {
+	defer afterTest(t)
+	fsys := fstest.MapFS{
+		"index.txt":     {Data: []byte("index")},
+		"static/a.css":  {Data: []byte("body{}")},
+		"static/b.js":   {Data: []byte("var b;")},
+		"static/c/d.go": {Data: []byte("package d")},
+	}
+	ts := httptest.NewServer(FileServer(FS(fsys)))
+	defer ts.Close()
+
+	get := func(suffix string) (int, string) {
+		res, err := Get(ts.URL + suffix)
+		if err != nil {
+			t.Fatalf("Get %s: %v", suffix, err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("ReadAll %s: %v", suffix, err)
+		}
+		res.Body.Close()
+		return res.StatusCode, string(b)
+	}
+	if code, s := get("/index.txt"); code != StatusOK || s != "index" {
+		t.Errorf("GET /index.txt = %d, %q; want %d, %q", code, s, StatusOK, "index")
+	}
+	if code, s := get("/static/c/d.go"); code != StatusOK || s != "package d" {
+		t.Errorf("GET /static/c/d.go = %d, %q; want %d, %q", code, s, StatusOK, "package d")
+	}
+	if code, s := get("/"); code != StatusOK || !strings.Contains(s, "<a href=\"index.txt\">index.txt</a>\n<a href=\"static/\">static/</a>") {
+		t.Errorf("GET / = %d, %q; want directory listing", code, s)
+	}
+	if code, s := get("/static/"); code != StatusOK || !strings.Contains(s, "<a href=\"a.css\">a.css</a>\n<a href=\"b.js\">b.js</a>\n<a href=\"c/\">c/</a>") {
+		t.Errorf("GET /static/ = %d, %q; want directory listing", code, s)
+	}
+	if code, _ := get("/missing.txt"); code != StatusNotFound {
+		t.Errorf("GET /missing.txt = %d; want %d", code, StatusNotFound)
+	}
+}
+
 func mustRemoveAll(dir string) {
 	err := os.RemoveAll(dir)
 	if err != nil {
@@ -0,0 +1,240 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nethttpomithttp2
+
+// White-box tests for HTTP2Config, which look at the frames the bundled
+// HTTP/2 server and transport exchange.
+
+package http
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/internal"
+	"testing"
+	"time"
+)
+
+// newHTTP2ConfigServer starts a Server accepting unencrypted HTTP/2
+// connections, configured by c, and returns its address.
+func newHTTP2ConfigServer(t *testing.T, c *HTTP2Config) string {
+	ln := newLocalListener(t)
+	srv := &Server{
+		Handler:   HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		Protocols: new(Protocols),
+		HTTP2:     c,
+	}
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// http2ConfigTestConn is the client side of an HTTP/2 connection,
+// driven frame by frame.
+type http2ConfigTestConn struct {
+	t  *testing.T
+	c  net.Conn
+	fr *http2Framer
+}
+
+// startHTTP2ConfigTestConn sends the client preface and settings on c.
+func startHTTP2ConfigTestConn(t *testing.T, c net.Conn, settings ...http2Setting) *http2ConfigTestConn {
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(c, http2ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	tc := &http2ConfigTestConn{t: t, c: c, fr: http2NewFramer(c, c)}
+	if err := tc.fr.WriteSettings(settings...); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func dialHTTP2ConfigServer(t *testing.T, addr string, settings ...http2Setting) *http2ConfigTestConn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startHTTP2ConfigTestConn(t, c, settings...)
+}
+
+func (tc *http2ConfigTestConn) readFrame() http2Frame {
+	tc.t.Helper()
+	f, err := tc.fr.ReadFrame()
+	if err != nil {
+		tc.t.Fatalf("reading frame: %v", err)
+	}
+	return f
+}
+
+// readServerPreamble reads frames until the server acknowledges the
+// client's settings, and returns the settings of the server.
+func (tc *http2ConfigTestConn) readServerPreamble() map[http2SettingID]uint32 {
+	tc.t.Helper()
+	settings := make(map[http2SettingID]uint32)
+	for {
+		switch f := tc.readFrame().(type) {
+		case *http2SettingsFrame:
+			if f.IsAck() {
+				return settings
+			}
+			f.ForeachSetting(func(s http2Setting) error {
+				settings[s.ID] = s.Val
+				return nil
+			})
+			tc.fr.WriteSettingsAck()
+		case *http2GoAwayFrame:
+			tc.t.Fatalf("server sent GOAWAY with %v", f.ErrCode)
+		}
+	}
+}
+
+func TestHTTP2ConfigServerSettings(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		c    HTTP2Config
+		id   http2SettingID
+		want uint32
+	}{
+		{"MaxConcurrentStreams", HTTP2Config{MaxConcurrentStreams: 7}, http2SettingMaxConcurrentStreams, 7},
+		{"MaxReadFrameSize", HTTP2Config{MaxReadFrameSize: 256 << 10}, http2SettingMaxFrameSize, 256 << 10},
+		{"InvalidMaxReadFrameSize", HTTP2Config{MaxReadFrameSize: 1 << 30}, http2SettingMaxFrameSize, http2defaultMaxReadFrameSize},
+		{"MaxReceiveBufferPerStream", HTTP2Config{MaxReceiveBufferPerStream: 256 << 10}, http2SettingInitialWindowSize, 256 << 10},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := dialHTTP2ConfigServer(t, newHTTP2ConfigServer(t, &tt.c))
+			settings := tc.readServerPreamble()
+			if got, ok := settings[tt.id]; !ok || got != tt.want {
+				t.Errorf("server setting %v = %d, want %d", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTP2ConfigServerMaxReceiveBufferPerConnection(t *testing.T) {
+	const n = 4 << 20
+	tc := dialHTTP2ConfigServer(t, newHTTP2ConfigServer(t, &HTTP2Config{MaxReceiveBufferPerConnection: n}))
+	for {
+		if f, ok := tc.readFrame().(*http2WindowUpdateFrame); ok && f.StreamID == 0 {
+			if want := uint32(n - http2initialWindowSize); f.Increment != want {
+				t.Errorf("connection window grown by %d, want %d", f.Increment, want)
+			}
+			return
+		}
+	}
+}
+
+func TestHTTP2ConfigServerPermitProhibitedCipherSuites(t *testing.T) {
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, permit := range []bool{false, true} {
+		ln := newLocalListener(t)
+		srv := &Server{
+			Handler:   HandlerFunc(func(w ResponseWriter, r *Request) {}),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			HTTP2:     &HTTP2Config{PermitProhibitedCipherSuites: permit},
+		}
+		go srv.ServeTLS(ln, "", "")
+		defer srv.Close()
+
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{http2NextProtoTLS},
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := c.ConnectionState().NegotiatedProtocol; p != http2NextProtoTLS {
+			t.Fatalf("negotiated protocol %q, want %q", p, http2NextProtoTLS)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		// The server writes its first frame without waiting for the
+		// client preface.
+		tc := &http2ConfigTestConn{t: t, c: c, fr: http2NewFramer(c, c)}
+		switch f := tc.readFrame().(type) {
+		case *http2SettingsFrame:
+			if !permit {
+				t.Errorf("server accepted a prohibited cipher suite")
+			}
+		case *http2GoAwayFrame:
+			if permit || f.ErrCode != http2ErrCodeInadequateSecurity {
+				t.Errorf("PermitProhibitedCipherSuites = %v: server sent GOAWAY with %v", permit, f.ErrCode)
+			}
+		default:
+			t.Errorf("server started with %v frame", f.Header().Type)
+		}
+	}
+}
+
+func TestHTTP2ConfigTransportPing(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	pingc := make(chan bool, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			pingc <- false
+			return
+		}
+		defer c.Close()
+		preface := make([]byte, len(http2ClientPreface))
+		if _, err := io.ReadFull(c, preface); err != nil {
+			pingc <- false
+			return
+		}
+		// Never answer: the transport should give up on the connection.
+		fr := http2NewFramer(c, c)
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				pingc <- false
+				return
+			}
+			if f, ok := f.(*http2PingFrame); ok && !f.IsAck() {
+				pingc <- true
+				io.Copy(ioutil.Discard, c)
+				return
+			}
+		}
+	}()
+
+	tr := &Transport{
+		Protocols: new(Protocols),
+		HTTP2: &HTTP2Config{
+			SendPingTimeout: 20 * time.Millisecond,
+			PingTimeout:     100 * time.Millisecond,
+		},
+	}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	defer tr.CloseIdleConnections()
+	errc := make(chan error, 1)
+	go func() {
+		res, err := (&Client{Transport: tr}).Get("http://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	if !<-pingc {
+		t.Fatal("transport didn't send a health check ping")
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("request succeeded without a response")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request still pending after the health check ping timed out")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nethttpomithttp2
+
+package http
+
+// This file reports the connections of the bundled HTTP/2 transport
+// to Transport.ConnPoolStates, Transport.EvictHost and
+// Transport.ConnPoolHook. It lives outside h2_bundle.go, which is
+// generated from golang.org/x/net/http2 and must not be edited.
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// http2hookedConnPool is the connection pool of an http2Transport made
+// for a Transport. It wraps the bundled pool and reports its connections
+// to the Transport's ConnPoolHook.
+//
+// A connection is reported as opened when it's added to the pool, as
+// reused each time the pool hands it out after the first, and as closed
+// when it's removed from the pool, whether or not it served a request.
+// The bundled pool gets connections only from the "h2" upgrade function
+// of the Transport and from addUnencryptedConn, and removes them only in
+// MarkDead, which every connection goes through when its read loop exits.
+type http2hookedConnPool struct {
+	http2noDialClientConnPool // the bundled pool
+	t                         *http2Transport
+
+	// hookMu serializes the events of a connection, and is held
+	// while looking up a new connection in the bundled pool so that
+	// MarkDead can't remove it in between.
+	hookMu sync.Mutex
+	conns  map[*http2ClientConn]*http2hookedConn // connections reported as open
+}
+
+type http2hookedConn struct {
+	addr string
+	used bool // handed out for a request
+}
+
+// hookConnPool wraps t's connection pool, which must be unused,
+// in an http2hookedConnPool. If t was made by http2configureTransport,
+// it also wraps the "h2" upgrade function it installed, so that the
+// connections it adds are reported.
+func (t *http2Transport) hookConnPool() {
+	p := &http2hookedConnPool{
+		http2noDialClientConnPool: t.ConnPool.(http2noDialClientConnPool),
+		t:                         t,
+	}
+	t.ConnPool = p
+	if upgrade := t.t1.TLSNextProto[http2NextProtoTLS]; upgrade != nil && !t.AllowHTTP {
+		t.t1.TLSNextProto[http2NextProtoTLS] = func(authority string, c *tls.Conn) RoundTripper {
+			rt := upgrade(authority, c)
+			p.added(http2authorityAddr("https", authority), c)
+			return rt
+		}
+	}
+}
+
+// added reports the connection made from c as opened, if c has just
+// been added to the pool for addr and the pool still has it.
+func (p *http2hookedConnPool) added(addr string, c net.Conn) {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	p.mu.Lock()
+	var cc *http2ClientConn
+	for _, v := range p.http2clientConnPool.conns[addr] {
+		if v.tconn == c {
+			cc = v
+			break
+		}
+	}
+	p.mu.Unlock()
+	if cc != nil {
+		p.openedLocked(addr, cc)
+	}
+}
+
+// openedLocked reports cc as opened, unless it already was.
+// p.hookMu must be held.
+func (p *http2hookedConnPool) openedLocked(addr string, cc *http2ClientConn) *http2hookedConn {
+	if hc, ok := p.conns[cc]; ok {
+		return hc
+	}
+	if p.conns == nil {
+		p.conns = make(map[*http2ClientConn]*http2hookedConn)
+	}
+	hc := &http2hookedConn{addr: addr}
+	p.conns[cc] = hc
+	p.t.connPoolEvent(addr, cc.tconn, ConnPoolOpen)
+	return hc
+}
+
+func (p *http2hookedConnPool) GetClientConn(req *Request, addr string) (*http2ClientConn, error) {
+	cc, err := p.http2noDialClientConnPool.GetClientConn(req, addr)
+	if err != nil {
+		return nil, err
+	}
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	// A request may get a connection before it's reported as added.
+	hc := p.openedLocked(addr, cc)
+	if hc.used {
+		p.t.connPoolEvent(addr, cc.tconn, ConnPoolReuse)
+	}
+	hc.used = true
+	return cc, nil
+}
+
+func (p *http2hookedConnPool) MarkDead(cc *http2ClientConn) {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	p.http2noDialClientConnPool.MarkDead(cc)
+	if hc, ok := p.conns[cc]; ok {
+		delete(p.conns, cc)
+		p.t.connPoolEvent(hc.addr, cc.tconn, ConnPoolClose)
+	}
+}
+
+// clientConnPool returns the clientConnPool underlying t's connection pool.
+// t must be a Transport made by http2configureTransport or
+// http2configureUnencryptedTransport.
+func (t *http2Transport) clientConnPool() *http2clientConnPool {
+	switch cp := t.ConnPool.(type) {
+	case *http2hookedConnPool:
+		return cp.http2clientConnPool
+	case http2noDialClientConnPool:
+		return cp.http2clientConnPool
+	}
+	return nil
+}
+
+// connPoolStates returns the state of t's connection pool for each
+// address, for Transport.ConnPoolStates.
+func (t *http2Transport) connPoolStates() []ConnPoolState {
+	p := t.clientConnPool()
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var states []ConnPoolState
+	for addr, vv := range p.conns {
+		st := ConnPoolState{Key: t.connPoolKey(addr), HTTP2: len(vv)}
+		for _, cc := range vv {
+			cc.mu.Lock()
+			st.HTTP2Streams += len(cc.streams)
+			cc.mu.Unlock()
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+// shutdownConns gracefully shuts down the connections in t's pool
+// whose address satisfies match, for Transport.EvictHost.
+func (t *http2Transport) shutdownConns(match func(addr string) bool) {
+	p := t.clientConnPool()
+	if p == nil {
+		return
+	}
+	var ccs []*http2ClientConn
+	p.mu.Lock()
+	for addr, vv := range p.conns {
+		if match(addr) {
+			ccs = append(ccs, vv...)
+		}
+	}
+	p.mu.Unlock()
+	for _, cc := range ccs {
+		go cc.Shutdown(context.Background())
+	}
+}
+
+// connPoolKey returns the Transport.ConnPoolHook key for connections
+// to addr.
+func (t *http2Transport) connPoolKey(addr string) ConnPoolKey {
+	scheme := "https"
+	if t.AllowHTTP {
+		scheme = "http"
+	}
+	return ConnPoolKey{Scheme: scheme, Addr: addr}
+}
+
+// connPoolEvent reports ev for the connection c to addr to the
+// Transport.ConnPoolHook of t's HTTP/1 Transport, if any.
+func (t *http2Transport) connPoolEvent(addr string, c net.Conn, ev ConnPoolEvent) {
+	if t.t1 != nil {
+		t.t1.connPoolEvent(t.connPoolKey(addr), c, ev)
+	}
+}
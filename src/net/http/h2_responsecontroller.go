@@ -0,0 +1,167 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nethttpomithttp2
+
+package http
+
+// This file implements the ResponseController methods of the bundled
+// HTTP/2 server's ResponseWriter. It lives outside h2_bundle.go, which
+// is generated from golang.org/x/net/http2 and must not be edited.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// http2readDeadlines holds the timers started by
+// http2responseWriter.SetReadDeadline for the streams of one connection.
+// A timer is removed when its stream's context is canceled,
+// which happens when the stream is closed.
+//
+// The server keeps one http2readDeadlines in the base context of each
+// connection it serves with HTTP/2 (see http2withReadDeadlines), so the
+// timers belong to the http2serverConn and go away with it.
+type http2readDeadlines struct {
+	mu sync.Mutex
+	m  map[*http2stream]*http2readDeadline
+}
+
+// http2readDeadline is the armed read deadline of a stream.
+type http2readDeadline struct {
+	timer *time.Timer
+	stop  func() bool // cancels the cleanup run when the stream is closed
+}
+
+// http2readDeadlinesKey is the context key for a connection's
+// *http2readDeadlines.
+type http2readDeadlinesKey struct{}
+
+// http2withReadDeadlines returns a copy of ctx, the base context of a
+// connection about to be served with HTTP/2, that holds the read
+// deadline timers of the connection's streams.
+func http2withReadDeadlines(ctx context.Context) context.Context {
+	return context.WithValue(ctx, http2readDeadlinesKey{}, new(http2readDeadlines))
+}
+
+// http2onReadTimeout is run on its own goroutine (from time.AfterFunc)
+// when the stream's read deadline has been exceeded.
+func http2onReadTimeout(st *http2stream) {
+	if st.body != nil {
+		// Wrap the ErrDeadlineExceeded to avoid callers depending on us
+		// returning the bare error.
+		st.body.CloseWithError(fmt.Errorf("%w", os.ErrDeadlineExceeded))
+	}
+}
+
+func (w *http2responseWriter) SetReadDeadline(deadline time.Time) error {
+	st := w.rws.stream
+	rd, _ := st.ctx.Value(http2readDeadlinesKey{}).(*http2readDeadlines)
+	if rd == nil {
+		// The connection wasn't set up by Server.
+		return ErrNotSupported
+	}
+	rd.mu.Lock()
+	if d, ok := rd.m[st]; ok {
+		delete(rd.m, st)
+		d.stop()
+		if !d.timer.Stop() {
+			// Deadline already exceeded.
+			rd.mu.Unlock()
+			return nil
+		}
+	}
+	if deadline.IsZero() {
+		rd.mu.Unlock()
+		return nil
+	}
+	if deadline.Before(time.Now()) {
+		rd.mu.Unlock()
+		// If we're setting a deadline in the past, fail the body immediately
+		// so reads after SetReadDeadline returns will fail.
+		http2onReadTimeout(st)
+		return nil
+	}
+	if rd.m == nil {
+		rd.m = make(map[*http2stream]*http2readDeadline)
+	}
+	d := &http2readDeadline{
+		timer: time.AfterFunc(time.Until(deadline), func() { http2onReadTimeout(st) }),
+	}
+	d.stop = context.AfterFunc(st.ctx, func() {
+		rd.mu.Lock()
+		defer rd.mu.Unlock()
+		if rd.m[st] == d {
+			d.timer.Stop()
+			delete(rd.m, st)
+		}
+	})
+	rd.m[st] = d
+	rd.mu.Unlock()
+	return nil
+}
+
+func (w *http2responseWriter) SetWriteDeadline(deadline time.Time) error {
+	st := w.rws.stream
+	if !deadline.IsZero() && deadline.Before(time.Now()) {
+		// If we're setting a deadline in the past, reset the stream immediately
+		// so writes after SetWriteDeadline returns will fail.
+		st.onWriteTimeout()
+		return nil
+	}
+	// The stream's write deadline timer belongs to the serve goroutine.
+	// Wait for it to apply the deadline, so that writes after
+	// SetWriteDeadline returns are subject to it.
+	sc := w.rws.conn
+	done := make(chan struct{})
+	sc.sendServeMsg(func(int) {
+		defer close(done)
+		if st.writeDeadline != nil {
+			if !st.writeDeadline.Stop() {
+				// Deadline already exceeded, or stream has been closed.
+				return
+			}
+		}
+		if deadline.IsZero() {
+			st.writeDeadline = nil
+		} else if st.writeDeadline == nil {
+			st.writeDeadline = time.AfterFunc(time.Until(deadline), st.onWriteTimeout)
+		} else {
+			st.writeDeadline.Reset(time.Until(deadline))
+		}
+	})
+	select {
+	case <-done:
+	case <-sc.doneServing:
+	}
+	return nil
+}
+
+func (w *http2responseWriter) EnableFullDuplex() error {
+	// We always support full duplex responses, so this is a no-op.
+	return nil
+}
+
+// FlushError is like Flush, but returns any error from writing
+// buffered data or the response header to the connection.
+func (w *http2responseWriter) FlushError() error {
+	rws := w.rws
+	if rws == nil {
+		panic("Header called after Handler finished")
+	}
+	var err error
+	if rws.bw.Buffered() > 0 {
+		err = rws.bw.Flush()
+	} else {
+		// The bufio.Writer won't call chunkWriter.Write
+		// (writeChunk with zero bytes, so we have to do it
+		// ourselves to force the HTTP response header and/or
+		// final DATA frame (with END_STREAM) to be sent.
+		_, err = rws.writeChunk(nil)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nethttpomithttp2
+
+package http
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// newReadDeadlineTestWriter returns the ResponseWriter of a stream of
+// a connection set up for read deadlines, and the stream's cancel
+// function, which the server calls when the stream is closed.
+func newReadDeadlineTestWriter(t *testing.T) (*http2responseWriter, *http2readDeadlines, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(http2withReadDeadlines(context.Background()))
+	t.Cleanup(cancel)
+	st := &http2stream{ctx: ctx, body: new(http2pipe)}
+	w := &http2responseWriter{rws: &http2responseWriterState{stream: st}}
+	return w, ctx.Value(http2readDeadlinesKey{}).(*http2readDeadlines), cancel
+}
+
+func (rd *http2readDeadlines) get(st *http2stream) *http2readDeadline {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	return rd.m[st]
+}
+
+func TestHTTP2SetReadDeadlineRepeatedly(t *testing.T) {
+	w, rd, cancel := newReadDeadlineTestWriter(t)
+	st := w.rws.stream
+	var prev *http2readDeadline
+	for i := 0; i < 10; i++ {
+		if err := w.SetReadDeadline(time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("SetReadDeadline = %v", err)
+		}
+		d := rd.get(st)
+		if d == nil {
+			t.Fatalf("after SetReadDeadline #%d, no deadline armed", i)
+		}
+		if prev != nil {
+			if prev.timer.Stop() {
+				t.Errorf("after SetReadDeadline #%d, previous timer still armed", i)
+			}
+			if prev.stop() {
+				t.Errorf("after SetReadDeadline #%d, previous cleanup still registered", i)
+			}
+		}
+		prev = d
+	}
+
+	if err := w.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatalf("SetReadDeadline(zero) = %v", err)
+	}
+	if d := rd.get(st); d != nil {
+		t.Errorf("after clearing the deadline, deadline still armed")
+	}
+	if prev.timer.Stop() || prev.stop() {
+		t.Errorf("after clearing the deadline, timer or cleanup still registered")
+	}
+
+	if err := w.SetReadDeadline(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetReadDeadline = %v", err)
+	}
+	cancel()
+	// The cleanup runs on its own goroutine.
+	for deadline := time.Now().Add(5 * time.Second); rd.get(st) != nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("after closing the stream, deadline still armed")
+		}
+	}
+}
+
+func TestHTTP2SetPastReadDeadlineStopsTimer(t *testing.T) {
+	w, rd, _ := newReadDeadlineTestWriter(t)
+	st := w.rws.stream
+	if err := w.SetReadDeadline(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetReadDeadline = %v", err)
+	}
+	d := rd.get(st)
+	if err := w.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline = %v", err)
+	}
+	if rd.get(st) != nil {
+		t.Errorf("after setting a past deadline, deadline still armed")
+	}
+	if d.timer.Stop() || d.stop() {
+		t.Errorf("after setting a past deadline, previous timer or cleanup still registered")
+	}
+	if _, err := st.body.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("body Read = %v, want os.ErrDeadlineExceeded", err)
+	}
+}
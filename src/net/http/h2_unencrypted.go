@@ -0,0 +1,79 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nethttpomithttp2
+
+package http
+
+// This file connects the bundled HTTP/2 server and transport to
+// unencrypted connections using HTTP/2 with prior knowledge (h2c).
+// It lives outside h2_bundle.go, which is generated from
+// golang.org/x/net/http2 and must not be edited.
+
+import "net"
+
+// http2configureUnencryptedServer prepares conf to serve connections
+// accepted by s that use HTTP/2 with prior knowledge over cleartext TCP.
+// Unlike http2ConfigureServer, it leaves the TLS configuration of s alone.
+func http2configureUnencryptedServer(s *Server, conf *http2Server) {
+	conf.state = &http2serverInternalState{activeConns: make(map[*http2serverConn]struct{})}
+	if conf.IdleTimeout == 0 {
+		if s.IdleTimeout != 0 {
+			conf.IdleTimeout = s.IdleTimeout
+		} else {
+			conf.IdleTimeout = s.ReadTimeout
+		}
+	}
+	s.RegisterOnShutdown(conf.state.startGracefulShutdown)
+}
+
+// http2configureUnencryptedTransport returns a Transport for the
+// cleartext connections t1 makes using HTTP/2 with prior knowledge.
+// Its connections are pooled separately from those of
+// http2configureTransport, so that a cleartext connection is never
+// used for an https request.
+func http2configureUnencryptedTransport(t1 *Transport) *http2Transport {
+	connPool := new(http2clientConnPool)
+	t2 := &http2Transport{
+		ConnPool:  http2noDialClientConnPool{connPool},
+		AllowHTTP: true,
+		t1:        t1,
+	}
+	connPool.t = t2
+	t2.hookConnPool()
+	return t2
+}
+
+// addUnencryptedConn starts HTTP/2 on c, a cleartext connection to
+// authority made for t, a Transport from http2configureUnencryptedTransport.
+// It returns the RoundTripper to use for requests to authority.
+//
+// If the pool already has a connection to authority that can take
+// a new request, c is closed instead.
+func (t *http2Transport) addUnencryptedConn(authority string, c net.Conn) RoundTripper {
+	p := t.clientConnPool()
+	key := http2authorityAddr("http", authority)
+	p.mu.Lock()
+	for _, cc := range p.conns[key] {
+		if cc.CanTakeNewRequest() {
+			p.mu.Unlock()
+			go c.Close()
+			return t
+		}
+	}
+	p.mu.Unlock()
+
+	cc, err := t.NewClientConn(c)
+	if err != nil {
+		go c.Close()
+		return http2erringRoundTripper{err}
+	}
+	p.mu.Lock()
+	p.addConnLocked(key, cc)
+	p.mu.Unlock()
+	if hp, ok := t.ConnPool.(*http2hookedConnPool); ok {
+		hp.added(key, c)
+	}
+	return t
+}
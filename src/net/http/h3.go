@@ -0,0 +1,922 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 (RFC 9114) framing shared by the client and server.
+// The QUIC transport lives in net/http/internal/quic; header
+// compression (QPACK) is in h3_qpack.go.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"net/http/internal/quic"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// http3NextProtoTLS is the ALPN protocol identifier for HTTP/3.
+const http3NextProtoTLS = "h3"
+
+// Stream types.
+//
+// For unidirectional streams, the value is the stream type sent over the wire.
+//
+// For bidirectional streams (which are always request streams),
+// the value is arbitrary and never sent on the wire.
+type http3StreamType int64
+
+const (
+	// Bidirectional request stream.
+	// All bidirectional streams are request streams.
+	// This stream type is never sent over the wire.
+	//
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-6.1
+	http3StreamTypeRequest = http3StreamType(-1)
+
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-6.2
+	http3StreamTypeControl = http3StreamType(0x00)
+	http3StreamTypePush    = http3StreamType(0x01)
+
+	// https://www.rfc-editor.org/rfc/rfc9204.html#section-4.2
+	http3StreamTypeEncoder = http3StreamType(0x02)
+	http3StreamTypeDecoder = http3StreamType(0x03)
+)
+
+func (stype http3StreamType) String() string {
+	switch stype {
+	case http3StreamTypeRequest:
+		return "request"
+	case http3StreamTypeControl:
+		return "control"
+	case http3StreamTypePush:
+		return "push"
+	case http3StreamTypeEncoder:
+		return "encoder"
+	case http3StreamTypeDecoder:
+		return "decoder"
+	default:
+		return "unknown"
+	}
+}
+
+// Frame types.
+type http3FrameType int64
+
+const (
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2
+	http3FrameTypeData        = http3FrameType(0x00)
+	http3FrameTypeHeaders     = http3FrameType(0x01)
+	http3FrameTypeCancelPush  = http3FrameType(0x03)
+	http3FrameTypeSettings    = http3FrameType(0x04)
+	http3FrameTypePushPromise = http3FrameType(0x05)
+	http3FrameTypeGoaway      = http3FrameType(0x07)
+	http3FrameTypeMaxPushID   = http3FrameType(0x0d)
+)
+
+func (ftype http3FrameType) String() string {
+	switch ftype {
+	case http3FrameTypeData:
+		return "DATA"
+	case http3FrameTypeHeaders:
+		return "HEADERS"
+	case http3FrameTypeCancelPush:
+		return "CANCEL_PUSH"
+	case http3FrameTypeSettings:
+		return "SETTINGS"
+	case http3FrameTypePushPromise:
+		return "PUSH_PROMISE"
+	case http3FrameTypeGoaway:
+		return "GOAWAY"
+	case http3FrameTypeMaxPushID:
+		return "MAX_PUSH_ID"
+	default:
+		return fmt.Sprintf("UNKNOWN_%d", int64(ftype))
+	}
+}
+
+// http3CanceledCtx is a canceled Context.
+// Used for performing non-blocking QUIC operations.
+var http3CanceledCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// http3Error is an HTTP/3 error code.
+type http3Error int
+
+const (
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-8.1
+	http3ErrNoError              = http3Error(0x0100)
+	http3ErrGeneralProtocolError = http3Error(0x0101)
+	http3ErrInternalError        = http3Error(0x0102)
+	http3ErrStreamCreationError  = http3Error(0x0103)
+	http3ErrClosedCriticalStream = http3Error(0x0104)
+	http3ErrFrameUnexpected      = http3Error(0x0105)
+	http3ErrFrameError           = http3Error(0x0106)
+	http3ErrExcessiveLoad        = http3Error(0x0107)
+	http3ErrIDError              = http3Error(0x0108)
+	http3ErrSettingsError        = http3Error(0x0109)
+	http3ErrMissingSettings      = http3Error(0x010a)
+	http3ErrRequestRejected      = http3Error(0x010b)
+	http3ErrRequestCancelled     = http3Error(0x010c)
+	http3ErrRequestIncomplete    = http3Error(0x010d)
+	http3ErrMessageError         = http3Error(0x010e)
+	http3ErrConnectError         = http3Error(0x010f)
+	http3ErrVersionFallback      = http3Error(0x0110)
+
+	// https://www.rfc-editor.org/rfc/rfc9204.html#section-8.3
+	http3ErrQPACKDecompressionFailed = http3Error(0x0200)
+	http3ErrQPACKEncoderStreamError  = http3Error(0x0201)
+	http3ErrQPACKDecoderStreamError  = http3Error(0x0202)
+)
+
+func (e http3Error) Error() string {
+	switch e {
+	case http3ErrNoError:
+		return "H3_NO_ERROR"
+	case http3ErrGeneralProtocolError:
+		return "H3_GENERAL_PROTOCOL_ERROR"
+	case http3ErrInternalError:
+		return "H3_INTERNAL_ERROR"
+	case http3ErrStreamCreationError:
+		return "H3_STREAM_CREATION_ERROR"
+	case http3ErrClosedCriticalStream:
+		return "H3_CLOSED_CRITICAL_STREAM"
+	case http3ErrFrameUnexpected:
+		return "H3_FRAME_UNEXPECTED"
+	case http3ErrFrameError:
+		return "H3_FRAME_ERROR"
+	case http3ErrExcessiveLoad:
+		return "H3_EXCESSIVE_LOAD"
+	case http3ErrIDError:
+		return "H3_ID_ERROR"
+	case http3ErrSettingsError:
+		return "H3_SETTINGS_ERROR"
+	case http3ErrMissingSettings:
+		return "H3_MISSING_SETTINGS"
+	case http3ErrRequestRejected:
+		return "H3_REQUEST_REJECTED"
+	case http3ErrRequestCancelled:
+		return "H3_REQUEST_CANCELLED"
+	case http3ErrRequestIncomplete:
+		return "H3_REQUEST_INCOMPLETE"
+	case http3ErrMessageError:
+		return "H3_MESSAGE_ERROR"
+	case http3ErrConnectError:
+		return "H3_CONNECT_ERROR"
+	case http3ErrVersionFallback:
+		return "H3_VERSION_FALLBACK"
+	case http3ErrQPACKDecompressionFailed:
+		return "QPACK_DECOMPRESSION_FAILED"
+	case http3ErrQPACKEncoderStreamError:
+		return "QPACK_ENCODER_STREAM_ERROR"
+	case http3ErrQPACKDecoderStreamError:
+		return "QPACK_DECODER_STREAM_ERROR"
+	}
+	return fmt.Sprintf("H3_ERROR_%v", int(e))
+}
+
+// An http3StreamError is an error which terminates a stream, but not the connection.
+// https://www.rfc-editor.org/rfc/rfc9114.html#section-8-1
+type http3StreamError struct {
+	code    http3Error
+	message string
+}
+
+func (e *http3StreamError) Error() string { return e.message }
+func (e *http3StreamError) Unwrap() error { return e.code }
+
+// An http3ConnectionError is an error which results in the entire connection closing.
+// https://www.rfc-editor.org/rfc/rfc9114.html#section-8-2
+type http3ConnectionError struct {
+	code    http3Error
+	message string
+}
+
+func (e *http3ConnectionError) Error() string { return e.message }
+func (e *http3ConnectionError) Unwrap() error { return e.code }
+
+// http3QUICConfig returns a QUIC configuration using tlsConfig,
+// which is cloned and adjusted for HTTP/3 if necessary.
+func http3QUICConfig(tlsConfig *tls.Config) *quic.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.MinVersion < tls.VersionTLS13 {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	tlsConfig.NextProtos = []string{http3NextProtoTLS}
+	return &quic.Config{TLSConfig: tlsConfig}
+}
+
+// An http3Stream wraps a QUIC stream, providing methods to read/write various values.
+type http3Stream struct {
+	stream *quic.Stream
+
+	// lim is the current read limit.
+	// Reading a frame header sets the limit to the end of the frame.
+	// Reading past the limit or reading less than the limit and ending the frame
+	// results in an error.
+	// -1 indicates no limit.
+	lim int64
+}
+
+// http3NewConnStream creates a new stream on a connection.
+// It writes the stream header for unidirectional streams.
+//
+// The returned stream is not flushed, and will not be sent to the
+// peer until the caller calls Flush or writes enough data to the stream.
+func http3NewConnStream(ctx context.Context, qconn *quic.Conn, stype http3StreamType) (*http3Stream, error) {
+	var qs *quic.Stream
+	var err error
+	if stype == http3StreamTypeRequest {
+		// Request streams are bidirectional.
+		qs, err = qconn.NewStream(ctx)
+	} else {
+		// All other streams are unidirectional.
+		qs, err = qconn.NewSendOnlyStream(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := http3NewStream(qs)
+	if stype != http3StreamTypeRequest {
+		// Unidirectional stream header.
+		st.writeVarint(int64(stype))
+	}
+	return st, nil
+}
+
+func http3NewStream(qs *quic.Stream) *http3Stream {
+	return &http3Stream{
+		stream: qs,
+		lim:    -1, // no limit
+	}
+}
+
+// readFrameHeader reads the type and length fields of an HTTP/3 frame.
+// It sets the read limit to the end of the frame.
+//
+// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.1
+func (st *http3Stream) readFrameHeader() (http3FrameType, error) {
+	if st.lim >= 0 {
+		// We shouldn't call readFrameHeader before ending the previous frame.
+		return 0, http3ErrFrameError
+	}
+	ftype, err := st.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	size, err := st.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	st.lim = size
+	return http3FrameType(ftype), nil
+}
+
+// endFrame is called after reading a frame to reset the read limit.
+// It returns an error if the entire contents of a frame have not been read.
+func (st *http3Stream) endFrame() error {
+	if st.lim != 0 {
+		return &http3ConnectionError{
+			code:    http3ErrFrameError,
+			message: "invalid HTTP/3 frame",
+		}
+	}
+	st.lim = -1
+	return nil
+}
+
+// ReadByte reads one byte from the stream.
+func (st *http3Stream) ReadByte() (b byte, err error) {
+	if err := st.recordBytesRead(1); err != nil {
+		return 0, err
+	}
+	b, err = st.stream.ReadByte()
+	if err != nil {
+		if err == io.EOF && st.lim < 0 {
+			return 0, io.EOF
+		}
+		return 0, http3ErrFrameError
+	}
+	return b, nil
+}
+
+// Read reads from the stream.
+func (st *http3Stream) Read(b []byte) (int, error) {
+	if st.lim == 0 {
+		// At the end of the current frame. Don't read the next one,
+		// and don't return 0, nil, which would make io.ReadFull spin.
+		return 0, io.EOF
+	}
+	n, err := st.stream.Read(b)
+	if e2 := st.recordBytesRead(n); e2 != nil {
+		return 0, e2
+	}
+	if err == io.EOF {
+		if st.lim == 0 {
+			// EOF at end of frame, ignore.
+			return n, nil
+		} else if st.lim > 0 {
+			// EOF inside frame, error.
+			return 0, http3ErrFrameError
+		} else {
+			// EOF outside of frame, surface to caller.
+			return n, io.EOF
+		}
+	}
+	if err != nil {
+		return 0, http3ErrFrameError
+	}
+	return n, nil
+}
+
+// discardUnknownFrame discards an unknown frame.
+//
+// HTTP/3 requires that unknown frames be ignored on all streams.
+// However, a known frame appearing in an unexpected place is a fatal error,
+// so this returns an error if the frame is one we know.
+func (st *http3Stream) discardUnknownFrame(ftype http3FrameType) error {
+	switch ftype {
+	case http3FrameTypeData,
+		http3FrameTypeHeaders,
+		http3FrameTypeCancelPush,
+		http3FrameTypeSettings,
+		http3FrameTypePushPromise,
+		http3FrameTypeGoaway,
+		http3FrameTypeMaxPushID:
+		return &http3ConnectionError{
+			code:    http3ErrFrameUnexpected,
+			message: "unexpected " + ftype.String() + " frame",
+		}
+	}
+	return st.discardFrame()
+}
+
+// discardFrame discards any remaining data in the current frame and resets the read limit.
+func (st *http3Stream) discardFrame() error {
+	for ; st.lim > 0; st.lim-- {
+		if _, err := st.stream.ReadByte(); err != nil {
+			return &http3StreamError{http3ErrFrameError, err.Error()}
+		}
+	}
+	st.lim = -1
+	return nil
+}
+
+// Write writes to the stream.
+func (st *http3Stream) Write(b []byte) (int, error) { return st.stream.Write(b) }
+
+// Flush commits data written to the stream.
+func (st *http3Stream) Flush() error { return st.stream.Flush() }
+
+// readVarint reads a QUIC variable-length integer from the stream.
+func (st *http3Stream) readVarint() (v int64, err error) {
+	b, err := st.stream.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v = int64(b & 0x3f)
+	n := 1 << (b >> 6)
+	for i := 1; i < n; i++ {
+		b, err := st.stream.ReadByte()
+		if err != nil {
+			return 0, http3ErrFrameError
+		}
+		v = (v << 8) | int64(b)
+	}
+	if err := st.recordBytesRead(n); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// writeVarint writes a QUIC variable-length integer to the stream.
+func (st *http3Stream) writeVarint(v int64) {
+	switch {
+	case v <= (1<<6)-1:
+		st.stream.WriteByte(byte(v))
+	case v <= (1<<14)-1:
+		st.stream.WriteByte((1 << 6) | byte(v>>8))
+		st.stream.WriteByte(byte(v))
+	case v <= (1<<30)-1:
+		st.stream.WriteByte((2 << 6) | byte(v>>24))
+		st.stream.WriteByte(byte(v >> 16))
+		st.stream.WriteByte(byte(v >> 8))
+		st.stream.WriteByte(byte(v))
+	case v <= (1<<62)-1:
+		st.stream.WriteByte((3 << 6) | byte(v>>56))
+		st.stream.WriteByte(byte(v >> 48))
+		st.stream.WriteByte(byte(v >> 40))
+		st.stream.WriteByte(byte(v >> 32))
+		st.stream.WriteByte(byte(v >> 24))
+		st.stream.WriteByte(byte(v >> 16))
+		st.stream.WriteByte(byte(v >> 8))
+		st.stream.WriteByte(byte(v))
+	default:
+		panic("varint too large")
+	}
+}
+
+// recordBytesRead records that n bytes have been read.
+// It returns an error if the read passes the current limit.
+func (st *http3Stream) recordBytesRead(n int) error {
+	if st.lim < 0 {
+		return nil
+	}
+	st.lim -= int64(n)
+	if st.lim < 0 {
+		st.stream = nil // panic if we try to read again
+		return &http3ConnectionError{
+			code:    http3ErrFrameError,
+			message: "invalid HTTP/3 frame",
+		}
+	}
+	return nil
+}
+
+// http3SizeVarint returns the size of the variable-length integer encoding of v.
+func http3SizeVarint(v uint64) int {
+	switch {
+	case v <= 63:
+		return 1
+	case v <= 16383:
+		return 2
+	case v <= 1073741823:
+		return 4
+	case v <= 4611686018427387903:
+		return 8
+	default:
+		panic("varint too large")
+	}
+}
+
+const (
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.4.1
+	http3SettingsMaxFieldSectionSize = 0x06
+
+	// https://www.rfc-editor.org/rfc/rfc9204.html#section-5
+	http3SettingsQPACKMaxTableCapacity = 0x01
+	http3SettingsQPACKBlockedStreams   = 0x07
+)
+
+// writeSettings writes a complete SETTINGS frame.
+// Its parameter is a list of alternating setting types and values.
+func (st *http3Stream) writeSettings(settings ...int64) {
+	var size int64
+	for _, s := range settings {
+		// Settings values that don't fit in a QUIC varint ([0,2^62)) will panic here.
+		size += int64(http3SizeVarint(uint64(s)))
+	}
+	st.writeVarint(int64(http3FrameTypeSettings))
+	st.writeVarint(size)
+	for _, s := range settings {
+		st.writeVarint(s)
+	}
+}
+
+// readSettings reads a complete SETTINGS frame, including the frame header.
+func (st *http3Stream) readSettings(f func(settingType, value int64) error) error {
+	frameType, err := st.readFrameHeader()
+	if err != nil || frameType != http3FrameTypeSettings {
+		return &http3ConnectionError{
+			code:    http3ErrMissingSettings,
+			message: "settings not sent on control stream",
+		}
+	}
+	for st.lim > 0 {
+		settingsType, err := st.readVarint()
+		if err != nil {
+			return err
+		}
+		settingsValue, err := st.readVarint()
+		if err != nil {
+			return err
+		}
+
+		// Use of HTTP/2 settings where there is no corresponding HTTP/3 setting
+		// is an error.
+		// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.4.1-5
+		switch settingsType {
+		case 0x02, 0x03, 0x04, 0x05:
+			return &http3ConnectionError{
+				code:    http3ErrSettingsError,
+				message: "use of reserved setting",
+			}
+		}
+
+		if err := f(settingsType, settingsValue); err != nil {
+			return err
+		}
+	}
+	return st.endFrame()
+}
+
+// http3StreamHandler is implemented by the client and server
+// connections to handle streams created by the peer.
+type http3StreamHandler interface {
+	handleControlStream(*http3Stream) error
+	handlePushStream(*http3Stream) error
+	handleEncoderStream(*http3Stream) error
+	handleDecoderStream(*http3Stream) error
+	handleRequestStream(*http3Stream) error
+	abort(error)
+}
+
+// http3GenericConn holds the state common to client and server connections.
+type http3GenericConn struct {
+	mu sync.Mutex
+
+	// The peer may create exactly one control, encoder, and decoder stream.
+	// streamsCreated is a bitset of streams created so far.
+	// Bits are 1 << streamType.
+	streamsCreated uint8
+}
+
+// acceptStreams accepts streams created by the peer until the
+// connection closes.
+func (c *http3GenericConn) acceptStreams(qconn *quic.Conn, h http3StreamHandler) {
+	for {
+		// Use context.Background: This blocks until a stream is accepted
+		// or the connection closes.
+		st, err := qconn.AcceptStream(context.Background())
+		if err != nil {
+			return // connection closed
+		}
+		if st.IsReadOnly() {
+			go c.handleUnidirectionalStream(http3NewStream(st), h)
+		} else {
+			go c.handleRequestStream(http3NewStream(st), h)
+		}
+	}
+}
+
+func (c *http3GenericConn) handleUnidirectionalStream(st *http3Stream, h http3StreamHandler) {
+	// Unidirectional stream header: One varint with the stream type.
+	v, err := st.readVarint()
+	if err != nil {
+		h.abort(&http3ConnectionError{
+			code:    http3ErrStreamCreationError,
+			message: "error reading unidirectional stream header",
+		})
+		return
+	}
+	stype := http3StreamType(v)
+	if err := c.checkStreamCreation(stype); err != nil {
+		h.abort(err)
+		return
+	}
+	switch stype {
+	case http3StreamTypeControl:
+		err = h.handleControlStream(st)
+	case http3StreamTypePush:
+		err = h.handlePushStream(st)
+	case http3StreamTypeEncoder:
+		err = h.handleEncoderStream(st)
+	case http3StreamTypeDecoder:
+		err = h.handleDecoderStream(st)
+	default:
+		// "Recipients of unknown stream types MUST either abort reading
+		// of the stream or discard incoming data without further processing."
+		// https://www.rfc-editor.org/rfc/rfc9114.html#section-6.2-7
+		err = nil
+	}
+	if err == io.EOF {
+		err = &http3ConnectionError{
+			code:    http3ErrClosedCriticalStream,
+			message: stype.String() + " stream closed",
+		}
+	}
+	c.handleStreamError(st, h, err)
+}
+
+func (c *http3GenericConn) handleRequestStream(st *http3Stream, h http3StreamHandler) {
+	c.handleStreamError(st, h, h.handleRequestStream(st))
+}
+
+func (c *http3GenericConn) handleStreamError(st *http3Stream, h http3StreamHandler, err error) {
+	switch err := err.(type) {
+	case *http3ConnectionError:
+		h.abort(err)
+	case nil:
+		st.stream.CloseRead()
+		st.stream.CloseWrite()
+	case *http3StreamError:
+		st.stream.CloseRead()
+		st.stream.Reset(uint64(err.code))
+	default:
+		st.stream.CloseRead()
+		st.stream.Reset(uint64(http3ErrInternalError))
+	}
+}
+
+func (c *http3GenericConn) checkStreamCreation(stype http3StreamType) error {
+	switch stype {
+	case http3StreamTypeControl, http3StreamTypeEncoder, http3StreamTypeDecoder:
+		// The peer may create exactly one control, encoder, and decoder stream.
+	default:
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bit := uint8(1) << stype
+	if c.streamsCreated&bit != 0 {
+		return &http3ConnectionError{
+			code:    http3ErrStreamCreationError,
+			message: "multiple " + stype.String() + " streams created",
+		}
+	}
+	c.streamsCreated |= bit
+	return nil
+}
+
+// readControlSettings reads the SETTINGS frame which starts a control stream.
+func (st *http3Stream) readControlSettings() error {
+	// "A SETTINGS frame MUST be sent as the first frame of each control stream [...]"
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.4-2
+	return st.readSettings(func(settingsType, settingsValue int64) error {
+		// We don't use the dynamic table and don't limit the
+		// header size the peer may send, so none of the settings
+		// we know about change anything yet. Unknown settings
+		// types are ignored.
+		return nil
+	})
+}
+
+// http3ExtractTrailerFromHeader extracts the "Trailer" header values from a header
+// map, and populates a trailer map with those values as keys. The extracted
+// header values will be canonicalized.
+func http3ExtractTrailerFromHeader(header, trailer Header) {
+	for _, names := range header["Trailer"] {
+		foreachHeaderElement(names, func(name string) {
+			name = CanonicalHeaderKey(name)
+			if !httpguts.ValidTrailerHeader(name) {
+				return
+			}
+			trailer[name] = nil
+		})
+	}
+}
+
+// An http3BodyWriter writes a request or response body to a stream
+// as a series of DATA frames.
+type http3BodyWriter struct {
+	st      *http3Stream
+	remain  int64              // -1 when content-length is not known
+	flush   bool               // flush the stream after every write
+	name    string             // "request" or "response"
+	trailer Header             // trailer headers that will be written once the bodyWriter is closed.
+	enc     *http3QPACKEncoder // QPACK encoder used by the connection.
+}
+
+func (w *http3BodyWriter) write(ps ...[]byte) (n int, err error) {
+	var size int64
+	for _, p := range ps {
+		size += int64(len(p))
+	}
+	// If write is called with empty byte slices, just return instead of
+	// sending out a DATA frame containing nothing.
+	if size == 0 {
+		return 0, nil
+	}
+	if w.remain >= 0 && size > w.remain {
+		return 0, &http3StreamError{
+			code:    http3ErrInternalError,
+			message: w.name + " body longer than specified content length",
+		}
+	}
+	w.st.writeVarint(int64(http3FrameTypeData))
+	w.st.writeVarint(size)
+	for _, p := range ps {
+		var n2 int
+		n2, err = w.st.Write(p)
+		n += n2
+		if w.remain >= 0 {
+			w.remain -= int64(n2)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if w.flush && err == nil {
+		err = w.st.Flush()
+	}
+	if err != nil {
+		err = fmt.Errorf("writing %v body: %w", w.name, err)
+	}
+	return n, err
+}
+
+func (w *http3BodyWriter) Write(p []byte) (n int, err error) {
+	return w.write(p)
+}
+
+func (w *http3BodyWriter) Close() error {
+	if w.remain > 0 {
+		return errors.New(w.name + " body shorter than specified content length")
+	}
+	if len(w.trailer) > 0 {
+		encTrailer := w.enc.encode(func(f func(itype http3IndexType, name, value string)) {
+			for name, values := range w.trailer {
+				if !httpguts.ValidHeaderFieldName(name) {
+					continue
+				}
+				for _, val := range values {
+					if !httpguts.ValidHeaderFieldValue(val) {
+						continue
+					}
+					f(http3MayIndex, name, val)
+				}
+			}
+		})
+		w.st.writeVarint(int64(http3FrameTypeHeaders))
+		w.st.writeVarint(int64(len(encTrailer)))
+		w.st.Write(encTrailer)
+	}
+	if w.st != nil && w.st.stream != nil {
+		w.st.stream.CloseWrite()
+	}
+	return nil
+}
+
+// An http3BodyReader reads a request or response body from a stream.
+type http3BodyReader struct {
+	st *http3Stream
+
+	mu     sync.Mutex
+	remain int64
+	err    error
+	// If not nil, the body contains an "Expect: 100-continue" header, and
+	// send100Continue should be called when Read is invoked for the first
+	// time.
+	send100Continue func()
+	// A map where the key represents the trailer header names we expect. If
+	// there is a HEADERS frame after reading DATA frames to EOF, the value of
+	// the headers will be written here, provided that the name of the header
+	// exists in the map already.
+	trailer Header
+}
+
+func (r *http3BodyReader) Read(p []byte) (n int, err error) {
+	// The HTTP/1 and HTTP/2 implementations both permit concurrent reads from a body,
+	// in the sense that the race detector won't complain.
+	// Use a mutex here to provide the same behavior.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.send100Continue != nil {
+		r.send100Continue()
+		r.send100Continue = nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	defer func() {
+		if err != nil {
+			r.err = err
+		}
+	}()
+	if r.st.lim == 0 {
+		// We've finished reading the previous DATA frame, so end it.
+		if err := r.st.endFrame(); err != nil {
+			return 0, err
+		}
+	}
+	// Read the next DATA frame header,
+	// if we aren't already in the middle of one.
+	for r.st.lim < 0 {
+		ftype, err := r.st.readFrameHeader()
+		if err == io.EOF && r.remain > 0 {
+			return 0, &http3StreamError{
+				code:    http3ErrMessageError,
+				message: "body shorter than content-length",
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch ftype {
+		case http3FrameTypeData:
+			if r.remain >= 0 && r.st.lim > r.remain {
+				return 0, &http3StreamError{
+					code:    http3ErrMessageError,
+					message: "body longer than content-length",
+				}
+			}
+			// Fall out of the loop and process the frame body below.
+		case http3FrameTypeHeaders:
+			// This HEADERS frame contains the message trailers.
+			if r.remain > 0 {
+				return 0, &http3StreamError{
+					code:    http3ErrMessageError,
+					message: "body shorter than content-length",
+				}
+			}
+			var dec http3QPACKDecoder
+			if err := dec.decode(r.st, func(_ http3IndexType, name, value string) error {
+				name = textproto.CanonicalMIMEHeaderKey(textproto.TrimString(name))
+				if _, ok := r.trailer[name]; ok {
+					r.trailer.Add(name, value)
+				}
+				return nil
+			}); err != nil {
+				return 0, err
+			}
+			if err := r.st.discardFrame(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		default:
+			if err := r.st.discardUnknownFrame(ftype); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// We are now reading the content of a DATA frame.
+	// Fill the read buffer or read to the end of the frame,
+	// whichever comes first.
+	if int64(len(p)) > r.st.lim {
+		p = p[:r.st.lim]
+	}
+	n, err = r.st.Read(p)
+	if r.remain > 0 {
+		r.remain -= int64(n)
+	}
+	return n, err
+}
+
+func (r *http3BodyReader) Close() error {
+	// Calling Close concurrently with Read will interrupt the read.
+	r.st.stream.CloseRead()
+	// Make sure that any data that has already been written to the
+	// bodyReader cannot be read after it has been closed.
+	r.mu.Lock()
+	r.err = net.ErrClosed
+	r.remain = 0
+	r.mu.Unlock()
+	return nil
+}
+
+// http3ValidWireHeaderFieldName reports whether v is a valid header field
+// name (key). See httpguts.ValidHeaderFieldName for the base rules.
+//
+// Further, http3 says:
+// "A request or response containing uppercase characters in field names MUST
+// be treated as malformed."
+//
+// This function does not validate whether a pseudo-header field name is valid.
+func http3ValidWireHeaderFieldName(v string) bool {
+	if len(v) == 0 {
+		return false
+	}
+	for _, r := range v {
+		if !httpguts.IsTokenRune(r) {
+			return false
+		}
+		if 'A' <= r && r <= 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// http3HasDisallowedConnectionHeader reports whether h contains
+// connection-specific headers that are not allowed in HTTP/3:
+//
+// "An endpoint MUST NOT generate an HTTP/3 field section containing
+// connection-specific fields; any message containing connection-specific
+// fields MUST be treated as malformed."
+//
+// "The only exception to this is the TE header field, which MAY be present in
+// an HTTP/3 request header; when it is, it MUST NOT contain any value other
+// than "trailers"."
+func http3HasDisallowedConnectionHeader(h Header) bool {
+	for _, k := range []string{
+		"Connection",
+		"Keep-Alive",
+		"Proxy-Connection",
+		"Transfer-Encoding",
+		"Upgrade",
+	} {
+		if _, ok := h[k]; ok {
+			return true
+		}
+	}
+	if te, ok := h["Te"]; ok && (len(te) != 1 || !strings.EqualFold(te[0], "trailers")) {
+		return true
+	}
+	return false
+}
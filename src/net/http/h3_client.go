@@ -0,0 +1,899 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 client, and the Alt-Svc cache Transport uses to find
+// HTTP/3 endpoints.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"net/http/internal/quic"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	// http3DefaultAltSvcMaxAge is the freshness lifetime of an
+	// alternative service without a "ma" parameter (RFC 7838, section 3.1).
+	http3DefaultAltSvcMaxAge = 24 * time.Hour
+
+	// http3BrokenAltSvcTimeout is how long the Transport avoids an
+	// HTTP/3 alternative after failing to connect to it.
+	http3BrokenAltSvcTimeout = 5 * time.Minute
+)
+
+// http3Transport holds the HTTP/3 state of a Transport: the alternative
+// services advertised by origins, and the connections made to them.
+type http3Transport struct {
+	mu       sync.Mutex
+	alts     map[string]*http3Alternative // keyed by origin host:port
+	conns    map[string]*http3ClientConn  // keyed by http3ConnKey
+	endpoint *quic.Endpoint
+	dialing  int // dials in progress using endpoint
+}
+
+// An http3Alternative is an HTTP/3 alternative service for an origin.
+type http3Alternative struct {
+	addr        string // host:port to connect to
+	expires     time.Time
+	brokenUntil time.Time // don't use before this time
+}
+
+// http3ConnKey returns the key for a connection to addr
+// authenticating as serverName.
+func http3ConnKey(addr, serverName string) string {
+	return addr + "|" + serverName
+}
+
+// roundTripHTTP3 sends req using HTTP/3 if the origin in cm has
+// advertised an HTTP/3 alternative. It reports false if it did not
+// attempt the request, in which case the caller uses HTTP/1 or HTTP/2.
+func (t *Transport) roundTripHTTP3(req *Request, cm connectMethod) (*Response, error, bool) {
+	t3 := &t.h3
+	addr, ok := t3.alternative(cm.targetAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	serverName := cm.tlsHost()
+	cc, err := t3.getClientConn(req.Context(), t, addr, serverName)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err(), true
+		}
+		t3.markBroken(cm.targetAddr, addr)
+		return nil, nil, false
+	}
+	resp, err := cc.RoundTrip(req)
+	return resp, err, true
+}
+
+// alternative returns the address of the usable HTTP/3 alternative
+// for origin, if there is one.
+func (t3 *http3Transport) alternative(origin string) (addr string, ok bool) {
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	alt := t3.alts[origin]
+	if alt == nil {
+		return "", false
+	}
+	now := time.Now()
+	if now.After(alt.expires) {
+		delete(t3.alts, origin)
+		return "", false
+	}
+	if now.Before(alt.brokenUntil) {
+		return "", false
+	}
+	return alt.addr, true
+}
+
+// markBroken records that the HTTP/3 alternative addr for origin
+// could not be reached.
+func (t3 *http3Transport) markBroken(origin, addr string) {
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	if alt := t3.alts[origin]; alt != nil && alt.addr == addr {
+		alt.brokenUntil = time.Now().Add(http3BrokenAltSvcTimeout)
+	}
+}
+
+// processAltSvc records the HTTP/3 alternative, if any, advertised by
+// the Alt-Svc header of a response from origin.
+func (t3 *http3Transport) processAltSvc(origin string, h Header) {
+	values, ok := h["Alt-Svc"]
+	if !ok {
+		return
+	}
+	authority, maxAge, clear := http3ParseAltSvc(values)
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	if clear {
+		delete(t3.alts, origin)
+		return
+	}
+	if authority == "" {
+		return
+	}
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return
+	}
+	if host == "" {
+		// The alternative is on the origin's host.
+		host, _, err = net.SplitHostPort(origin)
+		if err != nil {
+			return
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+	if t3.alts == nil {
+		t3.alts = make(map[string]*http3Alternative)
+	}
+	alt := t3.alts[origin]
+	if alt == nil || alt.addr != addr {
+		alt = &http3Alternative{addr: addr}
+		t3.alts[origin] = alt
+	}
+	alt.expires = time.Now().Add(maxAge)
+}
+
+// http3ParseAltSvc parses the values of an Alt-Svc header (RFC 7838).
+// It returns the alternative authority of the first HTTP/3 alternative
+// and its freshness lifetime, and reports whether the values clear all
+// alternatives instead.
+func http3ParseAltSvc(values []string) (authority string, maxAge time.Duration, clear bool) {
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entry = textproto.TrimString(entry)
+			if entry == "clear" {
+				return "", 0, true
+			}
+			params := strings.Split(entry, ";")
+			i := strings.IndexByte(params[0], '=')
+			if i < 0 || textproto.TrimString(params[0][:i]) != http3NextProtoTLS || authority != "" {
+				continue
+			}
+			alt, ok := http3UnquoteAltSvcValue(textproto.TrimString(params[0][i+1:]))
+			if !ok {
+				continue
+			}
+			age := http3DefaultAltSvcMaxAge
+			for _, p := range params[1:] {
+				p = textproto.TrimString(p)
+				if !strings.HasPrefix(p, "ma=") {
+					continue
+				}
+				if s, err := strconv.ParseUint(p[len("ma="):], 10, 32); err == nil {
+					age = time.Duration(s) * time.Second
+				}
+			}
+			authority, maxAge = alt, age
+		}
+	}
+	return authority, maxAge, false
+}
+
+// http3UnquoteAltSvcValue returns the value of an alt-authority,
+// which is a token or quoted-string.
+func http3UnquoteAltSvcValue(v string) (string, bool) {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+		if strings.ContainsAny(v, `"\`) {
+			// The values we understand never need escaping.
+			return "", false
+		}
+		return v, true
+	}
+	if v == "" || !strings.Contains(v, ":") {
+		return "", false
+	}
+	return v, true
+}
+
+// getClientConn returns a connection to addr, authenticating the
+// server as serverName, dialing one if necessary.
+func (t3 *http3Transport) getClientConn(ctx context.Context, t *Transport, addr, serverName string) (*http3ClientConn, error) {
+	key := http3ConnKey(addr, serverName)
+	t3.mu.Lock()
+	if cc := t3.conns[key]; cc != nil {
+		t3.mu.Unlock()
+		return cc, nil
+	}
+	if t3.endpoint == nil {
+		e, err := quic.Listen("udp", ":0", nil)
+		if err != nil {
+			t3.mu.Unlock()
+			return nil, err
+		}
+		t3.endpoint = e
+	}
+	e := t3.endpoint
+	t3.dialing++
+	t3.mu.Unlock()
+
+	tlsConfig := cloneTLSConfig(t.TLSClientConfig)
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	qconn, err := e.Dial(ctx, "udp", addr, http3QUICConfig(tlsConfig))
+
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	t3.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if cc := t3.conns[key]; cc != nil {
+		// Lost a race with another dial; use its connection.
+		go qconn.Close()
+		return cc, nil
+	}
+	cc, err := t3.newClientConn(qconn, key)
+	if err != nil {
+		return nil, err
+	}
+	if t3.conns == nil {
+		t3.conns = make(map[string]*http3ClientConn)
+	}
+	t3.conns[key] = cc
+	return cc, nil
+}
+
+// removeConn removes cc from the pool, so it is not used for new requests.
+func (t3 *http3Transport) removeConn(cc *http3ClientConn) {
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	if t3.conns[cc.key] == cc {
+		delete(t3.conns, cc.key)
+	}
+}
+
+// closeIdleConns closes connections with no requests in flight. Once
+// no connections remain, it also closes the QUIC endpoint.
+func (t3 *http3Transport) closeIdleConns() {
+	t3.mu.Lock()
+	defer t3.mu.Unlock()
+	for key, cc := range t3.conns {
+		if cc.idle() {
+			delete(t3.conns, key)
+			go cc.qconn.Close()
+		}
+	}
+	if len(t3.conns) == 0 && t3.dialing == 0 && t3.endpoint != nil {
+		go t3.endpoint.Close(context.Background())
+		t3.endpoint = nil
+	}
+}
+
+// An http3ClientConn is a client HTTP/3 connection.
+//
+// Multiple goroutines may invoke methods on an http3ClientConn simultaneously.
+type http3ClientConn struct {
+	t3    *http3Transport
+	key   string
+	qconn *quic.Conn
+	http3GenericConn
+
+	enc http3QPACKEncoder
+	dec http3QPACKDecoder
+
+	inFlight int32 // requests in flight; guarded by http3GenericConn.mu
+}
+
+// newClientConn starts an HTTP/3 connection on qconn.
+// t3.mu must be held.
+func (t3 *http3Transport) newClientConn(qconn *quic.Conn, key string) (*http3ClientConn, error) {
+	cc := &http3ClientConn{
+		t3:    t3,
+		key:   key,
+		qconn: qconn,
+	}
+	cc.enc.init()
+
+	// Create control stream and send SETTINGS frame.
+	controlStream, err := http3NewConnStream(http3CanceledCtx, cc.qconn, http3StreamTypeControl)
+	if err != nil {
+		qconn.Abort(nil)
+		return nil, fmt.Errorf("http3: cannot create control stream: %v", err)
+	}
+	controlStream.writeSettings()
+	controlStream.Flush()
+
+	go func() {
+		cc.acceptStreams(qconn, cc)
+		t3.removeConn(cc)
+	}()
+	return cc, nil
+}
+
+func (cc *http3ClientConn) idle() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.inFlight == 0
+}
+
+func (cc *http3ClientConn) handleControlStream(st *http3Stream) error {
+	if err := st.readControlSettings(); err != nil {
+		return err
+	}
+	for {
+		ftype, err := st.readFrameHeader()
+		if err != nil {
+			return err
+		}
+		switch ftype {
+		case http3FrameTypeCancelPush:
+			// "If a CANCEL_PUSH frame is received that references a push ID
+			// greater than currently allowed on the connection,
+			// this MUST be treated as a connection error of type H3_ID_ERROR."
+			// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.3-7
+			return &http3ConnectionError{
+				code:    http3ErrIDError,
+				message: "CANCEL_PUSH received when no MAX_PUSH_ID has been sent",
+			}
+		case http3FrameTypeGoaway:
+			// The server is going away. Requests in flight may
+			// complete, but don't start new ones.
+			cc.t3.removeConn(cc)
+			if err := st.discardFrame(); err != nil {
+				return err
+			}
+		default:
+			// Unknown frames are ignored.
+			if err := st.discardUnknownFrame(ftype); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (cc *http3ClientConn) handleEncoderStream(*http3Stream) error {
+	// We advertise no dynamic table, so the peer has no
+	// reason to send anything here.
+	return nil
+}
+
+func (cc *http3ClientConn) handleDecoderStream(*http3Stream) error {
+	return nil
+}
+
+func (cc *http3ClientConn) handlePushStream(*http3Stream) error {
+	// "A client MUST treat receipt of a push stream as a connection error
+	// of type H3_ID_ERROR when no MAX_PUSH_ID frame has been sent [...]"
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-4.6-3
+	return &http3ConnectionError{
+		code:    http3ErrIDError,
+		message: "push stream created when no MAX_PUSH_ID has been sent",
+	}
+}
+
+func (cc *http3ClientConn) handleRequestStream(st *http3Stream) error {
+	// "Clients MUST treat receipt of a server-initiated bidirectional
+	// stream as a connection error of type H3_STREAM_CREATION_ERROR [...]"
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-6.1-3
+	return &http3ConnectionError{
+		code:    http3ErrStreamCreationError,
+		message: "server created bidirectional stream",
+	}
+}
+
+// abort closes the connection with an error.
+func (cc *http3ClientConn) abort(err error) {
+	if e, ok := err.(*http3ConnectionError); ok {
+		cc.qconn.Abort(&quic.ApplicationError{
+			Code:   uint64(e.code),
+			Reason: e.message,
+		})
+	} else {
+		cc.qconn.Abort(err)
+	}
+}
+
+type http3RoundTripState struct {
+	cc *http3ClientConn
+	st *http3Stream
+
+	// Request body, provided by the caller.
+	onceCloseReqBody sync.Once
+	reqBody          io.ReadCloser
+
+	reqBodyWriter http3BodyWriter
+
+	// Response.Body, provided to the caller.
+	respBody io.ReadCloser
+
+	trace *httptrace.ClientTrace
+
+	errOnce sync.Once
+	err     error
+}
+
+// abort terminates the RoundTrip.
+// It returns the first fatal error encountered by the RoundTrip call.
+func (rt *http3RoundTripState) abort(err error) error {
+	rt.errOnce.Do(func() {
+		rt.err = err
+		switch e := err.(type) {
+		case *http3ConnectionError:
+			rt.cc.abort(e)
+		case *http3StreamError:
+			rt.st.stream.CloseRead()
+			rt.st.stream.Reset(uint64(e.code))
+		default:
+			rt.st.stream.CloseRead()
+			rt.st.stream.Reset(uint64(http3ErrNoError))
+		}
+		rt.cc.mu.Lock()
+		rt.cc.inFlight--
+		rt.cc.mu.Unlock()
+	})
+	return rt.err
+}
+
+// closeReqBody closes the Request.Body, at most once.
+func (rt *http3RoundTripState) closeReqBody() {
+	if rt.reqBody != nil {
+		rt.onceCloseReqBody.Do(func() {
+			rt.reqBody.Close()
+		})
+	}
+}
+
+// RoundTrip sends a request on the connection.
+func (cc *http3ClientConn) RoundTrip(req *Request) (_ *Response, err error) {
+	// Each request gets its own QUIC stream.
+	st, err := http3NewConnStream(req.Context(), cc.qconn, http3StreamTypeRequest)
+	if err != nil {
+		req.closeBody()
+		return nil, err
+	}
+	cc.mu.Lock()
+	cc.inFlight++
+	cc.mu.Unlock()
+	rt := &http3RoundTripState{
+		cc:      cc,
+		st:      st,
+		trace:   httptrace.ContextClientTrace(req.Context()),
+		reqBody: req.Body,
+	}
+	if rt.reqBody == nil {
+		rt.reqBody = NoBody
+	}
+	defer func() {
+		if err != nil {
+			rt.closeReqBody()
+			err = rt.abort(err)
+		}
+	}()
+
+	// Cancel reads/writes on the stream when the request expires.
+	st.stream.SetReadContext(req.Context())
+	st.stream.SetWriteContext(req.Context())
+
+	headers, err := cc.encodeHeaders(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the HEADERS frame.
+	st.writeVarint(int64(http3FrameTypeHeaders))
+	st.writeVarint(int64(len(headers)))
+	st.Write(headers)
+	if err := st.Flush(); err != nil {
+		return nil, err
+	}
+	if rt.trace != nil && rt.trace.WroteHeaders != nil {
+		rt.trace.WroteHeaders()
+	}
+
+	var bodyAndTrailerWritten bool
+	is100ContinueReq := req.expectsContinue()
+	if is100ContinueReq {
+		if rt.trace != nil && rt.trace.Wait100Continue != nil {
+			rt.trace.Wait100Continue()
+		}
+	} else {
+		bodyAndTrailerWritten = true
+		go cc.writeBodyAndTrailer(rt, req)
+	}
+
+	// Read the response headers.
+	for {
+		ftype, err := st.readFrameHeader()
+		if err != nil {
+			return nil, err
+		}
+		switch ftype {
+		case http3FrameTypeHeaders:
+			statusCode, h, err := cc.handleHeaders(st)
+			if err != nil {
+				return nil, err
+			}
+
+			if statusCode >= 100 && statusCode <= 199 {
+				if rt.trace != nil && rt.trace.Got1xxResponse != nil {
+					if err := rt.trace.Got1xxResponse(statusCode, textproto.MIMEHeader(h)); err != nil {
+						return nil, err
+					}
+				}
+				if statusCode == StatusContinue {
+					if rt.trace != nil && rt.trace.Got100Continue != nil {
+						rt.trace.Got100Continue()
+					}
+					if is100ContinueReq && !bodyAndTrailerWritten {
+						bodyAndTrailerWritten = true
+						go cc.writeBodyAndTrailer(rt, req)
+					}
+				}
+				continue
+			}
+
+			// We have the response headers.
+			// Set up the response and return it to the caller.
+			contentLength, err := http3ParseResponseContentLength(req.Method, statusCode, h)
+			if err != nil {
+				return nil, err
+			}
+
+			trailer := make(Header)
+			http3ExtractTrailerFromHeader(h, trailer)
+			delete(h, "Trailer")
+
+			if (contentLength != 0 && req.Method != "HEAD") || len(trailer) > 0 {
+				rt.respBody = &http3BodyReader{
+					st:      st,
+					remain:  contentLength,
+					trailer: trailer,
+				}
+			} else {
+				rt.respBody = NoBody
+			}
+			state := cc.qconn.ConnectionState()
+			return &Response{
+				Status:        strconv.Itoa(statusCode) + " " + StatusText(statusCode),
+				StatusCode:    statusCode,
+				Proto:         "HTTP/3.0",
+				ProtoMajor:    3,
+				Header:        h,
+				ContentLength: contentLength,
+				Trailer:       trailer,
+				Body:          (*http3TransportResponseBody)(rt),
+				Request:       req,
+				TLS:           &state,
+			}, nil
+		case http3FrameTypePushPromise:
+			// "A client MUST treat receipt of a PUSH_PROMISE frame that contains a
+			// larger push ID than the client has advertised as a connection error of H3_ID_ERROR."
+			// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.5-5
+			return nil, &http3ConnectionError{
+				code:    http3ErrIDError,
+				message: "PUSH_PROMISE received when no MAX_PUSH_ID has been sent",
+			}
+		default:
+			if err := st.discardUnknownFrame(ftype); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// encodeHeaders returns the QPACK-encoded header section for req.
+func (cc *http3ClientConn) encodeHeaders(req *Request) ([]byte, error) {
+	if err := http3CheckConnHeaders(req); err != nil {
+		return nil, err
+	}
+	trailers, err := http3CommaSeparatedTrailers(req)
+	if err != nil {
+		return nil, err
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host, err = httpguts.PunycodeHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	var path string
+	if req.Method != "CONNECT" {
+		path = req.URL.RequestURI()
+		if !http3ValidPseudoPath(path) {
+			orig := path
+			path = strings.TrimPrefix(path, req.URL.Scheme+"://"+host)
+			if !http3ValidPseudoPath(path) {
+				return nil, fmt.Errorf("invalid request :path %q", orig)
+			}
+		}
+	}
+	contentLength := req.outgoingLength()
+	trace := httptrace.ContextClientTrace(req.Context())
+
+	return cc.enc.encode(func(f func(itype http3IndexType, name, value string)) {
+		add := func(name, value string) {
+			f(http3MayIndex, name, value)
+			if trace != nil && trace.WroteHeaderField != nil {
+				trace.WroteHeaderField(strings.ToLower(name), []string{value})
+			}
+		}
+		add(":authority", host)
+		m := req.Method
+		if m == "" {
+			m = MethodGet
+		}
+		add(":method", m)
+		if req.Method != "CONNECT" {
+			add(":path", path)
+			add(":scheme", req.URL.Scheme)
+		}
+		if trailers != "" {
+			add("trailer", trailers)
+		}
+		var didUA bool
+		for k, vv := range req.Header {
+			switch {
+			case strings.EqualFold(k, "host"), strings.EqualFold(k, "content-length"):
+				// Host is :authority, already sent.
+				// Content-Length is automatic, set below.
+				continue
+			case strings.EqualFold(k, "connection"), strings.EqualFold(k, "proxy-connection"),
+				strings.EqualFold(k, "transfer-encoding"), strings.EqualFold(k, "upgrade"),
+				strings.EqualFold(k, "keep-alive"):
+				// Connection-specific header fields are not
+				// permitted in HTTP/3.
+				continue
+			case strings.EqualFold(k, "user-agent"):
+				// Match the HTTP/1 behavior: at most one User-Agent.
+				// If set to nil or empty string, then omit it.
+				didUA = true
+				if len(vv) < 1 || vv[0] == "" {
+					continue
+				}
+				vv = vv[:1]
+			}
+			for _, v := range vv {
+				add(k, v)
+			}
+		}
+		if http3ShouldSendReqContentLength(req.Method, contentLength) {
+			add("content-length", strconv.FormatInt(contentLength, 10))
+		}
+		if !didUA {
+			add("user-agent", "Go-http-client/3")
+		}
+	}), nil
+}
+
+// http3CheckConnHeaders reports an error if req has connection-specific
+// headers that cannot be expressed in HTTP/3.
+func http3CheckConnHeaders(req *Request) error {
+	if v := req.Header.Get("Upgrade"); v != "" {
+		return fmt.Errorf("http3: invalid Upgrade request header: %q", req.Header["Upgrade"])
+	}
+	if vv := req.Header["Transfer-Encoding"]; len(vv) > 0 && (len(vv) > 1 || vv[0] != "" && vv[0] != "chunked") {
+		return fmt.Errorf("http3: invalid Transfer-Encoding request header: %q", vv)
+	}
+	if vv := req.Header["Connection"]; len(vv) > 0 && (len(vv) > 1 || vv[0] != "" && !strings.EqualFold(vv[0], "close") && !strings.EqualFold(vv[0], "keep-alive")) {
+		return fmt.Errorf("http3: invalid Connection request header: %q", vv)
+	}
+	return nil
+}
+
+// http3CommaSeparatedTrailers returns the value of the trailer header
+// announcing the keys of req.Trailer.
+func http3CommaSeparatedTrailers(req *Request) (string, error) {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		k = CanonicalHeaderKey(k)
+		switch k {
+		case "Transfer-Encoding", "Trailer", "Content-Length":
+			return "", fmt.Errorf("invalid Trailer key %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ","), nil
+}
+
+// http3ValidPseudoPath reports whether v is a valid :path pseudo-header
+// value: an asterisk or a path starting with a slash.
+func http3ValidPseudoPath(v string) bool {
+	return (len(v) > 0 && v[0] == '/') || v == "*"
+}
+
+// http3ShouldSendReqContentLength reports whether a request with the
+// given method and outgoing length should carry a content-length header.
+func http3ShouldSendReqContentLength(method string, contentLength int64) bool {
+	if contentLength > 0 {
+		return true
+	}
+	if contentLength < 0 {
+		return false
+	}
+	// For zero bodies, whether we send a content-length depends on the method.
+	switch method {
+	case "POST", "PUT", "PATCH":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeBodyAndTrailer handles writing the body and trailer for a given
+// request, if any. This function will close the write direction of the stream.
+func (cc *http3ClientConn) writeBodyAndTrailer(rt *http3RoundTripState, req *Request) {
+	defer rt.closeReqBody()
+
+	declaredTrailer := req.Trailer.Clone()
+
+	rt.reqBodyWriter.st = rt.st
+	rt.reqBodyWriter.remain = req.outgoingLength()
+	rt.reqBodyWriter.flush = true
+	rt.reqBodyWriter.name = "request"
+	rt.reqBodyWriter.trailer = req.Trailer
+	rt.reqBodyWriter.enc = &cc.enc
+
+	if _, err := io.Copy(&rt.reqBodyWriter, rt.reqBody); err != nil {
+		rt.abort(err)
+	}
+	// Get rid of any trailer that was not declared beforehand, before we
+	// close the request body which will cause the trailer headers to be
+	// written.
+	for name := range req.Trailer {
+		if _, ok := declaredTrailer[name]; !ok {
+			delete(req.Trailer, name)
+		}
+	}
+	if err := rt.reqBodyWriter.Close(); err != nil {
+		rt.abort(err)
+	}
+	if rt.trace != nil && rt.trace.WroteRequest != nil {
+		rt.trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+}
+
+// http3TransportResponseBody is the Response.Body returned by RoundTrip.
+type http3TransportResponseBody http3RoundTripState
+
+// Read is Response.Body.Read.
+func (b *http3TransportResponseBody) Read(p []byte) (n int, err error) {
+	return b.respBody.Read(p)
+}
+
+var http3ErrRespBodyClosed = errors.New("response body closed")
+
+// Close is Response.Body.Close.
+// Closing the response body is how the caller signals that they're done with a request.
+func (b *http3TransportResponseBody) Close() error {
+	rt := (*http3RoundTripState)(b)
+	// Close the request body, which should wake up copyRequestBody if it's
+	// currently blocked reading the body.
+	rt.closeReqBody()
+	// Close the request stream, since we're done with the request.
+	// Reset closes the sending half of the stream.
+	rt.st.stream.Reset(uint64(http3ErrNoError))
+	// respBody.Close is responsible for closing the receiving half.
+	err := rt.respBody.Close()
+	if err == nil {
+		err = http3ErrRespBodyClosed
+	}
+	err = rt.abort(err)
+	if err == http3ErrRespBodyClosed {
+		// No other errors occurred before closing Response.Body,
+		// so consider this a successful request.
+		return nil
+	}
+	return err
+}
+
+func http3ParseResponseContentLength(method string, statusCode int, h Header) (int64, error) {
+	clens := h["Content-Length"]
+	if len(clens) == 0 {
+		return -1, nil
+	}
+
+	// We allow duplicate Content-Length headers,
+	// but only if they all have the same value.
+	for _, v := range clens[1:] {
+		if clens[0] != v {
+			return -1, &http3StreamError{http3ErrMessageError, "mismatching Content-Length headers"}
+		}
+	}
+
+	// "A server MUST NOT send a Content-Length header field in any response
+	// with a status code of 1xx (Informational) or 204 (No Content).
+	// A server MUST NOT send a Content-Length header field in any 2xx (Successful)
+	// response to a CONNECT request [...]"
+	// https://www.rfc-editor.org/rfc/rfc9110#section-8.6-8
+	if (statusCode >= 100 && statusCode < 200) ||
+		statusCode == 204 ||
+		(method == "CONNECT" && statusCode >= 200 && statusCode < 300) {
+		// This is a protocol violation, but a fairly harmless one.
+		// Just ignore the header.
+		return -1, nil
+	}
+
+	contentLen, err := strconv.ParseUint(clens[0], 10, 63)
+	if err != nil {
+		return -1, &http3StreamError{http3ErrMessageError, "invalid Content-Length header"}
+	}
+	return int64(contentLen), nil
+}
+
+func (cc *http3ClientConn) handleHeaders(st *http3Stream) (statusCode int, h Header, err error) {
+	haveStatus := false
+	cookie := ""
+	h = make(Header)
+	err = cc.dec.decode(st, func(_ http3IndexType, name, value string) error {
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return &http3StreamError{http3ErrMessageError, "invalid field value"}
+		}
+		switch {
+		case name == ":status":
+			if haveStatus {
+				return &http3StreamError{http3ErrMessageError, "duplicate :status"}
+			}
+			haveStatus = true
+			statusCode, err = strconv.Atoi(value)
+			if err != nil {
+				return &http3StreamError{http3ErrMessageError, "invalid :status"}
+			}
+		case name[0] == ':':
+			// "Endpoints MUST treat a request or response
+			// that contains undefined or invalid
+			// pseudo-header fields as malformed."
+			// https://www.rfc-editor.org/rfc/rfc9114.html#section-4.3-3
+			return &http3StreamError{http3ErrMessageError, "undefined pseudo-header"}
+		case name == "cookie":
+			// "If a decompressed field section contains multiple cookie field lines,
+			// these MUST be concatenated into a single byte string [...]"
+			// using the two-byte delimiter of "; "''
+			// https://www.rfc-editor.org/rfc/rfc9114.html#section-4.2.1-2
+			if cookie == "" {
+				cookie = value
+			} else {
+				cookie += "; " + value
+			}
+		default:
+			if !http3ValidWireHeaderFieldName(name) {
+				return &http3StreamError{http3ErrMessageError, "invalid field name"}
+			}
+			cname := CanonicalHeaderKey(name)
+			h[cname] = append(h[cname], value)
+		}
+		return nil
+	})
+	if err == nil && !haveStatus {
+		// "[The :status] pseudo-header field MUST be included in all responses [...]"
+		// https://www.rfc-editor.org/rfc/rfc9114.html#section-4.3.2-1
+		err = &http3StreamError{http3ErrMessageError, "missing :status"}
+	}
+	if cookie != "" {
+		h["Cookie"] = []string{cookie}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := st.endFrame(); err != nil {
+		return 0, nil, err
+	}
+	return statusCode, h, nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// White-box tests for h3_qpack.go (in package http instead of http_test).
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http/internal"
+	"net/http/internal/quic"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// qpackTestConn is a loopback QUIC connection.
+// The stream method sends data on a new stream and returns
+// the peer's end of the stream.
+type qpackTestConn struct {
+	t              *testing.T
+	ctx            context.Context
+	server, client *quic.Endpoint
+	sconn, cconn   *quic.Conn
+}
+
+func newQPACKTestConn(t *testing.T) *qpackTestConn {
+	t.Helper()
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(internal.LocalhostCert) {
+		t.Fatal("failed to add test certificate to pool")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	tc := &qpackTestConn{t: t, ctx: ctx}
+	tc.server, err = quic.Listen("udp", "127.0.0.1:0", http3QUICConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.server.Close(context.Background()) })
+	tc.client, err = quic.Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.client.Close(context.Background()) })
+	tc.cconn, err = tc.client.Dial(ctx, "udp", tc.server.LocalAddr().String(), http3QUICConfig(&tls.Config{
+		ServerName: "example.com",
+		RootCAs:    roots,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.sconn, err = tc.server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+// stream returns an http3Stream which reads b and then ends.
+// Its read limit is set to len(b), as if b were the contents of a frame.
+func (tc *qpackTestConn) stream(b []byte) *http3Stream {
+	tc.t.Helper()
+	qs, err := tc.cconn.NewStream(tc.ctx)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	if _, err := qs.Write(b); err != nil {
+		tc.t.Fatal(err)
+	}
+	qs.CloseWrite()
+	rs, err := tc.sconn.AcceptStream(tc.ctx)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	rs.SetReadContext(tc.ctx)
+	st := http3NewStream(rs)
+	st.lim = int64(len(b))
+	return st
+}
+
+type qpackTestField struct {
+	itype       http3IndexType
+	name, value string
+}
+
+// decode decodes the field section b.
+func (tc *qpackTestConn) decode(b []byte) ([]qpackTestField, error) {
+	var dec http3QPACKDecoder
+	var fields []qpackTestField
+	err := dec.decode(tc.stream(b), func(itype http3IndexType, name, value string) error {
+		fields = append(fields, qpackTestField{itype, name, value})
+		return nil
+	})
+	return fields, err
+}
+
+func qpackUnhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Integer representation examples from RFC 7541, Appendix C.1.
+var qpackPrefixedIntTests = []struct {
+	prefixLen uint8
+	v         int64
+	enc       string
+}{
+	{5, 10, "0a"},
+	{5, 1337, "1f9a0a"},
+	{8, 42, "2a"},
+}
+
+func TestHTTP3AppendPrefixedInt(t *testing.T) {
+	for _, test := range qpackPrefixedIntTests {
+		want := qpackUnhex(t, test.enc)
+		if got := http3AppendPrefixedInt(nil, 0, test.prefixLen, test.v); !bytes.Equal(got, want) {
+			t.Errorf("http3AppendPrefixedInt(nil, 0, %v, %v) = %x, want %x", test.prefixLen, test.v, got, want)
+		}
+	}
+	// Bits above the prefix are preserved.
+	if got, want := http3AppendPrefixedInt(nil, 0xe0, 5, 31), []byte{0xff, 0x00}; !bytes.Equal(got, want) {
+		t.Errorf("http3AppendPrefixedInt(nil, 0xe0, 5, 31) = %x, want %x", got, want)
+	}
+}
+
+// String literal examples from RFC 7541, Appendices C.4 and C.6
+// (requests and responses with Huffman coding).
+var qpackHuffmanStringTests = []struct {
+	s   string
+	enc string
+}{
+	{"www.example.com", "8c f1e3 c2e5 f23a 6ba0 ab90 f4ff"},
+	{"no-cache", "86 a8eb 1064 9cbf"},
+	{"custom-key", "88 25a8 49e9 5ba9 7d7f"},
+	{"custom-value", "89 25a8 49e9 5bb8 e8b4 bf"},
+	{"302", "82 6402"},
+	{"private", "85 aec3 771a 4b"},
+	{"Mon, 21 Oct 2013 20:13:21 GMT", "96 d07a be94 1054 d444 a820 0595 040b 8166 e082 a62d 1bff"},
+	{"https://www.example.com", "91 9d29 ad17 1863 c78f 0b97 c8e9 ae82 ae43 d3"},
+	// Strings which Huffman coding does not shorten are sent as-is.
+	{"a", "01 61"},
+	{"", "00"},
+}
+
+func TestHTTP3AppendPrefixedString(t *testing.T) {
+	for _, test := range qpackHuffmanStringTests {
+		want := qpackUnhex(t, test.enc)
+		if got := http3AppendPrefixedString(nil, 0, 7, test.s); !bytes.Equal(got, want) {
+			t.Errorf("http3AppendPrefixedString(nil, 0, 7, %q) = %x, want %x", test.s, got, want)
+		}
+	}
+}
+
+func TestHTTP3ReadPrefixedIntAndString(t *testing.T) {
+	tc := newQPACKTestConn(t)
+	var b []byte
+	for _, test := range qpackPrefixedIntTests {
+		b = append(b, qpackUnhex(t, test.enc)...)
+	}
+	for _, test := range qpackHuffmanStringTests {
+		b = append(b, qpackUnhex(t, test.enc)...)
+	}
+	st := tc.stream(b)
+	for _, test := range qpackPrefixedIntTests {
+		if _, v, err := st.readPrefixedInt(test.prefixLen); err != nil || v != test.v {
+			t.Errorf("readPrefixedInt(%v) of %v = %v, %v; want %v, nil", test.prefixLen, test.enc, v, err, test.v)
+		}
+	}
+	for _, test := range qpackHuffmanStringTests {
+		if _, s, err := st.readPrefixedString(7); err != nil || s != test.s {
+			t.Errorf("readPrefixedString(7) of %v = %q, %v; want %q, nil", test.enc, s, err, test.s)
+		}
+	}
+	if st.lim != 0 {
+		t.Errorf("%v bytes left unread", st.lim)
+	}
+}
+
+func TestHTTP3StaticTable(t *testing.T) {
+	// Spot checks against RFC 9204, Appendix A.
+	if got, want := len(http3StaticTableEntries), 99; got != want {
+		t.Errorf("static table has %v entries, want %v", got, want)
+	}
+	for _, test := range []struct {
+		index       int64
+		name, value string
+	}{
+		{0, ":authority", ""},
+		{1, ":path", "/"},
+		{15, ":method", "CONNECT"},
+		{17, ":method", "GET"},
+		{25, ":status", "200"},
+		{31, "accept-encoding", "gzip, deflate, br"},
+		{52, "content-type", "text/html; charset=utf-8"},
+		{98, "x-frame-options", "sameorigin"},
+	} {
+		ent, err := http3StaticTableEntry(test.index)
+		if err != nil || ent.name != test.name || ent.value != test.value {
+			t.Errorf("http3StaticTableEntry(%v) = %q: %q, %v; want %q: %q", test.index, ent.name, ent.value, err, test.name, test.value)
+		}
+	}
+	if _, err := http3StaticTableEntry(99); err != http3ErrQPACKDecompressionFailed {
+		t.Errorf("http3StaticTableEntry(99): err = %v, want QPACK_DECOMPRESSION_FAILED", err)
+	}
+}
+
+func TestHTTP3QPACKEncode(t *testing.T) {
+	var enc http3QPACKEncoder
+	enc.init()
+	for _, test := range []struct {
+		name   string
+		fields []qpackTestField
+		want   []byte
+	}{{
+		name: "indexed field lines",
+		fields: []qpackTestField{
+			{http3MayIndex, ":method", "GET"},
+			{http3MayIndex, ":status", "200"},
+		},
+		want: qpackUnhex(t, "0000 d1 d9"),
+	}, {
+		// RFC 9204, Appendix B.1, with the value Huffman-encoded.
+		name:   "name reference",
+		fields: []qpackTestField{{http3MayIndex, ":path", "/index.html"}},
+		want:   append(qpackUnhex(t, "0000 51"), http3AppendPrefixedString(nil, 0, 7, "/index.html")...),
+	}, {
+		name:   "literal name",
+		fields: []qpackTestField{{http3MayIndex, "x", "y"}},
+		want:   qpackUnhex(t, "0000 21 78 01 79"),
+	}, {
+		name:   "uppercase name",
+		fields: []qpackTestField{{http3MayIndex, "X", "y"}},
+		want:   qpackUnhex(t, "0000 21 78 01 79"),
+	}, {
+		name:   "never indexed",
+		fields: []qpackTestField{{http3NeverIndex, ":method", "GET"}, {http3NeverIndex, "x", "y"}},
+		want:   qpackUnhex(t, "0000 7f00 03474554 31 78 01 79"),
+	}, {
+		name:   "non-ASCII name",
+		fields: []qpackTestField{{http3MayIndex, "é", "y"}, {http3MayIndex, ":method", "GET"}},
+		want:   qpackUnhex(t, "0000 d1"),
+	}} {
+		got := enc.encode(func(yield func(itype http3IndexType, name, value string)) {
+			for _, f := range test.fields {
+				yield(f.itype, f.name, f.value)
+			}
+		})
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%v: encode = %x, want %x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHTTP3QPACKDecode(t *testing.T) {
+	tc := newQPACKTestConn(t)
+	for _, test := range []struct {
+		name    string
+		enc     string
+		want    []qpackTestField
+		wantErr error // nil: no error; errAny: any error
+	}{{
+		// RFC 9204, Appendix B.1.
+		name: "literal with name reference",
+		enc:  "0000 510b 2f69 6e64 6578 2e68 746d 6c",
+		want: []qpackTestField{{http3MayIndex, ":path", "/index.html"}},
+	}, {
+		name: "indexed and literal fields",
+		enc:  "0000 d1 d9 7f02 03474554 31 78 01 79",
+		want: []qpackTestField{
+			{http3MayIndex, ":method", "GET"},
+			{http3MayIndex, ":status", "200"},
+			{http3NeverIndex, ":method", "GET"},
+			{http3NeverIndex, "x", "y"},
+		},
+	}, {
+		name: "empty field section",
+		enc:  "0000",
+	}, {
+		name:    "nonzero Required Insert Count",
+		enc:     "0100 d1",
+		wantErr: http3ErrQPACKDecompressionFailed,
+	}, {
+		name:    "static index out of range",
+		enc:     "0000 ff24",
+		wantErr: http3ErrQPACKDecompressionFailed,
+	}, {
+		name:    "static name index out of range",
+		enc:     "0000 5f54 00",
+		wantErr: http3ErrQPACKDecompressionFailed,
+	}, {
+		name:    "dynamic table reference",
+		enc:     "0000 80",
+		wantErr: errAny,
+	}, {
+		name:    "truncated string",
+		enc:     "0000 510b 2f69",
+		wantErr: http3ErrQPACKDecompressionFailed,
+	}, {
+		name:    "invalid Huffman code",
+		enc:     "0000 5181 ff",
+		wantErr: http3ErrQPACKDecompressionFailed,
+	}, {
+		name:    "pseudo-header after regular header",
+		enc:     "0000 21 78 01 79 d1",
+		wantErr: http3ErrMessageError,
+	}, {
+		name:    "empty name",
+		enc:     "0000 20 01 79",
+		wantErr: http3ErrMessageError,
+	}} {
+		got, err := tc.decode(qpackUnhex(t, test.enc))
+		switch {
+		case test.wantErr == errAny:
+			if err == nil {
+				t.Errorf("%v: decode succeeded, want error", test.name)
+			}
+		case !errors.Is(err, test.wantErr):
+			t.Errorf("%v: decode error = %v, want %v", test.name, err, test.wantErr)
+		case test.wantErr == nil && !reflect.DeepEqual(got, test.want):
+			t.Errorf("%v: decode = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+var errAny = errors.New("any error")
+
+func TestHTTP3QPACKRoundTrip(t *testing.T) {
+	tc := newQPACKTestConn(t)
+	var enc http3QPACKEncoder
+	enc.init()
+	fields := []qpackTestField{
+		{http3MayIndex, ":method", "POST"},
+		{http3MayIndex, ":scheme", "https"},
+		{http3MayIndex, ":authority", "www.example.com"},
+		{http3MayIndex, ":path", "/upload?id=1"},
+		{http3MayIndex, "content-type", "application/json"},
+		{http3MayIndex, "content-length", "1234"},
+		{http3NeverIndex, "authorization", "Bearer secret"},
+		{http3MayIndex, "x-custom-header", strings.Repeat("value ", 50)},
+	}
+	b := enc.encode(func(yield func(itype http3IndexType, name, value string)) {
+		for _, f := range fields {
+			yield(f.itype, f.name, f.value)
+		}
+	})
+	got, err := tc.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("decode(encode(fields)):\n got: %q\nwant: %q", got, fields)
+	}
+}
@@ -0,0 +1,940 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 server.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"net/http/internal/quic"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ServeQUIC accepts incoming HTTP/3 connections on the UDP socket pc,
+// creating a new service goroutine for each. The service goroutines
+// read requests and then call srv.Handler to reply to them.
+//
+// The TLS configuration and certificate files are handled as for
+// ServeTLS, except that HTTP/3 requires TLS 1.3 and the "h3" protocol,
+// which ServeQUIC always configures.
+//
+// If srv.AdvertiseHTTP3 is set, then while ServeQUIC is running, responses
+// the Server sends over TLS using HTTP/1.1 or HTTP/2 advertise the HTTP/3
+// endpoint in an Alt-Svc header (RFC 7838), unless the Handler sets or
+// deletes that header itself. Clients that support HTTP/3 may then switch
+// to it for later requests.
+//
+// ServeQUIC always returns a non-nil error. After Shutdown or Close, the
+// returned error is ErrServerClosed.
+func (srv *Server) ServeQUIC(pc net.PacketConn, certFile, keyFile string) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	config := cloneTLSConfig(srv.TLSConfig)
+	if err := loadServerCertificate(config, certFile, keyFile); err != nil {
+		return err
+	}
+	qconfig := http3QUICConfig(config)
+	if d := srv.idleTimeout(); d > 0 {
+		qconfig.MaxIdleTimeout = d
+	}
+	e, err := quic.NewEndpoint(pc, qconfig)
+	if err != nil {
+		return err
+	}
+
+	baseCtx := context.WithValue(context.Background(), ServerContextKey, srv)
+	baseCtx = context.WithValue(baseCtx, LocalAddrContextKey, pc.LocalAddr())
+	s := &http3Server{
+		srv:         srv,
+		endpoint:    e,
+		activeConns: make(map[*http3ServerConn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(baseCtx)
+	s.acceptCtx, s.stopAccepting = context.WithCancel(s.ctx)
+	if ua, ok := pc.LocalAddr().(*net.UDPAddr); ok {
+		s.altSvc = fmt.Sprintf(`%s=":%d"`, http3NextProtoTLS, ua.Port)
+	}
+	if !srv.trackQUIC(s, true) {
+		e.Close(http3CanceledCtx)
+		return ErrServerClosed
+	}
+
+	for {
+		qconn, err := e.Accept(s.acceptCtx)
+		if err != nil {
+			if srv.shuttingDown() {
+				// Shutdown or Close now own the endpoint.
+				return ErrServerClosed
+			}
+			srv.trackQUIC(s, false)
+			s.close()
+			return err
+		}
+		go s.serveConn(qconn)
+	}
+}
+
+// ListenAndServeQUIC listens on the UDP network address srv.Addr and
+// then calls ServeQUIC to handle requests on incoming HTTP/3
+// connections.
+//
+// If srv.Addr is blank, ":https" is used.
+//
+// ListenAndServeQUIC always returns a non-nil error. After Shutdown or
+// Close, the returned error is ErrServerClosed.
+func (srv *Server) ListenAndServeQUIC(certFile, keyFile string) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.ServeQUIC(pc, certFile, keyFile)
+}
+
+// trackQUIC adds or removes an HTTP/3 endpoint from the set of
+// endpoints Shutdown and Close know about.
+// It reports whether the server is still up (not Shutdown or Closed).
+func (s *Server) trackQUIC(h3 *http3Server, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.quicServers == nil {
+		s.quicServers = make(map[*http3Server]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.quicServers[h3] = struct{}{}
+	} else {
+		delete(s.quicServers, h3)
+	}
+	s.updateAltSvcLocked()
+	return true
+}
+
+// updateAltSvcLocked records the Alt-Svc value advertising the server's
+// HTTP/3 endpoints, if any.
+func (s *Server) updateAltSvcLocked() {
+	var alts []string
+	if !s.shuttingDown() {
+		for h3 := range s.quicServers {
+			if h3.altSvc != "" {
+				alts = append(alts, h3.altSvc)
+			}
+		}
+	}
+	sort.Strings(alts)
+	s.altSvc.Store(strings.Join(alts, ", "))
+}
+
+// http3AltSvc returns the Alt-Svc header value advertising the
+// server's HTTP/3 endpoints, or "" if there are none.
+func (s *Server) http3AltSvc() string {
+	v, _ := s.altSvc.Load().(string)
+	return v
+}
+
+// closeQUICLocked abruptly closes all HTTP/3 endpoints.
+func (s *Server) closeQUICLocked() {
+	for h3 := range s.quicServers {
+		h3.close()
+		delete(s.quicServers, h3)
+	}
+	s.updateAltSvcLocked()
+}
+
+// shutdownQUICLocked starts a graceful shutdown of all HTTP/3 endpoints.
+func (s *Server) shutdownQUICLocked() {
+	for h3 := range s.quicServers {
+		h3.startShutdown()
+	}
+	s.updateAltSvcLocked()
+}
+
+// closeIdleQUIC closes idle HTTP/3 connections on shut down endpoints,
+// closes endpoints with no connections left, and reports whether all
+// endpoints are closed.
+func (s *Server) closeIdleQUIC() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for h3 := range s.quicServers {
+		if h3.closeIdleConns() {
+			h3.close()
+			delete(s.quicServers, h3)
+		}
+	}
+	return len(s.quicServers) == 0
+}
+
+// An http3Server serves HTTP/3 requests on one QUIC endpoint.
+type http3Server struct {
+	srv      *Server
+	endpoint *quic.Endpoint
+	altSvc   string // Alt-Svc value advertising this endpoint
+
+	// ctx is the base context for requests.
+	// It is canceled when the server is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// acceptCtx is canceled when the server stops accepting connections.
+	acceptCtx     context.Context
+	stopAccepting context.CancelFunc
+
+	mu          sync.Mutex // guards fields below
+	activeConns map[*http3ServerConn]struct{}
+	inShutdown  bool
+}
+
+func (s *http3Server) serveConn(qconn *quic.Conn) {
+	sc := &http3ServerConn{
+		s:     s,
+		qconn: qconn,
+	}
+	if !s.registerConn(sc) {
+		sc.abort(&http3ConnectionError{
+			code:    http3ErrNoError,
+			message: "server is shutting down",
+		})
+		return
+	}
+	defer s.unregisterConn(sc)
+	sc.enc.init()
+
+	// Create control stream and send SETTINGS frame.
+	var err error
+	sc.controlStream, err = http3NewConnStream(s.ctx, sc.qconn, http3StreamTypeControl)
+	if err != nil {
+		return
+	}
+	sc.controlStream.writeSettings()
+	sc.controlStream.Flush()
+
+	sc.acceptStreams(sc.qconn, sc)
+}
+
+func (s *http3Server) registerConn(sc *http3ServerConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return false
+	}
+	s.activeConns[sc] = struct{}{}
+	return true
+}
+
+func (s *http3Server) unregisterConn(sc *http3ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activeConns, sc)
+}
+
+// startShutdown stops accepting new connections and sends a GOAWAY
+// frame on every active connection.
+func (s *http3Server) startShutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return
+	}
+	s.inShutdown = true
+	s.stopAccepting()
+	for sc := range s.activeConns {
+		go sc.sendGoaway()
+	}
+}
+
+// closeIdleConns closes connections with no requests in flight,
+// and reports whether no connections remain.
+func (s *http3Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.activeConns {
+		if sc.idle() {
+			sc.abort(&http3ConnectionError{
+				code:    http3ErrNoError,
+				message: "server is shutting down",
+			})
+			delete(s.activeConns, sc)
+		}
+	}
+	return len(s.activeConns) == 0
+}
+
+// close immediately closes the endpoint and all its connections.
+func (s *http3Server) close() {
+	s.mu.Lock()
+	s.inShutdown = true
+	s.cancel()
+	for sc := range s.activeConns {
+		sc.abort(&http3ConnectionError{
+			code:    http3ErrNoError,
+			message: "server is shutting down",
+		})
+		delete(s.activeConns, sc)
+	}
+	s.mu.Unlock()
+	s.endpoint.Close(http3CanceledCtx)
+}
+
+// An http3ServerConn is a server HTTP/3 connection.
+type http3ServerConn struct {
+	s     *http3Server
+	qconn *quic.Conn
+
+	http3GenericConn // for handleUnidirectionalStream
+	enc              http3QPACKEncoder
+
+	// For handling shutdown.
+	controlStream      *http3Stream
+	mu                 sync.Mutex // Guards everything below.
+	maxRequestStreamID int64
+	goawaySent         bool
+	inFlight           int // requests being handled
+}
+
+func (sc *http3ServerConn) handleControlStream(st *http3Stream) error {
+	if err := st.readControlSettings(); err != nil {
+		return err
+	}
+	for {
+		ftype, err := st.readFrameHeader()
+		if err != nil {
+			return err
+		}
+		switch ftype {
+		case http3FrameTypeCancelPush:
+			// "If a server receives a CANCEL_PUSH frame for a push ID
+			// that has not yet been mentioned by a PUSH_PROMISE frame,
+			// this MUST be treated as a connection error of type H3_ID_ERROR."
+			// https://www.rfc-editor.org/rfc/rfc9114.html#section-7.2.3-8
+			return &http3ConnectionError{
+				code:    http3ErrIDError,
+				message: "CANCEL_PUSH for unsent push ID",
+			}
+		case http3FrameTypeGoaway:
+			return http3ErrNoError
+		default:
+			// Unknown frames are ignored.
+			if err := st.discardUnknownFrame(ftype); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (sc *http3ServerConn) handleEncoderStream(*http3Stream) error {
+	// We don't use the dynamic table, so the peer has no
+	// reason to send anything here.
+	return nil
+}
+
+func (sc *http3ServerConn) handleDecoderStream(*http3Stream) error {
+	return nil
+}
+
+func (sc *http3ServerConn) handlePushStream(*http3Stream) error {
+	// "[...] if a server receives a client-initiated push stream,
+	// this MUST be treated as a connection error of type H3_STREAM_CREATION_ERROR."
+	// https://www.rfc-editor.org/rfc/rfc9114.html#section-6.2.2-3
+	return &http3ConnectionError{
+		code:    http3ErrStreamCreationError,
+		message: "client created push stream",
+	}
+}
+
+type http3PseudoHeader struct {
+	method    string
+	scheme    string
+	path      string
+	authority string
+}
+
+func (sc *http3ServerConn) parseHeader(st *http3Stream) (Header, http3PseudoHeader, error) {
+	ftype, err := st.readFrameHeader()
+	if err != nil {
+		return nil, http3PseudoHeader{}, err
+	}
+	if ftype != http3FrameTypeHeaders {
+		return nil, http3PseudoHeader{}, &http3StreamError{http3ErrMessageError, "received other frames when expecting HEADERS"}
+	}
+	header := make(Header)
+	var ph http3PseudoHeader
+	var dec http3QPACKDecoder
+	var hasMethod, hasScheme, hasPath, hasAuthority bool
+	if err := dec.decode(st, func(_ http3IndexType, name, value string) error {
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return &http3StreamError{http3ErrMessageError, "invalid field value"}
+		}
+		switch name {
+		case ":method":
+			if hasMethod {
+				return &http3StreamError{http3ErrMessageError, "duplicate :method"}
+			}
+			hasMethod = true
+			ph.method = value
+		case ":scheme":
+			if hasScheme {
+				return &http3StreamError{http3ErrMessageError, "duplicate :scheme"}
+			}
+			hasScheme = true
+			ph.scheme = value
+		case ":path":
+			if hasPath {
+				return &http3StreamError{http3ErrMessageError, "duplicate :path"}
+			}
+			hasPath = true
+			ph.path = value
+		case ":authority":
+			if hasAuthority {
+				return &http3StreamError{http3ErrMessageError, "duplicate :authority"}
+			}
+			hasAuthority = true
+			ph.authority = value
+		default:
+			if !http3ValidWireHeaderFieldName(name) {
+				return &http3StreamError{http3ErrMessageError, "invalid field name"}
+			}
+			header.Add(name, value)
+		}
+		return nil
+	}); err != nil {
+		return nil, http3PseudoHeader{}, err
+	}
+	if err := st.endFrame(); err != nil {
+		return nil, http3PseudoHeader{}, err
+	}
+	if http3HasDisallowedConnectionHeader(header) {
+		return nil, http3PseudoHeader{}, &http3StreamError{http3ErrMessageError, "invalid connection-related header"}
+	}
+
+	// "All HTTP/3 requests MUST include exactly one value for the :method,
+	// :scheme, and :path pseudo-header fields, unless the request is a CONNECT
+	// request"
+	//
+	// "A CONNECT request MUST be constructed as follows:
+	// - The :method pseudo-header field is set to "CONNECT"
+	// - The :scheme and :path pseudo-header fields are omitted
+	// - The :authority pseudo-header field contains the host and port to connect to"
+	if !hasMethod {
+		return nil, http3PseudoHeader{}, &http3StreamError{http3ErrMessageError, "missing :method"}
+	}
+	if ph.method != "CONNECT" && (!hasScheme || !hasPath) {
+		return nil, http3PseudoHeader{}, &http3StreamError{http3ErrMessageError, "missing :scheme or :path for non-CONNECT requests"}
+	}
+	if ph.method == "CONNECT" && (hasScheme || hasPath || !hasAuthority) {
+		return nil, http3PseudoHeader{}, &http3StreamError{
+			http3ErrMessageError, "CONNECT request must only have :method and :authority pseudo-headers",
+		}
+	}
+	return header, ph, nil
+}
+
+func (sc *http3ServerConn) sendGoaway() {
+	sc.mu.Lock()
+	if sc.goawaySent || sc.controlStream == nil {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goawaySent = true
+	sc.mu.Unlock()
+
+	// No lock in this section in case writing to stream blocks. This is safe
+	// since sc.maxRequestStreamID is only updated when sc.goawaySent is false.
+	//
+	// The GOAWAY frame carries the first request stream ID we won't
+	// process: the next client-initiated bidirectional stream.
+	id := sc.maxRequestStreamID + 4
+	sc.controlStream.writeVarint(int64(http3FrameTypeGoaway))
+	sc.controlStream.writeVarint(int64(http3SizeVarint(uint64(id))))
+	sc.controlStream.writeVarint(id)
+	sc.controlStream.Flush()
+}
+
+// startRequest records the start of a request on st and reports
+// whether it may proceed. Requests on streams with IDs at or past the
+// one sent in a GOAWAY frame are rejected.
+func (sc *http3ServerConn) startRequest(st *http3Stream) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	id := st.stream.ID()
+	if sc.goawaySent && id > sc.maxRequestStreamID {
+		return false
+	}
+	if id > sc.maxRequestStreamID {
+		sc.maxRequestStreamID = id
+	}
+	sc.inFlight++
+	return true
+}
+
+func (sc *http3ServerConn) endRequest() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.inFlight--
+}
+
+// idle reports whether the connection has sent a GOAWAY frame
+// and has no requests in flight.
+func (sc *http3ServerConn) idle() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.goawaySent && sc.inFlight == 0
+}
+
+func (sc *http3ServerConn) handleRequestStream(st *http3Stream) error {
+	if !sc.startRequest(st) {
+		return &http3StreamError{
+			code:    http3ErrRequestRejected,
+			message: "request stream ID at or past the one sent in GOAWAY",
+		}
+	}
+	defer sc.endRequest()
+	header, ph, err := sc.parseHeader(st)
+	if err != nil {
+		return err
+	}
+	req, err := sc.newRequest(header, ph)
+	if err != nil {
+		return err
+	}
+
+	var body io.ReadCloser
+	if req.ContentLength != 0 || len(req.Trailer) != 0 {
+		body = &http3BodyReader{
+			st:      st,
+			remain:  req.ContentLength,
+			trailer: req.Trailer,
+		}
+	} else {
+		body = NoBody
+	}
+	req.Body = body
+	defer req.Body.Close()
+
+	ctx, cancel := context.WithCancel(sc.s.ctx)
+	defer cancel()
+	req.ctx = ctx
+
+	rw := &http3ResponseWriter{
+		st:             st,
+		headers:        make(Header),
+		trailer:        make(Header),
+		bb:             make(http3BodyBuffer, 0, http3DefaultBodyBufferCap),
+		cannotHaveBody: req.Method == "HEAD",
+		bw: &http3BodyWriter{
+			st:     st,
+			remain: -1,
+			flush:  false,
+			name:   "response",
+			enc:    &sc.enc,
+		},
+	}
+	if req.expectsContinue() {
+		if br, ok := body.(*http3BodyReader); ok {
+			br.send100Continue = func() {
+				rw.WriteHeader(StatusContinue)
+			}
+		}
+	}
+
+	if !sc.runHandler(rw, req) {
+		return &http3StreamError{
+			code:    http3ErrInternalError,
+			message: "handler panicked",
+		}
+	}
+	return rw.close()
+}
+
+// runHandler calls the Server's Handler for req, and reports whether
+// it returned without panicking.
+func (sc *http3ServerConn) runHandler(rw *http3ResponseWriter, req *Request) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if e != ErrAbortHandler {
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				sc.s.srv.logf("http3: panic serving %v: %v\n%s", req.RemoteAddr, e, buf)
+			}
+			ok = false
+		}
+	}()
+	serverHandler{sc.s.srv}.ServeHTTP(rw, req)
+	return true
+}
+
+// newRequest returns the Request described by a HEADERS frame.
+// The caller sets the Body and context.
+func (sc *http3ServerConn) newRequest(header Header, ph http3PseudoHeader) (*Request, error) {
+	if !validMethod(ph.method) {
+		return nil, &http3StreamError{http3ErrMessageError, "invalid :method"}
+	}
+	var u *url.URL
+	var requestURI string
+	if ph.method == "CONNECT" {
+		u = &url.URL{Host: ph.authority}
+		requestURI = ph.authority // mimic HTTP/1 server behavior
+	} else {
+		var err error
+		u, err = url.ParseRequestURI(ph.path)
+		if err != nil {
+			return nil, &http3StreamError{http3ErrMessageError, "invalid :path"}
+		}
+		requestURI = ph.path
+	}
+	host := ph.authority
+	if host == "" {
+		host = header.Get("Host")
+	}
+
+	// Setup Trailers
+	var trailer Header
+	for _, v := range header["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			key = CanonicalHeaderKey(key)
+			switch key {
+			case "Transfer-Encoding", "Trailer", "Content-Length":
+				// Bogus. (copy of http1 rules)
+				// Ignore.
+			default:
+				if trailer == nil {
+					trailer = make(Header)
+				}
+				trailer[key] = nil
+			}
+		})
+	}
+	delete(header, "Trailer")
+
+	contentLength := int64(-1)
+	if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseUint(cl, 10, 63)
+		if err != nil {
+			return nil, &http3StreamError{http3ErrMessageError, "invalid Content-Length"}
+		}
+		contentLength = int64(n)
+	}
+
+	state := sc.qconn.ConnectionState()
+	return &Request{
+		Method:        ph.method,
+		URL:           u,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		ProtoMinor:    0,
+		Header:        header,
+		ContentLength: contentLength,
+		Host:          host,
+		RemoteAddr:    sc.qconn.RemoteAddr().String(),
+		RequestURI:    requestURI,
+		TLS:           &state,
+		Trailer:       trailer,
+	}, nil
+}
+
+// abort closes the connection with an error.
+func (sc *http3ServerConn) abort(err error) {
+	if e, ok := err.(*http3ConnectionError); ok {
+		sc.qconn.Abort(&quic.ApplicationError{
+			Code:   uint64(e.code),
+			Reason: e.message,
+		})
+	} else {
+		sc.qconn.Abort(err)
+	}
+}
+
+// An http3ResponseWriter is the ResponseWriter for HTTP/3 requests.
+type http3ResponseWriter struct {
+	st             *http3Stream
+	bw             *http3BodyWriter
+	mu             sync.Mutex
+	headers        Header
+	trailer        Header
+	bb             http3BodyBuffer
+	wroteHeader    bool // Non-1xx header has been (logically) written.
+	statusCode     int  // Status of the response that will be sent in HEADERS frame.
+	statusCodeSet  bool // Status of the response has been set via a call to WriteHeader.
+	cannotHaveBody bool // Response should not have a body (e.g. response to a HEAD request).
+	bodyLenLeft    int  // How much of the content body is left to be sent, set via "Content-Length" header. -1 if unknown.
+}
+
+func (rw *http3ResponseWriter) Header() Header {
+	return rw.headers
+}
+
+// prepareTrailerForWriteLocked populates any pre-declared trailer header with
+// its value, and passes it to the bodyWriter so it can be written after body EOF.
+// Caller must hold rw.mu.
+func (rw *http3ResponseWriter) prepareTrailerForWriteLocked() {
+	for name := range rw.trailer {
+		if val, ok := rw.headers[name]; ok {
+			rw.trailer[name] = val
+		} else {
+			delete(rw.trailer, name)
+		}
+	}
+	if len(rw.trailer) > 0 {
+		rw.bw.trailer = rw.trailer
+	}
+}
+
+// writeHeaderLockedOnce writes the final response header. If rw.wroteHeader is
+// true, calling this method is a no-op. Sending informational status headers
+// should be done using writeInfoHeaderLocked, rather than this method.
+// Caller must hold rw.mu.
+func (rw *http3ResponseWriter) writeHeaderLockedOnce() {
+	if rw.wroteHeader {
+		return
+	}
+	if !bodyAllowedForStatus(rw.statusCode) {
+		rw.cannotHaveBody = true
+	}
+	// If there is any Trailer declared in headers, save them so we know which
+	// trailers have been pre-declared. Also, write back the extracted value,
+	// which is canonicalized, to rw.Header for consistency.
+	if _, ok := rw.headers["Trailer"]; ok {
+		http3ExtractTrailerFromHeader(rw.headers, rw.trailer)
+		names := make([]string, 0, len(rw.trailer))
+		for name := range rw.trailer {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rw.headers.Set("Trailer", strings.Join(names, ", "))
+	}
+
+	rw.bb.inferHeader(rw.headers, rw.statusCode)
+	rw.writeHeaderFrameLocked(rw.statusCode, false)
+	rw.wroteHeader = true
+}
+
+// writeInfoHeaderLocked writes informational status headers (i.e. status 1XX).
+// If a non-informational status header has been written via
+// writeHeaderLockedOnce, this method is a no-op.
+// Caller must hold rw.mu.
+func (rw *http3ResponseWriter) writeInfoHeaderLocked(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.writeHeaderFrameLocked(statusCode, true)
+}
+
+// writeHeaderFrameLocked writes a HEADERS frame with the given status and
+// the current response header.
+// Caller must hold rw.mu.
+func (rw *http3ResponseWriter) writeHeaderFrameLocked(statusCode int, info bool) {
+	encHeaders := rw.bw.enc.encode(func(f func(itype http3IndexType, name, value string)) {
+		f(http3MayIndex, ":status", strconv.Itoa(statusCode))
+		for name, values := range rw.headers {
+			if info && (name == "Content-Length" || name == "Transfer-Encoding") {
+				continue
+			}
+			if !httpguts.ValidHeaderFieldName(name) {
+				continue
+			}
+			for _, val := range values {
+				if !httpguts.ValidHeaderFieldValue(val) {
+					continue
+				}
+				f(http3MayIndex, name, val)
+			}
+		}
+	})
+	rw.st.writeVarint(int64(http3FrameTypeHeaders))
+	rw.st.writeVarint(int64(len(encHeaders)))
+	rw.st.Write(encHeaders)
+}
+
+func (rw *http3ResponseWriter) WriteHeader(statusCode int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.statusCodeSet {
+		return
+	}
+	checkWriteHeaderCode(statusCode)
+
+	// Informational headers can be sent multiple times, and should be flushed
+	// immediately.
+	if statusCode >= 100 && statusCode <= 199 {
+		rw.writeInfoHeaderLocked(statusCode)
+		rw.st.Flush()
+		return
+	}
+
+	// Non-informational headers should only be set once, and should be
+	// buffered.
+	rw.statusCodeSet = true
+	rw.statusCode = statusCode
+	if n, err := strconv.Atoi(rw.headers.Get("Content-Length")); err == nil {
+		rw.bodyLenLeft = n
+	} else {
+		rw.bodyLenLeft = -1 // Unknown.
+	}
+}
+
+// trimWriteLocked trims a byte slice, b, such that the length of b will not
+// exceed rw.bodyLenLeft. This method will update rw.bodyLenLeft when trimming
+// b, and will also return whether b was trimmed or not.
+// Caller must hold rw.mu.
+func (rw *http3ResponseWriter) trimWriteLocked(b []byte) ([]byte, bool) {
+	if rw.bodyLenLeft < 0 {
+		return b, false
+	}
+	n := len(b)
+	if n > rw.bodyLenLeft {
+		n = rw.bodyLenLeft
+	}
+	rw.bodyLenLeft -= n
+	return b[:n], n != len(b)
+}
+
+func (rw *http3ResponseWriter) Write(b []byte) (n int, err error) {
+	// Calling Write implicitly calls WriteHeader(200) if WriteHeader has not
+	// been called before.
+	rw.WriteHeader(StatusOK)
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.statusCode == StatusNotModified {
+		return 0, ErrBodyNotAllowed
+	}
+
+	b, trimmed := rw.trimWriteLocked(b)
+	if trimmed {
+		defer func() {
+			err = ErrContentLength
+		}()
+	}
+
+	// If b fits entirely in our body buffer, save it to the buffer and return
+	// early so we can coalesce small writes.
+	// As a special case, we always want to save b to the buffer even when b is
+	// big if we had yet to write our header, so we can infer headers like
+	// "Content-Type" with as much information as possible.
+	initialBLen := len(b)
+	initialBufLen := len(rw.bb)
+	if !rw.wroteHeader || len(b) <= cap(rw.bb)-len(rw.bb) {
+		b = rw.bb.write(b)
+		if len(b) == 0 {
+			return initialBLen, nil
+		}
+	}
+
+	// Reaching this point means that our buffer has been sufficiently filled.
+	// Therefore, we now want to:
+	// 1. Infer and write response headers based on our body buffer, if not
+	// done yet.
+	// 2. Write our body buffer and the rest of b (if any).
+	// 3. Reset the current body buffer so it can be used again.
+	rw.writeHeaderLockedOnce()
+	if rw.cannotHaveBody {
+		return initialBLen, nil
+	}
+	if n, err := rw.bw.write(rw.bb, b); err != nil {
+		if n -= initialBufLen; n < 0 {
+			n = 0
+		}
+		return n, err
+	}
+	rw.bb.discard()
+	return initialBLen, nil
+}
+
+func (rw *http3ResponseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError is like Flush, but returns any error writing to the stream.
+// ResponseController uses it.
+func (rw *http3ResponseWriter) FlushError() error {
+	// Calling Flush implicitly calls WriteHeader(200) if WriteHeader has not
+	// been called before.
+	rw.WriteHeader(StatusOK)
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.writeHeaderLockedOnce()
+	if !rw.cannotHaveBody {
+		if _, err := rw.bw.Write(rw.bb); err != nil {
+			return err
+		}
+		rw.bb.discard()
+	}
+	return rw.st.Flush()
+}
+
+func (rw *http3ResponseWriter) close() error {
+	rw.Flush()
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.prepareTrailerForWriteLocked()
+	if err := rw.bw.Close(); err != nil {
+		return err
+	}
+	return rw.st.stream.Close()
+}
+
+// http3DefaultBodyBufferCap is the default number of bytes of body that we are
+// willing to save in a buffer for the sake of inferring headers and coalescing
+// small writes. 512 was chosen to be consistent with how much
+// DetectContentType is willing to read.
+const http3DefaultBodyBufferCap = sniffLen
+
+// http3BodyBuffer is a buffer used to store body content of a response.
+type http3BodyBuffer []byte
+
+// write writes b to the buffer. It returns a new slice of b, which contains
+// any remaining data that could not be written to the buffer, if any.
+func (bb *http3BodyBuffer) write(b []byte) []byte {
+	n := cap(*bb) - len(*bb)
+	if n > len(b) {
+		n = len(b)
+	}
+	*bb = append(*bb, b[:n]...)
+	return b[n:]
+}
+
+// discard resets the buffer so it can be used again.
+func (bb *http3BodyBuffer) discard() {
+	*bb = (*bb)[:0]
+}
+
+// inferHeader populates h with the header values that we can infer from our
+// current buffer content, if not already explicitly set. This method should be
+// called only once with as much body content as possible in the buffer, before
+// a HEADERS frame is sent, and before discard has been called. Doing so
+// properly is the responsibility of the caller.
+func (bb *http3BodyBuffer) inferHeader(h Header, status int) {
+	if _, ok := h["Date"]; !ok {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	// If the Content-Encoding is non-blank, we shouldn't
+	// sniff the body. See Issue golang.org/issue/31753.
+	_, hasCE := h["Content-Encoding"]
+	_, hasCT := h["Content-Type"]
+	if !hasCE && !hasCT && bodyAllowedForStatus(status) && len(*bb) > 0 {
+		h.Set("Content-Type", DetectContentType(*bb))
+	}
+}
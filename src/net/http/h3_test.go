@@ -0,0 +1,334 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/http/internal"
+	"testing"
+	"time"
+)
+
+// h3ServerTest is a Server serving the same Handler over TLS
+// (HTTP/1.1) and over QUIC (HTTP/3) on the loopback interface.
+type h3ServerTest struct {
+	ts    *httptest.Server
+	tr    *Transport
+	c     *Client
+	errc  chan error // result of ServeQUIC
+	udpPC net.PacketConn
+}
+
+func newH3ServerTest(t *testing.T, h Handler) *h3ServerTest {
+	t.Helper()
+	st := &h3ServerTest{
+		ts:   httptest.NewUnstartedServer(h),
+		errc: make(chan error, 1),
+	}
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.ts.Config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	st.ts.Config.AdvertiseHTTP3 = true
+	st.ts.StartTLS()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		st.ts.Close()
+		t.Fatal(err)
+	}
+	st.udpPC = pc
+	go func() {
+		st.errc <- st.ts.Config.ServeQUIC(pc, "", "")
+	}()
+	st.tr = st.ts.Client().Transport.(*Transport).Clone()
+	st.tr.EnableHTTP3 = true
+	st.c = &Client{Transport: st.tr}
+
+	// Wait for ServeQUIC to start advertising itself.
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+		res, err := st.ts.Client().Head(st.ts.URL)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.Header.Get("Alt-Svc") != ""
+	}) {
+		st.close()
+		select {
+		case err := <-st.errc:
+			t.Fatalf("ServeQUIC: %v", err)
+		default:
+		}
+		t.Fatal("server never advertised an HTTP/3 alternative")
+	}
+	return st
+}
+
+func (st *h3ServerTest) close() {
+	st.tr.CloseIdleConnections()
+	st.ts.Close()
+	st.ts.Config.Close()
+	st.udpPC.Close()
+}
+
+func mustNewRequest(t *testing.T, method, url string, body io.Reader) *Request {
+	t.Helper()
+	req, err := NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestHTTP3AltSvcUpgrade(t *testing.T) {
+	defer afterTest(t)
+	st := newH3ServerTest(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	}))
+	defer st.close()
+
+	wantAltSvc := fmt.Sprintf(`h3=":%d"`, st.udpPC.LocalAddr().(*net.UDPAddr).Port)
+	for i, want := range []string{"HTTP/1.1", "HTTP/3.0", "HTTP/3.0"} {
+		res, err := st.c.Get(st.ts.URL + "/path")
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("request %d: reading body: %v", i, err)
+		}
+		if res.Proto != want {
+			t.Fatalf("request %d: Proto = %q, want %q", i, res.Proto, want)
+		}
+		if got, want := string(body), want+" /path"; got != want {
+			t.Errorf("request %d: body = %q, want %q", i, got, want)
+		}
+		if res.ProtoMajor == 1 {
+			if got := res.Header.Get("Alt-Svc"); got != wantAltSvc {
+				t.Errorf("request %d: Alt-Svc = %q, want %q", i, got, wantAltSvc)
+			}
+		} else {
+			if got := res.Header.Get("Alt-Svc"); got != "" {
+				t.Errorf("request %d: HTTP/3 response has Alt-Svc %q", i, got)
+			}
+			if res.TLS == nil || res.TLS.NegotiatedProtocol != "h3" {
+				t.Errorf("request %d: TLS = %+v, want negotiated protocol h3", i, res.TLS)
+			}
+		}
+	}
+}
+
+// Without Server.AdvertiseHTTP3, ServeQUIC serves HTTP/3 but responses over
+// TLS don't advertise it.
+func TestHTTP3NoAltSvcByDefault(t *testing.T) {
+	defer afterTest(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	altSvc := fmt.Sprintf(`h3=":%d"`, pc.LocalAddr().(*net.UDPAddr).Port)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/advertise" {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		io.WriteString(w, r.Proto)
+	}))
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	defer ts.Close()
+	defer ts.Config.Close()
+	go ts.Config.ServeQUIC(pc, "", "")
+
+	// Learn the HTTP/3 endpoint from the Handler, and wait for it to
+	// serve a request.
+	tr := ts.Client().Transport.(*Transport).Clone()
+	defer tr.CloseIdleConnections()
+	tr.EnableHTTP3 = true
+	c := &Client{Transport: tr}
+	getAndDiscard(t, c, ts.URL+"/advertise")
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.ProtoMajor == 3
+	}) {
+		t.Fatal("ServeQUIC never served an HTTP/3 request")
+	}
+
+	for _, c := range []*Client{ts.Client(), c} {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Alt-Svc"); got != "" {
+			t.Errorf("%s response has Alt-Svc %q, want none", res.Proto, got)
+		}
+	}
+}
+
+func TestHTTP3RequestBodyAndTrailers(t *testing.T) {
+	defer afterTest(t)
+	st := newH3ServerTest(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.ProtoMajor != 3 {
+			w.Header().Set("Content-Type", "text/plain")
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		w.Header().Set("Trailer", "Server-Trailer")
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s:%s", body, r.Trailer.Get("Client-Trailer"))
+		w.Header().Set("Server-Trailer", "world")
+	}))
+	defer st.close()
+
+	// Learn the alternative.
+	res, err := st.c.Get(st.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	pr, pw := io.Pipe()
+	req := mustNewRequest(t, "POST", st.ts.URL, pr)
+	req.Trailer = Header{"Client-Trailer": nil}
+	go func() {
+		io.WriteString(pw, "hello")
+		req.Trailer.Set("Client-Trailer", "trailer")
+		pw.Close()
+	}()
+	res, err = st.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.ProtoMajor != 3 {
+		t.Fatalf("ProtoMajor = %d, want 3", res.ProtoMajor)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), "hello:trailer"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got, want := res.Trailer.Get("Server-Trailer"), "world"; got != want {
+		t.Errorf("Server-Trailer = %q, want %q", got, want)
+	}
+}
+
+func TestHTTP3Shutdown(t *testing.T) {
+	defer afterTest(t)
+	release := make(chan struct{})
+	st := newH3ServerTest(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer st.close()
+
+	res, err := st.c.Get(st.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Start a request, then shut down while it is in flight.
+	resc := make(chan *Response, 1)
+	errc := make(chan error, 1)
+	go func() {
+		res, err := st.c.Get(st.ts.URL + "/slow")
+		if err != nil {
+			errc <- err
+			return
+		}
+		resc <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+	shutdownc := make(chan error, 1)
+	go func() {
+		shutdownc <- st.ts.Config.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-st.errc:
+		if err != ErrServerClosed {
+			t.Errorf("ServeQUIC = %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeQUIC did not return after Shutdown")
+	}
+	close(release)
+
+	select {
+	case res := <-resc:
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.ProtoMajor != 3 || string(body) != "ok" {
+			t.Errorf("in-flight request: got HTTP/%d %q, want HTTP/3 %q", res.ProtoMajor, body, "ok")
+		}
+	case err := <-errc:
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	if err := <-shutdownc; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
+
+func TestHTTP3BrokenAlternativeFallback(t *testing.T) {
+	defer afterTest(t)
+	// Reserve a UDP port with nothing serving HTTP/3 on it.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=3600`, pc.LocalAddr().(*net.UDPAddr).Port)
+
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+	}))
+	defer ts.Close()
+	tr := ts.Client().Transport.(*Transport).Clone()
+	defer tr.CloseIdleConnections()
+	tr.EnableHTTP3 = true
+	tr.TLSHandshakeTimeout = 200 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", ts.URL, nil))
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		res.Body.Close()
+		if res.ProtoMajor != 1 {
+			t.Errorf("request %d: ProtoMajor = %d, want 1", i, res.ProtoMajor)
+		}
+		// Only the first request after learning the alternative
+		// should wait for the failed dial.
+		if i == 2 && time.Since(start) > 150*time.Millisecond {
+			t.Errorf("request %d took %v; broken alternative was retried", i, time.Since(start))
+		}
+	}
+}
@@ -34,6 +34,141 @@ var aLongTimeAgo = time.Unix(1, 0)
 // shouldn't try to use it.
 var omitBundledHTTP2 bool
 
+// Protocols is a set of HTTP protocols.
+// The zero value is an empty set of protocols.
+//
+// The supported protocols are:
+//
+//   - HTTP1 is the HTTP/1.0 and HTTP/1.1 protocols.
+//     HTTP1 is supported on both unsecured TCP and secured TLS connections.
+//
+//   - HTTP2 is the HTTP/2 protocol over a TLS connection.
+//
+//   - UnencryptedHTTP2 is the HTTP/2 protocol over an unsecured TCP
+//     connection, using prior knowledge rather than an upgrade from
+//     HTTP/1.1 (RFC 7540, section 3.4). This is sometimes called h2c.
+type Protocols struct {
+	bits uint8
+}
+
+const (
+	protoHTTP1 = 1 << iota
+	protoHTTP2
+	protoUnencryptedHTTP2
+)
+
+// HTTP1 reports whether p includes HTTP/1.
+func (p Protocols) HTTP1() bool { return p.bits&protoHTTP1 != 0 }
+
+// SetHTTP1 adds or removes HTTP/1 from p.
+func (p *Protocols) SetHTTP1(ok bool) { p.setBit(protoHTTP1, ok) }
+
+// HTTP2 reports whether p includes HTTP/2.
+func (p Protocols) HTTP2() bool { return p.bits&protoHTTP2 != 0 }
+
+// SetHTTP2 adds or removes HTTP/2 from p.
+func (p *Protocols) SetHTTP2(ok bool) { p.setBit(protoHTTP2, ok) }
+
+// UnencryptedHTTP2 reports whether p includes unencrypted HTTP/2.
+func (p Protocols) UnencryptedHTTP2() bool { return p.bits&protoUnencryptedHTTP2 != 0 }
+
+// SetUnencryptedHTTP2 adds or removes unencrypted HTTP/2 from p.
+func (p *Protocols) SetUnencryptedHTTP2(ok bool) { p.setBit(protoUnencryptedHTTP2, ok) }
+
+func (p *Protocols) setBit(bit uint8, ok bool) {
+	if ok {
+		p.bits |= bit
+	} else {
+		p.bits &^= bit
+	}
+}
+
+func (p Protocols) String() string {
+	var s []string
+	if p.HTTP1() {
+		s = append(s, "HTTP1")
+	}
+	if p.HTTP2() {
+		s = append(s, "HTTP2")
+	}
+	if p.UnencryptedHTTP2() {
+		s = append(s, "UnencryptedHTTP2")
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// HTTP2Config defines HTTP/2 configuration parameters common to
+// both Transport and Server.
+//
+// Fields that do not apply to one side of a connection are noted,
+// and ignored there.
+type HTTP2Config struct {
+	// MaxConcurrentStreams optionally specifies the number of
+	// concurrent streams that a client may have open at a time
+	// on a connection to a Server.
+	// If zero, MaxConcurrentStreams defaults to at least 100.
+	// A Transport ignores it: it disables server push, so its
+	// peer never opens streams.
+	MaxConcurrentStreams int
+
+	// MaxReadFrameSize optionally specifies the largest frame
+	// this endpoint is willing to read.
+	// A valid value is between 16KiB and 16MiB, inclusive.
+	// If zero or invalid, a default value is used.
+	// A Transport ignores it and advertises the default of 16KiB.
+	MaxReadFrameSize int
+
+	// MaxReceiveBufferPerConnection is the initial size of the
+	// flow control window for data received on a connection.
+	// A valid value is at least 64KiB and less than 2GiB.
+	// If zero or invalid, a default value is used.
+	// A Transport ignores it and uses a window of 1GiB.
+	MaxReceiveBufferPerConnection int
+
+	// MaxReceiveBufferPerStream is the initial size of the flow
+	// control window for data received on a stream (request or
+	// response body).
+	// A valid value is at least 64KiB and less than 2GiB.
+	// If zero or invalid, a default value is used.
+	// A Transport ignores it and uses a window of 4MiB.
+	MaxReceiveBufferPerStream int
+
+	// SendPingTimeout is the timeout after which a health check using
+	// a PING frame will be carried out if no frame is received on a
+	// connection. If zero, no health check is performed.
+	// A Server ignores it.
+	SendPingTimeout time.Duration
+
+	// PingTimeout is the timeout after which a connection will be
+	// closed if a response to a health check PING is not received.
+	// If zero, a default of 15 seconds is used.
+	// A Server ignores it.
+	PingTimeout time.Duration
+
+	// PermitProhibitedCipherSuites, if true, permits a Server to use
+	// cipher suites prohibited by the HTTP/2 spec. A Transport
+	// ignores it.
+	PermitProhibitedCipherSuites bool
+}
+
+// h2FlowWindowSize returns v as an initial flow control window size,
+// or 0 (the default) if it's out of range.
+func h2FlowWindowSize(v int) int32 {
+	if v >= 64<<10 && int64(v) < 1<<31 {
+		return int32(v)
+	}
+	return 0
+}
+
+// h2FrameSize returns v as a maximum frame size,
+// or 0 (the default) if it's out of range.
+func h2FrameSize(v int) uint32 {
+	if v >= 16<<10 && v <= 16<<20 {
+		return uint32(v)
+	}
+	return 0
+}
+
 // TODO(bradfitz): move common stuff here. The other files have accumulated
 // generic http stuff in random places.
 
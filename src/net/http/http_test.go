@@ -161,3 +161,23 @@ func BenchmarkCopyValues(b *testing.B) {
 		b.Fatal("Benchmark wasn't run")
 	}
 }
+
+func TestProtocols(t *testing.T) {
+	var p Protocols
+	if p.HTTP1() || p.HTTP2() || p.UnencryptedHTTP2() {
+		t.Errorf("zero Protocols = %v, want empty", p)
+	}
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(true)
+	if got, want := p.String(), "{HTTP1,UnencryptedHTTP2}"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	p.SetHTTP1(false)
+	p.SetHTTP2(true)
+	if !p.HTTP2() || p.HTTP1() {
+		t.Errorf("after SetHTTP1(false), SetHTTP2(true): %v", p)
+	}
+	if got, want := p.String(), "{HTTP2,UnencryptedHTTP2}"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -310,6 +311,99 @@ func TestXForwardedFor_Omit(t *testing.T) {
 	res.Body.Close()
 }
 
+func TestReverseProxyRewriteStripsForwarded(t *testing.T) {
+	headers := []string{
+		"Forwarded",
+		"X-Forwarded-For",
+		"X-Forwarded-Host",
+		"X-Forwarded-Proto",
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range headers {
+			if v := r.Header.Get(h); v != "" {
+				t.Errorf("got %v header: %q", h, v)
+			}
+		}
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyHandler := &ReverseProxy{
+		Rewrite: func(r *ProxyRequest) {
+			r.SetURL(backendURL)
+		},
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	getReq, _ := http.NewRequest("GET", frontend.URL, nil)
+	getReq.Host = "some-name"
+	getReq.Close = true
+	for _, h := range headers {
+		getReq.Header.Set(h, "x")
+	}
+	res, err := frontend.Client().Do(getReq)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	res.Body.Close()
+}
+
+// A client listing a header in Connection must not be able to remove
+// headers set by the Rewrite function.
+func TestReverseProxyRewriteKeepsHeadersPresentInConnection(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Forwarded-For"), "127.0.0.1"; got != want {
+			t.Errorf("got X-Forwarded-For %q, want %q", got, want)
+		}
+		if got, want := r.Header.Get("X-Added-By-Proxy"), "yes"; got != want {
+			t.Errorf("got X-Added-By-Proxy %q, want %q", got, want)
+		}
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyHandler := &ReverseProxy{
+		Rewrite: func(r *ProxyRequest) {
+			r.SetURL(backendURL)
+			r.SetXForwarded()
+			r.Out.Header.Set("X-Added-By-Proxy", "yes")
+		},
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	getReq, _ := http.NewRequest("GET", frontend.URL, nil)
+	getReq.Header.Set("Connection", "X-Forwarded-For, X-Added-By-Proxy")
+	res, err := frontend.Client().Do(getReq)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	res.Body.Close()
+}
+
+func TestReverseProxyDirectorAndRewrite(t *testing.T) {
+	for _, proxyHandler := range []*ReverseProxy{
+		{},
+		{
+			Director: func(*http.Request) {},
+			Rewrite:  func(*ProxyRequest) {},
+		},
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		rec := httptest.NewRecorder()
+		proxyHandler.ErrorLog = log.New(ioutil.Discard, "", 0)
+		proxyHandler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("got status %d, want %d", rec.Code, http.StatusBadGateway)
+		}
+	}
+}
+
 var proxyQueryTests = []struct {
 	baseSuffix string // suffix to add to backend URL
 	reqSuffix  string // suffix to add to frontend's request URL
@@ -1401,3 +1495,118 @@ func TestJoinURLPath(t *testing.T) {
 		}
 	}
 }
+
+func TestSetURL(t *testing.T) {
+	tests := []struct {
+		target string
+		in     string
+		want   string
+	}{
+		{"http://backend", "http://proxy/dir", "http://backend/dir"},
+		{"http://backend/base", "http://proxy/dir", "http://backend/base/dir"},
+		{"http://backend/base/", "http://proxy/dir", "http://backend/base/dir"},
+		{"https://backend/a%2Fb", "http://proxy/c", "https://backend/a%2Fb/c"},
+		{"http://backend/?sta=tic", "http://proxy/?us=er", "http://backend/?sta=tic&us=er"},
+		{"http://backend/?sta=tic", "http://proxy/", "http://backend/?sta=tic"},
+	}
+	for _, tt := range tests {
+		target, err := url.Parse(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := httptest.NewRequest("GET", tt.in, nil)
+		r := &ProxyRequest{In: in, Out: in.Clone(in.Context())}
+		r.SetURL(target)
+		if got := r.Out.URL.String(); got != tt.want {
+			t.Errorf("SetURL(%q) for %q: got URL %q, want %q", tt.target, tt.in, got, tt.want)
+		}
+		if r.Out.Host != "" {
+			t.Errorf("SetURL(%q): got Host %q, want empty", tt.target, r.Out.Host)
+		}
+	}
+}
+
+func TestSetXForwarded(t *testing.T) {
+	in := httptest.NewRequest("GET", "http://example.com/", nil)
+	in.RemoteAddr = "192.0.2.1:1234"
+	r := &ProxyRequest{In: in, Out: in.Clone(in.Context())}
+	r.Out.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.SetXForwarded()
+	want := http.Header{
+		"X-Forwarded-For":   {"198.51.100.1, 192.0.2.1"},
+		"X-Forwarded-Host":  {"example.com"},
+		"X-Forwarded-Proto": {"http"},
+	}
+	if !reflect.DeepEqual(r.Out.Header, want) {
+		t.Errorf("got headers %v, want %v", r.Out.Header, want)
+	}
+
+	in.TLS = &tls.ConnectionState{}
+	in.RemoteAddr = "@"
+	r.SetXForwarded()
+	if v, ok := r.Out.Header["X-Forwarded-For"]; ok {
+		t.Errorf("got X-Forwarded-For %q for unparsable remote address", v)
+	}
+	if got := r.Out.Header.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("got X-Forwarded-Proto %q, want %q", got, "https")
+	}
+}
+
+func TestSetForwarded(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		host       string
+		tls        bool
+		prior      []string
+		want       string
+	}{
+		{"192.0.2.1:1234", "example.com", false, nil, "for=192.0.2.1;host=example.com;proto=http"},
+		{"192.0.2.1:1234", "example.com:8443", true, nil, `for=192.0.2.1;host="example.com:8443";proto=https`},
+		{"[2001:db8::1]:1234", "example.com", false, nil, `for="[2001:db8::1]";host=example.com;proto=http`},
+		{"@", "example.com", false, nil, "host=example.com;proto=http"},
+		{"192.0.2.1:1234", "example.com", false, []string{"for=198.51.100.1", "for=198.51.100.2"},
+			"for=198.51.100.1, for=198.51.100.2, for=192.0.2.1;host=example.com;proto=http"},
+	}
+	for _, tt := range tests {
+		in := httptest.NewRequest("GET", "http://example.com/", nil)
+		in.RemoteAddr = tt.remoteAddr
+		in.Host = tt.host
+		if tt.tls {
+			in.TLS = &tls.ConnectionState{}
+		}
+		r := &ProxyRequest{In: in, Out: in.Clone(in.Context())}
+		r.Out.Header["Forwarded"] = tt.prior
+		r.SetForwarded()
+		if got := r.Out.Header["Forwarded"]; len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s, %s: got Forwarded %q, want %q", tt.remoteAddr, tt.host, got, tt.want)
+		}
+	}
+	if got, want := forwardedValue(`a"b\c`), `"a\"b\\c"`; got != want {
+		t.Errorf("forwardedValue = %s, want %s", got, want)
+	}
+}
+
+func TestReverseProxyRewriteReplacesOut(t *testing.T) {
+	const content = "response_content"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer backend.Close()
+	proxyHandler := &ReverseProxy{
+		Rewrite: func(r *ProxyRequest) {
+			r.Out, _ = http.NewRequest("GET", backend.URL, nil)
+		},
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	res, err := frontend.Client().Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if got, want := string(body), content; got != want {
+		t.Errorf("got response %q, want %q", got, want)
+	}
+}
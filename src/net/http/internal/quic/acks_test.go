@@ -0,0 +1,186 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestAcksInitialAndHandshakeImmediate(t *testing.T) {
+	for _, space := range []numberSpace{initialSpace, handshakeSpace} {
+		var acks ackState
+		acks.receive(testStart, space, 0, true, ecnNotECT)
+		if !acks.shouldSendAck(testStart) {
+			t.Errorf("%v: ack-eliciting packet is not acked immediately", space)
+		}
+	}
+}
+
+func TestAcksDelayed(t *testing.T) {
+	var acks ackState
+	acks.receive(testStart, appDataSpace, 0, true, ecnNotECT)
+	if acks.shouldSendAck(testStart) {
+		t.Fatalf("first 1-RTT packet is acked immediately, want delayed ack")
+	}
+	ackTime := testStart.Add(maxAckDelay - timerGranularity)
+	if acks.shouldSendAck(ackTime.Add(-1)) {
+		t.Errorf("ack sent before max_ack_delay")
+	}
+	if !acks.shouldSendAck(ackTime) {
+		t.Errorf("ack not sent at max_ack_delay")
+	}
+	nums, delay := acks.acksToSend(testStart.Add(5 * time.Millisecond))
+	if want := (rangeset{{0, 1}}); !reflect.DeepEqual(nums, want) {
+		t.Errorf("acksToSend nums = %v, want %v", nums, want)
+	}
+	if want := 5 * time.Millisecond; delay != want {
+		t.Errorf("acksToSend delay = %v, want %v", delay, want)
+	}
+	acks.sentAck()
+	if acks.shouldSendAck(ackTime) {
+		t.Errorf("ack still due after sentAck")
+	}
+}
+
+func TestAcksEveryOtherPacket(t *testing.T) {
+	var acks ackState
+	now := testStart
+	for num := packetNumber(0); num < 102; num++ {
+		acks.receive(now, appDataSpace, num, true, ecnNotECT)
+		want := num%2 == 1
+		if got := acks.shouldSendAck(now); got != want {
+			t.Fatalf("after packet %v: shouldSendAck = %v, want %v", num, got, want)
+		}
+		if want {
+			acks.sentAck()
+		}
+	}
+	// After the first 100 packets, we ack every 10th packet.
+	for i := 1; i <= 10; i++ {
+		num := packetNumber(101 + i)
+		acks.receive(now, appDataSpace, num, true, ecnNotECT)
+		want := i == 10
+		if got := acks.shouldSendAck(now); got != want {
+			t.Fatalf("after packet %v: shouldSendAck = %v, want %v", num, got, want)
+		}
+	}
+}
+
+func TestAcksImmediateOnGapOrReordering(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		recv []packetNumber // the last packet received is checked
+	}{
+		{"gap", []packetNumber{0, 2}},
+		{"out of order", []packetNumber{0, 5, 3}},
+	} {
+		var acks ackState
+		for i, num := range test.recv {
+			acks.receive(testStart, appDataSpace, num, true, ecnNotECT)
+			if i < len(test.recv)-1 {
+				acks.sentAck()
+			}
+		}
+		if !acks.shouldSendAck(testStart) {
+			t.Errorf("%v: packet %v is not acked immediately", test.name, test.recv[len(test.recv)-1])
+		}
+	}
+}
+
+func TestAcksNoGapAfterNonAckEliciting(t *testing.T) {
+	// Receive ack-eliciting 0 and 1, then non-ack-eliciting 3,
+	// then ack-eliciting 2: there is no gap between the
+	// largest ack-eliciting packet (1) and 2.
+	var acks ackState
+	acks.receive(testStart, appDataSpace, 0, true, ecnNotECT)
+	acks.receive(testStart, appDataSpace, 1, true, ecnNotECT)
+	acks.sentAck()
+	acks.receive(testStart, appDataSpace, 3, false, ecnNotECT)
+	acks.receive(testStart, appDataSpace, 2, true, ecnNotECT)
+	if acks.shouldSendAck(testStart) {
+		t.Errorf("packet 2 is acked immediately, want delayed ack")
+	}
+}
+
+func TestAcksCongestionExperienced(t *testing.T) {
+	var acks ackState
+	acks.receive(testStart, appDataSpace, 0, true, ecnCE)
+	if !acks.shouldSendAck(testStart) {
+		t.Errorf("CE-marked packet is not acked immediately")
+	}
+	acks.receive(testStart, appDataSpace, 1, false, ecnECT0)
+	acks.receive(testStart, appDataSpace, 2, false, ecnECT1)
+	acks.receive(testStart, appDataSpace, 3, false, ecnECT0)
+	if want := (ecnCounts{t0: 2, t1: 1, ce: 1}); acks.ecn != want {
+		t.Errorf("ecn counts = %+v, want %+v", acks.ecn, want)
+	}
+}
+
+func TestAcksNonAckEliciting(t *testing.T) {
+	var acks ackState
+	for num := packetNumber(0); num < 10; num++ {
+		acks.receive(testStart, appDataSpace, num, false, ecnNotECT)
+	}
+	if acks.shouldSendAck(testStart.Add(time.Hour)) {
+		t.Errorf("ack sent for only non-ack-eliciting packets")
+	}
+	if nums, _ := acks.acksToSend(testStart); nums != nil {
+		t.Errorf("acksToSend = %v, want nothing", nums)
+	}
+	if got := acks.largestSeen(); got != 9 {
+		t.Errorf("largestSeen = %v, want 9", got)
+	}
+}
+
+func TestAcksDuplicates(t *testing.T) {
+	var acks ackState
+	for _, num := range []packetNumber{0, 1, 2, 5, 6} {
+		if !acks.shouldProcess(num) {
+			t.Fatalf("shouldProcess(%v) = false for a new packet", num)
+		}
+		acks.receive(testStart, appDataSpace, num, true, ecnNotECT)
+	}
+	for _, test := range []struct {
+		num  packetNumber
+		want bool
+	}{
+		{0, false},
+		{2, false},
+		{3, true},
+		{6, false},
+		{7, true},
+	} {
+		if got := acks.shouldProcess(test.num); got != test.want {
+			t.Errorf("shouldProcess(%v) = %v, want %v", test.num, got, test.want)
+		}
+	}
+
+	// The peer has received an ACK frame acking packet 5.
+	// We discard state for earlier packets, and stop processing them.
+	acks.handleAck(5)
+	if want := (rangeset{{5, 7}}); !reflect.DeepEqual(acks.seen, want) {
+		t.Errorf("after handleAck(5), seen = %v, want %v", acks.seen, want)
+	}
+	if acks.shouldProcess(3) {
+		t.Errorf("shouldProcess(3) = true after discarding ack state for it")
+	}
+}
+
+func TestAcksRangeLimit(t *testing.T) {
+	var acks ackState
+	for num := packetNumber(0); num < 40; num += 2 {
+		acks.receive(testStart, appDataSpace, num, false, ecnNotECT)
+	}
+	if got, want := acks.seen.numRanges(), 8; got != want {
+		t.Errorf("numRanges = %v, want %v", got, want)
+	}
+	if got, want := acks.seen.min(), int64(40-2*8); got != want {
+		t.Errorf("oldest remembered packet = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "sync/atomic"
+
+// atomicStreamState is an atomic streamState that supports setting
+// individual bits.
+type atomicStreamState struct {
+	bits uint32 // atomic
+}
+
+// set sets the bits in mask to the corresponding bits in v.
+// It returns the new value.
+func (a *atomicStreamState) set(v, mask streamState) streamState {
+	if v&^mask != 0 {
+		panic("BUG: bits in v are not in mask")
+	}
+	for {
+		o := atomic.LoadUint32(&a.bits)
+		n := (o &^ uint32(mask)) | uint32(v)
+		if atomic.CompareAndSwapUint32(&a.bits, o, n) {
+			return streamState(n)
+		}
+	}
+}
+
+func (a *atomicStreamState) load() streamState {
+	return streamState(atomic.LoadUint32(&a.bits))
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+// A ccTest drives a ccReno through batches of acked and lost packets.
+type ccTest struct {
+	t       *testing.T
+	cc      *ccReno
+	rtt     rttState
+	now     time.Time
+	nextNum packetNumber
+	sent    map[packetNumber]*sentPacket
+}
+
+func newCCTest(t *testing.T) *ccTest {
+	test := &ccTest{
+		t:    t,
+		cc:   newReno(smallestMaxDatagramSize),
+		now:  testStart,
+		sent: make(map[packetNumber]*sentPacket),
+	}
+	test.rtt.init()
+	return test
+}
+
+func (test *ccTest) advance(d time.Duration) {
+	test.now = test.now.Add(d)
+}
+
+// send sends n full-size, ack-eliciting packets.
+func (test *ccTest) send(n int) {
+	for i := 0; i < n; i++ {
+		sent := &sentPacket{
+			num:          test.nextNum,
+			size:         smallestMaxDatagramSize,
+			time:         test.now,
+			ackEliciting: true,
+			inFlight:     true,
+		}
+		test.nextNum++
+		test.sent[sent.num] = sent
+		test.cc.packetSent(test.now, appDataSpace, sent)
+	}
+}
+
+// batch acks and loses packets in a single batch.
+func (test *ccTest) batch(acked, lost []packetNumber) {
+	for _, num := range acked {
+		test.cc.packetAcked(test.now, test.sent[num])
+	}
+	for _, num := range lost {
+		test.cc.packetLost(test.now, appDataSpace, test.sent[num], &test.rtt)
+	}
+	test.cc.packetBatchEnd(test.now, appDataSpace, &test.rtt, maxAckDelay)
+}
+
+func (test *ccTest) wantWindow(want int) {
+	test.t.Helper()
+	if got := test.cc.congestionWindow; got != want {
+		test.t.Errorf("congestion window = %v, want %v", got, want)
+	}
+}
+
+func nums(start, end packetNumber) (s []packetNumber) {
+	for n := start; n < end; n++ {
+		s = append(s, n)
+	}
+	return s
+}
+
+func TestRenoInitialWindow(t *testing.T) {
+	// min(10 * max_datagram_size, max(14720, 2 * max_datagram_size))
+	// https://www.rfc-editor.org/rfc/rfc9002#section-7.2-1
+	for _, test := range []struct {
+		maxDatagramSize int
+		want            int
+	}{
+		{1200, 12000},
+		{1500, 14720},
+		{9000, 18000},
+	} {
+		if got := newReno(test.maxDatagramSize).congestionWindow; got != test.want {
+			t.Errorf("newReno(%v).congestionWindow = %v, want %v", test.maxDatagramSize, got, test.want)
+		}
+	}
+}
+
+func TestRenoCanSend(t *testing.T) {
+	test := newCCTest(t)
+	for i := 0; i < 10; i++ {
+		if !test.cc.canSend() {
+			t.Fatalf("canSend = false with %v bytes in flight", test.cc.bytesInFlight)
+		}
+		test.send(1)
+	}
+	if test.cc.canSend() {
+		t.Errorf("canSend = true with a full congestion window")
+	}
+	test.batch(nums(0, 1), nil)
+	if !test.cc.canSend() {
+		t.Errorf("canSend = false after ack")
+	}
+}
+
+func TestRenoSlowStart(t *testing.T) {
+	test := newCCTest(t)
+	test.send(10)
+	test.advance(10 * time.Millisecond)
+	test.batch(nums(0, 5), nil)
+	// In slow start, the window grows by the number of bytes acked.
+	test.wantWindow(12000 + 5*1200)
+	test.batch(nums(5, 10), nil)
+	test.wantWindow(12000 + 10*1200)
+	if got := test.cc.bytesInFlight; got != 0 {
+		t.Errorf("bytes in flight = %v, want 0", got)
+	}
+}
+
+func TestRenoRecovery(t *testing.T) {
+	test := newCCTest(t)
+	test.send(10)
+	test.advance(10 * time.Millisecond)
+	test.batch(nums(1, 5), nums(0, 1))
+	// Loss halves the window, and acks in the same batch do not grow it.
+	// https://www.rfc-editor.org/rfc/rfc9002#section-7.3.2
+	test.wantWindow(12000 / 2)
+	if !test.cc.inRecovery || test.cc.slowStartThreshold != 6000 {
+		t.Errorf("inRecovery = %v, ssthresh = %v; want true, 6000", test.cc.inRecovery, test.cc.slowStartThreshold)
+	}
+
+	// One packet may be sent on entering recovery,
+	// even though bytes in flight exceed the window.
+	if !test.cc.canSend() {
+		t.Errorf("canSend = false on entering recovery")
+	}
+	test.send(1)
+	if test.cc.canSend() {
+		t.Errorf("canSend = true after sending one packet in recovery")
+	}
+
+	// Acks and losses of packets sent before recovery began
+	// do not change the window.
+	test.advance(10 * time.Millisecond)
+	test.batch(nums(5, 8), nil)
+	test.batch(nil, nums(8, 9))
+	test.wantWindow(6000)
+
+	// An ack for a packet sent during recovery ends it.
+	test.batch(nums(9, 11), nil)
+	if test.cc.inRecovery {
+		t.Errorf("still in recovery after ack of packet sent in recovery")
+	}
+}
+
+func TestRenoMinimumWindow(t *testing.T) {
+	test := newCCTest(t)
+	for i := 0; i < 5; i++ {
+		test.send(1)
+		test.advance(10 * time.Millisecond)
+		test.batch(nil, []packetNumber{test.nextNum - 1})
+	}
+	test.wantWindow(test.cc.minimumCongestionWindow())
+	if got, want := test.cc.minimumCongestionWindow(), 2*smallestMaxDatagramSize; got != want {
+		t.Errorf("minimumCongestionWindow = %v, want %v", got, want)
+	}
+}
+
+func TestRenoCongestionAvoidance(t *testing.T) {
+	test := newCCTest(t)
+	test.send(10)
+	test.advance(10 * time.Millisecond)
+	test.batch(nums(1, 10), nums(0, 1))
+	test.wantWindow(6000)
+
+	// Above the slow start threshold, the window grows by one datagram
+	// for each window's worth of acked bytes.
+	test.advance(10 * time.Millisecond)
+	test.send(6)
+	test.batch(nums(10, 15), nil)
+	test.wantWindow(6000)
+	test.batch(nums(15, 16), nil)
+	test.wantWindow(6000 + smallestMaxDatagramSize)
+}
+
+func TestRenoPersistentCongestion(t *testing.T) {
+	test := newCCTest(t)
+	test.rtt.updateSample(test.now, true, appDataSpace, 10*time.Millisecond, 0, maxAckDelay)
+	// (smoothed_rtt + max(4*rttvar, kGranularity) + max_ack_delay) *
+	//     kPersistentCongestionThreshold
+	// https://www.rfc-editor.org/rfc/rfc9002#section-7.6.1
+	d := 3 * (10*time.Millisecond + 4*5*time.Millisecond + maxAckDelay)
+	test.send(1)
+	test.advance(d)
+	test.send(1)
+	test.advance(10 * time.Millisecond)
+	test.batch(nil, nums(0, 2))
+	test.wantWindow(test.cc.minimumCongestionWindow())
+	if !test.cc.recoveryStartTime.IsZero() {
+		t.Errorf("recovery start time set after persistent congestion")
+	}
+	if test.rtt.minRTT != test.rtt.latestRTT {
+		t.Errorf("min_rtt not reset to latest_rtt after persistent congestion")
+	}
+}
+
+func TestRenoNoPersistentCongestionForShortLoss(t *testing.T) {
+	test := newCCTest(t)
+	test.rtt.updateSample(test.now, true, appDataSpace, 10*time.Millisecond, 0, maxAckDelay)
+	test.send(1)
+	test.advance(10 * time.Millisecond)
+	test.send(1)
+	test.advance(10 * time.Millisecond)
+	test.batch(nil, nums(0, 2))
+	test.wantWindow(12000 / 2)
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "testing"
+
+func TestShouldUpdateFlowControl(t *testing.T) {
+	for _, test := range []struct {
+		maxWindow, addedWindow int64
+		want                   bool
+	}{
+		{1000, 0, false},
+		{1000, 124, false},
+		{1000, 125, true},
+		{1000, 1000, true},
+		{8, 1, true},
+	} {
+		if got := shouldUpdateFlowControl(test.maxWindow, test.addedWindow); got != test.want {
+			t.Errorf("shouldUpdateFlowControl(%v, %v) = %v, want %v", test.maxWindow, test.addedWindow, got, test.want)
+		}
+	}
+}
+
+func TestConnInflowLimit(t *testing.T) {
+	c := newStreamTestConn(t, &Config{MaxConnReadBufferSize: 1000}, 0)
+	if err := c.handleStreamBytesReceived(1000); err != nil {
+		t.Fatalf("receiving up to the connection window: %v", err)
+	}
+	wantTransportError(t, "receiving past the connection window", c.handleStreamBytesReceived(1), errFlowControl)
+}
+
+func TestConnInflowMaxData(t *testing.T) {
+	c := newStreamTestConn(t, &Config{MaxConnReadBufferSize: 1000}, 0)
+	if err := c.handleStreamBytesReceived(1000); err != nil {
+		t.Fatal(err)
+	}
+	maxDataFrame := func(pnum packetNumber) (max int64, ok bool) {
+		var w packetWriter
+		w.reset(smallestMaxDatagramSize)
+		w.start1RTTPacket(pnum, -1, nil)
+		c.appendMaxDataFrame(&w, pnum, false)
+		if len(w.payload()) == 0 {
+			return 0, false
+		}
+		max, n := consumeMaxDataFrame(w.payload())
+		if n != len(w.payload()) {
+			t.Fatalf("malformed MAX_DATA frame: %x", w.payload())
+		}
+		return max, true
+	}
+
+	// Reading less than 1/8 of the window does not extend it.
+	c.handleStreamBytesReadOnLoop(100)
+	if max, ok := maxDataFrame(0); ok {
+		t.Errorf("MAX_DATA %v sent after reading 100 bytes, want none", max)
+	}
+	c.handleStreamBytesReadOnLoop(25)
+	if max, ok := maxDataFrame(1); !ok || max != 1125 {
+		t.Errorf("MAX_DATA after reading 125 bytes = %v (sent=%v), want 1125", max, ok)
+	}
+	if err := c.handleStreamBytesReceived(125); err != nil {
+		t.Errorf("receiving up to the extended window: %v", err)
+	}
+
+	// A lost MAX_DATA is resent with the latest limit.
+	c.ackOrLossMaxData(1, packetLost)
+	if max, ok := maxDataFrame(2); !ok || max != 1125 {
+		t.Errorf("resent MAX_DATA = %v (sent=%v), want 1125", max, ok)
+	}
+	c.ackOrLossMaxData(2, packetAcked)
+	if max, ok := maxDataFrame(3); ok {
+		t.Errorf("MAX_DATA %v sent after ack, want none", max)
+	}
+}
+
+func TestConnOutflow(t *testing.T) {
+	var f connOutflow
+	f.setMaxData(10)
+	// MAX_DATA frames may arrive out of order; the limit never decreases.
+	f.setMaxData(5)
+	if got := f.avail(); got != 10 {
+		t.Errorf("avail = %v, want 10", got)
+	}
+	f.consume(4)
+	if got := f.avail(); got != 6 {
+		t.Errorf("avail after consuming 4 = %v, want 6", got)
+	}
+	f.setMaxData(20)
+	if got := f.avail(); got != 16 {
+		t.Errorf("avail after MAX_DATA 20 = %v, want 16", got)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"net/http/internal"
+)
+
+func newLoopbackConfigs(t *testing.T) (server, client *Config) {
+	t.Helper()
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(internal.LocalhostCert) {
+		t.Fatal("failed to add test certificate to pool")
+	}
+	server = &Config{TLSConfig: &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"test"},
+	}}
+	client = &Config{TLSConfig: &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		ServerName: "example.com",
+		RootCAs:    roots,
+		NextProtos: []string{"test"},
+	}}
+	return server, client
+}
+
+func TestLoopbackStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	serverConfig, clientConfig := newLoopbackConfigs(t)
+
+	server, err := Listen("udp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(context.Background())
+	client, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(context.Background())
+
+	// The server echoes everything it reads on the first stream.
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := server.Accept(ctx)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		s, err := conn.AcceptStream(ctx)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		_, err = io.Copy(s, s)
+		if err == nil {
+			err = s.Close()
+		}
+		serverErr <- err
+	}()
+
+	conn, err := client.Dial(ctx, "udp", server.LocalAddr().String(), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Abort(nil)
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "test" {
+		t.Errorf("NegotiatedProtocol = %q, want %q", got, "test")
+	}
+	s, err := conn.NewStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetReadContext(ctx)
+	s.SetWriteContext(ctx)
+	want := bytes.Repeat([]byte("hello, quic "), 10000)
+	go func() {
+		s.Write(want)
+		s.CloseWrite()
+	}()
+	got, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want echo of %d bytes", len(got), len(want))
+	}
+	if err := <-serverErr; err != nil {
+		t.Errorf("server: %v", err)
+	}
+}
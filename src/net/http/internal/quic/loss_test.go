@@ -0,0 +1,298 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A lossTest drives a lossState through a sequence of sent packets,
+// received acks, and time advances, recording packet fates.
+type lossTest struct {
+	t     *testing.T
+	c     lossState
+	now   time.Time
+	acked []packetNumber
+	lost  []packetNumber
+}
+
+func newLossTest(t *testing.T, side connSide) *lossTest {
+	test := &lossTest{t: t, now: testStart}
+	test.c.init(side, smallestMaxDatagramSize, test.now)
+	return test
+}
+
+func (test *lossTest) advance(d time.Duration) {
+	test.now = test.now.Add(d)
+	test.c.advance(test.now, test.onFate)
+}
+
+func (test *lossTest) advanceToLossTimer() {
+	test.t.Helper()
+	if test.c.timer.IsZero() {
+		test.t.Fatalf("loss timer is not set")
+	}
+	test.advance(test.c.timer.Sub(test.now))
+}
+
+func (test *lossTest) send(space numberSpace, num packetNumber, ackEliciting bool) {
+	sent := newSentPacket()
+	sent.num = num
+	sent.size = smallestMaxDatagramSize
+	sent.ackEliciting = ackEliciting
+	sent.inFlight = ackEliciting
+	test.c.packetSent(test.now, space, sent)
+}
+
+// ack processes an ACK frame containing the given ranges,
+// which must be in descending order.
+func (test *lossTest) ack(space numberSpace, ackDelay time.Duration, ranges ...i64range) error {
+	test.c.receiveAckStart()
+	for i, r := range ranges {
+		err := test.c.receiveAckRange(test.now, space, i, packetNumber(r.start), packetNumber(r.end), test.onFate)
+		if err != nil {
+			return err
+		}
+	}
+	test.c.receiveAckEnd(test.now, space, ackDelay, test.onFate)
+	return nil
+}
+
+func (test *lossTest) onFate(space numberSpace, sent *sentPacket, fate packetFate) {
+	switch fate {
+	case packetAcked:
+		test.acked = append(test.acked, sent.num)
+	case packetLost:
+		test.lost = append(test.lost, sent.num)
+	}
+}
+
+func (test *lossTest) wantFates(acked, lost []packetNumber) {
+	test.t.Helper()
+	if !reflect.DeepEqual(test.acked, acked) {
+		test.t.Errorf("acked packets = %v, want %v", test.acked, acked)
+	}
+	if !reflect.DeepEqual(test.lost, lost) {
+		test.t.Errorf("lost packets = %v, want %v", test.lost, lost)
+	}
+	test.acked = nil
+	test.lost = nil
+}
+
+func TestLossPacketThreshold(t *testing.T) {
+	test := newLossTest(t, clientSide)
+	for num := packetNumber(0); num < 5; num++ {
+		test.send(appDataSpace, num, true)
+	}
+	test.advance(10 * time.Millisecond)
+	if err := test.ack(appDataSpace, 0, i64range{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	// Packets three or more before the largest acked packet are lost.
+	test.wantFates([]packetNumber{4}, []packetNumber{0, 1})
+
+	// The remaining packets are lost after the time threshold:
+	// 9/8 of the RTT after they were sent.
+	if want := testStart.Add(9 * 10 * time.Millisecond / 8); !test.c.timer.Equal(want) {
+		t.Errorf("loss timer = %v, want %v", test.c.timer, want)
+	}
+	test.advance(test.c.timer.Sub(test.now) - 1)
+	test.wantFates(nil, nil)
+	test.advance(1)
+	test.wantFates(nil, []packetNumber{2, 3})
+}
+
+func TestLossAckForUnsentPacket(t *testing.T) {
+	test := newLossTest(t, clientSide)
+	test.send(appDataSpace, 0, true)
+	if err := test.ack(appDataSpace, 0, i64range{0, 2}); err == nil {
+		t.Errorf("ack for packet never sent: no error")
+	}
+
+	// A skipped packet number is never sent.
+	test = newLossTest(t, clientSide)
+	test.send(appDataSpace, 0, true)
+	test.c.skipNumber(test.now, appDataSpace)
+	test.send(appDataSpace, 2, true)
+	if err := test.ack(appDataSpace, 0, i64range{0, 3}); err == nil {
+		t.Errorf("ack for skipped packet number: no error")
+	}
+}
+
+func TestLossDuplicateAck(t *testing.T) {
+	test := newLossTest(t, clientSide)
+	test.send(appDataSpace, 0, true)
+	test.send(appDataSpace, 1, true)
+	if err := test.ack(appDataSpace, 0, i64range{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	test.wantFates([]packetNumber{0, 1}, nil)
+	if err := test.ack(appDataSpace, 0, i64range{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	test.wantFates(nil, nil)
+	if got := test.c.cc.bytesInFlight; got != 0 {
+		t.Errorf("bytes in flight = %v, want 0", got)
+	}
+}
+
+func TestLossPTO(t *testing.T) {
+	test := newLossTest(t, serverSide)
+	test.c.validateClientAddress()
+	test.c.confirmHandshake()
+	test.send(appDataSpace, 0, true)
+
+	// smoothed_rtt + max(4*rttvar, kGranularity) + max_ack_delay
+	// https://www.rfc-editor.org/rfc/rfc9002#section-6.2.1
+	pto := 333*time.Millisecond + 4*333*time.Millisecond/2 + 25*time.Millisecond
+	if got := test.c.ptoPeriod(); got != pto {
+		t.Fatalf("ptoPeriod = %v, want %v", got, pto)
+	}
+	if !test.c.ptoTimerArmed || !test.c.timer.Equal(test.now.Add(pto)) {
+		t.Fatalf("PTO timer = %v (armed=%v), want %v", test.c.timer, test.c.ptoTimerArmed, test.now.Add(pto))
+	}
+	test.advanceToLossTimer()
+	if !test.c.ptoExpired {
+		t.Fatalf("PTO timer expired, but ptoExpired is not set")
+	}
+	// A PTO does not declare packets lost.
+	test.wantFates(nil, nil)
+	// A probe may be sent even when congestion control would block it.
+	cwnd := test.c.cc.congestionWindow
+	test.c.cc.congestionWindow = 0
+	if limit, _ := test.c.sendLimit(test.now); limit != ccOK {
+		t.Errorf("sendLimit after PTO = %v, want ccOK", limit)
+	}
+	test.c.cc.congestionWindow = cwnd
+
+	// Sending the probe rearms the timer, with exponential backoff.
+	test.send(appDataSpace, 1, true)
+	if test.c.ptoExpired {
+		t.Errorf("ptoExpired still set after sending probe")
+	}
+	if want := test.now.Add(2 * pto); !test.c.timer.Equal(want) {
+		t.Errorf("PTO timer after one PTO = %v, want %v", test.c.timer, want)
+	}
+	test.advanceToLossTimer()
+	if got := test.c.ptoBackoffCount; got != 2 {
+		t.Errorf("ptoBackoffCount = %v, want 2", got)
+	}
+
+	// An ack resets the backoff.
+	if err := test.ack(appDataSpace, 0, i64range{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := test.c.ptoBackoffCount; got != 0 {
+		t.Errorf("ptoBackoffCount after ack = %v, want 0", got)
+	}
+}
+
+func TestLossClientInitialPTOWithNothingInFlight(t *testing.T) {
+	// A client sets a PTO timer until the handshake is confirmed,
+	// even with no ack-eliciting packets in flight.
+	// https://www.rfc-editor.org/rfc/rfc9002#section-6.2.2.1
+	test := newLossTest(t, clientSide)
+	test.send(initialSpace, 0, true)
+	if err := test.ack(initialSpace, 0, i64range{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !test.c.ptoTimerArmed || test.c.timer.IsZero() {
+		t.Errorf("client has no PTO timer before handshake confirmation")
+	}
+	test.c.discardKeys(test.now, initialSpace)
+	test.c.confirmHandshake()
+	test.c.scheduleTimer(test.now)
+	if !test.c.timer.IsZero() {
+		t.Errorf("timer = %v with nothing in flight after handshake confirmation, want unset", test.c.timer)
+	}
+}
+
+func TestLossAntiAmplification(t *testing.T) {
+	test := newLossTest(t, serverSide)
+	if limit, _ := test.c.sendLimit(test.now); limit != ccBlocked {
+		t.Fatalf("server sendLimit before receiving anything = %v, want ccBlocked", limit)
+	}
+	test.c.datagramReceived(test.now, smallestMaxDatagramSize)
+	for num := packetNumber(0); num < 3; num++ {
+		if limit, _ := test.c.sendLimit(test.now); limit == ccBlocked {
+			t.Fatalf("sendLimit before sending packet %v = ccBlocked, want not blocked", num)
+		}
+		if got := test.c.maxSendSize(); got != smallestMaxDatagramSize {
+			t.Fatalf("maxSendSize = %v, want %v", got, smallestMaxDatagramSize)
+		}
+		test.send(initialSpace, num, true)
+	}
+	// The server has sent three times what it received.
+	if limit, _ := test.c.sendLimit(test.now); limit != ccBlocked {
+		t.Errorf("sendLimit at anti-amplification limit = %v, want ccBlocked", limit)
+	}
+	if !test.c.timer.IsZero() {
+		t.Errorf("PTO timer set while at the anti-amplification limit")
+	}
+	test.c.validateClientAddress()
+	if limit, _ := test.c.sendLimit(test.now); limit == ccBlocked {
+		t.Errorf("sendLimit after address validation = ccBlocked")
+	}
+}
+
+func TestLossRTT(t *testing.T) {
+	test := newLossTest(t, clientSide)
+	test.c.confirmHandshake()
+	test.send(appDataSpace, 0, true)
+	test.advance(50 * time.Millisecond)
+	if err := test.ack(appDataSpace, 0, i64range{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	// The first sample sets the RTT.
+	// https://www.rfc-editor.org/rfc/rfc9002#section-5.3-14
+	rtt := test.c.rtt
+	if rtt.minRTT != 50*time.Millisecond || rtt.smoothedRTT != 50*time.Millisecond || rtt.rttvar != 25*time.Millisecond {
+		t.Errorf("after first sample: min=%v smoothed=%v rttvar=%v; want 50ms, 50ms, 25ms", rtt.minRTT, rtt.smoothedRTT, rtt.rttvar)
+	}
+
+	test.send(appDataSpace, 1, true)
+	test.advance(100 * time.Millisecond)
+	if err := test.ack(appDataSpace, 20*time.Millisecond, i64range{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// adjusted_rtt = latest_rtt - ack_delay = 80ms
+	// rttvar = 3/4 * 25ms + 1/4 * |50ms - 80ms|
+	// smoothed_rtt = 7/8 * 50ms + 1/8 * 80ms
+	// https://www.rfc-editor.org/rfc/rfc9002#section-5.3-16
+	rtt = test.c.rtt
+	if rtt.latestRTT != 100*time.Millisecond || rtt.minRTT != 50*time.Millisecond {
+		t.Errorf("latest=%v min=%v, want 100ms, 50ms", rtt.latestRTT, rtt.minRTT)
+	}
+	if want := (3*25*time.Millisecond + 30*time.Millisecond) / 4; rtt.rttvar != want {
+		t.Errorf("rttvar = %v, want %v", rtt.rttvar, want)
+	}
+	if want := (7*50*time.Millisecond + 80*time.Millisecond) / 8; rtt.smoothedRTT != want {
+		t.Errorf("smoothed_rtt = %v, want %v", rtt.smoothedRTT, want)
+	}
+
+	// An ack of only non-ack-eliciting packets does not produce a sample.
+	test.send(appDataSpace, 2, false)
+	test.advance(time.Second)
+	if err := test.ack(appDataSpace, 0, i64range{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if test.c.rtt != rtt {
+		t.Errorf("ack of non-ack-eliciting packet changed RTT state")
+	}
+}
+
+func TestLossDiscardPackets(t *testing.T) {
+	test := newLossTest(t, clientSide)
+	for num := packetNumber(0); num < 3; num++ {
+		test.send(initialSpace, num, true)
+	}
+	test.c.discardPackets(initialSpace, test.onFate)
+	test.wantFates(nil, []packetNumber{0, 1, 2})
+	if got := test.c.cc.bytesInFlight; got != 0 {
+		t.Errorf("bytes in flight after discarding packets = %v, want 0", got)
+	}
+}
@@ -0,0 +1,672 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes a hex string, ignoring spaces.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDecodePacketNumber(t *testing.T) {
+	for _, test := range []struct {
+		largest   packetNumber
+		truncated packetNumber
+		n         int
+		want      packetNumber
+	}{
+		// RFC 9000, Appendix A.3.
+		{0xa82f30ea, 0x9b32, 2, 0xa82f9b32},
+		{0, 1, 1, 1},
+		{0xff, 0x01, 1, 0x101},
+		{0x100, 0xff, 1, 0xff},
+		{0xabe8b3, 0xe8bc, 2, 0xabe8bc},
+		// The candidate would exceed the largest packet number.
+		{maxPacketNumber - 1, 0xff, 1, maxPacketNumber},
+	} {
+		if got := decodePacketNumber(test.largest, test.truncated, test.n); got != test.want {
+			t.Errorf("decodePacketNumber(%#x, %#x, %v) = %#x, want %#x", test.largest, test.truncated, test.n, got, test.want)
+		}
+	}
+}
+
+func TestPacketNumberLength(t *testing.T) {
+	for _, test := range []struct {
+		pnum, largestAck packetNumber
+		want             int
+	}{
+		// RFC 9000, Appendix A.2.
+		{0xac5c02, 0xabe8b3, 2},
+		{0xace8fe, 0xabe8b3, 3},
+		{0, -1, 1},
+		{0x7e, -1, 1},
+		{0x7f, -1, 2},
+		{0x80, -1, 2},
+		{0x10000, 0, 3},
+		{0x1000000, 0, 4},
+	} {
+		n := packetNumberLength(test.pnum, test.largestAck)
+		if n != test.want {
+			t.Errorf("packetNumberLength(%#x, %#x) = %v, want %v", test.pnum, test.largestAck, n, test.want)
+		}
+		b := appendPacketNumber(nil, test.pnum, test.largestAck)
+		if len(b) != n {
+			t.Errorf("appendPacketNumber(%#x, %#x) = %x, want %v bytes", test.pnum, test.largestAck, b, n)
+			continue
+		}
+		var truncated packetNumber
+		for _, c := range b {
+			truncated = truncated<<8 | packetNumber(c)
+		}
+		if got := decodePacketNumber(test.largestAck, truncated, n); got != test.pnum {
+			t.Errorf("decodePacketNumber(appendPacketNumber(%#x, %#x)) = %#x", test.pnum, test.largestAck, got)
+		}
+	}
+}
+
+// Initial secrets from RFC 9001, Appendix A.1.
+var rfc9001ConnID = unhex("8394c8f03e515708")
+
+func TestInitialKeys(t *testing.T) {
+	client := initialKeys(rfc9001ConnID, clientSide)
+	server := initialKeys(rfc9001ConnID, serverSide)
+	for _, test := range []struct {
+		name   string
+		k      fixedKeys
+		iv     string
+		sample string // RFC 9001, Appendix A.2 and A.3
+		mask   string
+	}{{
+		name:   "client",
+		k:      client.w,
+		iv:     "fa044b2f42a3fd3b46fb255c",
+		sample: "d1b1c98dd7689fb8ec11d242b123dc9b",
+		mask:   "437b9aec36",
+	}, {
+		name:   "server",
+		k:      server.w,
+		iv:     "0ac1493ca1905853b0bba03e",
+		sample: "2cd0991cd25b0aac406a5816b6394100",
+		mask:   "2ec0d8356a",
+	}} {
+		if got, want := test.k.pkt.iv, unhex(test.iv); !bytes.Equal(got, want) {
+			t.Errorf("%v iv = %x, want %x", test.name, got, want)
+		}
+		mask := test.k.hdr.hp.headerProtection(unhex(test.sample))
+		if got, want := mask[:], unhex(test.mask); !bytes.Equal(got, want) {
+			t.Errorf("%v header protection mask = %x, want %x", test.name, got, want)
+		}
+	}
+	if !bytes.Equal(client.r.pkt.iv, server.w.pkt.iv) || !bytes.Equal(client.w.pkt.iv, server.r.pkt.iv) {
+		t.Errorf("client and server initial keys do not match")
+	}
+}
+
+func TestServerInitialProtection(t *testing.T) {
+	// RFC 9001, Appendix A.3.
+	hdr := unhex("c1000000010008f067a5502a4262b50040750001")
+	pay := unhex("02000000000600405a020000560303ee fce7f7b37ba1d1632e96677825ddf739" +
+		"88cfc79825df566dc5430b9a045a1200 130100002e00330024001d00209d3c94" +
+		"0d89690b84d08a60993c144eca684d10 81287c834d5311bcf32bb9da1a002b00" +
+		"020304")
+	want := unhex("cf000000010008f067a5502a4262b500 4075c0d95a482cd0991cd25b0aac406a" +
+		"5816b6394100f37a1c69797554780bb3 8cc5a99f5ede4cf73c3ec2493a1839b3" +
+		"dbcba3f6ea46c5b7684df3548e7ddeb9 c3bf9c73cc3f3bded74b562bfb19fb84" +
+		"022f8ef4cdd93795d77d06edbb7aaf2f 58891850abbdca3d20398c276456cbc4" +
+		"2158407dd074ee")
+	const pnum = 1
+	pnumOff := len(hdr) - 2
+
+	server := initialKeys(rfc9001ConnID, serverSide)
+	got := server.w.protect(append([]byte(nil), hdr...), pay, pnumOff, pnum)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("protected packet:\n got %x\nwant %x", got, want)
+	}
+
+	client := initialKeys(rfc9001ConnID, clientSide)
+	p, n := parseLongHeaderPacket(got, client.r, 0)
+	if n != len(want) {
+		t.Fatalf("parseLongHeaderPacket: n = %v, want %v", n, len(want))
+	}
+	if p.ptype != packetTypeInitial || p.version != quicVersion1 || p.num != pnum {
+		t.Errorf("parsed packet type=%v version=%v num=%v, want Initial, 1, %v", p.ptype, p.version, p.num, pnum)
+	}
+	if !bytes.Equal(p.payload, pay) {
+		t.Errorf("parsed payload = %x, want %x", p.payload, pay)
+	}
+}
+
+func TestChaCha20ShortHeaderProtection(t *testing.T) {
+	// RFC 9001, Appendix A.5.
+	secret := unhex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b")
+	const pnum = 654360564
+	hdr := unhex("4200bff4")
+	pay := unhex("01")
+	want := unhex("4cfe4189655e5cd55c41f69080575d7999c25a5bfb")
+
+	var w updatingKeyPair
+	w.init()
+	w.w.init(tls.TLS_CHACHA20_POLY1305_SHA256, secret)
+	got := w.protect(hdr, pay, 1, pnum)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("protected packet:\n got %x\nwant %x", got, want)
+	}
+
+	var r updatingKeyPair
+	r.init()
+	r.r.init(tls.TLS_CHACHA20_POLY1305_SHA256, secret)
+	p, err := parse1RTTPacket(got, &r, 0, pnum-1)
+	if err != nil {
+		t.Fatalf("parse1RTTPacket: %v", err)
+	}
+	if p.num != pnum || !bytes.Equal(p.payload, pay) {
+		t.Errorf("parse1RTTPacket = num %v payload %x, want %v %x", p.num, p.payload, pnum, pay)
+	}
+}
+
+func TestLongHeaderPacketRoundTrip(t *testing.T) {
+	client := initialKeys(rfc9001ConnID, clientSide)
+	server := initialKeys(rfc9001ConnID, serverSide)
+	p := longPacket{
+		ptype:     packetTypeInitial,
+		version:   quicVersion1,
+		num:       0x1234,
+		dstConnID: rfc9001ConnID,
+		srcConnID: []byte{1, 2, 3, 4},
+		extra:     []byte("token"),
+	}
+	const pnumMaxAcked = 0x1200
+
+	var w packetWriter
+	w.reset(smallestMaxDatagramSize)
+	w.startProtectedLongHeaderPacket(pnumMaxAcked, p)
+	w.appendPingFrame()
+	b, added := w.appendCryptoFrame(0, 5)
+	if !added {
+		t.Fatalf("appendCryptoFrame: not added")
+	}
+	copy(b, "hello")
+	w.appendPaddingTo(smallestMaxDatagramSize)
+	sent := w.finishProtectedLongHeaderPacket(pnumMaxAcked, client.w, p)
+	if sent == nil {
+		t.Fatalf("finishProtectedLongHeaderPacket: no packet")
+	}
+	if sent.num != p.num || sent.ptype != p.ptype || !sent.ackEliciting || !sent.inFlight {
+		t.Errorf("sent packet = %+v, want num %v, type %v, ack-eliciting and in flight", sent, p.num, p.ptype)
+	}
+	dgram := w.datagram()
+	if len(dgram) != smallestMaxDatagramSize || sent.size != len(dgram) {
+		t.Errorf("datagram is %v bytes, sent.size %v, want %v", len(dgram), sent.size, smallestMaxDatagramSize)
+	}
+	if got := getPacketType(dgram); got != packetTypeInitial {
+		t.Errorf("getPacketType = %v, want Initial", got)
+	}
+	if id, ok := dstConnIDForDatagram(dgram); !ok || !bytes.Equal(id, p.dstConnID) {
+		t.Errorf("dstConnIDForDatagram = %x, %v; want %x, true", id, ok, p.dstConnID)
+	}
+	if n := skipLongHeaderPacket(dgram); n != len(dgram) {
+		t.Errorf("skipLongHeaderPacket = %v, want %v", n, len(dgram))
+	}
+
+	// Parsing with the wrong keys fails.
+	if _, n := parseLongHeaderPacket(append([]byte(nil), dgram...), server.w, pnumMaxAcked); n >= 0 {
+		t.Errorf("parseLongHeaderPacket with wrong keys succeeded")
+	}
+	// Parsing a truncated packet fails.
+	if _, n := parseLongHeaderPacket(append([]byte(nil), dgram[:100]...), server.r, pnumMaxAcked); n >= 0 {
+		t.Errorf("parseLongHeaderPacket of truncated packet succeeded")
+	}
+
+	got, n := parseLongHeaderPacket(dgram, server.r, pnumMaxAcked)
+	if n != len(dgram) {
+		t.Fatalf("parseLongHeaderPacket: n = %v, want %v", n, len(dgram))
+	}
+	if got.ptype != p.ptype || got.version != p.version || got.num != p.num ||
+		!bytes.Equal(got.dstConnID, p.dstConnID) ||
+		!bytes.Equal(got.srcConnID, p.srcConnID) ||
+		!bytes.Equal(got.extra, p.extra) {
+		t.Errorf("parseLongHeaderPacket = %+v, want %+v", got, p)
+	}
+	pay := got.payload
+	if len(pay) == 0 || pay[0] != frameTypePing {
+		t.Fatalf("payload does not start with PING: %x", pay)
+	}
+	off, data, n := consumeCryptoFrame(pay[1:])
+	if n < 0 || off != 0 || string(data) != "hello" {
+		t.Fatalf("consumeCryptoFrame = %v, %q, %v; want 0, %q", off, data, n, "hello")
+	}
+	for _, c := range pay[1+n:] {
+		if c != frameTypePadding {
+			t.Fatalf("payload after CRYPTO frame is not padding: %x", pay[1+n:])
+		}
+	}
+}
+
+func Test1RTTPacketRoundTrip(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	var w, r updatingKeyPair
+	w.init()
+	w.w.init(tls.TLS_AES_128_GCM_SHA256, secret)
+	r.init()
+	r.r.init(tls.TLS_AES_128_GCM_SHA256, secret)
+	dstConnID := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+
+	var pw packetWriter
+	for _, pnum := range []packetNumber{0, 1, 2, 0x1000} {
+		pnumMaxAcked := pnum - 1
+		pw.reset(smallestMaxDatagramSize)
+		pw.start1RTTPacket(pnum, pnumMaxAcked, dstConnID)
+		if sent := pw.finish1RTTPacket(pnum, pnumMaxAcked, dstConnID, &w); sent != nil {
+			t.Fatalf("finish1RTTPacket with empty payload wrote a packet")
+		}
+		pw.start1RTTPacket(pnum, pnumMaxAcked, dstConnID)
+		pw.appendMaxDataFrame(int64(pnum) + 1000)
+		sent := pw.finish1RTTPacket(pnum, pnumMaxAcked, dstConnID, &w)
+		if sent == nil || sent.ptype != packetType1RTT || sent.num != pnum {
+			t.Fatalf("finish1RTTPacket = %+v, want 1-RTT packet %v", sent, pnum)
+		}
+		dgram := pw.datagram()
+		if got := getPacketType(dgram); got != packetType1RTT {
+			t.Errorf("getPacketType = %v, want 1-RTT", got)
+		}
+		if id, ok := dstConnIDForDatagram(dgram); !ok || !bytes.Equal(id, dstConnID) {
+			t.Errorf("dstConnIDForDatagram = %x, %v; want %x, true", id, ok, dstConnID)
+		}
+		p, err := parse1RTTPacket(dgram, &r, len(dstConnID), pnumMaxAcked)
+		if err != nil {
+			t.Fatalf("parse1RTTPacket: %v", err)
+		}
+		if p.num != pnum {
+			t.Errorf("parse1RTTPacket num = %v, want %v", p.num, pnum)
+		}
+		max, n := consumeMaxDataFrame(p.payload)
+		if n < 0 || max != int64(pnum)+1000 {
+			t.Errorf("consumeMaxDataFrame = %v, %v; want %v", max, n, int64(pnum)+1000)
+		}
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	dst := []byte{1, 2, 3}
+	src := []byte{4, 5, 6, 7}
+	pkt := appendVersionNegotiation(nil, dst, src, quicVersion1, 0x0a0a0a0a)
+	if got := getPacketType(pkt); got != packetTypeVersionNegotiation {
+		t.Errorf("getPacketType = %v, want Version Negotiation", got)
+	}
+	gotDst, gotSrc, versions := parseVersionNegotiation(pkt)
+	if !bytes.Equal(gotDst, dst) || !bytes.Equal(gotSrc, src) {
+		t.Errorf("parseVersionNegotiation conn ids = %x, %x; want %x, %x", gotDst, gotSrc, dst, src)
+	}
+	if want := unhex("00000001 0a0a0a0a"); !bytes.Equal(versions, want) {
+		t.Errorf("parseVersionNegotiation versions = %x, want %x", versions, want)
+	}
+	if _, _, versions := parseVersionNegotiation(pkt[:len(pkt)-1]); versions != nil {
+		t.Errorf("parseVersionNegotiation with partial version = %x, want nil", versions)
+	}
+}
+
+func TestGetPacketType(t *testing.T) {
+	for _, test := range []struct {
+		b    []byte
+		want packetType
+	}{
+		{nil, packetTypeInvalid},
+		{[]byte{0x40}, packetType1RTT},
+		{[]byte{0x00}, packetTypeInvalid}, // fixed bit unset
+		{[]byte{0xc0, 0, 0, 0, 1}, packetTypeInitial},
+		{[]byte{0xd0, 0, 0, 0, 1}, packetType0RTT},
+		{[]byte{0xe0, 0, 0, 0, 1}, packetTypeHandshake},
+		{[]byte{0xf0, 0, 0, 0, 1}, packetTypeRetry},
+		{[]byte{0x80, 0, 0, 0, 1}, packetTypeInvalid}, // fixed bit unset
+		{[]byte{0x80, 0, 0, 0, 0}, packetTypeVersionNegotiation},
+		{[]byte{0xc0, 0, 0}, packetTypeInvalid},
+	} {
+		if got := getPacketType(test.b); got != test.want {
+			t.Errorf("getPacketType(%x) = %v, want %v", test.b, got, test.want)
+		}
+	}
+}
+
+func TestFrameEncoding(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		append  func(w *packetWriter) bool
+		consume func(b []byte) (interface{}, int)
+		want    interface{}
+	}{{
+		name:   "PING",
+		append: (*packetWriter).appendPingFrame,
+		consume: func(b []byte) (interface{}, int) {
+			return b[0], 1
+		},
+		want: byte(frameTypePing),
+	}, {
+		name: "RESET_STREAM",
+		append: func(w *packetWriter) bool {
+			return w.appendResetStreamFrame(4, 0x1234, 100000)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, code, size, n := consumeResetStreamFrame(b)
+			return []interface{}{id, code, size}, n
+		},
+		want: []interface{}{streamID(4), uint64(0x1234), int64(100000)},
+	}, {
+		name: "STOP_SENDING",
+		append: func(w *packetWriter) bool {
+			return w.appendStopSendingFrame(8, 77)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, code, n := consumeStopSendingFrame(b)
+			return []interface{}{id, code}, n
+		},
+		want: []interface{}{streamID(8), uint64(77)},
+	}, {
+		name: "CRYPTO",
+		append: func(w *packetWriter) bool {
+			b, added := w.appendCryptoFrame(1000, 4)
+			copy(b, "data")
+			return added
+		},
+		consume: func(b []byte) (interface{}, int) {
+			off, data, n := consumeCryptoFrame(b)
+			return []interface{}{off, string(data)}, n
+		},
+		want: []interface{}{int64(1000), "data"},
+	}, {
+		name: "NEW_TOKEN",
+		append: func(w *packetWriter) bool {
+			return w.appendNewTokenFrame([]byte("token"))
+		},
+		consume: func(b []byte) (interface{}, int) {
+			token, n := consumeNewTokenFrame(b)
+			return string(token), n
+		},
+		want: "token",
+	}, {
+		name: "STREAM",
+		append: func(w *packetWriter) bool {
+			b, added := w.appendStreamFrame(5, 1<<20, 5, true)
+			copy(b, "hello")
+			return added
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, off, fin, data, n := consumeStreamFrame(b)
+			return []interface{}{id, off, fin, string(data)}, n
+		},
+		want: []interface{}{streamID(5), int64(1 << 20), true, "hello"},
+	}, {
+		name: "STREAM at offset zero",
+		append: func(w *packetWriter) bool {
+			_, added := w.appendStreamFrame(0, 0, 0, false)
+			return added
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, off, fin, data, n := consumeStreamFrame(b)
+			return []interface{}{id, off, fin, string(data)}, n
+		},
+		want: []interface{}{streamID(0), int64(0), false, ""},
+	}, {
+		name: "MAX_DATA",
+		append: func(w *packetWriter) bool {
+			return w.appendMaxDataFrame(1 << 30)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			max, n := consumeMaxDataFrame(b)
+			return max, n
+		},
+		want: int64(1 << 30),
+	}, {
+		name: "MAX_STREAM_DATA",
+		append: func(w *packetWriter) bool {
+			return w.appendMaxStreamDataFrame(9, 1<<16)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, max, n := consumeMaxStreamDataFrame(b)
+			return []interface{}{id, max}, n
+		},
+		want: []interface{}{streamID(9), int64(1 << 16)},
+	}, {
+		name: "MAX_STREAMS",
+		append: func(w *packetWriter) bool {
+			return w.appendMaxStreamsFrame(uniStream, 100)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			typ, max, n := consumeMaxStreamsFrame(b)
+			return []interface{}{typ, max}, n
+		},
+		want: []interface{}{uniStream, int64(100)},
+	}, {
+		name: "DATA_BLOCKED",
+		append: func(w *packetWriter) bool {
+			return w.appendDataBlockedFrame(12345)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			max, n := consumeDataBlockedFrame(b)
+			return max, n
+		},
+		want: int64(12345),
+	}, {
+		name: "STREAM_DATA_BLOCKED",
+		append: func(w *packetWriter) bool {
+			return w.appendStreamDataBlockedFrame(13, 4096)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			id, max, n := consumeStreamDataBlockedFrame(b)
+			return []interface{}{id, max}, n
+		},
+		want: []interface{}{streamID(13), int64(4096)},
+	}, {
+		name: "STREAMS_BLOCKED",
+		append: func(w *packetWriter) bool {
+			return w.appendStreamsBlockedFrame(bidiStream, 10)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			typ, max, n := consumeStreamsBlockedFrame(b)
+			return []interface{}{typ, max}, n
+		},
+		want: []interface{}{bidiStream, int64(10)},
+	}, {
+		name: "NEW_CONNECTION_ID",
+		append: func(w *packetWriter) bool {
+			return w.appendNewConnectionIDFrame(3, 1, []byte{1, 2, 3, 4}, statelessResetToken{0: 1, 15: 2})
+		},
+		consume: func(b []byte) (interface{}, int) {
+			seq, retire, id, token, n := consumeNewConnectionIDFrame(b)
+			return []interface{}{seq, retire, id, token}, n
+		},
+		want: []interface{}{int64(3), int64(1), []byte{1, 2, 3, 4}, statelessResetToken{0: 1, 15: 2}},
+	}, {
+		name: "RETIRE_CONNECTION_ID",
+		append: func(w *packetWriter) bool {
+			return w.appendRetireConnectionIDFrame(2)
+		},
+		consume: func(b []byte) (interface{}, int) {
+			seq, n := consumeRetireConnectionIDFrame(b)
+			return seq, n
+		},
+		want: int64(2),
+	}, {
+		name: "PATH_CHALLENGE",
+		append: func(w *packetWriter) bool {
+			return w.appendPathChallengeFrame(pathChallengeData{1, 2, 3, 4, 5, 6, 7, 8})
+		},
+		consume: func(b []byte) (interface{}, int) {
+			data, n := consumePathChallengeFrame(b)
+			return data, n
+		},
+		want: pathChallengeData{1, 2, 3, 4, 5, 6, 7, 8},
+	}, {
+		name: "CONNECTION_CLOSE transport",
+		append: func(w *packetWriter) bool {
+			return w.appendConnectionCloseTransportFrame(errProtocolViolation, frameTypeStreamBase, "bad")
+		},
+		consume: func(b []byte) (interface{}, int) {
+			code, ftype, reason, n := consumeConnectionCloseTransportFrame(b)
+			return []interface{}{code, ftype, reason}, n
+		},
+		want: []interface{}{errProtocolViolation, uint64(frameTypeStreamBase), "bad"},
+	}, {
+		name: "CONNECTION_CLOSE application",
+		append: func(w *packetWriter) bool {
+			return w.appendConnectionCloseApplicationFrame(42, "bye")
+		},
+		consume: func(b []byte) (interface{}, int) {
+			code, reason, n := consumeConnectionCloseApplicationFrame(b)
+			return []interface{}{code, reason}, n
+		},
+		want: []interface{}{uint64(42), "bye"},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var w packetWriter
+			w.reset(smallestMaxDatagramSize)
+			w.start1RTTPacket(0, -1, nil)
+			if !test.append(&w) {
+				t.Fatalf("frame not added")
+			}
+			b := w.payload()
+			got, n := test.consume(b)
+			if n != len(b) {
+				t.Fatalf("consumed %v bytes of %x, want %v", n, b, len(b))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("consumed %v, want %v", got, test.want)
+			}
+			if len(b) == 1 {
+				return
+			}
+			for i := 1; i < len(b); i++ {
+				if _, n := test.consume(b[:i]); n >= 0 {
+					t.Errorf("consuming truncated frame %x succeeded", b[:i])
+				}
+			}
+		})
+	}
+}
+
+func TestStreamFrameTruncatedToPacket(t *testing.T) {
+	var w packetWriter
+	w.reset(smallestMaxDatagramSize)
+	w.start1RTTPacket(0, -1, nil)
+	const size = 2 * smallestMaxDatagramSize
+	b, added := w.appendStreamFrame(4, 0, size, true)
+	if !added {
+		t.Fatalf("appendStreamFrame: not added")
+	}
+	if len(b) >= size || w.avail() != 0 {
+		t.Errorf("appendStreamFrame returned %v bytes with %v available, want a frame filling the packet", len(b), w.avail())
+	}
+	_, _, fin, data, n := consumeStreamFrame(w.payload())
+	if n != len(w.payload()) || len(data) != len(b) {
+		t.Errorf("consumeStreamFrame: n=%v data=%v bytes, want %v, %v", n, len(data), len(w.payload()), len(b))
+	}
+	if fin {
+		t.Errorf("truncated STREAM frame has FIN bit set")
+	}
+	if _, added := w.appendStreamFrame(4, int64(len(b)), 1, false); added {
+		t.Errorf("appendStreamFrame to a full packet succeeded")
+	}
+	if w.appendPingFrame() {
+		t.Errorf("appendPingFrame to a full packet succeeded")
+	}
+}
+
+// ackRanges returns the ranges in an ACK frame, in the order they appear.
+func ackRanges(t *testing.T, b []byte) (ranges []i64range, delay unscaledAckDelay, ecn ecnCounts) {
+	t.Helper()
+	largest, delay, ecn, n := consumeAckFrame(b, func(_ int, start, end packetNumber) {
+		ranges = append(ranges, i64range{int64(start), int64(end)})
+	})
+	if n != len(b) {
+		t.Fatalf("consumeAckFrame consumed %v bytes of %x, want %v", n, b, len(b))
+	}
+	if len(ranges) == 0 || packetNumber(ranges[0].end-1) != largest {
+		t.Fatalf("consumeAckFrame: largest %v does not match first range %v", largest, ranges)
+	}
+	return ranges, delay, ecn
+}
+
+func TestAckFrameEncoding(t *testing.T) {
+	seen := rangeset{{0, 3}, {5, 6}, {10, 20}}
+	wantRanges := []i64range{{10, 20}, {5, 6}, {0, 3}}
+	for _, test := range []struct {
+		name  string
+		ecn   ecnCounts
+		ftype byte
+	}{
+		{"ACK", ecnCounts{}, frameTypeAck},
+		{"ACK_ECN", ecnCounts{t0: 1, t1: 2, ce: 3}, frameTypeAckECN},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var w packetWriter
+			w.reset(smallestMaxDatagramSize)
+			w.start1RTTPacket(0, -1, nil)
+			if !w.appendAckFrame(seen, 7, test.ecn) {
+				t.Fatalf("appendAckFrame: not added")
+			}
+			b := w.payload()
+			if b[0] != test.ftype {
+				t.Errorf("frame type = %#x, want %#x", b[0], test.ftype)
+			}
+			ranges, delay, ecn := ackRanges(t, b)
+			if !reflect.DeepEqual(ranges, wantRanges) {
+				t.Errorf("ack ranges = %v, want %v", ranges, wantRanges)
+			}
+			if delay != 7 {
+				t.Errorf("ack delay = %v, want 7", delay)
+			}
+			if ecn != test.ecn {
+				t.Errorf("ecn counts = %+v, want %+v", ecn, test.ecn)
+			}
+			for i := 1; i < len(b); i++ {
+				if _, _, _, n := consumeAckFrame(b[:i], func(int, packetNumber, packetNumber) {}); n >= 0 {
+					t.Errorf("consuming truncated frame %x succeeded", b[:i])
+				}
+			}
+		})
+	}
+}
+
+func TestAckFrameDropsOldRanges(t *testing.T) {
+	var seen rangeset
+	for i := int64(0); i < 40; i++ {
+		seen.add(i*10, i*10+5)
+	}
+	var w packetWriter
+	w.reset(64)
+	w.start1RTTPacket(0, -1, nil)
+	if !w.appendAckFrame(seen, 0, ecnCounts{}) {
+		t.Fatalf("appendAckFrame: not added")
+	}
+	ranges, _, _ := ackRanges(t, w.payload())
+	if len(ranges) >= seen.numRanges() {
+		t.Fatalf("ACK frame in a small packet contains all %v ranges", len(ranges))
+	}
+	// The most recent ranges are kept.
+	for i, r := range ranges {
+		if want := seen[len(seen)-1-i]; r != want {
+			t.Errorf("range %v = %v, want %v", i, r, want)
+		}
+	}
+}
+
+func TestConsumeAckFrameInvalidRange(t *testing.T) {
+	// Largest Acknowledged 2, First ACK Range 5: the range starts below zero.
+	b := []byte{frameTypeAck, 2, 0, 0, 5}
+	if _, _, _, n := consumeAckFrame(b, func(int, packetNumber, packetNumber) {}); n >= 0 {
+		t.Errorf("consumeAckFrame(%x) succeeded, want error", b)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quicwire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	// Sample encodings from RFC 9000, Appendix A.1.
+	for _, test := range []struct {
+		v uint64
+		b []byte
+	}{
+		{0, []byte{0x00}},
+		{37, []byte{0x25}},
+		{63, []byte{0x3f}},
+		{15293, []byte{0x7b, 0xbd}},
+		{16383, []byte{0x7f, 0xff}},
+		{494878333, []byte{0x9d, 0x7f, 0x3e, 0x7d}},
+		{1073741823, []byte{0xbf, 0xff, 0xff, 0xff}},
+		{151288809941952652, []byte{0xc2, 0x19, 0x7c, 0x5e, 0xff, 0x14, 0xe8, 0x8c}},
+		{MaxVarint, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	} {
+		if got := AppendVarint(nil, test.v); !bytes.Equal(got, test.b) {
+			t.Errorf("AppendVarint(nil, %v) = %x, want %x", test.v, got, test.b)
+		}
+		if got := SizeVarint(test.v); got != len(test.b) {
+			t.Errorf("SizeVarint(%v) = %v, want %v", test.v, got, len(test.b))
+		}
+		v, n := ConsumeVarint(test.b)
+		if v != test.v || n != len(test.b) {
+			t.Errorf("ConsumeVarint(%x) = %v, %v; want %v, %v", test.b, v, n, test.v, len(test.b))
+		}
+		if _, n := ConsumeVarint(test.b[:len(test.b)-1]); n >= 0 {
+			t.Errorf("ConsumeVarint(%x) = %v, want error", test.b[:len(test.b)-1], n)
+		}
+	}
+}
+
+func TestVarintNonMinimal(t *testing.T) {
+	// A varint need not use the smallest encoding.
+	// RFC 9000, Appendix A.1: "the two-byte sequence 0x4025 decodes
+	// to the same value of 37".
+	b := []byte{0x40, 0x25, 0xff}
+	v, n := ConsumeVarint(b)
+	if v != 37 || n != 2 {
+		t.Errorf("ConsumeVarint(%x) = %v, %v; want 37, 2", b, v, n)
+	}
+}
+
+func TestVarintBytes(t *testing.T) {
+	b := AppendVarintBytes(nil, []byte("hello"))
+	if want := []byte("\x05hello"); !bytes.Equal(b, want) {
+		t.Errorf("AppendVarintBytes(nil, %q) = %x, want %x", "hello", b, want)
+	}
+	got, n := ConsumeVarintBytes(append(b, "trailing"...))
+	if string(got) != "hello" || n != len(b) {
+		t.Errorf("ConsumeVarintBytes = %q, %v; want %q, %v", got, n, "hello", len(b))
+	}
+	if _, n := ConsumeVarintBytes(b[:len(b)-1]); n >= 0 {
+		t.Errorf("ConsumeVarintBytes(truncated) = %v, want error", n)
+	}
+}
+
+func TestUint8Bytes(t *testing.T) {
+	b := AppendUint8Bytes(nil, []byte{1, 2, 3})
+	if want := []byte{3, 1, 2, 3}; !bytes.Equal(b, want) {
+		t.Errorf("AppendUint8Bytes = %x, want %x", b, want)
+	}
+	got, n := ConsumeUint8Bytes(b)
+	if !bytes.Equal(got, []byte{1, 2, 3}) || n != 4 {
+		t.Errorf("ConsumeUint8Bytes(%x) = %x, %v; want 010203, 4", b, got, n)
+	}
+	if _, n := ConsumeUint8Bytes(b[:3]); n >= 0 {
+		t.Errorf("ConsumeUint8Bytes(truncated) = %v, want error", n)
+	}
+	if _, n := ConsumeUint8Bytes(nil); n >= 0 {
+		t.Errorf("ConsumeUint8Bytes(nil) = %v, want error", n)
+	}
+}
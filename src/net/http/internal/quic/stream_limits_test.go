@@ -0,0 +1,103 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRemoteStreamLimits(t *testing.T) {
+	var lim remoteStreamLimits
+	lim.init(2)
+	for num := int64(0); num < 2; num++ {
+		if err := lim.open(newStreamID(clientSide, bidiStream, num)); err != nil {
+			t.Fatalf("open stream %v: %v", num, err)
+		}
+	}
+	wantTransportError(t, "open past MAX_STREAMS", lim.open(newStreamID(clientSide, bidiStream, 2)), errStreamLimit)
+
+	maxStreamsFrame := func(pnum packetNumber) (max int64, ok bool) {
+		var w packetWriter
+		w.reset(smallestMaxDatagramSize)
+		w.start1RTTPacket(pnum, -1, nil)
+		lim.appendFrame(&w, bidiStream, pnum, false)
+		if len(w.payload()) == 0 {
+			return 0, false
+		}
+		typ, max, n := consumeMaxStreamsFrame(w.payload())
+		if n != len(w.payload()) || typ != bidiStream {
+			t.Fatalf("malformed MAX_STREAMS frame: %x", w.payload())
+		}
+		return max, true
+	}
+	if max, ok := maxStreamsFrame(0); ok {
+		t.Errorf("MAX_STREAMS %v sent with no streams closed, want none", max)
+	}
+
+	// Closing a stream permits the peer to open another.
+	lim.close()
+	if max, ok := maxStreamsFrame(1); !ok || max != 3 {
+		t.Errorf("MAX_STREAMS after closing a stream = %v (sent=%v), want 3", max, ok)
+	}
+	if err := lim.open(newStreamID(clientSide, bidiStream, 2)); err != nil {
+		t.Errorf("open stream 2 after MAX_STREAMS update: %v", err)
+	}
+	lim.sendMax.ackOrLoss(1, packetLost)
+	if max, ok := maxStreamsFrame(2); !ok || max != 3 {
+		t.Errorf("resent MAX_STREAMS = %v (sent=%v), want 3", max, ok)
+	}
+}
+
+func TestRemoteStreamLimitsImplicitLimit(t *testing.T) {
+	// The peer may not implicitly open more than implicitStreamLimit streams
+	// with a single frame, whatever the configured limit.
+	var lim remoteStreamLimits
+	lim.init(1000)
+	if lim.max != implicitStreamLimit {
+		t.Fatalf("initial MAX_STREAMS = %v, want %v", lim.max, implicitStreamLimit)
+	}
+	wantTransportError(t, "open past implicit limit", lim.open(newStreamID(clientSide, uniStream, implicitStreamLimit)), errStreamLimit)
+	if err := lim.open(newStreamID(clientSide, uniStream, implicitStreamLimit-1)); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(2 * implicitStreamLimit); lim.max != want {
+		t.Errorf("MAX_STREAMS after opening %v streams = %v, want %v", implicitStreamLimit, lim.max, want)
+	}
+}
+
+func TestLocalStreamLimits(t *testing.T) {
+	var lim localStreamLimits
+	lim.init()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lim.open(ctx, nil); err != context.Canceled {
+		t.Fatalf("open with MAX_STREAMS 0: err = %v, want context.Canceled", err)
+	}
+
+	lim.setMax(2)
+	for want := int64(0); want < 2; want++ {
+		if num, err := lim.open(ctx, nil); err != nil || num != want {
+			t.Fatalf("open = %v, %v; want %v, nil", num, err, want)
+		}
+	}
+	if _, err := lim.open(ctx, nil); err != context.Canceled {
+		t.Errorf("open past MAX_STREAMS: err = %v, want context.Canceled", err)
+	}
+	// MAX_STREAMS frames may arrive out of order; the limit never decreases.
+	lim.setMax(1)
+	if _, err := lim.open(ctx, nil); err != context.Canceled {
+		t.Errorf("open after lower MAX_STREAMS: err = %v, want context.Canceled", err)
+	}
+	if !lim.wasOpened(1) || lim.wasOpened(2) {
+		t.Errorf("wasOpened(1), wasOpened(2) = %v, %v; want true, false", lim.wasOpened(1), lim.wasOpened(2))
+	}
+
+	lim.setMax(3)
+	lim.connHasClosed()
+	if _, err := lim.open(ctx, nil); err != errConnClosed {
+		t.Errorf("open after conn closed: err = %v, want errConnClosed", err)
+	}
+}
@@ -0,0 +1,502 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// newStreamTestConn returns a server Conn with initialized stream state
+// but no running loop. Tests drive its streams directly,
+// collecting frames with streamFrames and reporting their fates by hand.
+func newStreamTestConn(t *testing.T, config *Config, peerMaxStreamData int64) *Conn {
+	t.Helper()
+	c := &Conn{
+		side:   serverSide,
+		config: config,
+		msgc:   make(chan interface{}, 1),
+		donec:  make(chan struct{}),
+	}
+	// With no loop to receive them, messages to the conn are dropped.
+	close(c.donec)
+	c.streamsInit()
+	c.streams.peerInitialMaxStreamDataBidiLocal = peerMaxStreamData
+	c.streams.outflow.setMaxData(1 << 20)
+	return c
+}
+
+// newPeerStream creates the peer's next stream of the given type.
+func newPeerStream(t *testing.T, c *Conn, styp streamType) *Stream {
+	t.Helper()
+	id := newStreamID(clientSide, styp, c.streams.remoteLimit[styp].opened)
+	s := c.streamForFrame(testStart, id, recvStream)
+	if s == nil {
+		t.Fatalf("streamForFrame(%v) = nil", id)
+	}
+	return s
+}
+
+// streamFrames returns descriptions of the frames s writes to packet pnum.
+func streamFrames(t *testing.T, s *Stream, pnum packetNumber) []string {
+	t.Helper()
+	var w packetWriter
+	w.reset(smallestMaxDatagramSize)
+	w.start1RTTPacket(pnum, -1, nil)
+	s.ingate.lock()
+	s.appendInFramesLocked(&w, pnum, false)
+	s.inUnlock()
+	s.outgate.lock()
+	s.appendOutFramesLocked(&w, pnum, false)
+	s.outUnlock()
+
+	var frames []string
+	for b := w.payload(); len(b) > 0; {
+		var f string
+		var n int
+		switch typ := b[0]; {
+		case typ&^0x07 == frameTypeStreamBase:
+			id, off, fin, data, nn := consumeStreamFrame(b)
+			f, n = fmt.Sprintf("STREAM id=%v off=%v data=%q fin=%v", id, off, data, fin), nn
+		case typ == frameTypeResetStream:
+			id, code, size, nn := consumeResetStreamFrame(b)
+			f, n = fmt.Sprintf("RESET_STREAM id=%v code=%v size=%v", id, code, size), nn
+		case typ == frameTypeStopSending:
+			id, code, nn := consumeStopSendingFrame(b)
+			f, n = fmt.Sprintf("STOP_SENDING id=%v code=%v", id, code), nn
+		case typ == frameTypeMaxStreamData:
+			id, max, nn := consumeMaxStreamDataFrame(b)
+			f, n = fmt.Sprintf("MAX_STREAM_DATA id=%v max=%v", id, max), nn
+		case typ == frameTypeStreamDataBlocked:
+			id, max, nn := consumeStreamDataBlockedFrame(b)
+			f, n = fmt.Sprintf("STREAM_DATA_BLOCKED id=%v max=%v", id, max), nn
+		default:
+			t.Fatalf("unexpected frame type %x", typ)
+		}
+		if n < 0 {
+			t.Fatalf("malformed frame: %x", b)
+		}
+		frames = append(frames, f)
+		b = b[n:]
+	}
+	return frames
+}
+
+func wantStreamFrames(t *testing.T, s *Stream, pnum packetNumber, want ...string) {
+	t.Helper()
+	if got := streamFrames(t, s, pnum); !reflect.DeepEqual(got, want) {
+		t.Errorf("packet %v frames:\n got: %q\nwant: %q", pnum, got, want)
+	}
+}
+
+func wantTransportError(t *testing.T, what string, err error, code transportError) {
+	t.Helper()
+	var e localTransportError
+	if !errors.As(err, &e) || e.code != code {
+		t.Errorf("%v: err = %v, want transport error %v", what, err, code)
+	}
+}
+
+func wantStreamState(t *testing.T, s *Stream, mask, want streamState) {
+	t.Helper()
+	if got := s.state.load() & mask; got != want {
+		t.Errorf("stream state & %x = %x, want %x", mask, got, want)
+	}
+}
+
+func TestStreamReadData(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 0)
+	s := newPeerStream(t, c, bidiStream)
+
+	// Data received out of order is readable once the gap is filled.
+	if err := s.handleData(6, []byte("world"), true); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.SetReadContext(ctx)
+	if n, err := s.Read(make([]byte, 16)); err != context.Canceled {
+		t.Fatalf("Read with gap at start of stream = %v, %v; want context.Canceled", n, err)
+	}
+	s.SetReadContext(context.Background())
+	if err := s.handleData(0, []byte("hello "), false); err != nil {
+		t.Fatal(err)
+	}
+	// Duplicate data is ignored.
+	if err := s.handleData(0, []byte("hello"), false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(s)
+	if err != nil || string(got) != "hello world" {
+		t.Fatalf("ReadAll = %q, %v; want %q, nil", got, err, "hello world")
+	}
+	if got := c.streams.inflow.usedLimit; got != 11 {
+		t.Errorf("connection flow control used = %v, want 11", got)
+	}
+
+	// The receive side is done once the user closes it.
+	wantStreamState(t, s, streamInDone, 0)
+	s.CloseRead()
+	wantStreamState(t, s, streamInDone|streamInSendMeta, streamInDone)
+	// All data was received, so there is no need for STOP_SENDING.
+	wantStreamFrames(t, s, 0)
+}
+
+func TestStreamReadReset(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 0)
+	s := newPeerStream(t, c, bidiStream)
+	if err := s.handleData(0, []byte("abc"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleReset(42, 10); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Read(make([]byte, 16))
+	var code StreamErrorCode
+	if !errors.As(err, &code) || code != 42 {
+		t.Errorf("Read after RESET_STREAM: err = %v, want StreamErrorCode(42)", err)
+	}
+	// The final size counts against connection flow control.
+	if got := c.streams.inflow.usedLimit; got != 10 {
+		t.Errorf("connection flow control used = %v, want 10", got)
+	}
+	// A repeated RESET_STREAM is ignored; one changing the final size is not.
+	if err := s.handleReset(42, 10); err != nil {
+		t.Errorf("duplicate RESET_STREAM: %v", err)
+	}
+	wantTransportError(t, "RESET_STREAM changing final size", s.handleReset(42, 11), errFinalSize)
+}
+
+func TestStreamCloseReadSendsStopSending(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 0)
+	s := newPeerStream(t, c, bidiStream)
+	if err := s.handleData(0, []byte("abc"), false); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseRead()
+	if _, err := s.Read(make([]byte, 16)); err == nil {
+		t.Errorf("Read after CloseRead: no error")
+	}
+	wantStreamState(t, s, streamInSendMeta, streamInSendMeta)
+	wantStreamFrames(t, s, 0, "STOP_SENDING id=0 code=0")
+	wantStreamFrames(t, s, 1)
+
+	// A lost STOP_SENDING is resent.
+	s.ackOrLoss(0, frameTypeStopSending, packetLost)
+	wantStreamFrames(t, s, 2, "STOP_SENDING id=0 code=0")
+	s.ackOrLoss(2, frameTypeStopSending, packetAcked)
+	wantStreamState(t, s, streamInDone|streamInSendMeta, 0)
+
+	// Data arriving after CloseRead is discarded.
+	if err := s.handleData(3, []byte("def"), false); err != nil {
+		t.Errorf("data after CloseRead: %v", err)
+	}
+	// The receive side is done when the peer resets the stream in response.
+	if err := s.handleReset(0, 6); err != nil {
+		t.Fatal(err)
+	}
+	wantStreamState(t, s, streamInDone|streamInSendMeta, streamInDone)
+}
+
+func TestStreamMaxStreamData(t *testing.T) {
+	c := newStreamTestConn(t, &Config{MaxStreamReadBufferSize: 1000}, 0)
+	s := newPeerStream(t, c, bidiStream)
+	buf := make([]byte, 100)
+
+	// Reading less than 1/8 of the window does not extend it.
+	if err := s.handleData(0, buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	wantStreamFrames(t, s, 0)
+
+	if err := s.handleData(100, buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	wantStreamFrames(t, s, 1, "MAX_STREAM_DATA id=0 max=1200")
+	if err := s.handleData(200, make([]byte, 1000), false); err != nil {
+		t.Errorf("data within extended window: %v", err)
+	}
+
+	// MAX_STREAM_DATA is resent only if the most recent one is lost.
+	s.ingate.lock()
+	s.insendmax.setUnsent()
+	s.inUnlock()
+	wantStreamFrames(t, s, 2, "MAX_STREAM_DATA id=0 max=1200")
+	s.ackOrLoss(1, frameTypeMaxStreamData, packetLost)
+	wantStreamFrames(t, s, 3)
+	s.ackOrLoss(2, frameTypeMaxStreamData, packetLost)
+	wantStreamFrames(t, s, 4, "MAX_STREAM_DATA id=0 max=1200")
+}
+
+func TestStreamFlowControlViolation(t *testing.T) {
+	c := newStreamTestConn(t, &Config{
+		MaxStreamReadBufferSize: 1000,
+		MaxConnReadBufferSize:   1500,
+	}, 0)
+	s := newPeerStream(t, c, bidiStream)
+	wantTransportError(t, "data past stream window", s.handleData(0, make([]byte, 1001), false), errFlowControl)
+	wantTransportError(t, "RESET_STREAM past stream window", s.handleReset(0, 1001), errFlowControl)
+
+	// Two streams within their own windows can exceed the connection window.
+	s = newPeerStream(t, c, bidiStream)
+	if err := s.handleData(0, make([]byte, 1000), false); err != nil {
+		t.Fatal(err)
+	}
+	s = newPeerStream(t, c, bidiStream)
+	wantTransportError(t, "data past connection window", s.handleData(0, make([]byte, 600), false), errFlowControl)
+}
+
+func TestStreamFinalSize(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 0)
+	for _, test := range []struct {
+		name  string
+		first func(s *Stream) error
+		next  func(s *Stream) error
+	}{{
+		name:  "data past final size",
+		first: func(s *Stream) error { return s.handleData(0, []byte("abc"), true) },
+		next:  func(s *Stream) error { return s.handleData(3, []byte("d"), false) },
+	}, {
+		name:  "final size changed",
+		first: func(s *Stream) error { return s.handleData(0, []byte("abc"), true) },
+		next:  func(s *Stream) error { return s.handleData(0, []byte("ab"), true) },
+	}, {
+		name:  "final size before prior data",
+		first: func(s *Stream) error { return s.handleData(0, []byte("abc"), false) },
+		next:  func(s *Stream) error { return s.handleData(0, []byte("ab"), true) },
+	}, {
+		name:  "RESET_STREAM before prior data",
+		first: func(s *Stream) error { return s.handleData(0, []byte("abc"), false) },
+		next:  func(s *Stream) error { return s.handleReset(0, 2) },
+	}} {
+		s := newPeerStream(t, c, bidiStream)
+		if err := test.first(s); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		wantTransportError(t, test.name, test.next(s), errFinalSize)
+	}
+}
+
+func TestStreamWriteAndAck(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 1<<20)
+	s := newPeerStream(t, c, bidiStream)
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// Data is buffered until flushed.
+	wantStreamState(t, s, streamOutSendMeta|streamOutSendData, 0)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// New data consumes flow control, so the stream waits on the data queue.
+	wantStreamState(t, s, streamOutSendMeta|streamOutSendData, streamOutSendData)
+	if got := s.state.load().wantQueue(); got != dataQueue {
+		t.Errorf("wantQueue = %v, want dataQueue", got)
+	}
+	wantStreamFrames(t, s, 0, `STREAM id=0 off=0 data="hello" fin=false`)
+	wantStreamState(t, s, streamOutSendMeta|streamOutSendData, 0)
+
+	// Lost data is resent without consuming flow control.
+	s.ackOrLossData(0, 0, 5, false, packetLost)
+	wantStreamState(t, s, streamOutSendMeta|streamOutSendData, streamOutSendMeta)
+	wantStreamFrames(t, s, 1, `STREAM id=0 off=0 data="hello" fin=false`)
+	if got := c.streams.outflow.used; got != 5 {
+		t.Errorf("connection flow control used = %v, want 5", got)
+	}
+
+	s.CloseWrite()
+	if _, err := s.Write([]byte("x")); err == nil {
+		t.Errorf("Write after CloseWrite: no error")
+	}
+	wantStreamFrames(t, s, 2, `STREAM id=0 off=5 data="" fin=true`)
+	s.ackOrLossData(1, 0, 5, false, packetAcked)
+	wantStreamState(t, s, streamOutDone, 0)
+	s.ackOrLossData(2, 5, 5, true, packetAcked)
+	wantStreamState(t, s, streamOutDone, streamOutDone)
+	select {
+	case <-s.outdone:
+	default:
+		t.Errorf("outdone not closed after all data acked")
+	}
+
+	// With both sides done, the stream is queued for removal.
+	if err := s.handleReset(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseRead()
+	wantStreamState(t, s, streamInDone|streamOutDone, streamInDone|streamOutDone)
+	if got := s.state.load().wantQueue(); got != metaQueue {
+		t.Errorf("wantQueue with both sides done = %v, want metaQueue", got)
+	}
+}
+
+func TestStreamWriteFlowControl(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 4)
+	s := newPeerStream(t, c, bidiStream)
+	if _, err := s.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	// The write exceeds the peer's window, so it is flushed immediately
+	// and we tell the peer we are blocked.
+	wantStreamFrames(t, s, 0,
+		"STREAM_DATA_BLOCKED id=0 max=4",
+		`STREAM id=0 off=0 data="hell" fin=false`,
+	)
+	wantStreamFrames(t, s, 1)
+
+	// A MAX_STREAM_DATA that does not extend the window is ignored.
+	if err := s.handleMaxStreamData(3); err != nil {
+		t.Fatal(err)
+	}
+	wantStreamFrames(t, s, 2)
+	if err := s.handleMaxStreamData(100); err != nil {
+		t.Fatal(err)
+	}
+	wantStreamFrames(t, s, 3, `STREAM id=0 off=4 data="o world" fin=false`)
+
+	// Connection-level flow control limits new data on all streams.
+	c.streams.outflow = connOutflow{}
+	c.streams.outflow.setMaxData(13)
+	c.streams.peerInitialMaxStreamDataBidiLocal = 1 << 20
+	s = newPeerStream(t, c, bidiStream)
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	wantStreamFrames(t, s, 4, `STREAM id=4 off=0 data="hello" fin=false`)
+	if _, err := s.Write(bytes.Repeat([]byte("x"), 10)); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	wantStreamFrames(t, s, 5, `STREAM id=4 off=5 data="xxxxxxxx" fin=false`)
+	if got := c.streams.outflow.avail(); got != 0 {
+		t.Errorf("connection flow control available = %v, want 0", got)
+	}
+	wantStreamState(t, s, streamOutSendMeta|streamOutSendData, streamOutSendData)
+	c.streams.outflow.setMaxData(15)
+	wantStreamFrames(t, s, 6, `STREAM id=4 off=13 data="xx" fin=false`)
+}
+
+func TestStreamReset(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 1<<20)
+	s := newPeerStream(t, c, bidiStream)
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	wantStreamFrames(t, s, 0, `STREAM id=0 off=0 data="hello" fin=false`)
+	s.Reset(7)
+	if _, err := s.Write([]byte("x")); err == nil {
+		t.Errorf("Write after Reset: no error")
+	}
+	// The final size is the amount of data sent.
+	wantStreamFrames(t, s, 1, "RESET_STREAM id=0 code=7 size=5")
+	// Losing data sent before the reset does not cause it to be resent.
+	s.ackOrLossData(0, 0, 5, false, packetLost)
+	wantStreamFrames(t, s, 2)
+
+	s.ackOrLoss(1, frameTypeResetStream, packetLost)
+	wantStreamFrames(t, s, 3, "RESET_STREAM id=0 code=7 size=5")
+	wantStreamState(t, s, streamOutDone, 0)
+	s.ackOrLoss(3, frameTypeResetStream, packetAcked)
+	wantStreamState(t, s, streamOutDone, streamOutDone)
+}
+
+func TestStreamStopSending(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 1<<20)
+	s := newPeerStream(t, c, bidiStream)
+	if err := s.handleStopSending(9); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("x")); err == nil {
+		t.Errorf("Write after STOP_SENDING: no error")
+	}
+	wantStreamFrames(t, s, 0, "RESET_STREAM id=0 code=9 size=0")
+	s.ackOrLoss(0, frameTypeResetStream, packetAcked)
+	// The send side is not done until the user closes it.
+	wantStreamState(t, s, streamOutDone, 0)
+	s.CloseWrite()
+	wantStreamState(t, s, streamOutDone, streamOutDone)
+}
+
+func TestStreamDirection(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 1<<20)
+
+	s := newPeerStream(t, c, uniStream)
+	if !s.IsReadOnly() || s.IsWriteOnly() {
+		t.Errorf("peer-created unidirectional stream: IsReadOnly = %v, IsWriteOnly = %v; want true, false", s.IsReadOnly(), s.IsWriteOnly())
+	}
+	if _, err := s.Write([]byte("x")); err == nil {
+		t.Errorf("Write to read-only stream: no error")
+	}
+	if err := s.Flush(); err == nil {
+		t.Errorf("Flush of read-only stream: no error")
+	}
+	wantStreamState(t, s, streamOutDone, streamOutDone)
+
+	s = newStream(c, newStreamID(serverSide, uniStream, 0))
+	s.inUnlock()
+	s.outUnlock()
+	if s.IsReadOnly() || !s.IsWriteOnly() {
+		t.Errorf("locally-created unidirectional stream: IsReadOnly = %v, IsWriteOnly = %v; want false, true", s.IsReadOnly(), s.IsWriteOnly())
+	}
+	if _, err := s.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read from write-only stream: no error")
+	}
+	wantStreamState(t, s, streamInDone, streamInDone)
+}
+
+func TestStreamImplicitOpen(t *testing.T) {
+	c := newStreamTestConn(t, &Config{}, 0)
+	// A frame for stream 2 implicitly opens streams 0 and 1.
+	// https://www.rfc-editor.org/rfc/rfc9000#section-3.2-6
+	s2 := c.streamForFrame(testStart, newStreamID(clientSide, bidiStream, 2), recvStream)
+	if s2 == nil {
+		t.Fatalf("streamForFrame(2) = nil")
+	}
+	for num := int64(0); num < 2; num++ {
+		id := newStreamID(clientSide, bidiStream, num)
+		if ms, ok := c.streams.streams[id]; !ok || ms.s != nil {
+			t.Errorf("stream %v: in map = %v, stream = %v; want implicitly opened", id, ok, ms.s)
+		}
+		if s := c.streamForFrame(testStart, id, recvStream); s == nil {
+			t.Errorf("streamForFrame(%v) = nil for implicitly opened stream", id)
+		}
+	}
+	if got := c.streamForFrame(testStart, s2.id, recvStream); got != s2 {
+		t.Errorf("streamForFrame returned a new stream for an existing one")
+	}
+}
+
+func TestStreamStateWantQueue(t *testing.T) {
+	for _, test := range []struct {
+		state streamState
+		want  streamQueue
+	}{
+		{0, noQueue},
+		{streamInSendMeta, metaQueue},
+		{streamOutSendMeta | streamOutSendData, metaQueue},
+		{streamOutSendData, dataQueue},
+		{streamInDone, noQueue},
+		{streamInDone | streamOutDone, metaQueue},
+		{streamInDone | streamOutDone | streamConnRemoved, noQueue},
+	} {
+		if got := test.state.wantQueue(); got != test.want {
+			t.Errorf("streamState(%x).wantQueue() = %v, want %v", test.state, got, test.want)
+		}
+	}
+}
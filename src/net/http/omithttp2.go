@@ -7,7 +7,9 @@
 package http
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sync"
 	"time"
 )
@@ -26,11 +28,17 @@ const http2NextProtoTLS = "h2"
 
 type http2Transport struct {
 	MaxHeaderListSize uint32
+	ReadIdleTimeout   time.Duration
+	PingTimeout       time.Duration
 	ConnPool          interface{}
 }
 
-func (*http2Transport) RoundTrip(*Request) (*Response, error) { panic(noHTTP2) }
-func (*http2Transport) CloseIdleConnections()                 {}
+func (*http2Transport) RoundTrip(*Request) (*Response, error)            { panic(noHTTP2) }
+func (*http2Transport) CloseIdleConnections()                            {}
+func (*http2Transport) addUnencryptedConn(string, net.Conn) RoundTripper { panic(noHTTP2) }
+func (*http2Transport) connPoolStates() []ConnPoolState                  { panic(noHTTP2) }
+func (*http2Transport) shutdownConns(func(string) bool)                  { panic(noHTTP2) }
+func (*http2Transport) hookConnPool()                                    { panic(noHTTP2) }
 
 type http2noDialH2RoundTripper struct{}
 
@@ -47,13 +55,28 @@ type http2clientConnPool struct {
 
 func http2configureTransport(*Transport) (*http2Transport, error) { panic(noHTTP2) }
 
+func http2configureUnencryptedTransport(*Transport) *http2Transport { panic(noHTTP2) }
+
 func http2isNoCachedConnError(err error) bool {
 	_, ok := err.(interface{ IsHTTP2NoCachedConnError() })
 	return ok
 }
 
 type http2Server struct {
-	NewWriteScheduler func() http2WriteScheduler
+	MaxConcurrentStreams         uint32
+	MaxReadFrameSize             uint32
+	MaxUploadBufferPerConnection int32
+	MaxUploadBufferPerStream     int32
+	PermitProhibitedCipherSuites bool
+	NewWriteScheduler            func() http2WriteScheduler
+}
+
+func (*http2Server) ServeConn(net.Conn, *http2ServeConnOpts) { panic(noHTTP2) }
+
+type http2ServeConnOpts struct {
+	Context    context.Context
+	BaseConfig *Server
+	Handler    Handler
 }
 
 type http2WriteScheduler interface{}
@@ -62,6 +85,10 @@ func http2NewPriorityWriteScheduler(interface{}) http2WriteScheduler { panic(noH
 
 func http2ConfigureServer(s *Server, conf *http2Server) error { panic(noHTTP2) }
 
+func http2configureUnencryptedServer(s *Server, conf *http2Server) { panic(noHTTP2) }
+
+func http2withReadDeadlines(ctx context.Context) context.Context { return ctx }
+
 var http2ErrNoCachedConn = http2noCachedConnError{}
 
 type http2noCachedConnError struct{}
@@ -455,6 +455,113 @@ func TestServeMuxHandlerRedirects(t *testing.T) {
 }
 
 // Tests for https://golang.org/issue/900
+func TestServeMuxPatterns(t *testing.T) {
+	mux := NewServeMux()
+	reply := func(name string) HandlerFunc {
+		return func(w ResponseWriter, r *Request) {
+			io.WriteString(w, name+" "+r.PathValue("x")+" "+r.PathValue("rest"))
+		}
+	}
+	mux.Handle("GET /item/{x}", reply("get"))
+	mux.Handle("POST /item/{x}", reply("post"))
+	mux.Handle("/item/{x}/new", reply("new"))
+	mux.Handle("/files/{rest...}", reply("files"))
+	mux.Handle("/{$}", reply("root"))
+	mux.Handle("example.com/item/{x}", reply("host"))
+
+	for _, test := range []struct {
+		method, host, path string
+		wantCode           int
+		wantBody           string
+		wantAllow          string
+	}{
+		{"GET", "", "/item/1", 200, "get 1 ", ""},
+		{"HEAD", "", "/item/1", 200, "get 1 ", ""},
+		{"POST", "", "/item/2", 200, "post 2 ", ""},
+		{"PUT", "", "/item/3", 405, "Method Not Allowed\n", "GET, HEAD, POST"},
+		{"PUT", "", "/item/3/new", 200, "new 3 ", ""},
+		{"GET", "", "/item/a%2Fb", 200, "get a/b ", ""},
+		{"GET", "", "/files/a/b/c", 200, "files  a/b/c", ""},
+		{"GET", "", "/files/", 200, "files  ", ""},
+		{"GET", "", "/", 200, "root  ", ""},
+		{"GET", "", "/other", 404, "404 page not found\n", ""},
+		{"PUT", "example.com", "/item/4", 200, "host 4 ", ""},
+	} {
+		r := httptest.NewRequest(test.method, "http://"+test.host+test.path, nil)
+		if test.host == "" {
+			r.Host = "localhost"
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("%s %s%s: code = %d, want %d", test.method, test.host, test.path, w.Code, test.wantCode)
+		}
+		if test.method != "HEAD" {
+			if got := w.Body.String(); got != test.wantBody {
+				t.Errorf("%s %s%s: body = %q, want %q", test.method, test.host, test.path, got, test.wantBody)
+			}
+		}
+		if got := w.Header().Get("Allow"); got != test.wantAllow {
+			t.Errorf("%s %s%s: Allow = %q, want %q", test.method, test.host, test.path, got, test.wantAllow)
+		}
+	}
+}
+
+func TestServeMuxConflictPanics(t *testing.T) {
+	for _, test := range []struct {
+		p1, p2 string
+	}{
+		{"/a", "/a"},
+		{"GET /a/{x}", "GET /a/{y}"},
+		{"/a/{x}", "/{y}/b"},
+		{"GET /", "/index.html"},
+	} {
+		mux := NewServeMux()
+		mux.Handle(test.p1, NotFoundHandler())
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q after %q did not panic", test.p2, test.p1)
+				}
+			}()
+			mux.Handle(test.p2, NotFoundHandler())
+		}()
+	}
+}
+
+func TestServeMuxPathValue(t *testing.T) {
+	mux := NewServeMux()
+	var got [3]string
+	mux.HandleFunc("/{a}/b/{c...}", func(w ResponseWriter, r *Request) {
+		got = [3]string{r.PathValue("a"), r.PathValue("c"), r.PathValue("missing")}
+	})
+	r := httptest.NewRequest("GET", "/x/b/y/z", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+	if want := [3]string{"x", "y/z", ""}; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetPathValue(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/a/{b}/c/{d...}", func(_ ResponseWriter, r *Request) {
+		kvs := map[string]string{
+			"b": "X",
+			"d": "Y",
+			"a": "Z",
+		}
+		for k, v := range kvs {
+			r.SetPathValue(k, v)
+		}
+		for k, w := range kvs {
+			if g := r.PathValue(k); g != w {
+				t.Errorf("%q: got %q, want %q", k, g, w)
+			}
+		}
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a/b/c/d/e", nil))
+}
+
 func TestMuxRedirectLeadingSlashes(t *testing.T) {
 	setParallel(t)
 	paths := []string{"//foo.txt", "///foo.txt", "/../../foo.txt"}
@@ -6403,6 +6510,68 @@ func TestTimeoutHandlerSuperfluousLogs(t *testing.T) {
 	}
 }
 
+func TestServerUnencryptedHTTP2(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	ts.Config.Protocols = new(Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	tr := &Transport{Protocols: new(Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	defer tr.CloseIdleConnections()
+	for _, test := range []struct {
+		c    *Client
+		want string
+	}{
+		{&Client{Transport: tr}, "HTTP/2.0"},
+		{ts.Client(), "HTTP/1.1"},
+	} {
+		res, err := test.c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Proto != test.want || string(body) != test.want {
+			t.Errorf("got response %s with body %q, want %s", res.Proto, body, test.want)
+		}
+	}
+}
+
+func TestServerUnencryptedHTTP2Only(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.Protocols = new(Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	if res, err := ts.Client().Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Fatalf("HTTP/1 request to a server without HTTP1 succeeded with %s", res.Proto)
+	}
+
+	// A Transport that needs HTTP/1 for a request fails it
+	// rather than falling back.
+	tr := &Transport{Protocols: new(Protocols)}
+	tr.Protocols.SetHTTP2(true)
+	defer tr.CloseIdleConnections()
+	if res, err := (&Client{Transport: tr}).Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Fatalf("plain http request from an HTTP/2-only Transport succeeded with %s", res.Proto)
+	}
+}
+
 // fetchWireResponse is a helper for dialing to host,
 // sending http1ReqBody as the payload and retrieving
 // the response as it was sent on the wire.
@@ -8,9 +8,263 @@ package http
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
 	"testing"
 )
 
+func TestServerFindHandler(t *testing.T) {
+	mux := NewServeMux()
+	for _, ph := range []struct {
+		pat string
+		h   Handler
+	}{
+		{"/", &handler{1}},
+		{"/foo/", &handler{2}},
+		{"/foo", &handler{3}},
+		{"/bar/", &handler{4}},
+		{"//foo", &handler{5}},
+	} {
+		mux.Handle(ph.pat, ph.h)
+	}
+
+	for _, test := range []struct {
+		method      string
+		path        string
+		wantHandler string
+	}{
+		{"GET", "/", "&http.handler{i:1}"},
+		{"GET", "//", `&http.redirectHandler{url:"/", code:301}`},
+		{"GET", "/foo/../bar/./..//baz", `&http.redirectHandler{url:"/baz", code:301}`},
+		{"GET", "/foo", "&http.handler{i:3}"},
+		{"GET", "/foo/x", "&http.handler{i:2}"},
+		{"GET", "/bar/x", "&http.handler{i:4}"},
+		{"GET", "/bar", `&http.redirectHandler{url:"/bar/", code:301}`},
+		{"CONNECT", "/", "&http.handler{i:1}"},
+		{"CONNECT", "//", "&http.handler{i:1}"},
+		{"CONNECT", "//foo", "&http.handler{i:5}"},
+		{"CONNECT", "/foo/../bar/./..//baz", "&http.handler{i:2}"},
+		{"CONNECT", "/foo", "&http.handler{i:3}"},
+		{"CONNECT", "/foo/x", "&http.handler{i:2}"},
+		{"CONNECT", "/bar/x", "&http.handler{i:4}"},
+		{"CONNECT", "/bar", `&http.redirectHandler{url:"/bar/", code:301}`},
+	} {
+		var r Request
+		r.Method = test.method
+		r.Host = "example.com"
+		r.URL = &url.URL{Path: test.path}
+		gotH, _, _, _ := mux.findHandler(&r)
+		got := fmt.Sprintf("%#v", gotH)
+		if got != test.wantHandler {
+			t.Errorf("%s %q: got %q, want %q", test.method, test.path, got, test.wantHandler)
+		}
+	}
+}
+
+func TestServerRegisterErr(t *testing.T) {
+	mux := NewServeMux()
+	h := &handler{}
+	mux.Handle("/a", h)
+
+	for _, test := range []struct {
+		pattern    string
+		handler    Handler
+		wantRegexp string
+	}{
+		{"", h, "invalid pattern"},
+		{"/", nil, "nil handler"},
+		{"/", HandlerFunc(nil), "nil handler"},
+		{"/{x", h, `parsing "/\{x": at offset 1: bad wildcard segment`},
+		{"/a", h, `conflicts with pattern.* \(registered at .*/server_test.go:\d+`},
+		{"GET /{x}", h, `matches fewer methods than`},
+	} {
+		t.Run(fmt.Sprintf("%s:%#v", test.pattern, test.handler), func(t *testing.T) {
+			err := mux.registerErr(test.pattern, test.handler)
+			if err == nil {
+				t.Fatal("got nil error")
+			}
+			re := regexp.MustCompile(test.wantRegexp)
+			if g := err.Error(); !re.MatchString(g) {
+				t.Errorf("\ngot %q\nwant string matching %q", g, test.wantRegexp)
+			}
+		})
+	}
+}
+
+func TestExactMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"", "/a", false},
+		{"/", "/a", false},
+		{"/a", "/a", true},
+		{"/a/{x...}", "/a/b", false},
+		{"GET /a", "/a", true},
+		{"GET /a/{x...}", "/a/b", false},
+		{"/a/{$}", "/a/", true},
+		{"/a/", "/a/", true},
+		{"/a/{x...}", "/a/", true},
+		{"/a/{x...}", "/a/b/c", false},
+		{"/{x...}", "/", true},
+	} {
+		var n *routingNode
+		if test.pattern != "" {
+			pat := mustParsePattern(t, test.pattern)
+			n = &routingNode{pattern: pat}
+		}
+		got := exactMatch(n, test.path)
+		if got != test.want {
+			t.Errorf("%q, %s: got %t, want %t", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestEscapedPathsAndPatterns(t *testing.T) {
+	matches := []struct {
+		pattern  string
+		paths    []string // paths that match the pattern
+		paths115 []string // paths that matched the pattern in Go 1.15.
+	}{
+		{
+			"/a", // this pattern matches a path that unescapes to "/a"
+			[]string{"/a", "/%61"},
+			[]string{"/a", "/%61"},
+		},
+		{
+			"/%62", // patterns are unescaped by segment; matches paths that unescape to "/b"
+			[]string{"/b", "/%62"},
+			[]string{"/%2562"}, // In 1.15, patterns were not unescaped but paths were.
+		},
+		{
+			"/%7B/%7D", // the only way to write a pattern that matches '{/}'
+			[]string{"/{/}", "/%7b/}", "/{/%7d", "/%7B/%7D"},
+			[]string{"/%257B/%257D"}, // In 1.15, patterns were not unescaped.
+		},
+		{
+			"/%x", // patterns that do not unescape are left unchanged
+			[]string{"/%25x"},
+			[]string{"/%25x"},
+		},
+	}
+
+	run := func(t *testing.T, test115 bool) {
+		defer func(u bool) { use115 = u }(use115)
+		use115 = test115
+
+		mux := NewServeMux()
+		for _, m := range matches {
+			mux.HandleFunc(m.pattern, func(w ResponseWriter, r *Request) {})
+		}
+
+		for _, m := range matches {
+			paths := m.paths
+			if use115 {
+				paths = m.paths115
+			}
+			for _, p := range paths {
+				u, err := url.ParseRequestURI(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req := &Request{
+					URL: u,
+				}
+				_, gotPattern := mux.Handler(req)
+				if g, w := gotPattern, m.pattern; g != w {
+					t.Errorf("%s: pattern: got %q, want %q", p, g, w)
+				}
+			}
+		}
+	}
+
+	t.Run("latest", func(t *testing.T) { run(t, false) })
+	t.Run("1.15", func(t *testing.T) { run(t, true) })
+}
+
+// TestServeMux115 pins the ServeMux behavior selected by GODEBUG=httpmuxgo115=1.
+func TestServeMux115(t *testing.T) {
+	defer func(u bool) { use115 = u }(use115)
+
+	for _, test := range []struct {
+		pattern  string
+		path     string
+		want115  string // pattern matched with httpmuxgo115=1
+		wantNew  string // pattern matched by default
+		register bool   // whether the pattern can be registered by default
+	}{
+		// An escaped slash is unescaped before matching in 1.15.
+		{"/a/b", "/a%2Fb", "/a/b", "", true},
+		{"/a/b/", "/a%2Fb/c", "/a/b/", "", true},
+		// Braces are literal in 1.15.
+		{"/files/{name}", "/files/x", "", "/files/{name}", true},
+		{"/files/{name}", "/files/%7Bname%7D", "/files/{name}", "/files/{name}", true},
+		{"/{", "/%7B", "/{", "", false},
+		{"/a{x}", "/a%7Bx%7D", "/a{x}", "", false},
+		// A method is part of the host in 1.15, so it never matches.
+		{"GET /c", "/c", "", "GET /c", true},
+		// In 1.15, the unescaped path is cleaned, so an escaped dot-dot
+		// segment redirects.
+		{"/b", "/a%2F..%2Fb", "/b", "", true},
+	} {
+		for _, u115 := range []bool{true, false} {
+			use115 = u115
+			want := test.want115
+			if !u115 {
+				want = test.wantNew
+			}
+			mux := NewServeMux()
+			registered := func() (ok bool) {
+				defer func() {
+					if recover() != nil {
+						ok = false
+					}
+				}()
+				mux.Handle(test.pattern, &handler{1})
+				return true
+			}()
+			if wantReg := u115 || test.register; registered != wantReg {
+				t.Errorf("use115=%t: registering %q: got ok=%t, want %t", u115, test.pattern, registered, wantReg)
+				continue
+			}
+			u, err := url.ParseRequestURI(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &Request{Method: "GET", Host: "example.com", URL: u}
+			if _, got := mux.Handler(r); got != want {
+				t.Errorf("use115=%t: pattern %q, path %q: matched %q, want %q", u115, test.pattern, test.path, got, want)
+			}
+		}
+	}
+}
+
+func TestGodebugValue(t *testing.T) {
+	for _, test := range []struct {
+		godebug, want string
+	}{
+		{"", ""},
+		{"httpmuxgo115=1", "1"},
+		{"http2client=0,httpmuxgo115=1", "1"},
+		{"httpmuxgo115=1,http2client=0", "1"},
+		{"httpmuxgo115=1,httpmuxgo115=0", "0"},
+		{"httpmuxgo115=0,httpmuxgo115=1", "1"},
+		{"xhttpmuxgo115=1", ""},
+		{"httpmuxgo1150=1", ""},
+		{"httpmuxgo115", ""},
+		{"x=httpmuxgo115=1", ""},
+	} {
+		if got := godebugValue(test.godebug, "httpmuxgo115"); got != test.want {
+			t.Errorf("godebugValue(%q, %q) = %q, want %q", test.godebug, "httpmuxgo115", got, test.want)
+		}
+	}
+}
+
+type handler struct{ i int }
+
+func (handler) ServeHTTP(ResponseWriter, *Request) {}
+
 func BenchmarkServerMatch(b *testing.B) {
 	fn := func(w ResponseWriter, r *Request) {
 		fmt.Fprintf(w, "OK")
@@ -37,7 +291,7 @@ func BenchmarkServerMatch(b *testing.B) {
 		"/products/", "/products/3/image.jpg"}
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
-		if h, p := mux.match(paths[i%len(paths)]); h != nil && p == "" {
+		if n, _ := mux.tree.match("", "GET", paths[i%len(paths)]); n != nil && n.pattern == nil {
 			b.Error("impossible")
 		}
 	}
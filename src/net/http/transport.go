@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -109,6 +110,10 @@ type Transport struct {
 	connsPerHost     map[connectMethodKey]int
 	connsPerHostWait map[connectMethodKey]wantConnQueue // waiting getConns
 
+	connsMu sync.Mutex                                     // never held while acquiring another lock
+	conns   map[connectMethodKey]map[*persistConn]struct{} // open HTTP/1 conns
+	dialing map[connectMethodKey]int                       // dials in progress
+
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
 	// request is aborted with the provided error.
@@ -271,6 +276,48 @@ type Transport struct {
 	// To use a custom dialer or TLS config and still attempt HTTP/2
 	// upgrades, set this to true.
 	ForceAttemptHTTP2 bool
+
+	// EnableHTTP3 controls whether the Transport uses HTTP/3 for
+	// https requests to origins that advertised an HTTP/3
+	// alternative service in the Alt-Svc header of an earlier
+	// response. Requests to other origins, and requests made
+	// through a proxy, use HTTP/1.1 or HTTP/2 as usual. If the
+	// Transport cannot connect to an alternative, it falls back
+	// to the origin for a while before trying it again.
+	EnableHTTP3 bool
+
+	// HTTP2 configures HTTP/2 connections.
+	// If nil, default settings are used.
+	HTTP2 *HTTP2Config
+
+	// Protocols is the set of protocols supported by the transport.
+	//
+	// If Protocols includes UnencryptedHTTP2 and does not include HTTP1,
+	// the transport will use unencrypted HTTP/2 with prior knowledge
+	// for requests to http:// URLs that are not sent through a proxy.
+	// If Protocols does not include HTTP1, requests that cannot use
+	// HTTP/2 fail.
+	//
+	// If Protocols is nil, the default is usually HTTP/1 only.
+	// If ForceAttemptHTTP2 is true, or if TLSNextProto contains an "h2"
+	// entry, or if TLSClientConfig, Dial, DialTLS and DialContext are
+	// all unset, the default is HTTP/1 and HTTP/2.
+	// A non-nil Protocols including HTTP2 enables HTTP/2 in the same
+	// way ForceAttemptHTTP2 does.
+	Protocols *Protocols
+
+	// ConnPoolHook optionally specifies a function called when the
+	// Transport opens a connection, chooses an already used connection
+	// for another request, or closes a connection. The key identifies
+	// the pool the connection belongs to. Calls may be concurrent.
+	//
+	// For HTTP/2 connections, which serve many requests at once,
+	// ConnPoolReuse is reported for each request on the connection
+	// after the first one.
+	ConnPoolHook func(key ConnPoolKey, c net.Conn, ev ConnPoolEvent)
+
+	h3           http3Transport  // HTTP/3 alternative services and connections
+	h2cTransport *http2Transport // non-nil if unencrypted HTTP/2 is wired up; set by nextProtoOnce
 }
 
 // A cancelKey is the key of the reqCanceler map.
@@ -315,12 +362,22 @@ func (t *Transport) Clone() *Transport {
 		ProxyConnectHeader:     t.ProxyConnectHeader.Clone(),
 		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
 		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
+		EnableHTTP3:            t.EnableHTTP3,
+		ConnPoolHook:           t.ConnPoolHook,
 		WriteBufferSize:        t.WriteBufferSize,
 		ReadBufferSize:         t.ReadBufferSize,
 	}
 	if t.TLSClientConfig != nil {
 		t2.TLSClientConfig = t.TLSClientConfig.Clone()
 	}
+	if t.HTTP2 != nil {
+		t2.HTTP2 = &HTTP2Config{}
+		*t2.HTTP2 = *t.HTTP2
+	}
+	if t.Protocols != nil {
+		t2.Protocols = &Protocols{}
+		*t2.Protocols = *t.Protocols
+	}
 	if !t.tlsNextProtoWasNil {
 		npm := map[string]func(authority string, c *tls.Conn) RoundTripper{}
 		for k, v := range t.TLSNextProto {
@@ -352,6 +409,12 @@ func (t *Transport) onceSetNextProtoDefaults() {
 	if strings.Contains(os.Getenv("GODEBUG"), "http2client=0") {
 		return
 	}
+	protocols := t.protocols()
+
+	if protocols.UnencryptedHTTP2() && !protocols.HTTP1() && !omitBundledHTTP2 {
+		t.h2cTransport = http2configureUnencryptedTransport(t)
+		t.configureHTTP2(t.h2cTransport)
+	}
 
 	// If they've already configured http2 with
 	// golang.org/x/net/http2 instead of the bundled copy, try to
@@ -373,13 +436,7 @@ func (t *Transport) onceSetNextProtoDefaults() {
 		// Transport.
 		return
 	}
-	if !t.ForceAttemptHTTP2 && (t.TLSClientConfig != nil || t.Dial != nil || t.DialContext != nil || t.hasCustomTLSDialer()) {
-		// Be conservative and don't automatically enable
-		// http2 if they've specified a custom TLS config or
-		// custom dialers. Let them opt-in themselves via
-		// http2.ConfigureTransport so we don't surprise them
-		// by modifying their tls.Config. Issue 14275.
-		// However, if ForceAttemptHTTP2 is true, it overrides the above checks.
+	if !protocols.HTTP2() {
 		return
 	}
 	if omitBundledHTTP2 {
@@ -390,8 +447,50 @@ func (t *Transport) onceSetNextProtoDefaults() {
 		log.Printf("Error enabling Transport HTTP/2 support: %v", err)
 		return
 	}
+	t2.hookConnPool()
 	t.h2transport = t2
+	t.configureHTTP2(t2)
+
+	if !protocols.HTTP1() {
+		// Don't offer HTTP/1.1 during ALPN negotiation.
+		var nextProtos []string
+		for _, p := range t.TLSClientConfig.NextProtos {
+			if p != "http/1.1" {
+				nextProtos = append(nextProtos, p)
+			}
+		}
+		t.TLSClientConfig.NextProtos = nextProtos
+	}
+}
 
+// protocols returns the set of protocols t supports.
+func (t *Transport) protocols() Protocols {
+	if t.Protocols != nil {
+		return *t.Protocols
+	}
+	var p Protocols
+	p.SetHTTP1(true)
+	switch {
+	case t.TLSNextProto != nil:
+		if t.TLSNextProto[http2NextProtoTLS] != nil {
+			p.SetHTTP2(true)
+		}
+	case !t.ForceAttemptHTTP2 && (t.TLSClientConfig != nil || t.Dial != nil || t.DialContext != nil || t.hasCustomTLSDialer()):
+		// Be conservative and don't automatically enable
+		// http2 if they've specified a custom TLS config or
+		// custom dialers. Let them opt-in themselves via
+		// http2.ConfigureTransport so we don't surprise them
+		// by modifying their tls.Config. Issue 14275.
+		// However, if ForceAttemptHTTP2 is true, it overrides the above checks.
+	default:
+		p.SetHTTP2(true)
+	}
+	return p
+}
+
+// configureHTTP2 applies t's HTTP/2 settings to the bundled
+// HTTP/2 transport t2.
+func (t *Transport) configureHTTP2(t2 *http2Transport) {
 	// Auto-configure the http2.Transport's MaxHeaderListSize from
 	// the http.Transport's MaxResponseHeaderBytes. They don't
 	// exactly mean the same thing, but they're close.
@@ -406,6 +505,13 @@ func (t *Transport) onceSetNextProtoDefaults() {
 			t2.MaxHeaderListSize = uint32(limit1)
 		}
 	}
+
+	c := t.HTTP2
+	if c == nil {
+		return
+	}
+	t2.ReadIdleTimeout = c.SendPingTimeout
+	t2.PingTimeout = c.PingTimeout
 }
 
 // ProxyFromEnvironment returns the URL of the proxy to use for a
@@ -562,6 +668,16 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 			return nil, err
 		}
 
+		if t.EnableHTTP3 && cm.proxyURL == nil && cm.targetScheme == "https" {
+			if resp, err, ok := t.roundTripHTTP3(req, cm); ok {
+				if err != nil {
+					return nil, err
+				}
+				resp.Request = origReq
+				return resp, nil
+			}
+		}
+
 		// Get the cached or newly-created connection to either the
 		// host (for http or https), the http proxy, or the http proxy
 		// pre-CONNECTed to https server. In any case, we'll be ready
@@ -582,6 +698,9 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 			resp, err = pconn.roundTrip(treq)
 		}
 		if err == nil {
+			if t.EnableHTTP3 && resp.TLS != nil && cm.proxyURL == nil {
+				t.h3.processAltSvc(cm.targetAddr, resp.Header)
+			}
 			resp.Request = origReq
 			return resp, nil
 		}
@@ -753,6 +872,290 @@ func (t *Transport) CloseIdleConnections() {
 	if t2 := t.h2transport; t2 != nil {
 		t2.CloseIdleConnections()
 	}
+	if t2 := t.h2cTransport; t2 != nil {
+		t2.CloseIdleConnections()
+	}
+	t.h3.closeIdleConns()
+}
+
+// A ConnPoolKey identifies a set of connections a Transport uses
+// interchangeably for requests.
+type ConnPoolKey struct {
+	// Scheme is the scheme of the requests sent on the connections,
+	// "http" or "https".
+	Scheme string
+
+	// Addr is the "host:port" address of the origin server.
+	// It is empty for connections to an HTTP proxy that forwards
+	// http requests for any host.
+	Addr string
+
+	// Proxy is the URL of the proxy the connections go through,
+	// with any password redacted, or empty for direct connections.
+	// HTTP/2 connections are pooled by Addr alone, so their Proxy
+	// is always empty.
+	Proxy string
+}
+
+// ConnPoolState is a snapshot of a Transport's connections for one
+// ConnPoolKey, as returned by Transport.ConnPoolStates.
+type ConnPoolState struct {
+	Key ConnPoolKey
+
+	Idle  int // HTTP/1 connections waiting for a request
+	InUse int // HTTP/1 connections serving a request
+
+	HTTP2        int // HTTP/2 connections
+	HTTP2Streams int // requests in flight on HTTP/2 connections
+
+	Dialing int // connections being established
+	Waiting int // requests waiting for a connection
+}
+
+// A ConnPoolEvent is a change to a Transport's connection, reported
+// to Transport.ConnPoolHook.
+type ConnPoolEvent int
+
+const (
+	// ConnPoolOpen means a new connection has been established
+	// and added to its pool.
+	ConnPoolOpen ConnPoolEvent = iota
+
+	// ConnPoolReuse means a connection that has served an
+	// earlier request has been chosen for another one.
+	ConnPoolReuse
+
+	// ConnPoolClose means a connection has been removed from its
+	// pool, usually because it was closed. A connection that was
+	// handed to the caller by a 101 Switching Protocols response
+	// is also removed.
+	ConnPoolClose
+)
+
+var connPoolEventName = map[ConnPoolEvent]string{
+	ConnPoolOpen:  "open",
+	ConnPoolReuse: "reuse",
+	ConnPoolClose: "close",
+}
+
+func (e ConnPoolEvent) String() string {
+	return connPoolEventName[e]
+}
+
+// ConnPoolStates returns a snapshot of t's connections, with an entry
+// for each ConnPoolKey that has open connections, dials in progress
+// or waiting requests. The entries are sorted by Scheme, Addr and
+// Proxy.
+func (t *Transport) ConnPoolStates() []ConnPoolState {
+	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+	m := make(map[ConnPoolKey]*ConnPoolState)
+	state := func(key ConnPoolKey) *ConnPoolState {
+		st := m[key]
+		if st == nil {
+			st = &ConnPoolState{Key: key}
+			m[key] = st
+		}
+		return st
+	}
+
+	t.idleMu.Lock()
+	t.connsMu.Lock()
+	for key, pconns := range t.conns {
+		state(key.connPoolKey()).InUse += len(pconns)
+	}
+	for key, n := range t.dialing {
+		state(key.connPoolKey()).Dialing += n
+	}
+	t.connsMu.Unlock()
+	for key, pconns := range t.idleConn {
+		for _, pconn := range pconns {
+			if pconn.alt == nil {
+				st := state(key.connPoolKey())
+				st.Idle++
+				st.InUse--
+			}
+		}
+	}
+	// A request that can't dial because of MaxConnsPerHost is usually
+	// queued in both idleConnWait and connsPerHostWait, so collect the
+	// waiting requests first to count each of them once.
+	waiting := make(map[connectMethodKey]map[*wantConn]bool)
+	addWaiting := func(key connectMethodKey, q wantConnQueue) {
+		if waiting[key] == nil {
+			waiting[key] = make(map[*wantConn]bool)
+		}
+		q.addWaiting(waiting[key])
+	}
+	for key, q := range t.idleConnWait {
+		addWaiting(key, q)
+	}
+	t.idleMu.Unlock()
+	t.connsPerHostMu.Lock()
+	for key, q := range t.connsPerHostWait {
+		addWaiting(key, q)
+	}
+	t.connsPerHostMu.Unlock()
+	for key, ws := range waiting {
+		if len(ws) > 0 {
+			state(key.connPoolKey()).Waiting += len(ws)
+		}
+	}
+
+	for _, t2 := range t.bundledHTTP2() {
+		for _, h2st := range t2.connPoolStates() {
+			st := state(h2st.Key)
+			st.HTTP2 += h2st.HTTP2
+			st.HTTP2Streams += h2st.HTTP2Streams
+		}
+	}
+
+	states := make([]ConnPoolState, 0, len(m))
+	for _, st := range m {
+		states = append(states, *st)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i].Key, states[j].Key
+		if a.Scheme != b.Scheme {
+			return a.Scheme < b.Scheme
+		}
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+		return a.Proxy < b.Proxy
+	})
+	return states
+}
+
+// EvictHost closes t's connections to host so that later requests to
+// it use new connections, letting requests in flight on them finish.
+// The host may be a host name or IP address, which matches connections
+// to any port, or a "host:port" address. An IPv6 address may be enclosed
+// in square brackets, as in "[::1]" or "[::1]:443".
+//
+// Idle connections are closed right away. HTTP/1 connections that are
+// serving a request are closed when the request is done. HTTP/2
+// connections are sent a GOAWAY frame and closed when their last
+// request is done. Connections to an HTTP proxy that forwards http
+// requests for any host are not affected, nor are connections that are
+// still being established.
+func (t *Transport) EvictHost(host string) {
+	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+	// The addresses of pooled connections always have a port, and
+	// brackets around IPv6 addresses.
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		hostname = host[1 : len(host)-1]
+	}
+	match := func(addr string) bool {
+		h, p, err := net.SplitHostPort(addr)
+		return err == nil && h == hostname && (port == "" || p == port)
+	}
+
+	// Mark the connections before looking for idle ones, so that a
+	// connection finishing its request in between isn't put back in
+	// the idle pool.
+	var pconns []*persistConn
+	t.connsMu.Lock()
+	for key, m := range t.conns {
+		if match(key.addr) {
+			for pconn := range m {
+				pconns = append(pconns, pconn)
+			}
+		}
+	}
+	t.connsMu.Unlock()
+	for _, pconn := range pconns {
+		pconn.mu.Lock()
+		pconn.evicted = true
+		pconn.mu.Unlock()
+	}
+
+	var idle []*persistConn
+	t.idleMu.Lock()
+	for key, list := range t.idleConn {
+		if !match(key.addr) {
+			continue
+		}
+		for _, pconn := range list {
+			if pconn.alt == nil {
+				idle = append(idle, pconn)
+			}
+		}
+	}
+	for _, pconn := range idle {
+		t.removeIdleConnLocked(pconn)
+	}
+	t.idleMu.Unlock()
+	for _, pconn := range idle {
+		pconn.close(errEvictHost)
+	}
+
+	for _, t2 := range t.bundledHTTP2() {
+		t2.shutdownConns(match)
+	}
+}
+
+// bundledHTTP2 returns the bundled HTTP/2 transports in use by t.
+func (t *Transport) bundledHTTP2() []*http2Transport {
+	var ts []*http2Transport
+	if t2, ok := t.h2transport.(*http2Transport); ok {
+		ts = append(ts, t2)
+	}
+	if t.h2cTransport != nil {
+		ts = append(ts, t.h2cTransport)
+	}
+	return ts
+}
+
+// trackConn adds or removes pconn, an HTTP/1 connection, from the set
+// of open connections, and reports the change to ConnPoolHook.
+func (t *Transport) trackConn(pconn *persistConn, add bool) {
+	key := pconn.cacheKey
+	t.connsMu.Lock()
+	if add {
+		if t.conns == nil {
+			t.conns = make(map[connectMethodKey]map[*persistConn]struct{})
+		}
+		if t.conns[key] == nil {
+			t.conns[key] = make(map[*persistConn]struct{})
+		}
+		t.conns[key][pconn] = struct{}{}
+	} else {
+		delete(t.conns[key], pconn)
+		if len(t.conns[key]) == 0 {
+			delete(t.conns, key)
+		}
+	}
+	t.connsMu.Unlock()
+
+	ev := ConnPoolOpen
+	if !add {
+		ev = ConnPoolClose
+	}
+	t.connPoolEvent(key.connPoolKey(), pconn.conn, ev)
+}
+
+// trackDial adds or removes a dial in progress for key.
+func (t *Transport) trackDial(key connectMethodKey, add bool) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if add {
+		if t.dialing == nil {
+			t.dialing = make(map[connectMethodKey]int)
+		}
+		t.dialing[key]++
+	} else if t.dialing[key]--; t.dialing[key] == 0 {
+		delete(t.dialing, key)
+	}
+}
+
+// connPoolEvent calls ConnPoolHook, if set.
+func (t *Transport) connPoolEvent(key ConnPoolKey, c net.Conn, ev ConnPoolEvent) {
+	if hook := t.ConnPoolHook; hook != nil {
+		hook(key, c, ev)
+	}
 }
 
 // CancelRequest cancels an in-flight request by closing its connection.
@@ -836,6 +1239,7 @@ var (
 	errCloseIdleConns     = errors.New("http: CloseIdleConnections called")
 	errReadLoopExiting    = errors.New("http: persistConn.readLoop exiting")
 	errIdleConnTimeout    = errors.New("http: idle connection timeout")
+	errEvictHost          = errors.New("http: EvictHost called")
 
 	// errServerClosedIdle is not seen by users for idempotent requests, but may be
 	// seen by a user if the server shuts down an idle connection and sends its FIN
@@ -887,6 +1291,9 @@ func (t *Transport) tryPutIdleConn(pconn *persistConn) error {
 	if pconn.isBroken() {
 		return errConnBroken
 	}
+	if pconn.isEvicted() {
+		return errEvictHost
+	}
 	pconn.markReused()
 
 	t.idleMu.Lock()
@@ -1240,6 +1647,21 @@ func (q *wantConnQueue) len() int {
 	return len(q.head) - q.headPos + len(q.tail)
 }
 
+// addWaiting adds the items in the queue still waiting for a
+// connection to set.
+func (q *wantConnQueue) addWaiting(set map[*wantConn]bool) {
+	for _, w := range q.head[q.headPos:] {
+		if w.waiting() {
+			set[w] = true
+		}
+	}
+	for _, w := range q.tail {
+		if w.waiting() {
+			set[w] = true
+		}
+	}
+}
+
 // pushBack adds w to the back of the queue.
 func (q *wantConnQueue) pushBack(w *wantConn) {
 	q.tail = append(q.tail, w)
@@ -1330,6 +1752,9 @@ func (t *Transport) getConn(treq *transportRequest, cm connectMethod) (pc *persi
 		if pc.alt == nil && trace != nil && trace.GotConn != nil {
 			trace.GotConn(pc.gotIdleConnTrace(pc.idleAt))
 		}
+		if pc.alt == nil {
+			t.connPoolEvent(pc.cacheKey.connPoolKey(), pc.conn, ConnPoolReuse)
+		}
 		// set request canceler to some non-nil function so we
 		// can detect whether it was cleared between now and when
 		// we enter roundTrip
@@ -1351,6 +1776,10 @@ func (t *Transport) getConn(treq *transportRequest, cm connectMethod) (pc *persi
 		if w.pc != nil && w.pc.alt == nil && trace != nil && trace.GotConn != nil {
 			trace.GotConn(httptrace.GotConnInfo{Conn: w.pc.conn, Reused: w.pc.isReused()})
 		}
+		if w.pc != nil && w.pc.alt == nil && w.pc.isReused() {
+			// Handed over by tryPutIdleConn while we were dialing.
+			t.connPoolEvent(w.pc.cacheKey.connPoolKey(), w.pc.conn, ConnPoolReuse)
+		}
 		if w.err != nil {
 			// If the request has been cancelled, that's probably
 			// what caused w.err; if so, prefer to return the
@@ -1418,7 +1847,9 @@ func (t *Transport) queueForDial(w *wantConn) {
 func (t *Transport) dialConnFor(w *wantConn) {
 	defer w.afterDial()
 
+	t.trackDial(w.key, true)
 	pc, err := t.dialConn(w.ctx, w.cm)
+	t.trackDial(w.key, false)
 	delivered := w.tryDeliver(pc, err)
 	if err == nil && (!delivered || pc.alt != nil) {
 		// pconn was not passed to w,
@@ -1484,7 +1915,7 @@ func (t *Transport) decConnsPerHost(key connectMethodKey) {
 // Add TLS to a persistent connection, i.e. negotiate a TLS session. If pconn is already a TLS
 // tunnel, this function establishes a nested TLS session inside the encrypted channel.
 // The remote endpoint's name may be overridden by TLSClientConfig.ServerName.
-func (pconn *persistConn) addTLS(name string, trace *httptrace.ClientTrace) error {
+func (pconn *persistConn) addTLS(ctx context.Context, name string, trace *httptrace.ClientTrace) error {
 	// Initiate TLS and check remote host name against certificate.
 	cfg := cloneTLSConfig(pconn.t.TLSClientConfig)
 	if cfg.ServerName == "" {
@@ -1506,7 +1937,7 @@ func (pconn *persistConn) addTLS(name string, trace *httptrace.ClientTrace) erro
 		if trace != nil && trace.TLSHandshakeStart != nil {
 			trace.TLSHandshakeStart()
 		}
-		err := tlsConn.Handshake()
+		err := tlsConn.HandshakeContext(ctx)
 		if timer != nil {
 			timer.Stop()
 		}
@@ -1562,7 +1993,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 			if trace != nil && trace.TLSHandshakeStart != nil {
 				trace.TLSHandshakeStart()
 			}
-			if err := tc.Handshake(); err != nil {
+			if err := tc.HandshakeContext(ctx); err != nil {
 				go pconn.conn.Close()
 				if trace != nil && trace.TLSHandshakeDone != nil {
 					trace.TLSHandshakeDone(tls.ConnectionState{}, err)
@@ -1586,7 +2017,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 			if firstTLSHost, _, err = net.SplitHostPort(cm.addr()); err != nil {
 				return nil, wrapErr(err)
 			}
-			if err = pconn.addTLS(firstTLSHost, trace); err != nil {
+			if err = pconn.addTLS(ctx, firstTLSHost, trace); err != nil {
 				return nil, wrapErr(err)
 			}
 		}
@@ -1690,7 +2121,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 	}
 
 	if cm.proxyURL != nil && cm.targetScheme == "https" {
-		if err := pconn.addTLS(cm.tlsHost(), trace); err != nil {
+		if err := pconn.addTLS(ctx, cm.tlsHost(), trace); err != nil {
 			return nil, err
 		}
 	}
@@ -1706,9 +2137,22 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 		}
 	}
 
+	if protocols := t.protocols(); !protocols.HTTP1() {
+		if pconn.tlsState == nil && t.h2cTransport != nil && cm.proxyURL == nil {
+			alt := t.h2cTransport.addUnencryptedConn(cm.targetAddr, pconn.conn)
+			if e, ok := alt.(erringRoundTripper); ok {
+				return nil, e.RoundTripErr()
+			}
+			return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+		}
+		pconn.conn.Close()
+		return nil, errors.New("net/http: Transport.Protocols does not permit HTTP/1 and the connection cannot use HTTP/2")
+	}
+
 	pconn.br = bufio.NewReaderSize(pconn, t.readBufferSize())
 	pconn.bw = bufio.NewWriterSize(persistConnWriter{pconn}, t.writeBufferSize())
 
+	t.trackConn(pconn, true)
 	go pconn.readLoop()
 	go pconn.writeLoop()
 	return pconn, nil
@@ -1820,6 +2264,18 @@ type connectMethodKey struct {
 	onlyH1              bool
 }
 
+// connPoolKey returns k as a ConnPoolKey.
+func (k connectMethodKey) connPoolKey() ConnPoolKey {
+	proxy := k.proxy
+	if proxy != "" {
+		// Don't hand proxy passwords to hooks and loggers.
+		if u, err := url.Parse(proxy); err == nil {
+			proxy = u.Redacted()
+		}
+	}
+	return ConnPoolKey{Scheme: k.scheme, Addr: k.addr, Proxy: proxy}
+}
+
 func (k connectMethodKey) String() string {
 	// Only used by tests.
 	var h1 string
@@ -1868,6 +2324,7 @@ type persistConn struct {
 	canceledErr          error // set non-nil if conn is canceled
 	broken               bool  // an error has happened on this connection; marked broken so it's not reused.
 	reused               bool  // whether conn has had successful request/response and is being reused.
+	evicted              bool  // whether EvictHost was called for conn; it's closed instead of becoming idle.
 	// mutateHeaderFunc is an optional func to modify extra
 	// headers on each outbound request before it's written. (the
 	// original Request given to RoundTrip is not modified)
@@ -1912,6 +2369,15 @@ func (pc *persistConn) canceled() error {
 	return pc.canceledErr
 }
 
+// isEvicted reports whether EvictHost has marked this connection to be
+// closed once its current request is done.
+func (pc *persistConn) isEvicted() bool {
+	pc.mu.Lock()
+	e := pc.evicted
+	pc.mu.Unlock()
+	return e
+}
+
 // isReused reports whether this connection has been used before.
 func (pc *persistConn) isReused() bool {
 	pc.mu.Lock()
@@ -2011,6 +2477,7 @@ func (pc *persistConn) readLoop() {
 	defer func() {
 		pc.close(closeErr)
 		pc.t.removeIdleConn(pc)
+		pc.t.trackConn(pc, false)
 	}()
 
 	tryPutIdleConn := func(trace *httptrace.ClientTrace) bool {
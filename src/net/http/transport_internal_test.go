@@ -16,6 +16,7 @@ import (
 	"net/http/internal"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Issue 15446: incorrect wrapping of errors when server closes an idle connection.
@@ -261,3 +262,50 @@ func TestTransportBodyAltRewind(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestHTTP3ParseAltSvc(t *testing.T) {
+	tests := []struct {
+		values    []string
+		authority string
+		maxAge    time.Duration
+		clear     bool
+	}{
+		{[]string{`h3=":443"`}, ":443", 24 * time.Hour, false},
+		{[]string{`h3=":443"; ma=60`}, ":443", time.Minute, false},
+		{[]string{`h2=":443", h3="alt.example.com:8443"; ma=10; persist=1`}, "alt.example.com:8443", 10 * time.Second, false},
+		{[]string{`h3-29=":443"`, `h3=":444"`}, ":444", 24 * time.Hour, false},
+		{[]string{`h3=":443"`, `h3=":444"`}, ":443", 24 * time.Hour, false},
+		{[]string{`h3=":443"; ma=bogus`}, ":443", 24 * time.Hour, false},
+		{[]string{`h3="bad\"quote:1"`}, "", 0, false},
+		{[]string{`h3=noport`}, "", 0, false},
+		{[]string{`clear`}, "", 0, true},
+		{[]string{`h3=":443"`, ` clear `}, "", 0, true},
+	}
+	for _, tt := range tests {
+		authority, maxAge, clear := http3ParseAltSvc(tt.values)
+		if authority != tt.authority || maxAge != tt.maxAge || clear != tt.clear {
+			t.Errorf("http3ParseAltSvc(%q) = %q, %v, %v; want %q, %v, %v",
+				tt.values, authority, maxAge, clear, tt.authority, tt.maxAge, tt.clear)
+		}
+	}
+}
+
+func TestHTTP3ProcessAltSvc(t *testing.T) {
+	var t3 http3Transport
+	t3.processAltSvc("origin.example.com:443", Header{"Alt-Svc": {`h3=":8443"`}})
+	if addr, ok := t3.alternative("origin.example.com:443"); !ok || addr != "origin.example.com:8443" {
+		t.Fatalf("alternative = %q, %v; want origin.example.com:8443, true", addr, ok)
+	}
+	t3.markBroken("origin.example.com:443", "origin.example.com:8443")
+	if _, ok := t3.alternative("origin.example.com:443"); ok {
+		t.Fatal("broken alternative is still used")
+	}
+	t3.processAltSvc("origin.example.com:443", Header{"Alt-Svc": {"clear"}})
+	if len(t3.alts) != 0 {
+		t.Fatalf("alternatives after clear: %v", t3.alts)
+	}
+	t3.processAltSvc("origin.example.com:443", Header{"Alt-Svc": {`h3=":8443"; ma=0`}})
+	if _, ok := t3.alternative("origin.example.com:443"); ok {
+		t.Fatal("expired alternative is used")
+	}
+}
@@ -5843,6 +5843,10 @@ func TestTransportClone(t *testing.T) {
 		ProxyConnectHeader:     Header{},
 		MaxResponseHeaderBytes: 1,
 		ForceAttemptHTTP2:      true,
+		EnableHTTP3:            true,
+		ConnPoolHook:           func(ConnPoolKey, net.Conn, ConnPoolEvent) { panic("") },
+		HTTP2:                  &HTTP2Config{MaxReadFrameSize: 1 << 20},
+		Protocols:              &Protocols{},
 		TLSNextProto: map[string]func(authority string, c *tls.Conn) RoundTripper{
 			"foo": func(authority string, c *tls.Conn) RoundTripper { panic("") },
 		},
@@ -5865,6 +5869,9 @@ func TestTransportClone(t *testing.T) {
 	if _, ok := tr2.TLSNextProto["foo"]; !ok {
 		t.Errorf("cloned Transport lacked TLSNextProto 'foo' key")
 	}
+	if tr2.HTTP2 == tr.HTTP2 || tr2.Protocols == tr.Protocols {
+		t.Errorf("cloned Transport shares HTTP2 or Protocols with the original")
+	}
 
 	// But test that a nil TLSNextProto is kept nil:
 	tr = new(Transport)
@@ -6284,3 +6291,268 @@ func TestTransportRejectsSignInContentLength(t *testing.T) {
 		t.Fatalf("Error mismatch\nGot: %q\nWanted substring: %q", got, want)
 	}
 }
+
+// connPoolEvents records the events reported to a Transport.ConnPoolHook.
+type connPoolEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *connPoolEvents) hook(key ConnPoolKey, c net.Conn, ev ConnPoolEvent) {
+	if c == nil {
+		panic("ConnPoolHook called with a nil net.Conn")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, key.Scheme+" "+ev.String())
+}
+
+func (e *connPoolEvents) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.Join(e.events, ", ")
+}
+
+func waitConnPoolEvents(t *testing.T, e *connPoolEvents, want string) {
+	t.Helper()
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+		return e.String() == want
+	}) {
+		t.Fatalf("ConnPoolHook events = %q, want %q", e.String(), want)
+	}
+}
+
+func getAndDiscard(t *testing.T, c *Client, url string) {
+	t.Helper()
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+func TestTransportConnPoolStatesAndHook(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	var events connPoolEvents
+	tr.ConnPoolHook = events.hook
+
+	getAndDiscard(t, c, ts.URL)
+	getAndDiscard(t, c, ts.URL)
+	waitConnPoolEvents(t, &events, "http open, http reuse")
+
+	want := []ConnPoolState{{
+		Key:  ConnPoolKey{Scheme: "http", Addr: ts.Listener.Addr().String()},
+		Idle: 1,
+	}}
+	if got := tr.ConnPoolStates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ConnPoolStates = %+v, want %+v", got, want)
+	}
+
+	tr.EvictHost("127.0.0.1")
+	waitConnPoolEvents(t, &events, "http open, http reuse, http close")
+	if got := tr.ConnPoolStates(); len(got) != 0 {
+		t.Errorf("after EvictHost, ConnPoolStates = %+v, want none", got)
+	}
+}
+
+func TestTransportEvictHostIPv6(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	for _, test := range []struct {
+		host  string
+		evict bool
+	}{
+		{"::1", true},
+		{"[::1]", true},
+		{"[::1]:" + port, true},
+		{"::1:" + port, false},
+		{"[::1]:1", false},
+		{"[::2]", false},
+	} {
+		c := ts.Client()
+		tr := c.Transport.(*Transport)
+		getAndDiscard(t, c, ts.URL)
+		tr.EvictHost(test.host)
+		if test.evict {
+			if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+				return len(tr.ConnPoolStates()) == 0
+			}) {
+				t.Errorf("EvictHost(%q): ConnPoolStates = %+v, want none", test.host, tr.ConnPoolStates())
+			}
+		} else if got := tr.ConnPoolStates(); len(got) != 1 {
+			t.Errorf("EvictHost(%q): ConnPoolStates = %+v, want one", test.host, got)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportConnPoolStatesMaxConnsPerHost(t *testing.T) {
+	defer afterTest(t)
+	for _, disableKeepAlives := range []bool{false, true} {
+		t.Run(fmt.Sprintf("DisableKeepAlives=%v", disableKeepAlives), func(t *testing.T) {
+			testTransportConnPoolStatesMaxConnsPerHost(t, disableKeepAlives)
+		})
+	}
+}
+
+func testTransportConnPoolStatesMaxConnsPerHost(t *testing.T, disableKeepAlives bool) {
+	started := make(chan bool, 2)
+	release := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		started <- true
+		<-release
+	}))
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	defer tr.CloseIdleConnections()
+	tr.MaxConnsPerHost = 1
+	tr.DisableKeepAlives = disableKeepAlives
+
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			res, err := c.Get(ts.URL)
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+	}
+	<-started
+
+	want := []ConnPoolState{{
+		Key:     ConnPoolKey{Scheme: "http", Addr: ts.Listener.Addr().String()},
+		InUse:   1,
+		Waiting: 1,
+	}}
+	var got []ConnPoolState
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+		got = tr.ConnPoolStates()
+		return reflect.DeepEqual(got, want)
+	}) {
+		t.Errorf("ConnPoolStates = %+v, want %+v", got, want)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTransportEvictHostInFlight(t *testing.T) {
+	defer afterTest(t)
+	for _, h2 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("h2=%v", h2), func(t *testing.T) {
+			testTransportEvictHostInFlight(t, h2)
+		})
+	}
+}
+
+func testTransportEvictHostInFlight(t *testing.T, h2 bool) {
+	if h2 {
+		CondSkipHTTP2(t)
+	}
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/slow" {
+			started <- true
+			<-release
+		}
+		io.WriteString(w, "ok")
+	}))
+	ts.EnableHTTP2 = h2
+	ts.StartTLS()
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	defer tr.CloseIdleConnections()
+	var events connPoolEvents
+	tr.ConnPoolHook = events.hook
+
+	getAndDiscard(t, c, ts.URL)
+	errc := make(chan error, 1)
+	go func() {
+		res, err := c.Get(ts.URL + "/slow")
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err == nil && string(body) != "ok" {
+				err = fmt.Errorf("body = %q, want %q", body, "ok")
+			}
+		}
+		errc <- err
+	}()
+	<-started
+
+	states := tr.ConnPoolStates()
+	if len(states) != 1 {
+		t.Fatalf("ConnPoolStates = %+v, want one entry", states)
+	}
+	if st := states[0]; h2 && (st.HTTP2 != 1 || st.HTTP2Streams != 1) || !h2 && st.InUse != 1 {
+		t.Errorf("ConnPoolStates = %+v, want one connection in use", states)
+	}
+
+	tr.EvictHost(ts.Listener.Addr().String())
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatalf("request in flight during EvictHost: %v", err)
+	}
+	waitConnPoolEvents(t, &events, "https open, https reuse, https close")
+
+	getAndDiscard(t, c, ts.URL)
+	waitConnPoolEvents(t, &events, "https open, https reuse, https close, https open")
+}
+
+// An HTTP/2 connection added to the pool but never used for a
+// request must still be reported as opened and closed.
+func TestTransportConnPoolHookHTTP2Unused(t *testing.T) {
+	CondSkipHTTP2(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	tr := ts.Client().Transport.(*Transport)
+	var events connPoolEvents
+	tr.ConnPoolHook = events.hook
+	tr.CloseIdleConnections() // sets up HTTP/2
+
+	addr := ts.Listener.Addr().String()
+	tc, err := tls.Dial("tcp", addr, tr.TLSClientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := tc.ConnectionState().NegotiatedProtocol; p != "h2" {
+		tc.Close()
+		t.Fatalf("NegotiatedProtocol = %q, want h2", p)
+	}
+	tr.TLSNextProto["h2"](addr, tc)
+	waitConnPoolEvents(t, &events, "https open")
+	if states := tr.ConnPoolStates(); len(states) != 1 || states[0].HTTP2 != 1 {
+		t.Errorf("ConnPoolStates = %+v, want one HTTP/2 connection", states)
+	}
+
+	tr.CloseIdleConnections()
+	waitConnPoolEvents(t, &events, "https open, https close")
+}
@@ -12,7 +12,10 @@
 
 package net
 
-import "internal/bytealg"
+import (
+	"internal/bytealg"
+	"net/netip"
+)
 
 // IP address lengths (bytes).
 const (
@@ -534,6 +537,41 @@ func (n *IPNet) String() string {
 	return nn.String() + "/" + uitoa(uint(l))
 }
 
+// Prefix returns n as a netip.Prefix. As with String, the host bits
+// of n.IP are preserved; use netip.Prefix.Masked to clear them.
+// It reports false if n is nil or malformed or if its mask is not
+// in the canonical form.
+func (n *IPNet) Prefix() (netip.Prefix, bool) {
+	if n == nil {
+		return netip.Prefix{}, false
+	}
+	nn, m := networkNumberAndMask(n)
+	if nn == nil || m == nil {
+		return netip.Prefix{}, false
+	}
+	l := simpleMaskLength(m)
+	if l == -1 {
+		return netip.Prefix{}, false
+	}
+	ip, ok := netip.AddrFromSlice(nn)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(ip, l), true
+}
+
+// IPNetFromPrefix returns p as an IPNet.
+// It returns nil if p is not valid.
+func IPNetFromPrefix(p netip.Prefix) *IPNet {
+	if !p.IsValid() {
+		return nil
+	}
+	return &IPNet{
+		IP:   p.Addr().AsSlice(),
+		Mask: CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}
+}
+
 // Parse IPv4 address (d.d.d.d).
 func parseIPv4(s string) IP {
 	var p [IPv4len]byte
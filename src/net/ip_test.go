@@ -9,6 +9,7 @@ package net
 import (
 	"bytes"
 	"math/rand"
+	"net/netip"
 	"reflect"
 	"runtime"
 	"testing"
@@ -739,3 +740,34 @@ func benchmarkIPEqual(b *testing.B, size int) {
 		x.Equal(y)
 	}
 }
+
+func TestIPNetPrefix(t *testing.T) {
+	tests := []struct {
+		in   *IPNet
+		want netip.Prefix
+		ok   bool
+	}{
+		{nil, netip.Prefix{}, false},
+		{&IPNet{IP: IPv4(192, 168, 1, 0), Mask: CIDRMask(24, 32)}, netip.MustParsePrefix("192.168.1.0/24"), true},
+		{&IPNet{IP: IPv4(192, 168, 1, 7), Mask: CIDRMask(24, 32)}, netip.MustParsePrefix("192.168.1.7/24"), true},
+		{&IPNet{IP: ParseIP("2001:db8::"), Mask: CIDRMask(32, 128)}, netip.MustParsePrefix("2001:db8::/32"), true},
+		{&IPNet{IP: IPv4(192, 168, 1, 0), Mask: IPv4Mask(255, 0, 255, 0)}, netip.Prefix{}, false},
+		{&IPNet{IP: IP{1, 2, 3}, Mask: CIDRMask(24, 32)}, netip.Prefix{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.in.Prefix()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%v.Prefix() = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+		if !ok {
+			continue
+		}
+		back := IPNetFromPrefix(got)
+		if back.String() != tt.in.String() {
+			t.Errorf("IPNetFromPrefix(%v) = %v, want %v", got, back, tt.in)
+		}
+	}
+	if got := IPNetFromPrefix(netip.Prefix{}); got != nil {
+		t.Errorf("IPNetFromPrefix of invalid Prefix = %v, want nil", got)
+	}
+}
@@ -0,0 +1,614 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netip_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	. "net/netip"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var long = "fe80:1234:5678:90ab:cdef:1234:5678:90ab%" + strings.Repeat("z", 20)
+
+var parseAddrTests = []struct {
+	in   string
+	want Addr
+	str  string // expected String, if different from in
+}{
+	{"0.0.0.0", AddrFrom4([4]byte{}), ""},
+	{"192.168.140.255", AddrFrom4([4]byte{192, 168, 140, 255}), ""},
+	{"::", IPv6Unspecified(), ""},
+	{"::1", AddrFrom16([16]byte{15: 1}), ""},
+	{"fd7a:115c:a1e0:ab12:4843:cd96:626b:430b", AddrFrom16([16]byte{0xfd, 0x7a, 0x11, 0x5c, 0xa1, 0xe0, 0xab, 0x12, 0x48, 0x43, 0xcd, 0x96, 0x62, 0x6b, 0x43, 0x0b}), ""},
+	{"fd7a:115c::626b:430b", AddrFrom16([16]byte{0xfd, 0x7a, 0x11, 0x5c, 12: 0x62, 13: 0x6b, 14: 0x43, 15: 0x0b}), ""},
+	{"FD9E:1A04:F01D::1", AddrFrom16([16]byte{0xfd, 0x9e, 0x1a, 0x04, 0xf0, 0x1d, 15: 1}), "fd9e:1a04:f01d::1"},
+	{"1:0:0:0:0:0:0:1", AddrFrom16([16]byte{1: 1, 15: 1}), "1::1"},
+	{"1:0:0:1:0:0:0:1", AddrFrom16([16]byte{1: 1, 7: 1, 15: 1}), "1:0:0:1::1"},
+	{"1:0:0:0:1:0:0:1", AddrFrom16([16]byte{1: 1, 9: 1, 15: 1}), "1::1:0:0:1"},
+	{"1:2:3:4:5:6:7:0", AddrFrom16([16]byte{1: 1, 3: 2, 5: 3, 7: 4, 9: 5, 11: 6, 13: 7}), "1:2:3:4:5:6:7:0"},
+	{"::ffff:192.168.140.255", AddrFrom16([16]byte{10: 0xff, 11: 0xff, 12: 192, 13: 168, 14: 140, 15: 255}), ""},
+	{"::ffff:c0a8:8cff", AddrFrom16([16]byte{10: 0xff, 11: 0xff, 12: 192, 13: 168, 14: 140, 15: 255}), "::ffff:192.168.140.255"},
+	{"fe80::1%eth0", AddrFrom16([16]byte{0xfe, 0x80, 15: 1}).WithZone("eth0"), ""},
+	{"fe80::1%eth0%x", AddrFrom16([16]byte{0xfe, 0x80, 15: 1}).WithZone("eth0%x"), ""},
+	{"::%0", IPv6Unspecified().WithZone("0"), ""},
+	{long, MustParseAddr(long[:strings.IndexByte(long, '%')]).WithZone(strings.Repeat("z", 20)), ""},
+}
+
+func TestParseAddr(t *testing.T) {
+	for _, test := range parseAddrTests {
+		got, err := ParseAddr(test.in)
+		if err != nil {
+			t.Errorf("ParseAddr(%q) error: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseAddr(%q) = %#v, want %#v", test.in, got, test.want)
+		}
+		want := test.str
+		if want == "" {
+			want = test.in
+		}
+		if s := got.String(); s != want {
+			t.Errorf("ParseAddr(%q).String() = %q, want %q", test.in, s, want)
+		}
+		if b := got.AppendTo(nil); string(b) != want {
+			t.Errorf("ParseAddr(%q).AppendTo = %q, want %q", test.in, b, want)
+		}
+
+		// Text round-trip.
+		text, err := got.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Addr
+		if err := back.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if back != got {
+			t.Errorf("text round trip of %v = %v", got, back)
+		}
+
+		// Binary round-trip.
+		bin, err := got.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		back = Addr{}
+		if err := back.UnmarshalBinary(bin); err != nil {
+			t.Fatal(err)
+		}
+		if back != got {
+			t.Errorf("binary round trip of %v = %v", got, back)
+		}
+
+		// Agreement with package net, ignoring zones.
+		if got.Zone() == "" {
+			stdIP := net.ParseIP(test.in)
+			if stdIP == nil {
+				t.Errorf("net.ParseIP(%q) = nil", test.in)
+			} else if !stdIP.Equal(net.IP(got.AsSlice())) {
+				t.Errorf("net.ParseIP(%q) = %v, ParseAddr = %v", test.in, stdIP, got)
+			}
+		}
+	}
+}
+
+func TestParseAddrErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"bad",
+		"1.2.3",
+		"1.2.3.4.5",
+		"1.2.3.256",
+		"1.2.3.04",
+		"1..2.3",
+		".1.2.3",
+		"1.2.3.",
+		"1.2.3.4%eth0",
+		"%eth0",
+		"fe80::1%",
+		":::",
+		"1::2::3",
+		"1:2:3:4:5:6:7:8:9",
+		"1:2:3:4:5:6:7",
+		"1:2:3:4::5:6:7:8",
+		"12345::",
+		"::1.2.3",
+		"1:2:3:4:5:6:1.2.3.4.5",
+		"fe80::g",
+		"1:",
+		"1.2.3.4:",
+	} {
+		if got, err := ParseAddr(s); err == nil {
+			t.Errorf("ParseAddr(%q) = %v, want error", s, got)
+		}
+	}
+}
+
+func TestAddrProperties(t *testing.T) {
+	tests := []struct {
+		ip                                   string
+		is4, is6, is4in6                     bool
+		loopback, multicast, private         bool
+		linkLocalUnicast, linkLocalMulticast bool
+		interfaceLocalMulticast              bool
+		globalUnicast, unspecified           bool
+	}{
+		{ip: "0.0.0.0", is4: true, unspecified: true},
+		{ip: "::", is6: true, unspecified: true},
+		{ip: "127.0.0.1", is4: true, loopback: true},
+		{ip: "::1", is6: true, loopback: true},
+		{ip: "10.1.2.3", is4: true, private: true, globalUnicast: true},
+		{ip: "172.16.0.1", is4: true, private: true, globalUnicast: true},
+		{ip: "192.168.1.1", is4: true, private: true, globalUnicast: true},
+		{ip: "fd00::1", is6: true, private: true, globalUnicast: true},
+		{ip: "8.8.8.8", is4: true, globalUnicast: true},
+		{ip: "2001:db8::1", is6: true, globalUnicast: true},
+		{ip: "255.255.255.255", is4: true},
+		{ip: "169.254.1.1", is4: true, linkLocalUnicast: true},
+		{ip: "fe80::1", is6: true, linkLocalUnicast: true},
+		{ip: "224.0.0.1", is4: true, multicast: true, linkLocalMulticast: true},
+		{ip: "239.1.1.1", is4: true, multicast: true},
+		{ip: "ff02::1", is6: true, multicast: true, linkLocalMulticast: true},
+		{ip: "ff01::1", is6: true, multicast: true, interfaceLocalMulticast: true},
+		{ip: "::ffff:1.2.3.4", is6: true, is4in6: true, globalUnicast: true},
+	}
+	for _, tt := range tests {
+		ip := MustParseAddr(tt.ip)
+		check := func(name string, got, want bool) {
+			t.Helper()
+			if got != want {
+				t.Errorf("%s(%v) = %v, want %v", name, ip, got, want)
+			}
+		}
+		check("Is4", ip.Is4(), tt.is4)
+		check("Is6", ip.Is6(), tt.is6)
+		check("Is4In6", ip.Is4In6(), tt.is4in6)
+		check("IsLoopback", ip.IsLoopback(), tt.loopback)
+		check("IsMulticast", ip.IsMulticast(), tt.multicast)
+		check("IsPrivate", ip.IsPrivate(), tt.private)
+		check("IsLinkLocalUnicast", ip.IsLinkLocalUnicast(), tt.linkLocalUnicast)
+		check("IsLinkLocalMulticast", ip.IsLinkLocalMulticast(), tt.linkLocalMulticast)
+		check("IsInterfaceLocalMulticast", ip.IsInterfaceLocalMulticast(), tt.interfaceLocalMulticast)
+		check("IsGlobalUnicast", ip.IsGlobalUnicast(), tt.globalUnicast)
+		check("IsUnspecified", ip.IsUnspecified(), tt.unspecified)
+
+		if std := net.ParseIP(tt.ip); std != nil && !ip.Is4In6() {
+			check("net.IP.IsGlobalUnicast agreement", ip.IsGlobalUnicast(), std.IsGlobalUnicast())
+			check("net.IP.IsLoopback agreement", ip.IsLoopback(), std.IsLoopback())
+			check("net.IP.IsMulticast agreement", ip.IsMulticast(), std.IsMulticast())
+		}
+	}
+
+	var zero Addr
+	if zero.IsValid() || zero.Is4() || zero.Is6() || zero.IsGlobalUnicast() || zero.IsUnspecified() {
+		t.Error("zero Addr reports a property")
+	}
+	if got := zero.String(); got != "invalid IP" {
+		t.Errorf("zero Addr String = %q", got)
+	}
+	if got := zero.BitLen(); got != 0 {
+		t.Errorf("zero Addr BitLen = %d", got)
+	}
+}
+
+func TestAddrComparable(t *testing.T) {
+	a := MustParseAddr("fe80::1%eth0")
+	b := MustParseAddr("fe80::1%eth0")
+	c := MustParseAddr("fe80::1%eth1")
+	if a != b {
+		t.Error("equal addresses compare unequal")
+	}
+	if a == c {
+		t.Error("addresses with different zones compare equal")
+	}
+	m := map[Addr]int{a: 1}
+	if m[b] != 1 {
+		t.Error("map lookup with equal address failed")
+	}
+	if MustParseAddr("1.2.3.4") == MustParseAddr("::ffff:1.2.3.4") {
+		t.Error("IPv4 and IPv4-mapped IPv6 addresses compare equal")
+	}
+	if MustParseAddr("::ffff:1.2.3.4").Unmap() != MustParseAddr("1.2.3.4") {
+		t.Error("Unmap did not produce the IPv4 address")
+	}
+}
+
+func TestAddrLessCompare(t *testing.T) {
+	values := []Addr{
+		MustParseAddr("::1"),
+		{},
+		MustParseAddr("fe80::1%b"),
+		MustParseAddr("1.2.3.4"),
+		MustParseAddr("fe80::1"),
+		MustParseAddr("fe80::1%a"),
+		MustParseAddr("1.2.3.5"),
+	}
+	want := []string{
+		"invalid IP",
+		"1.2.3.4",
+		"1.2.3.5",
+		"::1",
+		"fe80::1",
+		"fe80::1%a",
+		"fe80::1%b",
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Less(values[j]) })
+	var got []string
+	for _, v := range values {
+		got = append(got, v.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %q, want %q", got, want)
+	}
+	for i := range values {
+		for j := range values {
+			c := values[i].Compare(values[j])
+			if (c == 0) != (i == j) || (c < 0) != (i < j) {
+				t.Errorf("Compare(%v, %v) = %d", values[i], values[j], c)
+			}
+		}
+	}
+}
+
+func TestAddrNextPrev(t *testing.T) {
+	tests := []struct {
+		ip, next string
+	}{
+		{"0.0.0.0", "0.0.0.1"},
+		{"1.2.3.255", "1.2.4.0"},
+		{"::", "::1"},
+		{"::ffff", "::1:0"},
+		{"fe80::ffff:ffff:ffff:ffff%eth0", "fe80:0:0:1::%eth0"},
+	}
+	for _, tt := range tests {
+		ip, next := MustParseAddr(tt.ip), MustParseAddr(tt.next)
+		if got := ip.Next(); got != next {
+			t.Errorf("%v.Next() = %v, want %v", ip, got, next)
+		}
+		if got := next.Prev(); got != ip {
+			t.Errorf("%v.Prev() = %v, want %v", next, got, ip)
+		}
+	}
+	if got := MustParseAddr("255.255.255.255").Next(); got.IsValid() {
+		t.Errorf("Next of max IPv4 = %v, want zero Addr", got)
+	}
+	if got := MustParseAddr("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff").Next(); got.IsValid() {
+		t.Errorf("Next of max IPv6 = %v, want zero Addr", got)
+	}
+	if got := MustParseAddr("0.0.0.0").Prev(); got.IsValid() {
+		t.Errorf("Prev of 0.0.0.0 = %v, want zero Addr", got)
+	}
+	if got := MustParseAddr("::").Prev(); got.IsValid() {
+		t.Errorf("Prev of :: = %v, want zero Addr", got)
+	}
+}
+
+func TestAddrConversions(t *testing.T) {
+	v4 := MustParseAddr("1.2.3.4")
+	if got := v4.As4(); got != [4]byte{1, 2, 3, 4} {
+		t.Errorf("As4 = %v", got)
+	}
+	if got := v4.As16(); got != [16]byte{10: 0xff, 11: 0xff, 12: 1, 13: 2, 14: 3, 15: 4} {
+		t.Errorf("As16 = %v", got)
+	}
+	if got := v4.AsSlice(); !bytes.Equal(got, []byte{1, 2, 3, 4}) {
+		t.Errorf("AsSlice = %v", got)
+	}
+	if got, ok := AddrFromSlice(net.ParseIP("1.2.3.4")); !ok || got.Unmap() != v4 {
+		t.Errorf("AddrFromSlice(net.ParseIP) = %v, %v", got, ok)
+	}
+	if got, ok := AddrFromSlice(net.IPv4(1, 2, 3, 4).To4()); !ok || got != v4 {
+		t.Errorf("AddrFromSlice(To4) = %v, %v", got, ok)
+	}
+	if _, ok := AddrFromSlice([]byte{1, 2, 3}); ok {
+		t.Error("AddrFromSlice of 3 bytes succeeded")
+	}
+	if got := MustParseAddr("2001:db8::1").StringExpanded(); got != "2001:0db8:0000:0000:0000:0000:0000:0001" {
+		t.Errorf("StringExpanded = %q", got)
+	}
+	if got := IPv6LinkLocalAllNodes().String(); got != "ff02::1" {
+		t.Errorf("IPv6LinkLocalAllNodes = %q", got)
+	}
+	if got := v4.WithZone("eth0"); got != v4 {
+		t.Errorf("WithZone on IPv4 = %v", got)
+	}
+	if got := MustParseAddr("fe80::1%eth0").WithZone(""); got != MustParseAddr("fe80::1") {
+		t.Errorf("WithZone(\"\") = %v", got)
+	}
+}
+
+func TestAddrJSON(t *testing.T) {
+	type T struct {
+		A  Addr
+		AP AddrPort
+		P  Prefix
+	}
+	in := T{
+		A:  MustParseAddr("fe80::1%eth0"),
+		AP: MustParseAddrPort("[::1]:80"),
+		P:  MustParsePrefix("10.0.0.0/8"),
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"A":"fe80::1%eth0","AP":"[::1]:80","P":"10.0.0.0/8"}`
+	if string(b) != want {
+		t.Errorf("json.Marshal = %s, want %s", b, want)
+	}
+	var out T
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("json round trip = %+v, want %+v", out, in)
+	}
+
+	b, err = json.Marshal(T{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"A":"","AP":"","P":""}` {
+		t.Errorf("json.Marshal of zero values = %s", b)
+	}
+}
+
+func TestParseAddrPort(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AddrPort
+		wantErr bool
+	}{
+		{in: "1.2.3.4:1234", want: AddrPortFrom(AddrFrom4([4]byte{1, 2, 3, 4}), 1234)},
+		{in: "1.1.1.1:123456", wantErr: true},
+		{in: "1.1.1.1:-123", wantErr: true},
+		{in: "[::1]:1234", want: AddrPortFrom(MustParseAddr("::1"), 1234)},
+		{in: "[1.2.3.4]:1234", wantErr: true},
+		{in: "fe80::1:1234", wantErr: true},
+		{in: ":0", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+		{in: "[fe80::1%eth0]:1234", want: AddrPortFrom(MustParseAddr("fe80::1%eth0"), 1234)},
+		{in: "[::ffff:1.2.3.4]:80", want: AddrPortFrom(MustParseAddr("::ffff:1.2.3.4"), 80)},
+	}
+	for _, test := range tests {
+		got, err := ParseAddrPort(test.in)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("ParseAddrPort(%q) error: %v", test.in, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("ParseAddrPort(%q) = %v, want error", test.in, got)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseAddrPort(%q) = %v, want %v", test.in, got, test.want)
+		}
+		if s := got.String(); s != test.in {
+			t.Errorf("ParseAddrPort(%q).String() = %q", test.in, s)
+		}
+		bin, _ := got.MarshalBinary()
+		var back AddrPort
+		if err := back.UnmarshalBinary(bin); err != nil || back != got {
+			t.Errorf("binary round trip of %v = %v, %v", got, back, err)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		prefix      string
+		ip          Addr
+		bits        int
+		str         string
+		contains    []Addr
+		notContains []Addr
+	}{
+		{
+			prefix:      "192.168.0.0/24",
+			ip:          MustParseAddr("192.168.0.0"),
+			bits:        24,
+			contains:    []Addr{MustParseAddr("192.168.0.1"), MustParseAddr("192.168.0.255")},
+			notContains: []Addr{MustParseAddr("192.168.1.1"), MustParseAddr("1.1.1.1"), MustParseAddr("::ffff:192.168.0.1"), {}},
+		},
+		{
+			prefix:      "192.168.1.1/24",
+			ip:          MustParseAddr("192.168.1.1"),
+			bits:        24,
+			contains:    []Addr{MustParseAddr("192.168.1.0")},
+			notContains: []Addr{MustParseAddr("192.168.0.1")},
+		},
+		{
+			prefix:      "0.0.0.0/0",
+			ip:          MustParseAddr("0.0.0.0"),
+			bits:        0,
+			contains:    []Addr{MustParseAddr("192.168.0.1"), MustParseAddr("1.1.1.1")},
+			notContains: []Addr{MustParseAddr("2001:db8::1")},
+		},
+		{
+			prefix:      "2001:db8::/32",
+			ip:          MustParseAddr("2001:db8::"),
+			bits:        32,
+			contains:    []Addr{MustParseAddr("2001:db8::1"), MustParseAddr("2001:db8:ffff::1")},
+			notContains: []Addr{MustParseAddr("2001:db9::1"), MustParseAddr("2001:db8::1%eth0"), MustParseAddr("1.2.3.4")},
+		},
+		{
+			prefix:      "::ffff:1.2.3.4/128",
+			ip:          MustParseAddr("::ffff:1.2.3.4"),
+			bits:        128,
+			contains:    []Addr{MustParseAddr("::ffff:1.2.3.4")},
+			notContains: []Addr{MustParseAddr("1.2.3.4")},
+		},
+	}
+	for _, test := range tests {
+		p, err := ParsePrefix(test.prefix)
+		if err != nil {
+			t.Errorf("ParsePrefix(%q) error: %v", test.prefix, err)
+			continue
+		}
+		if p.Addr() != test.ip {
+			t.Errorf("ParsePrefix(%q).Addr() = %v, want %v", test.prefix, p.Addr(), test.ip)
+		}
+		if p.Bits() != test.bits {
+			t.Errorf("ParsePrefix(%q).Bits() = %d, want %d", test.prefix, p.Bits(), test.bits)
+		}
+		if s := p.String(); s != test.prefix {
+			t.Errorf("ParsePrefix(%q).String() = %q", test.prefix, s)
+		}
+		for _, ip := range test.contains {
+			if !p.Contains(ip) {
+				t.Errorf("%v.Contains(%v) = false, want true", p, ip)
+			}
+		}
+		for _, ip := range test.notContains {
+			if p.Contains(ip) {
+				t.Errorf("%v.Contains(%v) = true, want false", p, ip)
+			}
+		}
+		text, _ := p.MarshalText()
+		var back Prefix
+		if err := back.UnmarshalText(text); err != nil || back != p {
+			t.Errorf("text round trip of %v = %v, %v", p, back, err)
+		}
+		bin, _ := p.MarshalBinary()
+		back = Prefix{}
+		if err := back.UnmarshalBinary(bin); err != nil || back != p {
+			t.Errorf("binary round trip of %v = %v, %v", p, back, err)
+		}
+	}
+}
+
+func TestParsePrefixErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1.2.3.4",
+		"1.2.3.4/",
+		"1.2.3.4/33",
+		"1.2.3.4/-1",
+		"1.2.3.4/+8",
+		"1.2.3.4/x",
+		"2001:db8::/129",
+		"fe80::%eth0/64",
+		"bad/8",
+	} {
+		if p, err := ParsePrefix(s); err == nil {
+			t.Errorf("ParsePrefix(%q) = %v, want error", s, p)
+		}
+	}
+}
+
+func TestPrefixMaskedOverlaps(t *testing.T) {
+	if got, want := MustParsePrefix("192.168.1.123/24").Masked(), MustParsePrefix("192.168.1.0/24"); got != want {
+		t.Errorf("Masked = %v, want %v", got, want)
+	}
+	if got, want := MustParsePrefix("2001:db8::1/32").Masked(), MustParsePrefix("2001:db8::/32"); got != want {
+		t.Errorf("Masked = %v, want %v", got, want)
+	}
+	if got := (Prefix{}).Masked(); got != (Prefix{}) {
+		t.Errorf("zero Prefix Masked = %v", got)
+	}
+	if p := PrefixFrom(MustParseAddr("1.2.3.4"), 33); p.IsValid() || p.Bits() != -1 {
+		t.Errorf("PrefixFrom with 33 bits = %v valid=%v", p, p.IsValid())
+	}
+	if p := PrefixFrom(MustParseAddr("fe80::1%eth0"), 64); p.Addr().Zone() != "" {
+		t.Errorf("PrefixFrom kept zone: %v", p)
+	}
+	if !MustParsePrefix("1.2.3.4/32").IsSingleIP() || MustParsePrefix("1.2.3.0/24").IsSingleIP() {
+		t.Error("IsSingleIP mismatch")
+	}
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.0.0.0/8", "10.1.0.0/16", true},
+		{"10.1.0.0/16", "10.0.0.0/8", true},
+		{"10.0.0.0/16", "10.1.0.0/16", false},
+		{"0.0.0.0/0", "1.2.3.4/32", true},
+		{"2001:db8::/32", "2001:db8:1::/48", true},
+		{"2001:db8::/32", "10.0.0.0/8", false},
+		{"::/0", "0.0.0.0/0", false},
+	}
+	for _, tt := range tests {
+		a, b := MustParsePrefix(tt.a), MustParsePrefix(tt.b)
+		if got := a.Overlaps(b); got != tt.want {
+			t.Errorf("%v.Overlaps(%v) = %v, want %v", a, b, got, tt.want)
+		}
+	}
+}
+
+func TestAddrPrefix(t *testing.T) {
+	ip := MustParseAddr("1.2.3.4")
+	p, err := ip.Prefix(16)
+	if err != nil || p != MustParsePrefix("1.2.0.0/16") {
+		t.Errorf("Prefix(16) = %v, %v", p, err)
+	}
+	if _, err := ip.Prefix(33); err == nil {
+		t.Error("Prefix(33) on IPv4 succeeded")
+	}
+	if _, err := ip.Prefix(-1); err == nil {
+		t.Error("Prefix(-1) succeeded")
+	}
+	if p, err := (Addr{}).Prefix(8); err != nil || p != (Prefix{}) {
+		t.Errorf("zero Addr Prefix = %v, %v", p, err)
+	}
+}
+
+var sink interface{}
+
+func TestNoAllocs(t *testing.T) {
+	test := func(name string, f func()) {
+		t.Helper()
+		if n := testing.AllocsPerRun(1000, f); n != 0 {
+			t.Errorf("%s: got %v allocs, want 0", name, n)
+		}
+	}
+	test("ParseAddr/4", func() { sinkAddr = MustParseAddr("1.2.3.4") })
+	test("ParseAddr/6", func() { sinkAddr = MustParseAddr("::1") })
+	test("ParseAddr/6zone", func() { sinkAddr = MustParseAddr("fe80::1%eth0") })
+	test("AddrFrom16", func() { sinkAddr = AddrFrom16([16]byte{15: 1}) })
+	test("ParseAddrPort", func() { sinkAddrPort = MustParseAddrPort("[::1]:1234") })
+	test("ParsePrefix", func() { sinkPrefix = MustParsePrefix("10.0.0.0/8") })
+	test("Prefix.Contains", func() { sinkBool = MustParsePrefix("10.0.0.0/8").Contains(MustParseAddr("10.1.2.3")) })
+	test("Addr.Compare", func() { sinkInt = MustParseAddr("::1").Compare(MustParseAddr("::2")) })
+}
+
+var (
+	sinkAddr     Addr
+	sinkAddrPort AddrPort
+	sinkPrefix   Prefix
+	sinkBool     bool
+	sinkInt      int
+)
+
+func BenchmarkParseAddr(b *testing.B) {
+	for _, s := range []string{"192.168.1.1", "2001:db8::1", "fe80::1%eth0"} {
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sinkAddr, _ = ParseAddr(s)
+			}
+		})
+	}
+}
+
+func BenchmarkAddrString(b *testing.B) {
+	for _, s := range []string{"192.168.1.1", "2001:db8::1", "fe80::1%eth0"} {
+		ip := MustParseAddr(s)
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sink = ip.String()
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"internal/testenv"
 	"io"
+	"net/netip"
 	"os"
 	"reflect"
 	"runtime"
@@ -823,3 +824,19 @@ func BenchmarkSetReadDeadline(b *testing.B) {
 		deadline = deadline.Add(1)
 	}
 }
+
+func TestTCPAddrPortConversion(t *testing.T) {
+	if got := (*TCPAddr)(nil).AddrPort(); got.IsValid() {
+		t.Errorf("nil TCPAddr AddrPort = %v, want invalid", got)
+	}
+	for _, s := range []string{"192.0.2.1:80", "[2001:db8::1]:443", "[fe80::1%eth0]:22"} {
+		ap := netip.MustParseAddrPort(s)
+		a := TCPAddrFromAddrPort(ap)
+		if a.String() != s {
+			t.Errorf("TCPAddrFromAddrPort(%v) = %v", ap, a)
+		}
+		if got := a.AddrPort(); got != ap {
+			t.Errorf("%v.AddrPort() = %v, want %v", a, got, ap)
+		}
+	}
+}
@@ -8,6 +8,7 @@ package net
 
 import (
 	"internal/testenv"
+	"net/netip"
 	"reflect"
 	"runtime"
 	"testing"
@@ -444,3 +445,114 @@ func TestUDPReadSizeError(t *testing.T) {
 		}
 	}
 }
+
+func TestUDPAddrPortConversion(t *testing.T) {
+	tests := []struct {
+		addr *UDPAddr
+		want netip.AddrPort
+	}{
+		{nil, netip.AddrPort{}},
+		{&UDPAddr{IP: IPv4(192, 0, 2, 1).To4(), Port: 53}, netip.MustParseAddrPort("192.0.2.1:53")},
+		{&UDPAddr{IP: ParseIP("2001:db8::1"), Port: 443}, netip.MustParseAddrPort("[2001:db8::1]:443")},
+		{&UDPAddr{IP: ParseIP("fe80::1"), Port: 1, Zone: "eth0"}, netip.MustParseAddrPort("[fe80::1%eth0]:1")},
+	}
+	for _, tt := range tests {
+		if got := tt.addr.AddrPort(); got != tt.want {
+			t.Errorf("%v.AddrPort() = %v, want %v", tt.addr, got, tt.want)
+		}
+		if tt.addr == nil {
+			continue
+		}
+		if got := UDPAddrFromAddrPort(tt.want); !reflect.DeepEqual(got, tt.addr) {
+			t.Errorf("UDPAddrFromAddrPort(%v) = %#v, want %#v", tt.want, got, tt.addr)
+		}
+	}
+	if got := UDPAddrFromAddrPort(netip.AddrPort{}); got.IP != nil {
+		t.Errorf("UDPAddrFromAddrPort of invalid AddrPort = %v, want nil IP", got)
+	}
+}
+
+func TestUDPAddrPortReadWrite(t *testing.T) {
+	switch runtime.GOOS {
+	case "plan9":
+		t.Skipf("not supported on %s", runtime.GOOS)
+	}
+
+	for _, network := range []string{"udp4", "udp6"} {
+		if network == "udp4" && !supportsIPv4() || network == "udp6" && !supportsIPv6() {
+			continue
+		}
+		ln, err := newLocalPacketListener(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		c1 := ln.(*UDPConn)
+		c2, err := newLocalPacketListener(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Close()
+
+		dst := c1.LocalAddr().(*UDPAddr).AddrPort()
+		src := c2.LocalAddr().(*UDPAddr).AddrPort()
+		b := []byte("ADDRPORT")
+		if _, err := c2.(*UDPConn).WriteToUDPAddrPort(b, dst); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c2.(*UDPConn).WriteMsgUDPAddrPort(b, nil, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 64)
+		n, from, err := c1.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != string(b) || from != src {
+			t.Errorf("%s: ReadFromUDPAddrPort = %q, %v; want %q, %v", network, buf[:n], from, b, src)
+		}
+		n, _, _, from, err = c1.ReadMsgUDPAddrPort(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != string(b) || from != src {
+			t.Errorf("%s: ReadMsgUDPAddrPort = %q, %v; want %q, %v", network, buf[:n], from, b, src)
+		}
+
+		_, err = c2.(*UDPConn).WriteToUDPAddrPort(b, netip.AddrPort{})
+		if err == nil || err.(*OpError).Err != errMissingAddress {
+			t.Errorf("WriteToUDPAddrPort with invalid address: %v, want errMissingAddress", err)
+		}
+	}
+}
+
+func TestUDPAddrPortAllocs(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "netbsd", "openbsd", "dragonfly":
+	default:
+		t.Skipf("not allocation-free on %s", runtime.GOOS)
+	}
+	conn, err := ListenUDP("udp4", &UDPAddr{IP: IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr := conn.LocalAddr().(*UDPAddr).AddrPort()
+	buf := make([]byte, 8)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		_, err := conn.WriteToUDPAddrPort(buf, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("got %v allocs, want 0", allocs)
+	}
+}
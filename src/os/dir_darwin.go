@@ -28,7 +28,7 @@ func (f *File) readdirnames(n int) (names []string, err error) {
 	if f.dirinfo == nil {
 		dir, call, errno := f.pfd.OpenDir()
 		if errno != nil {
-			return nil, &PathError{call, f.name, errno}
+			return nil, &PathError{Op: call, Path: f.name, Err: errno}
 		}
 		f.dirinfo = &dirInfo{
 			dir: dir,
@@ -50,7 +50,7 @@ func (f *File) readdirnames(n int) (names []string, err error) {
 			if errno == syscall.EINTR {
 				continue
 			}
-			return names, &PathError{"readdir", f.name, errno}
+			return names, &PathError{Op: "readdir", Path: f.name, Err: errno}
 		}
 		if entptr == nil { // EOF
 			break
@@ -23,6 +23,7 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -366,6 +367,50 @@ func TestReaddir(t *testing.T) {
 	testReaddir(sysdir.name, sysdir.files, t)
 }
 
+func TestReadDir(t *testing.T) {
+	dirs, err := ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir(.): %v", err)
+	}
+	if !sort.SliceIsSorted(dirs, func(i, j int) bool { return dirs[i].Name() < dirs[j].Name() }) {
+		t.Error("ReadDir(.) returned unsorted entries")
+	}
+	for _, m := range dot {
+		found := false
+		for _, d := range dirs {
+			if equal(m, d.Name()) {
+				if found {
+					t.Error("present twice:", m)
+				}
+				found = true
+				if d.IsDir() {
+					t.Errorf("%s: IsDir() = true, want false", m)
+				}
+			}
+		}
+		if !found {
+			t.Error("could not find", m)
+		}
+	}
+
+	if _, err := ReadDir("nonexistent-dir"); !IsNotExist(err) {
+		t.Errorf("ReadDir(nonexistent-dir) = %v, want not-exist error", err)
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	if err := fstest.TestFS(DirFS("./testdata/dirfs"), "a", "b", "dir/x"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that Open does not accept backslash as separator.
+	d := DirFS(".")
+	_, err := d.Open(`testdata\dirfs`)
+	if err == nil {
+		t.Fatalf(`Open testdata\dirfs succeeded`)
+	}
+}
+
 func benchmarkReaddirname(path string, b *testing.B) {
 	var nentries int
 	for i := 0; i < b.N; i++ {
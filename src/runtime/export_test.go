@@ -376,6 +376,32 @@ func ReadMemStatsSlow() (base, slow MemStats) {
 	return
 }
 
+// ReadMetricsSlow populates memStats and the metric samples at
+// samplesp with the world stopped, so the two are consistent.
+func ReadMetricsSlow(memStats *MemStats, samplesp unsafe.Pointer, len, cap int) {
+	stopTheWorld("ReadMetricsSlow")
+
+	// Initialize the metrics beforehand because this could
+	// allocate and skew the stats.
+	semacquire(&metricsSema)
+	initMetrics()
+	semrelease(&metricsSema)
+
+	systemstack(func() {
+		// Read memstats first. It's going to flush
+		// the mcaches which readMetrics does not do, so
+		// going the other way around may result in
+		// inconsistent statistics.
+		readmemstats_m(memStats)
+	})
+
+	// Read metrics off the system stack. The caller must make
+	// sure no histograms need to be allocated here.
+	readMetrics(samplesp, len, cap)
+
+	startTheWorld()
+}
+
 // BlockOnSystemStack switches to the system stack, prints "x\n" to
 // stderr, and blocks in a stack containing
 // "runtime.blockOnSystemStackInternal".
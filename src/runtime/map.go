@@ -54,7 +54,6 @@ package runtime
 // before the table grows. Typical tables will be somewhat less loaded.
 
 import (
-	"runtime"
 	"runtime/internal/atomic"
 	"runtime/internal/math"
 	"runtime/internal/sys"
@@ -484,7 +483,7 @@ func mapaccess1(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	//在 bucketloop 循环中，哈希会依次遍历正常桶和溢出桶中的数据，它会比较这 8 位数字和桶中存储的 tophash，
 	//每一个桶都存储键对应的 tophash，每一次读写操作都会与桶中所有的 tophash 进行比较
 bucketloop:
-	for ; b != nil; b = b.overflow(t)
+	for ; b != nil; b = b.overflow(t) {
 		for i := uintptr(0); i < bucketCnt; i++ {
 			//比较这 8 位数字和桶中存储的 tophash
 			if b.tophash[i] != top {
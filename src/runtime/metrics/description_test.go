@@ -0,0 +1,77 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics_test
+
+import (
+	"go/parser"
+	"go/token"
+	"regexp"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDescriptionNameFormat(t *testing.T) {
+	r := regexp.MustCompile("^(?P<name>/[^:]+):(?P<unit>[^:*/]+(?:[*/][^:*/]+)*)$")
+	descriptions := metrics.All()
+	for _, desc := range descriptions {
+		if !r.MatchString(desc.Name) {
+			t.Errorf("metrics %q does not match regexp %s", desc.Name, r)
+		}
+	}
+}
+
+func TestDescriptionSorted(t *testing.T) {
+	descriptions := metrics.All()
+	if !sort.SliceIsSorted(descriptions, func(i, j int) bool {
+		return descriptions[i].Name < descriptions[j].Name
+	}) {
+		t.Error("metrics.All is not sorted by name")
+	}
+}
+
+// extractMetricDocs returns the name and whitespace-normalized
+// description of each metric listed in the package documentation.
+func extractMetricDocs(t *testing.T) map[string]string {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "doc.go", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parsing doc.go: %v", err)
+	}
+	const header = "Below is the full list of supported metrics, ordered lexicographically."
+	text := f.Doc.Text()
+	i := strings.Index(text, header)
+	if i < 0 {
+		t.Fatal("doc.go: missing list of supported metrics")
+	}
+	docs := make(map[string]string)
+	for _, entry := range strings.Split(text[i+len(header):], "\n\n") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		docs[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	return docs
+}
+
+func TestDescriptionDocs(t *testing.T) {
+	docs := extractMetricDocs(t)
+	for _, desc := range metrics.All() {
+		doc, ok := docs[desc.Name]
+		if !ok {
+			t.Errorf("metric %s is missing from doc.go", desc.Name)
+			continue
+		}
+		delete(docs, desc.Name)
+		if want := strings.Join(strings.Fields(desc.Description), " "); doc != want {
+			t.Errorf("doc.go description of %s does not match:\nhave %q\nwant %q", desc.Name, doc, want)
+		}
+	}
+	for name := range docs {
+		t.Errorf("doc.go lists unknown metric %s", name)
+	}
+}
@@ -109,6 +109,20 @@ type mheap struct {
 	// to the OS.
 	scavengeGoal uint64
 
+	// Malloc stats.
+	//
+	// The allocation counts are updated atomically, and the free
+	// counts only when the mcaches are flushed, with the heap
+	// locked or the world stopped. runtime/metrics may then read
+	// them without stopping the world. They are kept among the
+	// other uint64 fields so that they are 8-byte aligned on
+	// 32-bit systems.
+	largealloc  uint64                  // bytes allocated for large objects
+	nlargealloc uint64                  // number of large object allocations
+	largefree   uint64                  // bytes freed for large objects (>maxsmallsize)
+	nlargefree  uint64                  // number of frees for large objects (>maxsmallsize)
+	nsmallfree  [_NumSizeClasses]uint64 // number of frees for small objects (<=maxsmallsize)
+
 	// Page reclaimer state
 
 	// reclaimIndex is the page index in allArenas of next page to
@@ -128,13 +142,6 @@ type mheap struct {
 	// This is accessed atomically.
 	reclaimCredit uintptr
 
-	// Malloc stats.
-	largealloc  uint64                  // bytes allocated for large objects
-	nlargealloc uint64                  // number of large object allocations
-	largefree   uint64                  // bytes freed for large objects (>maxsmallsize)
-	nlargefree  uint64                  // number of frees for large objects (>maxsmallsize)
-	nsmallfree  [_NumSizeClasses]uint64 // number of frees for small objects (<=maxsmallsize)
-
 	// arenas is the heap arena map. It points to the metadata for
 	// the heap for every arena frame of the entire usable virtual
 	// address space.
@@ -1175,8 +1182,8 @@ func (h *mheap) allocSpan(npages uintptr, manual bool, spanclass spanClass, sysS
 
 		// Do some additional accounting if it's a large allocation.
 		if spanclass.sizeclass() == 0 {
-			mheap_.largealloc += uint64(npages * pageSize)
-			mheap_.nlargealloc++
+			atomic.Xadd64(&mheap_.largealloc, int64(npages*pageSize))
+			atomic.Xadd64(&mheap_.nlargealloc, 1)
 			atomic.Xadd64(&memstats.heap_live, int64(npages*pageSize))
 		}
 
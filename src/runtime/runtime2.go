@@ -504,8 +504,19 @@ type g struct {
 	// and check for debt in the malloc hot path. The assist ratio
 	// determines how this corresponds to scan work debt.
 	gcAssistBytes int64
+
+	// Scheduling latency tracking. Only every gTrackingPeriod'th
+	// G is tracked, to keep the cost of nanotime off the common path.
+	tracking      bool  // whether we're tracking this G for sched latency statistics
+	trackingSeq   uint8 // used to decide whether to track this G
+	runnableStamp int64 // timestamp of when the G last became runnable, only used when tracking
+	runnableTime  int64 // the amount of time spent runnable, cleared when running, only used when tracking
 }
 
+// gTrackingPeriod is the number of transitions out of _Grunning between
+// latency tracking runs.
+const gTrackingPeriod = 8
+
 type m struct {
 	g0      *g     // goroutine with scheduling stack
 	morebuf gobuf  // gobuf arg to morestack
@@ -722,6 +733,12 @@ type schedt struct {
 	lastpoll  uint64 // time of last network poll, 0 if currently polling
 	pollUntil uint64 // time to which current poll is sleeping
 
+	// timeToRun is a distribution of scheduling latencies, defined
+	// as the sum of time a G spends in the _Grunnable state before
+	// it transitions to _Grunning. It is updated atomically and,
+	// being made of uint64s, also kept near the top for alignment.
+	timeToRun timeHistogram
+
 	lock mutex
 
 	// When increasing nmidle, nmidlelocked, nmsys, or nmfreed, be
@@ -0,0 +1,56 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fstest
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// brokenFS wraps a MapFS but reports an extra entry
+// in the root directory listing that cannot be opened.
+type brokenFS struct {
+	MapFS
+}
+
+type brokenDir struct {
+	fs.ReadDirFile
+	done bool
+}
+
+func (fsys brokenFS) Open(name string) (fs.File, error) {
+	f, err := fsys.MapFS.Open(name)
+	if err != nil || name != "." {
+		return f, err
+	}
+	return &brokenDir{ReadDirFile: f.(fs.ReadDirFile)}, nil
+}
+
+func (d *brokenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	list, err := d.ReadDirFile.ReadDir(n)
+	if !d.done && (err == nil || err == io.EOF) && (n <= 0 || len(list) < n) {
+		d.done = true
+		list = append(list, &mapFileInfo{"ghost", &MapFile{}})
+		err = nil
+	}
+	return list, err
+}
+
+func TestTestFSReportsErrors(t *testing.T) {
+	fsys := brokenFS{MapFS{"hello": {Data: []byte("hello")}}}
+	err := TestFS(fsys, "hello")
+	if err == nil {
+		t.Fatal("TestFS succeeded on broken file system, want error")
+	}
+	if !strings.Contains(err.Error(), "ghost") {
+		t.Errorf("TestFS error does not mention bad entry:\n%v", err)
+	}
+
+	if err := TestFS(MapFS{"hello": {}}, "missing"); err == nil || !strings.Contains(err.Error(), "expected but not found: missing") {
+		t.Errorf("TestFS with missing file = %v, want error about missing file", err)
+	}
+}
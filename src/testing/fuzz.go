@@ -0,0 +1,498 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+func initFuzzFlags() {
+	matchFuzz = flag.String("test.fuzz", "", "run the fuzz target matching `regexp`")
+	flag.Var(&fuzzDuration, "test.fuzztime", "time to spend fuzzing; default is to run indefinitely")
+	flag.Var(&minimizeDuration, "test.fuzzminimizetime", "time to spend minimizing a value after finding a crash")
+	fuzzCacheDir = flag.String("test.fuzzcachedir", "", "directory where interesting fuzzing inputs are stored")
+	isFuzzWorker = flag.Bool("test.fuzzworker", false, "coordinate with the parent process to fuzz random values")
+}
+
+var (
+	matchFuzz        *string
+	fuzzDuration     benchTimeFlag
+	minimizeDuration = benchTimeFlag{d: 60 * time.Second}
+	fuzzCacheDir     *string
+	isFuzzWorker     *bool
+
+	// corpusDir is the parent directory of the fuzz targets' seed corpora
+	// within the package.
+	corpusDir = "testdata/fuzz"
+)
+
+// InternalFuzzTarget is an internal type but exported because it is
+// cross-package; it is part of the implementation of the "go test" command.
+type InternalFuzzTarget struct {
+	Name string
+	Fn   func(f *F)
+}
+
+// F is a type passed to fuzz targets.
+//
+// A fuzz target may add seed corpus entries using F.Add or by storing files
+// in the testdata/fuzz/<FuzzTargetName> directory. The fuzz target must then
+// call F.Fuzz once to provide a fuzz function.
+//
+// *F methods can only be called before (*F).Fuzz. Once inside the function
+// passed to (*F).Fuzz, only (*T) methods can be used.
+type F struct {
+	common
+	fuzzContext *fuzzContext
+	testContext *testContext
+
+	// inFuzzFn is true when the fuzz function is running. Most F methods
+	// cannot be called when inFuzzFn is true.
+	inFuzzFn bool
+
+	// corpus is a set of seed corpus entries, added with F.Add and loaded
+	// from testdata.
+	corpus []corpusEntry
+
+	fuzzCalled bool
+}
+
+var _ TB = (*F)(nil)
+
+// corpusEntry is an alias to the same type as internal/fuzz.CorpusEntry.
+// We use a type alias because we don't want to export this type, and we can't
+// import internal/fuzz from testing.
+type corpusEntry = struct {
+	Name   string
+	Path   string
+	Data   []byte
+	Values []interface{}
+	IsSeed bool
+}
+
+// Helper marks the calling function as a test helper function.
+// When printing file and line information, that function will be skipped.
+// Helper may be called simultaneously from multiple goroutines.
+func (f *F) Helper() {
+	if f.inFuzzFn {
+		panic("testing: f.Helper was called inside the f.Fuzz function, use t.Helper instead")
+	}
+
+	// common.Helper is inlined here.
+	// If we called it, it would mark F.Helper as the helper
+	// instead of the caller.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.helpers == nil {
+		f.helpers = make(map[string]struct{})
+	}
+	f.helpers[callerName(1)] = struct{}{}
+}
+
+// Fail marks the function as having failed but continues execution.
+func (f *F) Fail() {
+	// (*F).Fail may be called by (*T).Fail, which we should allow. However, we
+	// shouldn't allow direct (*F).Fail calls from inside the (*F).Fuzz function.
+	if f.inFuzzFn {
+		panic("testing: f.Fail was called inside the f.Fuzz function, use t.Fail instead")
+	}
+	f.common.Fail()
+}
+
+// Skipped reports whether the test was skipped.
+func (f *F) Skipped() bool {
+	if f.inFuzzFn {
+		panic("testing: f.Skipped was called inside the f.Fuzz function, use t.Skipped instead")
+	}
+	return f.common.Skipped()
+}
+
+// Add will add the arguments to the seed corpus for the fuzz target. This will
+// be a no-op if called after or within the Fuzz function. The args must match
+// those in the Fuzz function.
+func (f *F) Add(args ...interface{}) {
+	if f.fuzzCalled {
+		return
+	}
+	var values []interface{}
+	for i := range args {
+		if t := reflect.TypeOf(args[i]); !supportedTypes[t] {
+			panic(fmt.Sprintf("testing: unsupported type to Add %v", t))
+		}
+		values = append(values, args[i])
+	}
+	f.corpus = append(f.corpus, corpusEntry{Values: values, IsSeed: true, Name: fmt.Sprintf("seed#%d", len(f.corpus))})
+}
+
+// supportedTypes represents all of the supported types which can be fuzzed.
+var supportedTypes = map[reflect.Type]bool{
+	reflect.TypeOf(([]byte)("")):  true,
+	reflect.TypeOf((string)("")):  true,
+	reflect.TypeOf((bool)(false)): true,
+	reflect.TypeOf((byte)(0)):     true,
+	reflect.TypeOf((rune)(0)):     true,
+	reflect.TypeOf((float32)(0)):  true,
+	reflect.TypeOf((float64)(0)):  true,
+	reflect.TypeOf((int)(0)):      true,
+	reflect.TypeOf((int8)(0)):     true,
+	reflect.TypeOf((int16)(0)):    true,
+	reflect.TypeOf((int32)(0)):    true,
+	reflect.TypeOf((int64)(0)):    true,
+	reflect.TypeOf((uint)(0)):     true,
+	reflect.TypeOf((uint8)(0)):    true,
+	reflect.TypeOf((uint16)(0)):   true,
+	reflect.TypeOf((uint32)(0)):   true,
+	reflect.TypeOf((uint64)(0)):   true,
+}
+
+// Fuzz runs the fuzz function, ff, for fuzz testing. If ff fails for a set of
+// arguments, those arguments will be added to the seed corpus.
+//
+// ff must be a function with no return value whose first argument is *T and
+// whose remaining arguments are the types to be fuzzed.
+// For example:
+//
+//     f.Fuzz(func(t *testing.T, b []byte, i int) { ... })
+//
+// The following types are allowed: []byte, string, bool, byte, rune, float32,
+// float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64.
+// More types may be supported in the future.
+//
+// ff must not call any *F methods, e.g. (*F).Log, (*F).Error, (*F).Skip. Use
+// the corresponding *T method instead.
+//
+// ff should be fast and deterministic, and its behavior should not depend on
+// shared state. It must not retain or modify its arguments, as the memory
+// backing them may be reused by the fuzzing engine for later inputs.
+//
+// When fuzzing, F.Fuzz does not return until a problem is found, time runs out
+// (set with -fuzztime), or the test process is interrupted by a signal. F.Fuzz
+// should be called exactly once, unless F.Skip or F.Fail is called beforehand.
+func (f *F) Fuzz(ff interface{}) {
+	if f.fuzzCalled {
+		panic("testing: F.Fuzz called more than once")
+	}
+	f.fuzzCalled = true
+	f.Helper()
+
+	// ff should be in the form func(*testing.T, ...interface{})
+	fn := reflect.ValueOf(ff)
+	fnType := fn.Type()
+	if fnType.Kind() != reflect.Func {
+		panic("testing: F.Fuzz must receive a function")
+	}
+	if fnType.NumIn() < 2 || fnType.In(0) != reflect.TypeOf((*T)(nil)) {
+		panic("testing: F.Fuzz function must receive at least two arguments, where the first argument is a *T")
+	}
+	if fnType.NumOut() != 0 {
+		panic("testing: F.Fuzz function must not return a value")
+	}
+
+	// Save the types of the function to compare against the corpus.
+	var types []reflect.Type
+	for i := 1; i < fnType.NumIn(); i++ {
+		t := fnType.In(i)
+		if !supportedTypes[t] {
+			panic(fmt.Sprintf("testing: unsupported type for fuzzing %v", t))
+		}
+		types = append(types, t)
+	}
+
+	// Check the types of the entries declared with F.Add, and load the
+	// seed corpus from testdata. A worker process doesn't need either:
+	// the coordinator sends it every input it should run.
+	if f.fuzzContext.mode != fuzzWorker {
+		for _, c := range f.corpus {
+			if err := f.fuzzContext.deps.CheckCorpus(c.Values, types); err != nil {
+				f.Fatal(err)
+			}
+		}
+		c, err := f.fuzzContext.deps.ReadCorpus(filepath.Join(corpusDir, f.name), types)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.corpus = append(f.corpus, c...)
+	}
+
+	switch f.fuzzContext.mode {
+	case fuzzCoordinator:
+		// Fuzzing is enabled, and this is the test process started by
+		// 'go test'. Act as the coordinator process, and coordinate workers
+		// to perform the actual fuzzing.
+		err := f.fuzzContext.deps.CoordinateFuzzing(
+			fuzzDuration.d,
+			int64(fuzzDuration.n),
+			minimizeDuration.d,
+			*parallel,
+			f.corpus,
+			types,
+			filepath.Join(corpusDir, f.name),
+			filepath.Join(*fuzzCacheDir, f.name))
+		if err != nil {
+			f.Fail()
+			fmt.Fprintf(f.w, "%v\n", err)
+			if crashErr, ok := err.(interface{ CrashPath() string }); ok {
+				crashName := filepath.Base(crashErr.CrashPath())
+				fmt.Fprintf(f.w, "Failing input written to %s\n", crashErr.CrashPath())
+				fmt.Fprintf(f.w, "To re-run:\ngo test -run=%s/%s\n", f.name, crashName)
+			}
+		}
+
+	case fuzzWorker:
+		// Fuzzing is enabled, and this is a worker process. Follow
+		// instructions from the coordinator. Each input is run in a fresh
+		// T that has no parent, so that its output can be sent back to the
+		// coordinator as the error message if it fails.
+		err := f.fuzzContext.deps.RunFuzzWorker(func(e corpusEntry) error {
+			t := &T{
+				common: common{
+					barrier: make(chan bool),
+					signal:  make(chan bool),
+					name:    f.name,
+				},
+				context: f.testContext,
+			}
+			t.w = indenter{&t.common}
+			f.runFuzzFn(t, fn, e.Values)
+			if !t.Failed() {
+				return nil
+			}
+			if len(t.output) == 0 {
+				return errors.New("fuzz function failed without logging a reason")
+			}
+			return errors.New(string(t.output))
+		})
+		if err != nil {
+			f.Errorf("communicating with fuzzing coordinator: %v", err)
+		}
+
+	default:
+		// Fuzzing is not enabled, or will be done later. Only run the seed
+		// corpus now, each entry as a subtest that can be selected with -run.
+		for _, e := range f.corpus {
+			if shouldFailFast() {
+				break
+			}
+			name := e.Name
+			if e.Path != "" {
+				name = filepath.Base(e.Path)
+			}
+			testName, ok, _ := f.testContext.match.fullName(&f.common, name)
+			if !ok {
+				continue
+			}
+			var pc [maxStackLen]uintptr
+			n := runtime.Callers(2, pc[:])
+			t := &T{
+				common: common{
+					barrier: make(chan bool),
+					signal:  make(chan bool),
+					name:    testName,
+					parent:  &f.common,
+					level:   f.level + 1,
+					creator: pc[:n],
+					chatty:  f.chatty,
+				},
+				context: f.testContext,
+			}
+			t.w = indenter{&t.common}
+			if t.chatty {
+				printer.Fprint(f.parent.w, t.name, fmt.Sprintf("=== RUN   %s\n", t.name))
+			}
+			f.runFuzzFn(t, fn, e.Values)
+		}
+	}
+}
+
+// runFuzzFn calls the fuzz function fn with t and vals in a new goroutine,
+// the same way T.Run would, and waits for it to finish.
+func (f *F) runFuzzFn(t *T, fn reflect.Value, vals []interface{}) {
+	args := []reflect.Value{reflect.ValueOf(t)}
+	for _, v := range vals {
+		args = append(args, reflect.ValueOf(v))
+	}
+	f.inFuzzFn = true
+	go tRunner(t, func(t *T) { fn.Call(args) })
+	<-t.signal
+	f.inFuzzFn = false
+}
+
+func (f *F) report() {
+	if f.parent == nil {
+		return
+	}
+	dstr := fmtDuration(f.duration)
+	format := "--- %s: %s (%s)\n"
+	if f.Failed() {
+		f.flushToParent(f.name, format, "FAIL", f.name, dstr)
+	} else if f.chatty {
+		if f.Skipped() {
+			f.flushToParent(f.name, format, "SKIP", f.name, dstr)
+		} else {
+			f.flushToParent(f.name, format, "PASS", f.name, dstr)
+		}
+	}
+}
+
+// fuzzMode says what a fuzz target should do when it calls F.Fuzz.
+type fuzzMode uint8
+
+const (
+	seedCorpusOnly  fuzzMode = iota // run the seed corpus as subtests
+	fuzzCoordinator                 // start workers and generate new inputs
+	fuzzWorker                      // run inputs sent by the coordinator
+)
+
+// fuzzContext holds all fields that are common to all fuzz targets.
+type fuzzContext struct {
+	deps testDeps
+	mode fuzzMode
+}
+
+// runFuzzTargets runs the fuzz targets matching the pattern for -run, using
+// only their seed corpora. This happens whether or not fuzzing is enabled,
+// so that the seed corpus acts as a set of regression tests.
+func runFuzzTargets(deps testDeps, fuzzTargets []InternalFuzzTarget, deadline time.Time) (ran, ok bool) {
+	ok = true
+	if len(fuzzTargets) == 0 || *isFuzzWorker {
+		return ran, ok
+	}
+	tctx := newTestContext(*parallel, newMatcher(deps.MatchString, *match, "-test.run"))
+	tctx.deadline = deadline
+	fctx := &fuzzContext{deps: deps, mode: seedCorpusOnly}
+	root := common{w: os.Stdout} // gather output in one place
+	if Verbose() {
+		root.chatty = true
+	}
+	for _, ft := range fuzzTargets {
+		if shouldFailFast() {
+			break
+		}
+		testName, matched, _ := tctx.match.fullName(nil, ft.Name)
+		if !matched {
+			continue
+		}
+		f := newFuzzTarget(testName, &root, tctx, fctx)
+		go fRunner(f, ft.Fn)
+		<-f.signal
+	}
+	return root.ran, !root.Failed()
+}
+
+// runFuzzing runs the fuzz target matching the pattern for -fuzz. Exactly one
+// fuzz target must match. In the process started by 'go test', the target
+// coordinates a set of worker processes that generate and run new inputs;
+// in a worker process (-test.fuzzworker), it runs the inputs it is sent.
+//
+// If fuzzing is disabled (-test.fuzz is not set), runFuzzing
+// returns immediately.
+func runFuzzing(deps testDeps, fuzzTargets []InternalFuzzTarget) (ran, ok bool) {
+	if len(fuzzTargets) == 0 || *matchFuzz == "" {
+		return false, true
+	}
+	tctx := newTestContext(1, newMatcher(deps.MatchString, *matchFuzz, "-test.fuzz"))
+	fctx := &fuzzContext{deps: deps, mode: fuzzCoordinator}
+	root := common{w: os.Stdout}
+	if *isFuzzWorker {
+		fctx.mode = fuzzWorker
+	} else if Verbose() {
+		root.chatty = true
+	}
+
+	var target *InternalFuzzTarget
+	var targetName string
+	var matched []string
+	for i := range fuzzTargets {
+		name, ok, _ := tctx.match.fullName(nil, fuzzTargets[i].Name)
+		if !ok {
+			continue
+		}
+		matched = append(matched, name)
+		target = &fuzzTargets[i]
+		targetName = name
+	}
+	if len(matched) == 0 {
+		fmt.Fprintln(os.Stderr, "testing: warning: no targets to fuzz")
+		return false, true
+	}
+	if len(matched) > 1 {
+		fmt.Fprintf(os.Stderr, "testing: will not fuzz, -fuzz matches more than one target: %v\n", matched)
+		return false, false
+	}
+
+	f := newFuzzTarget(targetName, &root, tctx, fctx)
+	go fRunner(f, target.Fn)
+	<-f.signal
+	return root.ran, !f.Failed()
+}
+
+func newFuzzTarget(name string, root *common, tctx *testContext, fctx *fuzzContext) *F {
+	f := &F{
+		common: common{
+			signal:  make(chan bool),
+			barrier: make(chan bool),
+			name:    name,
+			parent:  root,
+			level:   root.level + 1,
+			chatty:  root.chatty,
+		},
+		testContext: tctx,
+		fuzzContext: fctx,
+	}
+	f.w = indenter{&f.common}
+	if f.chatty {
+		root.mu.Lock()
+		printer.Fprint(root.w, f.name, fmt.Sprintf("=== RUN   %s\n", f.name))
+		root.mu.Unlock()
+	}
+	return f
+}
+
+// fRunner runs a fuzz target function, fn, as tRunner runs a test function.
+// It records the duration, reports the result and signals f.signal when fn
+// returns or calls runtime.Goexit.
+func fRunner(f *F, fn func(*F)) {
+	f.runner = callerName(0)
+
+	defer func() {
+		if f.Failed() {
+			atomic.AddUint32(&numFailed, 1)
+		}
+		err := recover()
+		if !f.finished && err == nil {
+			err = errNilPanicOrGoexit
+		}
+		if err != nil {
+			f.Fail()
+			f.duration += time.Since(f.start)
+			f.flushToParent(f.name, "--- FAIL: %s (%s)\n", f.name, fmtDuration(f.duration))
+			panic(err)
+		}
+		f.duration += time.Since(f.start)
+		f.report()
+		f.done = true
+		f.setRan()
+		f.signal <- true
+	}()
+	defer f.runCleanup(normalPanic)
+
+	f.start = time.Now()
+	fn(f)
+
+	// Code beyond this point is not executed if fn called f.FailNow
+	// or f.SkipNow.
+	if !f.fuzzCalled && !f.Failed() {
+		f.Error("testing: fuzz target did not call F.Fuzz")
+	}
+	f.finished = true
+}